@@ -6,11 +6,13 @@ import (
 	"github.com/budka-tech/configo"
 	"github.com/budka-tech/envo"
 	"github.com/budka-tech/logit-go"
+	"s3n/internal/cache"
 	"s3n/internal/config"
 	"s3n/internal/db"
 	"s3n/internal/db/repository"
 	"s3n/internal/endpoint"
 	"s3n/internal/image_processing"
+	"s3n/internal/metrics"
 	"s3n/internal/s3"
 )
 
@@ -43,17 +45,26 @@ func main() {
 		panic(err)
 	}
 	logger.Info(ctx, "S3 сервис успешно запущен")
-	_ = s3Service
+
+	s3Service, err = cache.NewService(s3Service, &cfg.Cache, logger)
+	if err != nil {
+		logger.Fatal(ctx, fmt.Errorf("ошибка при создании кеша: %s", err))
+		panic(err)
+	}
 
 	imageService := image_processing.NewImageService(&cfg.ImageProcessing, logger)
 	_ = imageService
 
-	endpointService, err := endpoint.NewEndpoint(ctx, s3Service, dbService, imageService, logger)
+	endpointService, err := endpoint.NewEndpoint(ctx, s3Service, dbService, imageService, &cfg.Endpoint, logger)
 	if err != nil {
 		logger.Fatal(ctx, fmt.Errorf("ошибка при создании эндпойнта: %s", err))
 		panic(err)
 	}
 
+	go endpointService.RunPurge(ctx)
+	go endpointService.RunExpirySweep(ctx)
+	go endpointService.RunSizeBackfill(ctx)
+
 	grpcServer := endpoint.NewGrpcServer(endpointService, logger)
 	logger.Info(ctx, "grpc сервер успешно запущен")
 
@@ -65,7 +76,18 @@ func main() {
 		}
 	}()
 
-	server := endpoint.NewRedirectServer(s3Service, &cfg.HttpRedirect, logger)
+	if cfg.Metrics.Addr != "" {
+		go func() {
+			err := metrics.Serve(cfg.Metrics.Addr)
+			if err != nil {
+				logger.Fatal(ctx, fmt.Errorf("ошибка при работе сервера метрик: %s", err))
+				panic(err)
+			}
+		}()
+		logger.Info(ctx, "сервер метрик запущен")
+	}
+
+	server := endpoint.NewRedirectServer(endpointService, s3Service, &cfg.HttpRedirect, logger)
 
 	logger.Info(ctx, "redirect сервер запущен")
 	err = server.Run(ctx)
@@ -118,7 +118,10 @@ func main() {
 	fmt.Println("замер времени...")
 	for i := 0; i < cyclesInt; i++ {
 		start := time.Now()
-		transform, err := imageService.Transform(ctx, bytes, fileExtension, &qualityF32, &maxSizeInt)
+		transform, err := imageService.Transform(ctx, bytes, fileExtension, &image_processing.Options{
+			Quality: &qualityF32,
+			MaxSize: &maxSizeInt,
+		})
 		elapsed := time.Since(start)
 		if err != nil {
 			panic(err)
@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/budka-tech/logit-go"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"s3n/internal/config"
+	"s3n/internal/metrics"
+	"sync"
+	"time"
+)
+
+// errTooLarge объект больше maxObjectSize и не кешируется
+var errTooLarge = errors.New("объект слишком большой для кеша")
+
+// maxLargeKeys количество запоминаемых ключей объектов больше maxObjectSize
+const maxLargeKeys = 10000
+
+// Entry объект в кеше вместе с заголовками, Data не изменяется после добавления
+type Entry struct {
+	Data         []byte
+	ContentType  string
+	CacheControl string
+	ETag         string
+	LastModified time.Time
+}
+
+// Cache кеш объектов из двух уровней: LRU в памяти и необязательный LRU на диске.
+// Одновременные промахи по одному ключу объединяются в одну загрузку
+type Cache struct {
+	logger        logit.Logger
+	maxObjectSize int64
+
+	lock   sync.Mutex
+	memory *lru[*Entry] // nil - кеш в памяти отключён
+	// загружаемые ключи, true - ключ сброшен во время загрузки и результат не сохраняется
+	loading map[string]bool
+	// ключи объектов больше maxObjectSize, они читаются из S3 без попытки загрузки в кеш
+	large *lru[struct{}]
+
+	disk  *disk // nil - дисковый кеш отключён
+	group singleflight.Group
+}
+
+func NewCache(config *config.CacheConfig, logger logit.Logger) (*Cache, error) {
+	c := &Cache{
+		logger:        logger,
+		maxObjectSize: config.MaxObjectSize,
+		loading:       map[string]bool{},
+		large:         newLRU[struct{}](maxLargeKeys),
+	}
+	if config.MemorySize > 0 {
+		c.memory = newLRU[*Entry](config.MemorySize)
+	}
+	if config.DiskDir != "" {
+		d, err := newDisk(config.DiskDir, config.DiskSize)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = d
+	}
+	return c, nil
+}
+
+// Peek возвращает объект, если он есть в кеше, не загружая его
+func (c *Cache) Peek(ctx context.Context, key string) (*Entry, bool) {
+	const op = "Cache.Peek"
+	ctx = c.logger.NewOpCtx(ctx, op)
+
+	c.lock.Lock()
+	if c.memory != nil {
+		if entry, ok := c.memory.get(key); ok {
+			c.lock.Unlock()
+			metrics.CacheHits.Add("memory", 1)
+			return entry, true
+		}
+	}
+	c.lock.Unlock()
+
+	if c.disk == nil {
+		return nil, false
+	}
+	entry, ok, err := c.disk.get(key)
+	if err != nil {
+		err = fmt.Errorf("не удалось прочитать объект из дискового кеша: %w", err)
+		c.logger.Error(ctx, err, zap.String("key", key))
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	metrics.CacheHits.Add("disk", 1)
+	c.lock.Lock()
+	c.putMemory(key, entry)
+	c.lock.Unlock()
+	return entry, true
+}
+
+// Get возвращает объект из кеша или загружает его функцией load и сохраняет.
+// Если load возвращает объект больше maxObjectSize или возвращал его ранее, объект не сохраняется
+// и возвращается errTooLarge. Такие чтения учитываются как обходы кеша, а не как промахи
+func (c *Cache) Get(ctx context.Context, key string, load func() (*Entry, error)) (*Entry, error) {
+	const op = "Cache.Get"
+	ctx = c.logger.NewOpCtx(ctx, op)
+
+	if entry, ok := c.Peek(ctx, key); ok {
+		return entry, nil
+	}
+
+	c.lock.Lock()
+	_, large := c.large.get(key)
+	c.lock.Unlock()
+	if large {
+		metrics.CacheBypasses.Add(1)
+		return nil, errTooLarge
+	}
+
+	value, err, _ := c.group.Do(key, func() (any, error) {
+		c.lock.Lock()
+		c.loading[key] = false
+		c.lock.Unlock()
+
+		entry, err := load()
+		if err == nil && int64(len(entry.Data)) > c.maxObjectSize {
+			err = errTooLarge
+		}
+		if err == nil && c.disk != nil {
+			diskErr := c.disk.put(key, entry)
+			if diskErr != nil {
+				diskErr = fmt.Errorf("не удалось записать объект в дисковый кеш: %w", diskErr)
+				c.logger.Error(ctx, diskErr, zap.String("key", key))
+			}
+		}
+
+		c.lock.Lock()
+		stale := c.loading[key]
+		delete(c.loading, key)
+		if err == nil && !stale {
+			c.putMemory(key, entry)
+		}
+		if errors.Is(err, errTooLarge) && !stale {
+			c.large.add(key, struct{}{}, 1)
+		}
+		c.lock.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		if stale && c.disk != nil {
+			c.disk.remove(key)
+		}
+		return entry, nil
+	})
+	if errors.Is(err, errTooLarge) {
+		metrics.CacheBypasses.Add(1)
+		return nil, err
+	}
+	metrics.CacheMisses.Add(1)
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Entry), nil
+}
+
+// Invalidate удаляет объекты из всех уровней кеша
+func (c *Cache) Invalidate(keys ...string) {
+	c.lock.Lock()
+	for _, key := range keys {
+		if _, ok := c.loading[key]; ok {
+			c.loading[key] = true
+		}
+		// новое содержимое может поместиться в кеш
+		c.large.remove(key)
+	}
+	if c.memory != nil {
+		for _, key := range keys {
+			if item, ok := c.memory.remove(key); ok {
+				metrics.CacheBytes.Add("memory", -item.size)
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	if c.disk != nil {
+		for _, key := range keys {
+			c.disk.remove(key)
+		}
+	}
+}
+
+// putMemory сохраняет объект в памяти, вызывается под lock
+func (c *Cache) putMemory(key string, entry *Entry) {
+	if c.memory == nil {
+		return
+	}
+
+	before := c.memory.size
+	evicted, _ := c.memory.add(key, entry, int64(len(entry.Data)))
+	metrics.CacheBytes.Add("memory", c.memory.size-before)
+	metrics.CacheEvictions.Add("memory", int64(len(evicted)))
+}
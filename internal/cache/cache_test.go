@@ -0,0 +1,278 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"s3n/internal/config"
+	"s3n/internal/metrics"
+	"testing"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) NewOpCtx(ctx context.Context, _ string) context.Context          { return ctx }
+func (nopLogger) NewTraceCtx(ctx context.Context, _ *string) context.Context      { return ctx }
+func (nopLogger) NewCtx(ctx context.Context, _ string, _ *string) context.Context { return ctx }
+func (nopLogger) Debug(context.Context, any, ...zap.Field)                        {}
+func (nopLogger) Info(context.Context, any, ...zap.Field)                         {}
+func (nopLogger) Warn(context.Context, any, ...zap.Field)                         {}
+func (nopLogger) Error(context.Context, error, ...zap.Field)                      {}
+func (nopLogger) Fatal(context.Context, error, ...zap.Field)                      {}
+
+func newTestCache(t *testing.T, memorySize int64, disk bool) *Cache {
+	t.Helper()
+
+	cfg := &config.CacheConfig{MemorySize: memorySize, DiskSize: 100, MaxObjectSize: 10}
+	if disk {
+		cfg.DiskDir = t.TempDir()
+	}
+	c, err := NewCache(cfg, nopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestLRU(t *testing.T) {
+	type op struct {
+		add     string
+		size    int64
+		get     string
+		remove  string
+		evicted []string
+	}
+	tests := []struct {
+		name     string
+		ops      []op
+		wantKeys []string
+		wantSize int64
+	}{
+		{
+			name:     "вытеснение самого старого",
+			ops:      []op{{add: "a", size: 4}, {add: "b", size: 4}, {add: "c", size: 4, evicted: []string{"a"}}},
+			wantKeys: []string{"b", "c"},
+			wantSize: 8,
+		},
+		{
+			name:     "чтение продлевает жизнь",
+			ops:      []op{{add: "a", size: 4}, {add: "b", size: 4}, {get: "a"}, {add: "c", size: 4, evicted: []string{"b"}}},
+			wantKeys: []string{"a", "c"},
+			wantSize: 8,
+		},
+		{
+			name:     "значение больше ёмкости не добавляется",
+			ops:      []op{{add: "a", size: 4}, {add: "big", size: 11}},
+			wantKeys: []string{"a"},
+			wantSize: 4,
+		},
+		{
+			name:     "замена значения",
+			ops:      []op{{add: "a", size: 4}, {add: "a", size: 6}},
+			wantKeys: []string{"a"},
+			wantSize: 6,
+		},
+		{
+			name:     "вытеснение нескольких значений",
+			ops:      []op{{add: "a", size: 3}, {add: "b", size: 3}, {add: "c", size: 9, evicted: []string{"a", "b"}}},
+			wantKeys: []string{"c"},
+			wantSize: 9,
+		},
+		{
+			name:     "удаление",
+			ops:      []op{{add: "a", size: 3}, {add: "b", size: 3}, {remove: "a"}},
+			wantKeys: []string{"b"},
+			wantSize: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLRU[int](10)
+			for _, op := range tt.ops {
+				switch {
+				case op.add != "":
+					evicted, _ := l.add(op.add, 0, op.size)
+					if len(evicted) != len(op.evicted) {
+						t.Fatalf("вытеснено %v, ожидалось %v", evicted, op.evicted)
+					}
+					for i, item := range evicted {
+						if item.key != op.evicted[i] {
+							t.Errorf("вытеснен %s, ожидалось %s", item.key, op.evicted[i])
+						}
+					}
+				case op.get != "":
+					l.get(op.get)
+				case op.remove != "":
+					l.remove(op.remove)
+				}
+			}
+			if l.size != tt.wantSize || len(l.items) != len(tt.wantKeys) {
+				t.Fatalf("размер %d, ключей %d, ожидалось %d и %v", l.size, len(l.items), tt.wantSize, tt.wantKeys)
+			}
+			for _, key := range tt.wantKeys {
+				if _, ok := l.items[key]; !ok {
+					t.Errorf("нет ключа %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestDisk(t *testing.T) {
+	d, err := newDisk(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string]*Entry{
+		"a": {Data: []byte("12345"), ContentType: "image/png", ETag: `"a"`},
+		"b": {Data: []byte("12345")},
+		"c": {Data: []byte("12")},
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := d.put(key, entries[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.put("big", &Entry{Data: make([]byte, 11)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key    string
+		wantOk bool
+	}{
+		{key: "a"}, // вытеснен записью c
+		{key: "b", wantOk: true},
+		{key: "c", wantOk: true},
+		{key: "big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			entry, ok, err := d.get(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOk {
+				t.Fatalf("найден: %v, ожидалось %v", ok, tt.wantOk)
+			}
+			if ok && (string(entry.Data) != string(entries[tt.key].Data) || entry.ETag != entries[tt.key].ETag) {
+				t.Errorf("прочитан %+v, ожидалось %+v", entry, entries[tt.key])
+			}
+		})
+	}
+
+	d.remove("b")
+	if _, ok, _ := d.get("b"); ok {
+		t.Error("удалённый объект прочитан")
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	tests := []struct {
+		name       string
+		memorySize int64
+		disk       bool
+	}{
+		{name: "память", memorySize: 100},
+		{name: "диск", disk: true},
+		{name: "память и диск", memorySize: 100, disk: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(t, tt.memorySize, tt.disk)
+			loads := 0
+			load := func() (*Entry, error) {
+				loads++
+				return &Entry{Data: []byte("data")}, nil
+			}
+
+			for i := 0; i < 3; i++ {
+				entry, err := c.Get(context.Background(), "key", load)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(entry.Data) != "data" {
+					t.Fatalf("прочитано %q", entry.Data)
+				}
+			}
+			if loads != 1 {
+				t.Errorf("загрузок %d, ожидалась одна", loads)
+			}
+
+			c.Invalidate("key")
+			if _, ok := c.Peek(context.Background(), "key"); ok {
+				t.Error("сброшенный объект остался в кеше")
+			}
+		})
+	}
+}
+
+func TestCacheGetTooLarge(t *testing.T) {
+	c := newTestCache(t, 100, false)
+	ctx := context.Background()
+	loads := 0
+	load := func() (*Entry, error) {
+		loads++
+		return nil, errTooLarge
+	}
+
+	misses, bypasses := metrics.CacheMisses.Value(), metrics.CacheBypasses.Value()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(ctx, "key", load); !errors.Is(err, errTooLarge) {
+			t.Fatalf("ошибка %v, ожидалась errTooLarge", err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("загрузок %d, большой объект должен загружаться один раз", loads)
+	}
+	if metrics.CacheMisses.Value() != misses || metrics.CacheBypasses.Value() != bypasses+3 {
+		t.Errorf("промахов %d, обходов %d, ожидалось 0 и 3",
+			metrics.CacheMisses.Value()-misses, metrics.CacheBypasses.Value()-bypasses)
+	}
+
+	// после перезаписи объект снова пробует попасть в кеш
+	c.Invalidate("key")
+	entry, err := c.Get(ctx, "key", func() (*Entry, error) { return &Entry{Data: []byte("small")}, nil })
+	if err != nil || string(entry.Data) != "small" {
+		t.Errorf("прочитано %v, ошибка %v", entry, err)
+	}
+}
+
+func TestCacheInvalidateDuringLoad(t *testing.T) {
+	c := newTestCache(t, 100, true)
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan string)
+	go func() {
+		entry, err := c.Get(ctx, "key", func() (*Entry, error) {
+			close(started)
+			<-release
+			return &Entry{Data: []byte("old")}, nil
+		})
+		if err != nil {
+			t.Error(err)
+			done <- ""
+			return
+		}
+		done <- string(entry.Data)
+	}()
+
+	// сброс во время загрузки: вызывающий получает загруженный объект, но он не сохраняется
+	<-started
+	c.Invalidate("key")
+	close(release)
+	if data := <-done; data != "old" {
+		t.Errorf("прочитано %q", data)
+	}
+
+	if _, ok := c.Peek(ctx, "key"); ok {
+		t.Error("объект, сброшенный во время загрузки, сохранён в кеше")
+	}
+	entry, err := c.Get(ctx, "key", func() (*Entry, error) { return &Entry{Data: []byte("new")}, nil })
+	if err != nil || string(entry.Data) != "new" {
+		t.Errorf("прочитано %v, ошибка %v", entry, err)
+	}
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"s3n/internal/metrics"
+	"sync"
+)
+
+// disk дисковый уровень кеша, объект хранится в файле с именем из sha256 ключа.
+// Индекс хранится только в памяти, поэтому при запуске каталог очищается
+type disk struct {
+	dir   string
+	lock  sync.Mutex
+	index *lru[struct{}]
+}
+
+func newDisk(dir string, capacity int64) (*disk, error) {
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось очистить каталог дискового кеша: %w", err)
+	}
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать каталог дискового кеша: %w", err)
+	}
+
+	return &disk{
+		dir:   dir,
+		index: newLRU[struct{}](capacity),
+	}, nil
+}
+
+func (d *disk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+// get читает объект с диска, false - объекта нет в кеше
+func (d *disk) get(key string) (*Entry, bool, error) {
+	d.lock.Lock()
+	_, ok := d.index.get(key)
+	d.lock.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+
+	file, err := os.Open(d.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// файл удалён вытеснением после проверки индекса
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer file.Close()
+
+	var entry Entry
+	err = gob.NewDecoder(file).Decode(&entry)
+	if err != nil {
+		d.remove(key)
+		return nil, false, err
+	}
+	return &entry, true, nil
+}
+
+// put записывает объект на диск, вытесняя давно не использованные объекты
+func (d *disk) put(key string, entry *Entry) error {
+	size := int64(len(entry.Data))
+	if size > d.index.capacity {
+		return nil
+	}
+
+	// запись через временный файл, чтобы читатели не увидели файл частично записанным
+	temp, err := os.CreateTemp(d.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(temp).Encode(entry)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), d.path(key))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	d.lock.Lock()
+	before := d.index.size
+	evicted, _ := d.index.add(key, struct{}{}, size)
+	for _, item := range evicted {
+		os.Remove(d.path(item.key))
+	}
+	metrics.CacheBytes.Add("disk", d.index.size-before)
+	d.lock.Unlock()
+	metrics.CacheEvictions.Add("disk", int64(len(evicted)))
+
+	return nil
+}
+
+// remove удаляет объект с диска
+func (d *disk) remove(key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	item, ok := d.index.remove(key)
+	if !ok {
+		return
+	}
+	os.Remove(d.path(key))
+	metrics.CacheBytes.Add("disk", -item.size)
+}
@@ -0,0 +1,69 @@
+package cache
+
+import "container/list"
+
+// lru значения с размерами в порядке последнего использования, не потокобезопасен
+type lru[V any] struct {
+	capacity int64
+	size     int64
+	order    *list.List // в начале - последнее использованное значение
+	items    map[string]*list.Element
+}
+
+type lruItem[V any] struct {
+	key   string
+	value V
+	size  int64
+}
+
+func newLRU[V any](capacity int64) *lru[V] {
+	return &lru[V]{
+		capacity: capacity,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// get возвращает значение и отмечает его как последнее использованное
+func (l *lru[V]) get(key string) (V, bool) {
+	element, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(element)
+	return element.Value.(*lruItem[V]).value, true
+}
+
+// add добавляет или заменяет значение и возвращает значения, вытесненные из-за превышения capacity.
+// Значение больше capacity не добавляется, false - значение не добавлено
+func (l *lru[V]) add(key string, value V, size int64) ([]lruItem[V], bool) {
+	l.remove(key)
+	if size > l.capacity {
+		return nil, false
+	}
+
+	l.items[key] = l.order.PushFront(&lruItem[V]{key: key, value: value, size: size})
+	l.size += size
+
+	var evicted []lruItem[V]
+	for l.size > l.capacity {
+		oldest := l.order.Back().Value.(*lruItem[V])
+		l.remove(oldest.key)
+		evicted = append(evicted, *oldest)
+	}
+	return evicted, true
+}
+
+// remove удаляет значение, false - значения не было
+func (l *lru[V]) remove(key string) (lruItem[V], bool) {
+	element, ok := l.items[key]
+	if !ok {
+		return lruItem[V]{}, false
+	}
+	item := element.Value.(*lruItem[V])
+	l.order.Remove(element)
+	delete(l.items, key)
+	l.size -= item.size
+	return *item, true
+}
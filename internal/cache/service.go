@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/budka-tech/logit-go"
+	"io"
+	"s3n/internal/config"
+	"s3n/internal/s3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service s3.Service с кешем чтения объектов. Запись, копирование и удаление объектов
+// через Service сбрасывают их в кеше
+type Service struct {
+	s3.Service
+	cache *Cache
+}
+
+// NewService оборачивает service кешем, если включён хотя бы один уровень кеша
+func NewService(service s3.Service, config *config.CacheConfig, logger logit.Logger) (s3.Service, error) {
+	if config.MemorySize <= 0 && config.DiskDir == "" {
+		return service, nil
+	}
+
+	c, err := NewCache(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{Service: service, cache: c}, nil
+}
+
+func cacheKey(bucket string, key string) string {
+	return bucket + "/" + key
+}
+
+// entry возвращает объект из кеша, загружая его из S3 при промахе. Если объект больше maxObjectSize
+// и загружал его этот вызов, возвращается errTooLarge вместе с открытым объектом, его закрывает вызывающий
+func (s *Service) entry(ctx context.Context, bucket string, key string) (*Entry, *s3.Object, error) {
+	var large *s3.Object
+	entry, err := s.cache.Get(ctx, cacheKey(bucket, key), func() (*Entry, error) {
+		object, err := s.Service.ReadObject(ctx, bucket, key, &s3.ObjectRequest{})
+		if err != nil {
+			return nil, err
+		}
+
+		if object.Length > s.cache.maxObjectSize {
+			large = object
+			return nil, errTooLarge
+		}
+		defer object.Body.Close()
+		data, err := io.ReadAll(object.Body)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать файл: %w", err)
+		}
+
+		return &Entry{
+			Data:         data,
+			ContentType:  object.ContentType,
+			CacheControl: object.CacheControl,
+			ETag:         object.ETag,
+			LastModified: object.LastModified,
+		}, nil
+	})
+	if err != nil {
+		return nil, large, err
+	}
+	return entry, nil, nil
+}
+
+func (s *Service) ReadObject(ctx context.Context, bucket string, key string, request *s3.ObjectRequest) (*s3.Object, error) {
+	entry, large, err := s.entry(ctx, bucket, key)
+	if errors.Is(err, errTooLarge) {
+		if large == nil {
+			return s.Service.ReadObject(ctx, bucket, key, request)
+		}
+		return s.largeObject(ctx, bucket, key, large, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if notModified(entry, request) {
+		return nil, s3.ErrNotModified
+	}
+
+	object := entryObject(entry)
+	offset, length, ranged, err := parseRange(request.Range, object.Size)
+	if err != nil {
+		return nil, err
+	}
+	if ranged {
+		object.Offset = offset
+		object.Length = length
+		object.ContentRange = fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, object.Size)
+	}
+	object.Body = io.NopCloser(bytes.NewReader(entry.Data[offset : offset+object.Length]))
+
+	return object, nil
+}
+
+// largeObject отвечает на запрос уже открытым объектом целиком, не запрашивая его из S3 повторно.
+// Повторный запрос нужен только для диапазона
+func (s *Service) largeObject(ctx context.Context, bucket string, key string, object *s3.Object, request *s3.ObjectRequest) (*s3.Object, error) {
+	if request.Range == "" {
+		meta := &Entry{ETag: object.ETag, LastModified: object.LastModified}
+		if !notModified(meta, request) {
+			return object, nil
+		}
+		object.Body.Close()
+		return nil, s3.ErrNotModified
+	}
+
+	object.Body.Close()
+	return s.Service.ReadObject(ctx, bucket, key, request)
+}
+
+func (s *Service) StatObject(ctx context.Context, bucket string, key string, request *s3.ObjectRequest) (*s3.Object, error) {
+	// сведения берутся из кеша, только если объект уже загружен, HEAD не загружает содержимое
+	entry, ok := s.cache.Peek(ctx, cacheKey(bucket, key))
+	if !ok {
+		return s.Service.StatObject(ctx, bucket, key, request)
+	}
+
+	if notModified(entry, request) {
+		return nil, s3.ErrNotModified
+	}
+	return entryObject(entry), nil
+}
+
+func (s *Service) GetObject(ctx context.Context, bucket string, key string, offset int64, length int64) (*s3.Object, error) {
+	request := &s3.ObjectRequest{}
+	if length > 0 {
+		request.Range = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	} else if offset > 0 {
+		request.Range = fmt.Sprintf("bytes=%d-", offset)
+	}
+	return s.ReadObject(ctx, bucket, key, request)
+}
+
+func (s *Service) DownloadFileBytes(ctx context.Context, bucket string, key string) ([]byte, error) {
+	entry, large, err := s.entry(ctx, bucket, key)
+	if errors.Is(err, errTooLarge) {
+		if large == nil {
+			return s.Service.DownloadFileBytes(ctx, bucket, key)
+		}
+		defer large.Body.Close()
+		data, err := io.ReadAll(large.Body)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать файл: %w", err)
+		}
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(entry.Data), nil
+}
+
+func (s *Service) UploadFile(ctx context.Context, bucket string, key string, file io.Reader, attributes *s3.ObjectAttributes) error {
+	defer s.cache.Invalidate(cacheKey(bucket, key))
+	return s.Service.UploadFile(ctx, bucket, key, file, attributes)
+}
+
+func (s *Service) UploadFileBytes(ctx context.Context, bucket string, key string, file []byte, attributes *s3.ObjectAttributes) error {
+	defer s.cache.Invalidate(cacheKey(bucket, key))
+	return s.Service.UploadFileBytes(ctx, bucket, key, file, attributes)
+}
+
+func (s *Service) CopyFile(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string, attributes *s3.ObjectAttributes) error {
+	defer s.cache.Invalidate(cacheKey(dstBucket, dstKey))
+	return s.Service.CopyFile(ctx, srcBucket, srcKey, dstBucket, dstKey, attributes)
+}
+
+func (s *Service) DeleteFile(ctx context.Context, bucket string, key string) error {
+	defer s.cache.Invalidate(cacheKey(bucket, key))
+	return s.Service.DeleteFile(ctx, bucket, key)
+}
+
+func (s *Service) DeleteFiles(ctx context.Context, bucket string, keys []string) error {
+	defer s.Invalidate(bucket, keys...)
+	return s.Service.DeleteFiles(ctx, bucket, keys)
+}
+
+func (s *Service) Invalidate(bucket string, keys ...string) {
+	cacheKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		cacheKeys = append(cacheKeys, cacheKey(bucket, key))
+	}
+	s.cache.Invalidate(cacheKeys...)
+}
+
+func entryObject(entry *Entry) *s3.Object {
+	size := int64(len(entry.Data))
+	return &s3.Object{
+		ContentType:  entry.ContentType,
+		CacheControl: entry.CacheControl,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+		Length:       size,
+		Size:         size,
+	}
+}
+
+// notModified проверяет условия If-None-Match и If-Modified-Since так же, как S3
+func notModified(entry *Entry, request *s3.ObjectRequest) bool {
+	if request.IfNoneMatch != "" {
+		for _, tag := range strings.Split(request.IfNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(entry.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if request.IfModifiedSince != nil && !entry.LastModified.IsZero() {
+		return !entry.LastModified.Truncate(time.Second).After(*request.IfModifiedSince)
+	}
+	return false
+}
+
+// parseRange разбирает заголовок Range с одним диапазоном. Некорректный заголовок и несколько
+// диапазонов игнорируются, как это делает S3, диапазон за концом объекта - s3.ErrInvalidRange
+func parseRange(header string, size int64) (int64, int64, bool, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, false, nil
+	}
+
+	if first == "" {
+		// последние n байт
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, size, false, nil
+		}
+		if n <= 0 || size == 0 {
+			return 0, 0, false, s3.ErrInvalidRange
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+
+	offset, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, size, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < offset {
+			return 0, size, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if offset >= size {
+		return 0, 0, false, s3.ErrInvalidRange
+	}
+	return offset, end - offset + 1, true, nil
+}
@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"s3n/internal/s3"
+	"testing"
+	"time"
+)
+
+// fakeS3 отдаёт объекты из памяти и считает запросы, остальные методы s3.Service не реализованы
+type fakeS3 struct {
+	s3.Service
+	objects map[string][]byte
+	reads   int
+}
+
+func (f *fakeS3) ReadObject(_ context.Context, bucket string, key string, request *s3.ObjectRequest) (*s3.Object, error) {
+	f.reads++
+	data, ok := f.objects[cacheKey(bucket, key)]
+	if !ok {
+		return nil, s3.ErrObjectNotFound
+	}
+	size := int64(len(data))
+	offset, length, _, err := parseRange(request.Range, size)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.Object{
+		Body:   io.NopCloser(bytes.NewReader(data[offset : offset+length])),
+		ETag:   `"etag"`,
+		Offset: offset,
+		Length: length,
+		Size:   size,
+	}, nil
+}
+
+func (f *fakeS3) DownloadFileBytes(_ context.Context, bucket string, key string) ([]byte, error) {
+	f.reads++
+	return f.objects[cacheKey(bucket, key)], nil
+}
+
+func TestServiceTooLarge(t *testing.T) {
+	large := []byte("0123456789abcdef")
+	tests := []struct {
+		name      string
+		request   *s3.ObjectRequest
+		want      string
+		wantErr   error
+		wantReads int
+	}{
+		{name: "объект целиком", request: &s3.ObjectRequest{}, want: string(large), wantReads: 1},
+		{name: "не изменён", request: &s3.ObjectRequest{IfNoneMatch: `"etag"`}, wantErr: s3.ErrNotModified, wantReads: 1},
+		{name: "изменён", request: &s3.ObjectRequest{IfNoneMatch: `"other"`}, want: string(large), wantReads: 1},
+		{name: "диапазон", request: &s3.ObjectRequest{Range: "bytes=2-4"}, want: "234", wantReads: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeS3{objects: map[string][]byte{"b/large": large}}
+			service := &Service{Service: backend, cache: newTestCache(t, 100, false)}
+
+			object, err := service.ReadObject(context.Background(), "b", "large", tt.request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if err == nil {
+				data, _ := io.ReadAll(object.Body)
+				if string(data) != tt.want {
+					t.Errorf("прочитано %q, ожидалось %q", data, tt.want)
+				}
+			}
+			if backend.reads != tt.wantReads {
+				t.Errorf("запросов к S3 %d, ожидалось %d", backend.reads, tt.wantReads)
+			}
+
+			// повторное чтение сразу идёт в S3 одним запросом
+			backend.reads = 0
+			if _, err := service.ReadObject(context.Background(), "b", "large", &s3.ObjectRequest{}); err != nil {
+				t.Fatal(err)
+			}
+			if backend.reads != 1 {
+				t.Errorf("запросов к S3 при повторном чтении %d, ожидался один", backend.reads)
+			}
+		})
+	}
+}
+
+func TestServiceDownloadTooLarge(t *testing.T) {
+	backend := &fakeS3{objects: map[string][]byte{"b/large": []byte("0123456789abcdef")}}
+	service := &Service{Service: backend, cache: newTestCache(t, 100, false)}
+
+	data, err := service.DownloadFileBytes(context.Background(), "b", "large")
+	if err != nil || string(data) != "0123456789abcdef" {
+		t.Fatalf("прочитано %q, ошибка %v", data, err)
+	}
+	if backend.reads != 1 {
+		t.Errorf("запросов к S3 %d, ожидался один", backend.reads)
+	}
+}
+
+func TestServiceReadObject(t *testing.T) {
+	backend := &fakeS3{objects: map[string][]byte{"b/small": []byte("012345")}}
+	service := &Service{Service: backend, cache: newTestCache(t, 100, false)}
+
+	for _, request := range []*s3.ObjectRequest{{}, {Range: "bytes=1-2"}, {IfNoneMatch: `"etag"`}} {
+		service.ReadObject(context.Background(), "b", "small", request)
+	}
+	if backend.reads != 1 {
+		t.Errorf("запросов к S3 %d, ожидался один", backend.reads)
+	}
+
+	object, err := service.ReadObject(context.Background(), "b", "small", &s3.ObjectRequest{Range: "bytes=1-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(object.Body)
+	if string(data) != "12" || object.ContentRange != "bytes 1-2/6" {
+		t.Errorf("прочитано %q, Content-Range %q", data, object.ContentRange)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header     string
+		size       int64
+		wantOffset int64
+		wantLength int64
+		wantRanged bool
+		wantErr    bool
+	}{
+		{header: "", size: 10, wantLength: 10},
+		{header: "bytes=0-4", size: 10, wantLength: 5, wantRanged: true},
+		{header: "bytes=5-", size: 10, wantOffset: 5, wantLength: 5, wantRanged: true},
+		{header: "bytes=5-100", size: 10, wantOffset: 5, wantLength: 5, wantRanged: true},
+		{header: "bytes=-3", size: 10, wantOffset: 7, wantLength: 3, wantRanged: true},
+		{header: "bytes=-30", size: 10, wantLength: 10, wantRanged: true},
+		{header: "bytes= 2-3 ", size: 10, wantOffset: 2, wantLength: 2, wantRanged: true},
+		// некорректные заголовки и несколько диапазонов игнорируются
+		{header: "bytes=0-1,3-4", size: 10, wantLength: 10},
+		{header: "items=0-1", size: 10, wantLength: 10},
+		{header: "bytes=4-2", size: 10, wantLength: 10},
+		{header: "bytes=a-2", size: 10, wantLength: 10},
+		{header: "bytes=5", size: 10, wantLength: 10},
+		// диапазон за концом объекта
+		{header: "bytes=10-", size: 10, wantErr: true},
+		{header: "bytes=-0", size: 10, wantErr: true},
+		{header: "bytes=-5", size: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			offset, length, ranged, err := parseRange(tt.header, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка %v, ожидалась ошибка: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, s3.ErrInvalidRange) {
+					t.Errorf("ошибка %v, ожидалась s3.ErrInvalidRange", err)
+				}
+				return
+			}
+			if offset != tt.wantOffset || length != tt.wantLength || ranged != tt.wantRanged {
+				t.Errorf("получено %d, %d, %v; ожидалось %d, %d, %v", offset, length, ranged, tt.wantOffset, tt.wantLength, tt.wantRanged)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	entry := &Entry{ETag: `"abc"`, LastModified: modified}
+	before := modified.Add(-time.Second)
+	same := modified.Truncate(time.Second)
+
+	tests := []struct {
+		name    string
+		request *s3.ObjectRequest
+		want    bool
+	}{
+		{name: "без условий", request: &s3.ObjectRequest{}},
+		{name: "совпадающий etag", request: &s3.ObjectRequest{IfNoneMatch: `"abc"`}, want: true},
+		{name: "слабый etag", request: &s3.ObjectRequest{IfNoneMatch: `W/"abc"`}, want: true},
+		{name: "список etag", request: &s3.ObjectRequest{IfNoneMatch: `"x", "abc"`}, want: true},
+		{name: "любой etag", request: &s3.ObjectRequest{IfNoneMatch: "*"}, want: true},
+		{name: "другой etag", request: &s3.ObjectRequest{IfNoneMatch: `"x"`}},
+		// If-None-Match важнее If-Modified-Since
+		{name: "другой etag и дата", request: &s3.ObjectRequest{IfNoneMatch: `"x"`, IfModifiedSince: &same}},
+		{name: "не изменён с даты", request: &s3.ObjectRequest{IfModifiedSince: &same}, want: true},
+		{name: "изменён после даты", request: &s3.ObjectRequest{IfModifiedSince: &before}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := notModified(entry, tt.request); got != tt.want {
+				t.Errorf("notModified() = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
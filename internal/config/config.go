@@ -2,6 +2,7 @@ package config
 
 import (
 	"github.com/budka-tech/configo"
+	"time"
 )
 
 type Config struct {
@@ -13,6 +14,9 @@ type Config struct {
 	S3Service       S3ServiceConfig       `yaml:"s3"`
 	ImageProcessing ImageProcessingConfig `yaml:"imageProcessing"`
 	HttpRedirect    HttpRedirectConfig    `yaml:"httpRedirect"`
+	Endpoint        EndpointConfig        `yaml:"endpoint"`
+	Cache           CacheConfig           `yaml:"cache"`
+	Metrics         MetricsConfig         `yaml:"metrics"`
 }
 
 type S3ServiceConfig struct {
@@ -23,6 +27,10 @@ type S3ServiceConfig struct {
 	RedirectFormat string `yaml:"redirectFormat" env-required:"true"`
 	FileFormat     string `yaml:"fileFormat" env-required:"true"`
 
+	// Cache-Control объектов, для которых в бакете не задан свой,
+	// ключи содержат uuid и не перезаписываются, поэтому объекты неизменяемы
+	DefaultCacheControl string `yaml:"defaultCacheControl" env-default:"public, max-age=31536000, immutable"`
+
 	// менять только если нужно
 	UploadGoroutines int   `yaml:"uploadGoroutines" env-default:"0"`
 	UploadPartSize   int64 `yaml:"uploadPartSize" env-default:"0"`
@@ -31,9 +39,109 @@ type S3ServiceConfig struct {
 type ImageProcessingConfig struct {
 	DefaultQuality float32 `yaml:"defaultQuality" env-required:"true"`
 	DefaultMaxSize int     `yaml:"defaultMaxSize" env-required:"true"`
+
+	// перевод изображений с встроенным ICC профилем (Display P3, Adobe RGB) в sRGB
+	ConvertToSRGB bool `yaml:"convertToSRGB" env-default:"true"`
+	// встраивание профиля sRGB в результат
+	EmbedSRGBProfile bool `yaml:"embedSRGBProfile" env-default:"false"`
+	// нижняя граница качества при подборе под размер файла или сходство
+	TargetMinQuality float32 `yaml:"targetMinQuality" env-default:"10"`
+	// максимальное количество пикселей всех кадров вместе, ограничивает память на декодирование анимаций, 0 - без ограничения
+	MaxPixels int64 `yaml:"maxPixels" env-default:"100000000"`
 }
 
 type HttpRedirectConfig struct {
 	Port       int    `yaml:"port" env-required:"true"`
 	PathPrefix string `yaml:"pathPrefix" env-default:""`
+
+	// сервер сам отдаёт объекты из S3 вместо перенаправления на RedirectPath,
+	// адрес S3 не раскрывается клиентам
+	Proxy bool `yaml:"proxy" env-default:"false"`
+
+	// сколько помнить, что изображение не найдено, 0 - каждый запрос проверяется в БД
+	MissingCacheTTL time.Duration `yaml:"missingCacheTtl" env-default:"10s"`
+	// максимальное количество запомненных ненайденных изображений
+	MissingCacheSize int `yaml:"missingCacheSize" env-default:"10000"`
+
+	// CORS для всех бакетов
+	Cors CorsConfig `yaml:"cors"`
+	// CORS отдельных бакетов по названию, заменяют общие настройки целиком
+	BucketCors map[string]CorsConfig `yaml:"bucketCors"`
+	// заголовки, которые добавляются ко всем ответам, например Timing-Allow-Origin или X-Content-Type-Options
+	Headers map[string]string `yaml:"headers"`
+
+	// префикс HTTP API с JSON ответами, пустое значение - API отключён
+	ApiPrefix string `yaml:"apiPrefix" env-default:""`
+	// подключать HTTP API при выключенной проверке ключей. API открыт на публичном порту redirect сервера,
+	// поэтому без проверки ключей он по умолчанию не подключается
+	ApiAllowUnauthenticated bool `yaml:"apiAllowUnauthenticated" env-default:"false"`
+	// максимальный размер тела запроса HTTP API в байтах
+	ApiMaxBodySize int64 `yaml:"apiMaxBodySize" env-default:"33554432"`
+}
+
+type CorsConfig struct {
+	// разрешённые источники, "*" - любой, пустой список - заголовки CORS не отправляются
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	// разрешённые методы, пустой список - GET и HEAD
+	AllowedMethods []string `yaml:"allowedMethods"`
+	// разрешённые заголовки запроса, "*" - любые
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	// заголовки ответа, доступные скриптам
+	ExposedHeaders []string `yaml:"exposedHeaders"`
+	// сколько браузер кеширует ответ на preflight запрос, 0 - значение браузера по умолчанию
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+type EndpointConfig struct {
+	// при переименовании бакета объекты переносятся в бакет S3 с новым названием,
+	// иначе меняется только название, а объекты остаются в прежнем бакете S3
+	RenameStorage bool `yaml:"renameStorage" env-default:"false"`
+	// количество хранимых ревизий изображения вместе с текущей
+	KeepRevisions int `yaml:"keepRevisions" env-default:"5"`
+	// сколько удалённые изображения хранятся в корзине, 0 - изображения удаляются сразу
+	TrashRetention time.Duration `yaml:"trashRetention" env-default:"720h"`
+	// как часто из корзины удаляются изображения с истёкшим сроком хранения
+	PurgeInterval time.Duration `yaml:"purgeInterval" env-default:"1h"`
+	// как часто удаляются изображения с истёкшим сроком жизни
+	ExpirySweepInterval time.Duration `yaml:"expirySweepInterval" env-default:"5m"`
+
+	// проверка ключей API в gRPC и HTTP API
+	Auth AuthConfig `yaml:"auth"`
+	// квоты владельцев бакетов по идентификатору из ключа API, владельцы без квоты не ограничены
+	TenantQuotas map[string]QuotaConfig `yaml:"tenantQuotas"`
+}
+
+type QuotaConfig struct {
+	// максимальное количество изображений, 0 - без ограничения
+	MaxImages int `yaml:"maxImages"`
+	// максимальный объём хранимых ревизий в байтах, 0 - без ограничения
+	MaxBytes int64 `yaml:"maxBytes"`
+	// максимальное количество загрузок в минуту на экземпляр s3n, 0 - без ограничения
+	MaxUploadsPerMinute int `yaml:"maxUploadsPerMinute"`
+}
+
+type AuthConfig struct {
+	// без проверки любой клиент, которому доступен порт, получает полный доступ
+	Enabled bool `yaml:"enabled" env-default:"true"`
+	// ключ с ролью admin во всех бакетах для выпуска первых ключей, пустое значение - не задан
+	RootKey string `yaml:"rootKey" env:"S3N_ROOT_KEY" env-default:""`
+	// сколько проверенный ключ хранится в памяти, ключ, отозванный на другом экземпляре, действует до конца этого срока
+	CacheTTL time.Duration `yaml:"cacheTtl" env-default:"30s"`
+}
+
+type CacheConfig struct {
+	// размер кеша объектов в памяти в байтах, 0 - кеш в памяти отключён
+	MemorySize int64 `yaml:"memorySize" env-default:"0"`
+	// каталог дискового кеша, пустое значение - дисковый кеш отключён
+	DiskDir string `yaml:"diskDir" env-default:""`
+	// размер дискового кеша в байтах
+	DiskSize int64 `yaml:"diskSize" env-default:"1073741824"`
+	// объекты больше этого размера читаются из S3 без кеширования
+	MaxObjectSize int64 `yaml:"maxObjectSize" env-default:"8388608"`
+}
+
+type MetricsConfig struct {
+	// адрес отдельного сервера метрик /debug/vars, например 127.0.0.1:9090, пустое значение - метрики не публикуются.
+	// Метрики содержат командную строку и состояние памяти процесса, поэтому адрес не должен быть доступен снаружи
+	Addr string `yaml:"addr" env-default:""`
 }
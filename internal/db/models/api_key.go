@@ -0,0 +1,23 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type ApiKey struct {
+	ID        uuid.UUID  // Уникальный идентификатор ключа
+	Name      string     // Описание владельца ключа
+	Tenant    *string    // Владелец бакетов, к которым относится ключ, nil - ключ оператора с доступом ко всем бакетам
+	KeyHash   []byte     // SHA-256 ключа, сам ключ не хранится
+	Role      *string    // Роль во всех бакетах, nil - только роли из ApiKeyGrant
+	CreatedAt time.Time  // Время выпуска ключа
+	RotatedAt *time.Time // Время последней замены ключа
+	RevokedAt *time.Time // Время отзыва ключа, nil - ключ действует
+}
+
+type ApiKeyGrant struct {
+	KeyID    uuid.UUID // Ключ, которому выдана роль
+	BucketID int16     // Бакет, в котором действует роль
+	Role     string    // Роль: read, write или admin
+}
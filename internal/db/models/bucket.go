@@ -1,6 +1,27 @@
 package models
 
+import "github.com/google/uuid"
+
 type Bucket struct {
-	ID         int16  // Уникальный идентификатор бакета
-	BucketName string // Название бакета
+	ID             int16   // Уникальный идентификатор бакета
+	BucketName     string  // Название бакета
+	StorageName    string  // Название бакета в S3, отличается от BucketName после переименования без переноса
+	TargetSize     int     // Максимальный размер изображения в байтах, 0 - качество не подбирается под размер
+	MinSSIM        float32 // Минимальное сходство с исходником, 0 - не проверяется
+	AllowDownscale bool    // Уменьшение изображения, если размер не достигается на минимальном качестве
+	CacheControl   string  // Cache-Control объектов бакета, пустая строка - значение из конфига
+	Private        bool    // Объекты бакета загружаются без публичного доступа
+	Archived       bool    // Бакет только для чтения, загрузка изображений запрещена
+	DefaultTTL     int     // Время жизни новых изображений в секундах, 0 - бессрочно
+
+	FallbackImageID *uuid.UUID // Изображение, которое отдаётся вместо отсутствующих, nil - не задано
+
+	HotlinkHosts      []string   // Хосты, которым разрешено встраивать изображения, пустой список - без ограничений
+	HotlinkAllowEmpty bool       // Запросы без Referer и Origin разрешены
+	HotlinkImageID    *uuid.UUID // Изображение вместо запрещённого, nil - ответ 403
+
+	Tenant       *string // Владелец бакета из ключа API, nil - бакет без владельца
+	QuotaImages  int     // Максимальное количество изображений, 0 - без ограничения
+	QuotaBytes   int64   // Максимальный объём хранимых ревизий в байтах, 0 - без ограничения
+	QuotaUploads int     // Максимальное количество загрузок в минуту, 0 - без ограничения
 }
@@ -2,9 +2,14 @@ package models
 
 import (
 	"github.com/google/uuid"
+	"time"
 )
 
 type Image struct {
-	ID       uuid.UUID // Уникальный идентификатор изображения
-	BucketID int16     // Внешний ключ на bucket
+	ID        uuid.UUID  // Уникальный идентификатор изображения
+	BucketID  int16      // Внешний ключ на bucket
+	Revision  int        // Номер ревизии содержимого, меняется при замене изображения
+	DeletedAt *time.Time // Время удаления в корзину, nil - изображение не удалено
+	ExpiresAt *time.Time // Время, после которого изображение удаляется, nil - бессрочно
+	Slug      *string    // Читаемый адрес изображения, уникальный в бакете, nil - не задан
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type ImageVersion struct {
+	ImageID   uuid.UUID // Изображение, к которому относится ревизия
+	Revision  int       // Номер ревизии
+	Size      int       // Размер файла ревизии в байтах
+	CreatedAt time.Time // Время загрузки ревизии
+}
+
+// UnsizedImageVersion ревизия, размер которой не записан в БД, вместе с бакетом изображения
+type UnsizedImageVersion struct {
+	ImageVersion
+	BucketID int16 // Бакет изображения, в котором хранится объект ревизии
+}
@@ -0,0 +1,6 @@
+package models
+
+type Usage struct {
+	Images int   // Количество изображений без учёта корзины
+	Bytes  int64 // Объём хранимых ревизий изображений без учёта корзины
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+type Watermark struct {
+	BucketID int16     // Бакет, к изображениям которого применяется знак
+	ImageID  uuid.UUID // Изображение знака, хранящееся в s3n
+	Position string    // Положение знака на изображении
+	Margin   int       // Отступ от краёв в пикселях
+	Opacity  float32   // Непрозрачность от 0 до 1
+	Scale    float32   // Ширина знака относительно ширины изображения
+	Tiled    bool      // Заполнение изображения знаком по сетке
+	MinSize  int       // Минимальная большая сторона изображения для наложения знака
+}
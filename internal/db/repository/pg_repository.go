@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"s3n/internal/db/models"
+	"time"
 )
 
 // PostgresRepository — реализация Repository для PostgreSQL с использованием pgxpool
@@ -18,22 +20,66 @@ func NewPostgresRepository(pool *pgxpool.Pool) Repository {
 	return &PostgresRepository{pool: pool}
 }
 
+// bucketColumns столбцы bucket в порядке, в котором их читает scanBucket
+const bucketColumns = `id, bucket_name, storage_name, target_size, min_ssim, allow_downscale, cache_control, private, archived,
+    default_ttl, fallback_image_id, hotlink_hosts, hotlink_allow_empty, hotlink_image_id, tenant, quota_images,
+    quota_bytes, quota_uploads`
+
+// scanBucket читает bucket из строки, выбранной по bucketColumns
+func scanBucket(row pgx.Row, bucket *models.Bucket) error {
+	return row.Scan(
+		&bucket.ID,
+		&bucket.BucketName,
+		&bucket.StorageName,
+		&bucket.TargetSize,
+		&bucket.MinSSIM,
+		&bucket.AllowDownscale,
+		&bucket.CacheControl,
+		&bucket.Private,
+		&bucket.Archived,
+		&bucket.DefaultTTL,
+		&bucket.FallbackImageID,
+		&bucket.HotlinkHosts,
+		&bucket.HotlinkAllowEmpty,
+		&bucket.HotlinkImageID,
+		&bucket.Tenant,
+		&bucket.QuotaImages,
+		&bucket.QuotaBytes,
+		&bucket.QuotaUploads,
+	)
+}
+
+// imageColumns столбцы image в порядке, в котором их читает scanImage
+const imageColumns = `id, bucket_id, revision, deleted_at, expires_at, slug`
+
+// scanImage читает image из строки, выбранной по imageColumns
+func scanImage(row pgx.Row, image *models.Image) error {
+	return row.Scan(
+		&image.ID,
+		&image.BucketID,
+		&image.Revision,
+		&image.DeletedAt,
+		&image.ExpiresAt,
+		&image.Slug,
+	)
+}
+
 // InsertBucket добавляет новый bucket в базу данных и возвращает его
-func (r *PostgresRepository) InsertBucket(ctx context.Context, bucketName string) (*models.Bucket, error) {
-	query := `INSERT INTO bucket (bucket_name) VALUES ($1) RETURNING id`
-	var id int16
-	err := r.pool.QueryRow(ctx, query, bucketName).Scan(&id)
+func (r *PostgresRepository) InsertBucket(ctx context.Context, bucketName string, private bool, tenant *string) (*models.Bucket, error) {
+	query := `INSERT INTO bucket (bucket_name, storage_name, private, tenant) VALUES ($1, $1, $2, $3) RETURNING ` + bucketColumns
+	var bucket models.Bucket
+	err := scanBucket(r.pool.QueryRow(ctx, query, bucketName, private, tenant), &bucket)
 	if err != nil {
 		return nil, err
 	}
-	return &models.Bucket{ID: id, BucketName: bucketName}, nil
+	return &bucket, nil
 }
 
 // GetBucketByID возвращает bucket по его ID
 func (r *PostgresRepository) GetBucketByID(ctx context.Context, id int16) (*models.Bucket, error) {
 	var bucket models.Bucket
-	query := `SELECT id, bucket_name FROM bucket WHERE id = $1`
-	err := r.pool.QueryRow(ctx, query, id).Scan(&bucket.ID, &bucket.BucketName)
+	query := `SELECT ` + bucketColumns + ` FROM bucket WHERE id = $1`
+	err := scanBucket(r.pool.QueryRow(ctx, query, id), &bucket)
 	if err != nil {
 		return nil, err
 	}
@@ -47,9 +93,51 @@ func (r *PostgresRepository) DeleteBucketByID(ctx context.Context, id int16) err
 	return err
 }
 
+// UpdateBucket сохраняет настройки бакета
+func (r *PostgresRepository) UpdateBucket(ctx context.Context, bucket *models.Bucket) error {
+	query := `
+        UPDATE bucket SET
+            target_size = $2,
+            min_ssim = $3,
+            allow_downscale = $4,
+            cache_control = $5,
+            archived = $6,
+            bucket_name = $7,
+            storage_name = $8,
+            default_ttl = $9,
+            fallback_image_id = $10,
+            hotlink_hosts = coalesce($11, '{}'),
+            hotlink_allow_empty = $12,
+            hotlink_image_id = $13,
+            quota_images = $14,
+            quota_bytes = $15,
+            quota_uploads = $16
+        WHERE id = $1
+    `
+	_, err := r.pool.Exec(ctx, query,
+		bucket.ID,
+		bucket.TargetSize,
+		bucket.MinSSIM,
+		bucket.AllowDownscale,
+		bucket.CacheControl,
+		bucket.Archived,
+		bucket.BucketName,
+		bucket.StorageName,
+		bucket.DefaultTTL,
+		bucket.FallbackImageID,
+		bucket.HotlinkHosts,
+		bucket.HotlinkAllowEmpty,
+		bucket.HotlinkImageID,
+		bucket.QuotaImages,
+		bucket.QuotaBytes,
+		bucket.QuotaUploads,
+	)
+	return err
+}
+
 // GetAllBuckets возвращает список всех бакетов с ограничением на количество
 func (r *PostgresRepository) GetAllBuckets(ctx context.Context, limit int) ([]models.Bucket, error) {
-	query := `SELECT id, bucket_name FROM bucket LIMIT $1`
+	query := `SELECT ` + bucketColumns + ` FROM bucket LIMIT $1`
 	rows, err := r.pool.Query(ctx, query, limit)
 	if err != nil {
 		return nil, err
@@ -59,7 +147,7 @@ func (r *PostgresRepository) GetAllBuckets(ctx context.Context, limit int) ([]mo
 	var buckets []models.Bucket
 	for rows.Next() {
 		var bucket models.Bucket
-		if err := rows.Scan(&bucket.ID, &bucket.BucketName); err != nil {
+		if err := scanBucket(rows, &bucket); err != nil {
 			return nil, err
 		}
 		buckets = append(buckets, bucket)
@@ -72,55 +160,143 @@ func (r *PostgresRepository) GetAllBuckets(ctx context.Context, limit int) ([]mo
 }
 
 // InsertImage добавляет новое изображение в базу данных и возвращает его
-func (r *PostgresRepository) InsertImage(ctx context.Context, bucketID int16) (*models.Image, error) {
-	query := `INSERT INTO image (bucket_id) VALUES ($1) RETURNING id`
+func (r *PostgresRepository) InsertImage(ctx context.Context, bucketID int16, expiresAt *time.Time, slug *string) (*models.Image, error) {
+	query := `INSERT INTO image (bucket_id, expires_at, slug) VALUES ($1, $2, $3) RETURNING id`
 	var id uuid.UUID
-	err := r.pool.QueryRow(ctx, query, bucketID).Scan(&id)
+	err := r.pool.QueryRow(ctx, query, bucketID, expiresAt, slug).Scan(&id)
 	if err != nil {
 		return nil, err
 	}
-	return &models.Image{ID: id, BucketID: bucketID}, nil
+	return &models.Image{ID: id, BucketID: bucketID, ExpiresAt: expiresAt, Slug: slug}, nil
 }
 
-func (r *PostgresRepository) AddImage(ctx context.Context, bucketId int16, id uuid.UUID) error {
-	query := `INSERT INTO image (bucket_id, id) VALUES ($1, $2) RETURNING id`
-	err := r.pool.QueryRow(ctx, query, bucketId, id).Scan(&id)
+func (r *PostgresRepository) AddImage(ctx context.Context, bucketId int16, id uuid.UUID, expiresAt *time.Time) error {
+	query := `INSERT INTO image (bucket_id, id, expires_at) VALUES ($1, $2, $3) RETURNING id`
+	err := r.pool.QueryRow(ctx, query, bucketId, id, expiresAt).Scan(&id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// InsertImages добавляет изображения одним запросом и возвращает ID добавленных,
+// изображения с уже занятым ID или адресом в бакете пропускаются
+func (r *PostgresRepository) InsertImages(ctx context.Context, images []models.Image) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, 0, len(images))
+	bucketIDs := make([]int16, 0, len(images))
+	expiresAt := make([]*time.Time, 0, len(images))
+	slugs := make([]*string, 0, len(images))
+	for _, image := range images {
+		ids = append(ids, image.ID)
+		bucketIDs = append(bucketIDs, image.BucketID)
+		expiresAt = append(expiresAt, image.ExpiresAt)
+		slugs = append(slugs, image.Slug)
+	}
+
+	query := `
+        INSERT INTO image (id, bucket_id, expires_at, slug)
+        SELECT * FROM unnest($1::uuid[], $2::smallint[], $3::timestamptz[], $4::varchar[])
+        ON CONFLICT DO NOTHING
+        RETURNING id
+    `
+	return r.queryIDs(ctx, query, ids, bucketIDs, expiresAt, slugs)
+}
+
+// queryIDs выполняет запрос, возвращающий столбец ID изображений
+func (r *PostgresRepository) queryIDs(ctx context.Context, query string, args ...any) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 // GetImageByID возвращает изображение по его ID
 func (r *PostgresRepository) GetImageByID(ctx context.Context, id uuid.UUID) (*models.Image, error) {
 	var image models.Image
-	query := `SELECT id, bucket_id FROM image WHERE id = $1`
-	err := r.pool.QueryRow(ctx, query, id).Scan(&image.ID, &image.BucketID)
+	query := `SELECT ` + imageColumns + ` FROM image WHERE id = $1 AND deleted_at IS NULL`
+	err := scanImage(r.pool.QueryRow(ctx, query, id), &image)
 	if err != nil {
 		return nil, err
 	}
 	return &image, nil
 }
 
+// GetImagesByIDs возвращает изображения по списку ID, отсутствующие и удалённые в корзину пропускаются
+func (r *PostgresRepository) GetImagesByIDs(ctx context.Context, ids []uuid.UUID) ([]models.Image, error) {
+	query := `SELECT ` + imageColumns + ` FROM image WHERE id = ANY($1) AND deleted_at IS NULL`
+	rows, err := r.pool.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []models.Image
+	for rows.Next() {
+		var image models.Image
+		if err := scanImage(rows, &image); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
 // GetImageWithBucket извлекает изображение с данными о бакете по ID изображения
 func (r *PostgresRepository) GetImageWithBucket(ctx context.Context, id uuid.UUID) (*models.Image, *models.Bucket, error) {
+	return r.getImageWithBucket(ctx, id, `i.deleted_at IS NULL`)
+}
+
+// GetTrashedImageWithBucket возвращает удалённое в корзину изображение вместе с бакетом
+func (r *PostgresRepository) GetTrashedImageWithBucket(ctx context.Context, id uuid.UUID) (*models.Image, *models.Bucket, error) {
+	return r.getImageWithBucket(ctx, id, `i.deleted_at IS NOT NULL`)
+}
+
+func (r *PostgresRepository) getImageWithBucket(ctx context.Context, id uuid.UUID, condition string) (*models.Image, *models.Bucket, error) {
 	query := `
         SELECT 
             i.id, 
             i.bucket_id, 
+            i.revision,
+            i.deleted_at,
+            i.expires_at,
+            i.slug,
             b.id, 
-            b.bucket_name
+            b.bucket_name,
+            b.storage_name
         FROM image i
         JOIN bucket b ON i.bucket_id = b.id
-        WHERE i.id = $1
-    `
+        WHERE i.id = $1 AND ` + condition
 	var image models.Image
 	var bucket models.Bucket
 	err := r.pool.QueryRow(ctx, query, id).Scan(
 		&image.ID,
 		&image.BucketID,
+		&image.Revision,
+		&image.DeletedAt,
+		&image.ExpiresAt,
+		&image.Slug,
 		&bucket.ID,
 		&bucket.BucketName,
+		&bucket.StorageName,
 	)
 	if err != nil {
 		if err.Error() == "no rows in result set" {
@@ -138,9 +314,212 @@ func (r *PostgresRepository) DeleteImageByID(ctx context.Context, id uuid.UUID)
 	return err
 }
 
+// ReserveImageRevision выделяет изображению номер ревизии, который не выдавался другим запросам,
+// поэтому ключ объекта этой ревизии принадлежит только получившему его запросу
+func (r *PostgresRepository) ReserveImageRevision(ctx context.Context, id uuid.UUID) (int, error) {
+	query := `UPDATE image SET last_revision = greatest(last_revision, revision) + 1 WHERE id = $1 RETURNING last_revision`
+	var revision int
+	err := r.pool.QueryRow(ctx, query, id).Scan(&revision)
+	if err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// SetImageRevision меняет ревизию изображения, если текущая ревизия равна expected, а изображение
+// всё ещё в бакете bucketID. Возвращает false, если изображение было изменено или перенесено другим запросом
+func (r *PostgresRepository) SetImageRevision(ctx context.Context, id uuid.UUID, bucketID int16, expected int, revision int) (bool, error) {
+	query := `UPDATE image SET revision = $4 WHERE id = $1 AND bucket_id = $2 AND revision = $3`
+	tag, err := r.pool.Exec(ctx, query, id, bucketID, expected, revision)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// MoveImage переносит изображение в другой бакет, если оно всё ещё находится в исходном с ревизией revision.
+// Возвращает false, если изображение не найдено в исходном бакете или было заменено
+func (r *PostgresRepository) MoveImage(ctx context.Context, id uuid.UUID, fromBucketID int16, toBucketID int16, revision int) (bool, error) {
+	// адрес сохраняется, если он не занят в новом бакете
+	query := `
+        UPDATE image i SET
+            bucket_id = $3,
+            slug = CASE
+                WHEN EXISTS (SELECT 1 FROM image o WHERE o.bucket_id = $3 AND o.slug = i.slug) THEN NULL
+                ELSE i.slug
+            END
+        WHERE i.id = $1 AND i.bucket_id = $2 AND i.revision = $4
+    `
+	tag, err := r.pool.Exec(ctx, query, id, fromBucketID, toBucketID, revision)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetImageBySlug возвращает изображение бакета по текущему адресу, включая удалённые в корзину
+func (r *PostgresRepository) GetImageBySlug(ctx context.Context, bucketID int16, slug string) (*models.Image, error) {
+	var image models.Image
+	query := `SELECT ` + imageColumns + ` FROM image WHERE bucket_id = $1 AND slug = $2`
+	err := scanImage(r.pool.QueryRow(ctx, query, bucketID, slug), &image)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// GetImageByAlias возвращает изображение бакета по одному из прежних адресов
+func (r *PostgresRepository) GetImageByAlias(ctx context.Context, bucketID int16, slug string) (*models.Image, error) {
+	var image models.Image
+	query := `
+        SELECT ` + imageColumns + ` FROM image
+        WHERE id = (SELECT image_id FROM image_alias WHERE bucket_id = $1 AND slug = $2) AND bucket_id = $1
+    `
+	err := scanImage(r.pool.QueryRow(ctx, query, bucketID, slug), &image)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// SetImageSlug меняет адрес изображения, nil - адрес удаляется. Прежний адрес сохраняется в image_alias,
+// чтобы по нему можно было найти изображение. Возвращает false, если адрес занят другим изображением бакета
+func (r *PostgresRepository) SetImageSlug(ctx context.Context, id uuid.UUID, slug *string) (bool, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var bucketID int16
+	var previous *string
+	query := `SELECT bucket_id, slug FROM image WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, id).Scan(&bucketID, &previous)
+	if err != nil {
+		return false, err
+	}
+	if previous == nil && slug == nil || previous != nil && slug != nil && *previous == *slug {
+		return true, nil
+	}
+
+	query = `
+        UPDATE image SET slug = $2
+        WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM image WHERE bucket_id = $3 AND slug = $2)
+    `
+	tag, err := tx.Exec(ctx, query, id, slug, bucketID)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	// прежний адрес другого изображения переходит к этому, текущий адрес важнее прежних при поиске
+	if previous != nil {
+		query = `
+            INSERT INTO image_alias (bucket_id, slug, image_id) VALUES ($1, $2, $3)
+            ON CONFLICT (bucket_id, slug) DO UPDATE SET image_id = excluded.image_id
+        `
+		_, err = tx.Exec(ctx, query, bucketID, *previous, id)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TrashImage помечает изображение удалённым, false - изображение не найдено или уже удалено
+func (r *PostgresRepository) TrashImage(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `UPDATE image SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL`
+	tag, err := r.pool.Exec(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RestoreImage снимает пометку удаления, false - изображения нет в корзине
+func (r *PostgresRepository) RestoreImage(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `UPDATE image SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`
+	tag, err := r.pool.Exec(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetTrashedImages возвращает изображения, удалённые в корзину раньше deletedBefore
+func (r *PostgresRepository) GetTrashedImages(ctx context.Context, deletedBefore time.Time, limit int) ([]models.Image, error) {
+	query := `SELECT ` + imageColumns + ` FROM image WHERE deleted_at < $1 ORDER BY deleted_at LIMIT $2`
+	rows, err := r.pool.Query(ctx, query, deletedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []models.Image
+	for rows.Next() {
+		var image models.Image
+		if err := scanImage(rows, &image); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// PurgeImages удаляет изображения из корзины и возвращает ID удалённых,
+// изображения, восстановленные после выборки, не удаляются
+func (r *PostgresRepository) PurgeImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	query := `DELETE FROM image WHERE id = ANY($1) AND deleted_at IS NOT NULL RETURNING id`
+	return r.queryIDs(ctx, query, ids)
+}
+
+// TrashImages помечает изображения удалёнными и возвращает ID помеченных,
+// отсутствующие и уже удалённые изображения пропускаются
+func (r *PostgresRepository) TrashImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	query := `UPDATE image SET deleted_at = now() WHERE id = ANY($1) AND deleted_at IS NULL RETURNING id`
+	return r.queryIDs(ctx, query, ids)
+}
+
+// DeleteActiveImages удаляет изображения, не находящиеся в корзине, и возвращает ID удалённых.
+// Изображения, используемые как водяной знак, не удаляются
+func (r *PostgresRepository) DeleteActiveImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+        DELETE FROM image
+        WHERE id = ANY($1) AND deleted_at IS NULL AND id NOT IN (SELECT image_id FROM watermark)
+        RETURNING id
+    `
+	return r.queryIDs(ctx, query, ids)
+}
+
+// DeleteImagesByIDs удаляет изображения по списку ID
+func (r *PostgresRepository) DeleteImagesByIDs(ctx context.Context, ids []uuid.UUID) error {
+	query := `DELETE FROM image WHERE id = ANY($1)`
+	_, err := r.pool.Exec(ctx, query, ids)
+	return err
+}
+
+// CountImagesByBucketID возвращает количество изображений в бакете
+func (r *PostgresRepository) CountImagesByBucketID(ctx context.Context, bucketID int16) (int, error) {
+	query := `SELECT count(*) FROM image WHERE bucket_id = $1`
+	var count int
+	err := r.pool.QueryRow(ctx, query, bucketID).Scan(&count)
+	return count, err
+}
+
 // GetAllImages возвращает список всех изображений с ограничением на количество
 func (r *PostgresRepository) GetAllImages(ctx context.Context, limit int) ([]models.Image, error) {
-	query := `SELECT id, bucket_id FROM image LIMIT $1`
+	query := `SELECT ` + imageColumns + ` FROM image WHERE deleted_at IS NULL LIMIT $1`
 	rows, err := r.pool.Query(ctx, query, limit)
 	if err != nil {
 		return nil, err
@@ -150,7 +529,7 @@ func (r *PostgresRepository) GetAllImages(ctx context.Context, limit int) ([]mod
 	var images []models.Image
 	for rows.Next() {
 		var image models.Image
-		if err := rows.Scan(&image.ID, &image.BucketID); err != nil {
+		if err := scanImage(rows, &image); err != nil {
 			return nil, err
 		}
 		images = append(images, image)
@@ -162,9 +541,49 @@ func (r *PostgresRepository) GetAllImages(ctx context.Context, limit int) ([]mod
 	return images, nil
 }
 
+// GetImagesByBucketIDs возвращает изображения из нескольких бакетов с ограничением на количество
+func (r *PostgresRepository) GetImagesByBucketIDs(ctx context.Context, bucketIDs []int16, limit int) ([]models.Image, error) {
+	query := `SELECT ` + imageColumns + ` FROM image WHERE bucket_id = ANY($1) AND deleted_at IS NULL LIMIT $2`
+	rows, err := r.pool.Query(ctx, query, bucketIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []models.Image
+	for rows.Next() {
+		var image models.Image
+		if err := scanImage(rows, &image); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// GetUsageByBucketIDs считает изображения и объём хранимых ревизий в бакетах, изображения в корзине не учитываются
+func (r *PostgresRepository) GetUsageByBucketIDs(ctx context.Context, bucketIDs []int16) (*models.Usage, error) {
+	query := `
+        SELECT
+            (SELECT count(*) FROM image WHERE bucket_id = ANY($1) AND deleted_at IS NULL),
+            (SELECT coalesce(sum(v.size), 0) FROM image_version v JOIN image i ON i.id = v.image_id
+             WHERE i.bucket_id = ANY($1) AND i.deleted_at IS NULL)
+    `
+	var usage models.Usage
+	err := r.pool.QueryRow(ctx, query, bucketIDs).Scan(&usage.Images, &usage.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
 // GetImagesByBucketID возвращает список изображений для заданного bucketID с ограничением на количество
 func (r *PostgresRepository) GetImagesByBucketID(ctx context.Context, bucketID int16, limit int) ([]models.Image, error) {
-	query := `SELECT id, bucket_id FROM image WHERE bucket_id = $1 LIMIT $2`
+	query := `SELECT ` + imageColumns + ` FROM image WHERE bucket_id = $1 AND deleted_at IS NULL LIMIT $2`
 	rows, err := r.pool.Query(ctx, query, bucketID, limit)
 	if err != nil {
 		return nil, err
@@ -174,7 +593,60 @@ func (r *PostgresRepository) GetImagesByBucketID(ctx context.Context, bucketID i
 	var images []models.Image
 	for rows.Next() {
 		var image models.Image
-		if err := rows.Scan(&image.ID, &image.BucketID); err != nil {
+		if err := scanImage(rows, &image); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// GetImagesByBucketIDAfter возвращает изображения бакета с ID больше after в порядке ID для постраничного обхода
+func (r *PostgresRepository) GetImagesByBucketIDAfter(ctx context.Context, bucketID int16, after uuid.UUID, limit int) ([]models.Image, error) {
+	query := `SELECT ` + imageColumns + ` FROM image WHERE bucket_id = $1 AND id > $2 ORDER BY id LIMIT $3`
+	rows, err := r.pool.Query(ctx, query, bucketID, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []models.Image
+	for rows.Next() {
+		var image models.Image
+		if err := scanImage(rows, &image); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// GetExpiredImages возвращает изображения с истёкшим сроком жизни, кроме используемых как водяной знак
+func (r *PostgresRepository) GetExpiredImages(ctx context.Context, now time.Time, limit int) ([]models.Image, error) {
+	query := `
+        SELECT ` + imageColumns + ` FROM image
+        WHERE expires_at <= $1 AND id NOT IN (SELECT image_id FROM watermark)
+        ORDER BY expires_at
+        LIMIT $2
+    `
+	rows, err := r.pool.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []models.Image
+	for rows.Next() {
+		var image models.Image
+		if err := scanImage(rows, &image); err != nil {
 			return nil, err
 		}
 		images = append(images, image)
@@ -185,3 +657,324 @@ func (r *PostgresRepository) GetImagesByBucketID(ctx context.Context, bucketID i
 	}
 	return images, nil
 }
+
+// DeleteExpiredImages удаляет изображения с истёкшим сроком жизни и возвращает ID удалённых,
+// изображения, срок которых продлён после выборки, не удаляются
+func (r *PostgresRepository) DeleteExpiredImages(ctx context.Context, ids []uuid.UUID, now time.Time) ([]uuid.UUID, error) {
+	query := `
+        DELETE FROM image
+        WHERE id = ANY($1) AND expires_at <= $2 AND id NOT IN (SELECT image_id FROM watermark)
+        RETURNING id
+    `
+	return r.queryIDs(ctx, query, ids, now)
+}
+
+// InsertImageVersion добавляет запись о ревизии изображения
+func (r *PostgresRepository) InsertImageVersion(ctx context.Context, version *models.ImageVersion) error {
+	query := `
+        INSERT INTO image_version (image_id, revision, size)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (image_id, revision) DO UPDATE SET size = EXCLUDED.size
+    `
+	_, err := r.pool.Exec(ctx, query, version.ImageID, version.Revision, version.Size)
+	return err
+}
+
+// InsertImageVersions добавляет записи о ревизиях изображений одним запросом
+func (r *PostgresRepository) InsertImageVersions(ctx context.Context, versions []models.ImageVersion) error {
+	imageIDs := make([]uuid.UUID, 0, len(versions))
+	revisions := make([]int, 0, len(versions))
+	sizes := make([]int, 0, len(versions))
+	for _, version := range versions {
+		imageIDs = append(imageIDs, version.ImageID)
+		revisions = append(revisions, version.Revision)
+		sizes = append(sizes, version.Size)
+	}
+
+	query := `
+        INSERT INTO image_version (image_id, revision, size)
+        SELECT * FROM unnest($1::uuid[], $2::integer[], $3::integer[])
+        ON CONFLICT (image_id, revision) DO UPDATE SET size = EXCLUDED.size
+    `
+	_, err := r.pool.Exec(ctx, query, imageIDs, revisions, sizes)
+	return err
+}
+
+// GetImageVersion возвращает ревизию изображения
+func (r *PostgresRepository) GetImageVersion(ctx context.Context, imageID uuid.UUID, revision int) (*models.ImageVersion, error) {
+	query := `SELECT image_id, revision, size, created_at FROM image_version WHERE image_id = $1 AND revision = $2`
+	var version models.ImageVersion
+	err := r.pool.QueryRow(ctx, query, imageID, revision).Scan(
+		&version.ImageID,
+		&version.Revision,
+		&version.Size,
+		&version.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// GetImageVersions возвращает ревизии изображений, начиная с последней
+func (r *PostgresRepository) GetImageVersions(ctx context.Context, imageIDs []uuid.UUID) ([]models.ImageVersion, error) {
+	query := `
+        SELECT image_id, revision, size, created_at
+        FROM image_version
+        WHERE image_id = ANY($1)
+        ORDER BY image_id, revision DESC
+    `
+	rows, err := r.pool.Query(ctx, query, imageIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.ImageVersion
+	for rows.Next() {
+		var version models.ImageVersion
+		if err := rows.Scan(&version.ImageID, &version.Revision, &version.Size, &version.CreatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// DeleteImageVersions удаляет записи о ревизиях изображения
+func (r *PostgresRepository) DeleteImageVersions(ctx context.Context, imageID uuid.UUID, revisions []int) error {
+	query := `DELETE FROM image_version WHERE image_id = $1 AND revision = ANY($2)`
+	_, err := r.pool.Exec(ctx, query, imageID, revisions)
+	return err
+}
+
+// GetUnsizedImageVersions возвращает ревизии с размером 0 после ревизии (afterID, afterRevision) по порядку ключа
+func (r *PostgresRepository) GetUnsizedImageVersions(ctx context.Context, afterID uuid.UUID, afterRevision int, limit int) ([]models.UnsizedImageVersion, error) {
+	query := `
+        SELECT v.image_id, v.revision, v.size, v.created_at, i.bucket_id
+        FROM image_version v
+        JOIN image i ON i.id = v.image_id
+        WHERE v.size = 0 AND (v.image_id, v.revision) > ($1, $2)
+        ORDER BY v.image_id, v.revision
+        LIMIT $3
+    `
+	rows, err := r.pool.Query(ctx, query, afterID, afterRevision, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.UnsizedImageVersion
+	for rows.Next() {
+		var version models.UnsizedImageVersion
+		if err := rows.Scan(&version.ImageID, &version.Revision, &version.Size, &version.CreatedAt, &version.BucketID); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// SetImageVersionSize записывает размер ревизии, если он ещё не записан
+func (r *PostgresRepository) SetImageVersionSize(ctx context.Context, imageID uuid.UUID, revision int, size int) error {
+	query := `UPDATE image_version SET size = $3 WHERE image_id = $1 AND revision = $2 AND size = 0`
+	_, err := r.pool.Exec(ctx, query, imageID, revision, size)
+	return err
+}
+
+// UpsertWatermark сохраняет настройки водяного знака бакета, заменяя существующие
+func (r *PostgresRepository) UpsertWatermark(ctx context.Context, watermark *models.Watermark) error {
+	query := `
+        INSERT INTO watermark (bucket_id, image_id, position, margin, opacity, scale, tiled, min_size)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ON CONFLICT (bucket_id) DO UPDATE SET
+            image_id = excluded.image_id,
+            position = excluded.position,
+            margin = excluded.margin,
+            opacity = excluded.opacity,
+            scale = excluded.scale,
+            tiled = excluded.tiled,
+            min_size = excluded.min_size
+    `
+	_, err := r.pool.Exec(ctx, query,
+		watermark.BucketID,
+		watermark.ImageID,
+		watermark.Position,
+		watermark.Margin,
+		watermark.Opacity,
+		watermark.Scale,
+		watermark.Tiled,
+		watermark.MinSize,
+	)
+	return err
+}
+
+// DeleteWatermarkByBucketID удаляет водяной знак бакета
+func (r *PostgresRepository) DeleteWatermarkByBucketID(ctx context.Context, bucketID int16) error {
+	query := `DELETE FROM watermark WHERE bucket_id = $1`
+	_, err := r.pool.Exec(ctx, query, bucketID)
+	return err
+}
+
+// GetAllWatermarks возвращает настройки водяных знаков всех бакетов
+func (r *PostgresRepository) GetAllWatermarks(ctx context.Context) ([]models.Watermark, error) {
+	query := `SELECT bucket_id, image_id, position, margin, opacity, scale, tiled, min_size FROM watermark`
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var watermarks []models.Watermark
+	for rows.Next() {
+		var watermark models.Watermark
+		if err := rows.Scan(
+			&watermark.BucketID,
+			&watermark.ImageID,
+			&watermark.Position,
+			&watermark.Margin,
+			&watermark.Opacity,
+			&watermark.Scale,
+			&watermark.Tiled,
+			&watermark.MinSize,
+		); err != nil {
+			return nil, err
+		}
+		watermarks = append(watermarks, watermark)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return watermarks, nil
+}
+
+// GetImageBucketIDs возвращает бакеты изображений, включая удалённые в корзину
+func (r *PostgresRepository) GetImageBucketIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]int16, error) {
+	query := `SELECT id, bucket_id FROM image WHERE id = ANY($1)`
+	rows, err := r.pool.Query(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bucketIDs := map[uuid.UUID]int16{}
+	for rows.Next() {
+		var id uuid.UUID
+		var bucketID int16
+		if err := rows.Scan(&id, &bucketID); err != nil {
+			return nil, err
+		}
+		bucketIDs[id] = bucketID
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return bucketIDs, nil
+}
+
+const apiKeyColumns = `id, name, tenant, key_hash, role, created_at, rotated_at, revoked_at`
+
+// InsertApiKey добавляет ключ API вместе с ролями в бакетах
+func (r *PostgresRepository) InsertApiKey(ctx context.Context, key *models.ApiKey, grants []models.ApiKeyGrant) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO api_key (id, name, tenant, key_hash, role) VALUES ($1, $2, $3, $4, $5) RETURNING created_at`
+	err = tx.QueryRow(ctx, query, key.ID, key.Name, key.Tenant, key.KeyHash, key.Role).Scan(&key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, grant := range grants {
+		query = `INSERT INTO api_key_grant (key_id, bucket_id, role) VALUES ($1, $2, $3)`
+		_, err = tx.Exec(ctx, query, key.ID, grant.BucketID, grant.Role)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetApiKeyByID возвращает ключ API с ролями в бакетах
+func (r *PostgresRepository) GetApiKeyByID(ctx context.Context, id uuid.UUID) (*models.ApiKey, []models.ApiKeyGrant, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_key WHERE id = $1`
+	return r.getApiKey(ctx, query, id)
+}
+
+// GetActiveApiKeyByHash возвращает неотозванный ключ API по хешу с ролями в бакетах
+func (r *PostgresRepository) GetActiveApiKeyByHash(ctx context.Context, keyHash []byte) (*models.ApiKey, []models.ApiKeyGrant, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_key WHERE key_hash = $1 AND revoked_at IS NULL`
+	return r.getApiKey(ctx, query, keyHash)
+}
+
+func (r *PostgresRepository) getApiKey(ctx context.Context, query string, arg any) (*models.ApiKey, []models.ApiKeyGrant, error) {
+	var key models.ApiKey
+	err := r.pool.QueryRow(ctx, query, arg).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Tenant,
+		&key.KeyHash,
+		&key.Role,
+		&key.CreatedAt,
+		&key.RotatedAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query = `SELECT key_id, bucket_id, role FROM api_key_grant WHERE key_id = $1`
+	rows, err := r.pool.Query(ctx, query, key.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var grants []models.ApiKeyGrant
+	for rows.Next() {
+		var grant models.ApiKeyGrant
+		if err := rows.Scan(&grant.KeyID, &grant.BucketID, &grant.Role); err != nil {
+			return nil, nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return &key, grants, nil
+}
+
+// RotateApiKey заменяет хеш неотозванного ключа API, false - ключ не найден или отозван
+func (r *PostgresRepository) RotateApiKey(ctx context.Context, id uuid.UUID, keyHash []byte) (bool, error) {
+	query := `UPDATE api_key SET key_hash = $2, rotated_at = now() WHERE id = $1 AND revoked_at IS NULL`
+	tag, err := r.pool.Exec(ctx, query, id, keyHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeApiKey отзывает ключ API, false - ключ не найден или уже отозван
+func (r *PostgresRepository) RevokeApiKey(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `UPDATE api_key SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`
+	tag, err := r.pool.Exec(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
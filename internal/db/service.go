@@ -5,6 +5,7 @@ import (
 	"github.com/google/uuid"
 	"s3n/internal/db/models"
 	"s3n/internal/db/repository"
+	"time"
 )
 
 // DBService использует репозиторий для операций с bucket
@@ -18,8 +19,8 @@ func NewDBService(repo repository.Repository) Service {
 }
 
 // CreateBucket создает новый бакет
-func (s *DBService) CreateBucket(ctx context.Context, bucketName string) (*models.Bucket, error) {
-	return s.repo.InsertBucket(ctx, bucketName)
+func (s *DBService) CreateBucket(ctx context.Context, bucketName string, private bool, tenant *string) (*models.Bucket, error) {
+	return s.repo.InsertBucket(ctx, bucketName, private, tenant)
 }
 
 // GetBucket получает бакет по ID
@@ -27,6 +28,11 @@ func (s *DBService) GetBucket(ctx context.Context, id int16) (*models.Bucket, er
 	return s.repo.GetBucketByID(ctx, id)
 }
 
+// UpdateBucket сохраняет настройки бакета
+func (s *DBService) UpdateBucket(ctx context.Context, bucket *models.Bucket) error {
+	return s.repo.UpdateBucket(ctx, bucket)
+}
+
 // DeleteBucket удаляет бакет по ID
 func (s *DBService) DeleteBucket(ctx context.Context, id int16) error {
 	return s.repo.DeleteBucketByID(ctx, id)
@@ -38,12 +44,18 @@ func (s *DBService) GetAllBuckets(ctx context.Context, limit int) ([]models.Buck
 }
 
 // CreateImage создает новое изображение в указанном бакете
-func (s *DBService) CreateImage(ctx context.Context, bucketID int16) (*models.Image, error) {
-	return s.repo.InsertImage(ctx, bucketID)
+func (s *DBService) CreateImage(ctx context.Context, bucketID int16, expiresAt *time.Time, slug *string) (*models.Image, error) {
+	return s.repo.InsertImage(ctx, bucketID, expiresAt, slug)
 }
 
-func (s *DBService) AddImage(ctx context.Context, bucketID int16, id uuid.UUID) error {
-	return s.repo.AddImage(ctx, bucketID, id)
+func (s *DBService) AddImage(ctx context.Context, bucketID int16, id uuid.UUID, expiresAt *time.Time) error {
+	return s.repo.AddImage(ctx, bucketID, id, expiresAt)
+}
+
+// CreateImages добавляет изображения с заданными ID и возвращает ID добавленных,
+// изображения с занятым ID или адресом не добавляются
+func (s *DBService) CreateImages(ctx context.Context, images []models.Image) ([]uuid.UUID, error) {
+	return s.repo.InsertImages(ctx, images)
 }
 
 // GetImage получает изображение по ID
@@ -51,16 +63,106 @@ func (s *DBService) GetImage(ctx context.Context, id uuid.UUID) (*models.Image,
 	return s.repo.GetImageByID(ctx, id)
 }
 
+// GetImages получает изображения по списку ID
+func (s *DBService) GetImages(ctx context.Context, ids []uuid.UUID) ([]models.Image, error) {
+	return s.repo.GetImagesByIDs(ctx, ids)
+}
+
 // GetImageWithBucket получает изображение по ID
 func (s *DBService) GetImageWithBucket(ctx context.Context, id uuid.UUID) (*models.Image, *models.Bucket, error) {
 	return s.repo.GetImageWithBucket(ctx, id)
 }
 
+// GetTrashedImageWithBucket получает удалённое в корзину изображение по ID
+func (s *DBService) GetTrashedImageWithBucket(ctx context.Context, id uuid.UUID) (*models.Image, *models.Bucket, error) {
+	return s.repo.GetTrashedImageWithBucket(ctx, id)
+}
+
+// GetImageBySlug получает изображение бакета по текущему адресу
+func (s *DBService) GetImageBySlug(ctx context.Context, bucketID int16, slug string) (*models.Image, error) {
+	return s.repo.GetImageBySlug(ctx, bucketID, slug)
+}
+
+// GetImageByAlias получает изображение бакета по прежнему адресу
+func (s *DBService) GetImageByAlias(ctx context.Context, bucketID int16, slug string) (*models.Image, error) {
+	return s.repo.GetImageByAlias(ctx, bucketID, slug)
+}
+
+// SetImageSlug меняет адрес изображения, false - адрес занят
+func (s *DBService) SetImageSlug(ctx context.Context, id uuid.UUID, slug *string) (bool, error) {
+	return s.repo.SetImageSlug(ctx, id, slug)
+}
+
+// TrashImage помечает изображение удалённым, false - изображение не найдено
+func (s *DBService) TrashImage(ctx context.Context, id uuid.UUID) (bool, error) {
+	return s.repo.TrashImage(ctx, id)
+}
+
+// TrashImages помечает изображения удалёнными и возвращает ID помеченных
+func (s *DBService) TrashImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	return s.repo.TrashImages(ctx, ids)
+}
+
+// RestoreImage восстанавливает изображение из корзины, false - изображения нет в корзине
+func (s *DBService) RestoreImage(ctx context.Context, id uuid.UUID) (bool, error) {
+	return s.repo.RestoreImage(ctx, id)
+}
+
+// GetTrashedImages получает изображения, удалённые в корзину раньше deletedBefore
+func (s *DBService) GetTrashedImages(ctx context.Context, deletedBefore time.Time, limit int) ([]models.Image, error) {
+	return s.repo.GetTrashedImages(ctx, deletedBefore, limit)
+}
+
+// PurgeImages окончательно удаляет изображения из корзины и возвращает ID удалённых
+func (s *DBService) PurgeImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	return s.repo.PurgeImages(ctx, ids)
+}
+
+// GetExpiredImages получает изображения с истёкшим сроком жизни
+func (s *DBService) GetExpiredImages(ctx context.Context, now time.Time, limit int) ([]models.Image, error) {
+	return s.repo.GetExpiredImages(ctx, now, limit)
+}
+
+// DeleteExpiredImages удаляет изображения с истёкшим сроком жизни и возвращает ID удалённых
+func (s *DBService) DeleteExpiredImages(ctx context.Context, ids []uuid.UUID, now time.Time) ([]uuid.UUID, error) {
+	return s.repo.DeleteExpiredImages(ctx, ids, now)
+}
+
 // DeleteImage удаляет изображение по ID
 func (s *DBService) DeleteImage(ctx context.Context, id uuid.UUID) error {
 	return s.repo.DeleteImageByID(ctx, id)
 }
 
+// ReserveImageRevision выделяет изображению новый номер ревизии, уникальный для каждого вызова
+func (s *DBService) ReserveImageRevision(ctx context.Context, id uuid.UUID) (int, error) {
+	return s.repo.ReserveImageRevision(ctx, id)
+}
+
+// SetImageRevision меняет ревизию изображения, false - изображение изменено или перенесено другим запросом
+func (s *DBService) SetImageRevision(ctx context.Context, id uuid.UUID, bucketID int16, expected int, revision int) (bool, error) {
+	return s.repo.SetImageRevision(ctx, id, bucketID, expected, revision)
+}
+
+// MoveImage переносит изображение в другой бакет, false - изображения нет в исходном бакете или оно заменено
+func (s *DBService) MoveImage(ctx context.Context, id uuid.UUID, fromBucketID int16, toBucketID int16, revision int) (bool, error) {
+	return s.repo.MoveImage(ctx, id, fromBucketID, toBucketID, revision)
+}
+
+// DeleteImages удаляет изображения по списку ID
+func (s *DBService) DeleteImages(ctx context.Context, ids []uuid.UUID) error {
+	return s.repo.DeleteImagesByIDs(ctx, ids)
+}
+
+// DeleteActiveImages удаляет изображения не из корзины и возвращает ID удалённых
+func (s *DBService) DeleteActiveImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	return s.repo.DeleteActiveImages(ctx, ids)
+}
+
+// CountImagesInBucket возвращает количество изображений в бакете
+func (s *DBService) CountImagesInBucket(ctx context.Context, bucketID int16) (int, error) {
+	return s.repo.CountImagesByBucketID(ctx, bucketID)
+}
+
 // GetAllImages получает все изображения с лимитом на количество
 func (s *DBService) GetAllImages(ctx context.Context, limit int) ([]models.Image, error) {
 	return s.repo.GetAllImages(ctx, limit)
@@ -70,3 +172,98 @@ func (s *DBService) GetAllImages(ctx context.Context, limit int) ([]models.Image
 func (s *DBService) GetImagesByBucketID(ctx context.Context, bucketID int16, limit int) ([]models.Image, error) {
 	return s.repo.GetImagesByBucketID(ctx, bucketID, limit)
 }
+
+// GetImagesByBucketIDAfter получает страницу изображений бакета с ID больше after
+func (s *DBService) GetImagesByBucketIDAfter(ctx context.Context, bucketID int16, after uuid.UUID, limit int) ([]models.Image, error) {
+	return s.repo.GetImagesByBucketIDAfter(ctx, bucketID, after, limit)
+}
+
+// GetImagesByBucketIDs получает изображения из нескольких бакетов
+func (s *DBService) GetImagesByBucketIDs(ctx context.Context, bucketIDs []int16, limit int) ([]models.Image, error) {
+	return s.repo.GetImagesByBucketIDs(ctx, bucketIDs, limit)
+}
+
+// GetUsage считает изображения и объём их хранимых ревизий в бакетах без учёта корзины
+func (s *DBService) GetUsage(ctx context.Context, bucketIDs []int16) (*models.Usage, error) {
+	return s.repo.GetUsageByBucketIDs(ctx, bucketIDs)
+}
+
+// GetImageBucketIDs получает бакеты изображений, включая удалённые в корзину
+func (s *DBService) GetImageBucketIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]int16, error) {
+	return s.repo.GetImageBucketIDs(ctx, ids)
+}
+
+// AddImageVersion записывает ревизию изображения
+func (s *DBService) AddImageVersion(ctx context.Context, version *models.ImageVersion) error {
+	return s.repo.InsertImageVersion(ctx, version)
+}
+
+// AddImageVersions записывает ревизии нескольких изображений
+func (s *DBService) AddImageVersions(ctx context.Context, versions []models.ImageVersion) error {
+	return s.repo.InsertImageVersions(ctx, versions)
+}
+
+// GetImageVersion получает ревизию изображения
+func (s *DBService) GetImageVersion(ctx context.Context, imageID uuid.UUID, revision int) (*models.ImageVersion, error) {
+	return s.repo.GetImageVersion(ctx, imageID, revision)
+}
+
+// GetImageVersions получает ревизии изображений, начиная с последней
+func (s *DBService) GetImageVersions(ctx context.Context, imageIDs ...uuid.UUID) ([]models.ImageVersion, error) {
+	return s.repo.GetImageVersions(ctx, imageIDs)
+}
+
+// GetUnsizedImageVersions получает ревизии без записанного размера после ревизии (afterID, afterRevision)
+func (s *DBService) GetUnsizedImageVersions(ctx context.Context, afterID uuid.UUID, afterRevision int, limit int) ([]models.UnsizedImageVersion, error) {
+	return s.repo.GetUnsizedImageVersions(ctx, afterID, afterRevision, limit)
+}
+
+// SetImageVersionSize записывает размер ревизии, если он ещё не записан
+func (s *DBService) SetImageVersionSize(ctx context.Context, imageID uuid.UUID, revision int, size int) error {
+	return s.repo.SetImageVersionSize(ctx, imageID, revision, size)
+}
+
+// DeleteImageVersions удаляет записи о ревизиях изображения
+func (s *DBService) DeleteImageVersions(ctx context.Context, imageID uuid.UUID, revisions []int) error {
+	return s.repo.DeleteImageVersions(ctx, imageID, revisions)
+}
+
+// SetWatermark сохраняет водяной знак бакета
+func (s *DBService) SetWatermark(ctx context.Context, watermark *models.Watermark) error {
+	return s.repo.UpsertWatermark(ctx, watermark)
+}
+
+// DeleteWatermark удаляет водяной знак бакета
+func (s *DBService) DeleteWatermark(ctx context.Context, bucketID int16) error {
+	return s.repo.DeleteWatermarkByBucketID(ctx, bucketID)
+}
+
+// GetAllWatermarks получает водяные знаки всех бакетов
+func (s *DBService) GetAllWatermarks(ctx context.Context) ([]models.Watermark, error) {
+	return s.repo.GetAllWatermarks(ctx)
+}
+
+// CreateApiKey добавляет ключ API с ролями в бакетах
+func (s *DBService) CreateApiKey(ctx context.Context, key *models.ApiKey, grants []models.ApiKeyGrant) error {
+	return s.repo.InsertApiKey(ctx, key, grants)
+}
+
+// GetApiKey получает ключ API по ID
+func (s *DBService) GetApiKey(ctx context.Context, id uuid.UUID) (*models.ApiKey, []models.ApiKeyGrant, error) {
+	return s.repo.GetApiKeyByID(ctx, id)
+}
+
+// GetActiveApiKeyByHash получает неотозванный ключ API по хешу
+func (s *DBService) GetActiveApiKeyByHash(ctx context.Context, keyHash []byte) (*models.ApiKey, []models.ApiKeyGrant, error) {
+	return s.repo.GetActiveApiKeyByHash(ctx, keyHash)
+}
+
+// RotateApiKey заменяет хеш ключа API
+func (s *DBService) RotateApiKey(ctx context.Context, id uuid.UUID, keyHash []byte) (bool, error) {
+	return s.repo.RotateApiKey(ctx, id, keyHash)
+}
+
+// RevokeApiKey отзывает ключ API
+func (s *DBService) RevokeApiKey(ctx context.Context, id uuid.UUID) (bool, error) {
+	return s.repo.RevokeApiKey(ctx, id)
+}
@@ -3,19 +3,64 @@ package db
 import (
 	"context"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"s3n/internal/db/models"
+	"time"
 )
 
+// ErrNotFound запрошенная запись не найдена. Другие ошибки методов Service означают, что БД недоступна
+// или запрос не выполнен, и не говорят об отсутствии записи
+var ErrNotFound = pgx.ErrNoRows
+
 type Service interface {
-	CreateBucket(ctx context.Context, bucketName string) (*models.Bucket, error)
+	CreateBucket(ctx context.Context, bucketName string, private bool, tenant *string) (*models.Bucket, error)
 	GetBucket(ctx context.Context, id int16) (*models.Bucket, error)
+	UpdateBucket(ctx context.Context, bucket *models.Bucket) error
 	DeleteBucket(ctx context.Context, id int16) error
 	GetAllBuckets(ctx context.Context, limit int) ([]models.Bucket, error)
-	CreateImage(ctx context.Context, bucketID int16) (*models.Image, error)
-	AddImage(ctx context.Context, bucketID int16, id uuid.UUID) error
+	CreateImage(ctx context.Context, bucketID int16, expiresAt *time.Time, slug *string) (*models.Image, error)
+	AddImage(ctx context.Context, bucketID int16, id uuid.UUID, expiresAt *time.Time) error
+	CreateImages(ctx context.Context, images []models.Image) ([]uuid.UUID, error)
 	GetImage(ctx context.Context, id uuid.UUID) (*models.Image, error)
+	GetImages(ctx context.Context, ids []uuid.UUID) ([]models.Image, error)
 	GetImageWithBucket(ctx context.Context, id uuid.UUID) (*models.Image, *models.Bucket, error)
+	GetTrashedImageWithBucket(ctx context.Context, id uuid.UUID) (*models.Image, *models.Bucket, error)
 	DeleteImage(ctx context.Context, id uuid.UUID) error
+	GetImageBySlug(ctx context.Context, bucketID int16, slug string) (*models.Image, error)
+	GetImageByAlias(ctx context.Context, bucketID int16, slug string) (*models.Image, error)
+	SetImageSlug(ctx context.Context, id uuid.UUID, slug *string) (bool, error)
+	TrashImage(ctx context.Context, id uuid.UUID) (bool, error)
+	TrashImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)
+	RestoreImage(ctx context.Context, id uuid.UUID) (bool, error)
+	GetTrashedImages(ctx context.Context, deletedBefore time.Time, limit int) ([]models.Image, error)
+	PurgeImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)
+	GetExpiredImages(ctx context.Context, now time.Time, limit int) ([]models.Image, error)
+	DeleteExpiredImages(ctx context.Context, ids []uuid.UUID, now time.Time) ([]uuid.UUID, error)
+	DeleteImages(ctx context.Context, ids []uuid.UUID) error
+	DeleteActiveImages(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)
+	ReserveImageRevision(ctx context.Context, id uuid.UUID) (int, error)
+	SetImageRevision(ctx context.Context, id uuid.UUID, bucketID int16, expected int, revision int) (bool, error)
+	MoveImage(ctx context.Context, id uuid.UUID, fromBucketID int16, toBucketID int16, revision int) (bool, error)
+	CountImagesInBucket(ctx context.Context, bucketID int16) (int, error)
 	GetAllImages(ctx context.Context, limit int) ([]models.Image, error)
 	GetImagesByBucketID(ctx context.Context, bucketID int16, limit int) ([]models.Image, error)
+	GetImagesByBucketIDAfter(ctx context.Context, bucketID int16, after uuid.UUID, limit int) ([]models.Image, error)
+	GetImagesByBucketIDs(ctx context.Context, bucketIDs []int16, limit int) ([]models.Image, error)
+	GetImageBucketIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]int16, error)
+	GetUsage(ctx context.Context, bucketIDs []int16) (*models.Usage, error)
+	AddImageVersion(ctx context.Context, version *models.ImageVersion) error
+	AddImageVersions(ctx context.Context, versions []models.ImageVersion) error
+	GetImageVersion(ctx context.Context, imageID uuid.UUID, revision int) (*models.ImageVersion, error)
+	GetImageVersions(ctx context.Context, imageIDs ...uuid.UUID) ([]models.ImageVersion, error)
+	DeleteImageVersions(ctx context.Context, imageID uuid.UUID, revisions []int) error
+	GetUnsizedImageVersions(ctx context.Context, afterID uuid.UUID, afterRevision int, limit int) ([]models.UnsizedImageVersion, error)
+	SetImageVersionSize(ctx context.Context, imageID uuid.UUID, revision int, size int) error
+	SetWatermark(ctx context.Context, watermark *models.Watermark) error
+	DeleteWatermark(ctx context.Context, bucketID int16) error
+	GetAllWatermarks(ctx context.Context) ([]models.Watermark, error)
+	CreateApiKey(ctx context.Context, key *models.ApiKey, grants []models.ApiKeyGrant) error
+	GetApiKey(ctx context.Context, id uuid.UUID) (*models.ApiKey, []models.ApiKeyGrant, error)
+	GetActiveApiKeyByHash(ctx context.Context, keyHash []byte) (*models.ApiKey, []models.ApiKeyGrant, error)
+	RotateApiKey(ctx context.Context, id uuid.UUID, keyHash []byte) (bool, error)
+	RevokeApiKey(ctx context.Context, id uuid.UUID) (bool, error)
 }
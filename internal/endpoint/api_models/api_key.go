@@ -0,0 +1,58 @@
+package api_models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ApiKeyRole роль ключа API в бакете, каждая следующая роль включает предыдущие
+type ApiKeyRole string
+
+const (
+	RoleRead  ApiKeyRole = "read"  // Чтение бакета и его изображений
+	RoleWrite ApiKeyRole = "write" // Загрузка, изменение и удаление изображений
+	RoleAdmin ApiKeyRole = "admin" // Настройки бакета, во всех бакетах - также регистрация бакетов и управление ключами
+)
+
+func (r ApiKeyRole) rank() int {
+	switch r {
+	case RoleRead:
+		return 1
+	case RoleWrite:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+func (r ApiKeyRole) Valid() bool {
+	return r.rank() > 0
+}
+
+// Includes проверяет, что роль даёт права роли other
+func (r ApiKeyRole) Includes(other ApiKeyRole) bool {
+	return r.rank() >= other.rank()
+}
+
+// ApiKeyGrant роль ключа в бакете
+type ApiKeyGrant struct {
+	BucketName string     `json:"bucketName"`
+	Role       ApiKeyRole `json:"role"`
+}
+
+type ApiKey struct {
+	ID        uuid.UUID     `json:"id"`                  // Уникальный идентификатор ключа
+	Name      string        `json:"name"`                // Описание владельца ключа
+	Tenant    string        `json:"tenant,omitempty"`    // Владелец бакетов, пустая строка - ключ оператора
+	Role      ApiKeyRole    `json:"role,omitempty"`      // Роль во всех бакетах, пустая строка - только роли из Grants
+	Grants    []ApiKeyGrant `json:"grants,omitempty"`    // Роли в отдельных бакетах
+	CreatedAt time.Time     `json:"createdAt"`           // Время выпуска ключа
+	RotatedAt *time.Time    `json:"rotatedAt,omitempty"` // Время последней замены ключа
+}
+
+// IssuedApiKey выпущенный ключ API, сам ключ возвращается только при выпуске и замене
+type IssuedApiKey struct {
+	ApiKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,38 @@
+package api_models
+
+import "testing"
+
+func TestApiKeyRoleIncludes(t *testing.T) {
+	roles := []ApiKeyRole{"", RoleRead, RoleWrite, RoleAdmin, "owner"}
+	// want[i][j] - роль roles[i] включает роль roles[j]
+	want := [][]bool{
+		{true, false, false, false, true},
+		{true, true, false, false, true},
+		{true, true, true, false, true},
+		{true, true, true, true, true},
+		{true, false, false, false, true},
+	}
+	for i, role := range roles {
+		for j, other := range roles {
+			if got := role.Includes(other); got != want[i][j] {
+				t.Errorf("%q.Includes(%q) = %v, ожидалось %v", role, other, got, want[i][j])
+			}
+		}
+	}
+}
+
+func TestApiKeyRoleValid(t *testing.T) {
+	tests := map[ApiKeyRole]bool{
+		RoleRead:  true,
+		RoleWrite: true,
+		RoleAdmin: true,
+		"":        false,
+		"Admin":   false,
+		"owner":   false,
+	}
+	for role, want := range tests {
+		if got := role.Valid(); got != want {
+			t.Errorf("%q.Valid() = %v, ожидалось %v", role, got, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package api_models
+
+import (
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"time"
+)
+
+// CreateImageItem параметры одного изображения пакетной загрузки, совпадают с параметрами CreateImage
+type CreateImageItem struct {
+	BucketName    string
+	File          []byte
+	FileExtension string
+	Quality       *float32
+	MaxSize       *int
+	Frame         *int
+	Target        *EncodingTarget
+	ID            *uuid.UUID // Идентификатор нового изображения, nil - создаётся случайный
+	ExpiresAt     *time.Time
+	Slug          string // Читаемый адрес изображения в бакете, пустая строка - не задан
+}
+
+// ImageResult результат обработки одного изображения пакетного запроса
+type ImageResult struct {
+	ID         uuid.UUID     `json:"id"`
+	BucketName string        `json:"bucketName"`
+	Image      *Image        `json:"image,omitempty"` // nil, если Status не OK или изображение удалено
+	Status     status.Status `json:"status"`
+}
@@ -1,5 +1,63 @@
 package api_models
 
+import "github.com/google/uuid"
+
 type Bucket struct {
-	BucketName string // Название бакета
+	BucketName   string          `json:"bucketName"`       // Название бакета
+	Target       *EncodingTarget `json:"target,omitempty"` // Подбор качества под ограничения вместо качества по умолчанию, nil - отключён
+	CacheControl string          `json:"cacheControl"`     // Cache-Control объектов, пустая строка - значение по умолчанию
+	Private      bool            `json:"private"`          // Объекты бакета недоступны для публичного чтения
+	Archived     bool            `json:"archived"`         // Бакет только для чтения
+	DefaultTTL   int             `json:"defaultTtl"`       // Время жизни новых изображений в секундах, 0 - бессрочно
+	// Изображение, которое отдаётся вместо отсутствующих и удалённых, nil - не задано
+	FallbackImageID *uuid.UUID         `json:"fallbackImageId,omitempty"`
+	Hotlink         *HotlinkProtection `json:"hotlink,omitempty"` // Защита от встраивания на чужих сайтах, nil - отключена
+	Tenant          string             `json:"tenant,omitempty"`  // Владелец бакета, пустая строка - бакет без владельца
+	Quota           *Quota             `json:"quota,omitempty"`   // Квота бакета, nil - без ограничений
+}
+
+// BucketSettings настраиваемые параметры бакета. Обновляются только поля из маски обновления,
+// без маски - только заданные поля
+type BucketSettings struct {
+	Target       *EncodingTarget `json:"target,omitempty"` // Подбор качества под ограничения, nil - отключён
+	CacheControl string          `json:"cacheControl"`     // Cache-Control объектов, пустая строка - значение по умолчанию
+	DefaultTTL   int             `json:"defaultTtl"`       // Время жизни новых изображений в секундах, 0 - бессрочно
+	// Изображение, которое отдаётся вместо отсутствующих и удалённых, nil - не задано
+	FallbackImageID *uuid.UUID         `json:"fallbackImageId,omitempty"`
+	Hotlink         *HotlinkProtection `json:"hotlink,omitempty"` // Защита от встраивания на чужих сайтах, nil - отключена
+	Quota           *Quota             `json:"quota,omitempty"`   // Квота бакета, nil - без ограничений
+}
+
+// Названия полей BucketSettings для маски обновления, совпадают с названиями в JSON
+const (
+	BucketFieldTarget          = "target"
+	BucketFieldCacheControl    = "cacheControl"
+	BucketFieldDefaultTTL      = "defaultTtl"
+	BucketFieldFallbackImageID = "fallbackImageId"
+	BucketFieldHotlink         = "hotlink"
+	BucketFieldQuota           = "quota"
+)
+
+// HotlinkProtection ограничение сайтов, на которых можно встраивать изображения бакета
+type HotlinkProtection struct {
+	AllowedHosts       []string   `json:"allowedHosts,omitempty"`       // Хосты из Referer и Origin, "*.example.com" - любой поддомен example.com
+	AllowEmptyReferer  bool       `json:"allowEmptyReferer"`            // Разрешены запросы без Referer и Origin: прямые загрузки и приложения
+	PlaceholderImageID *uuid.UUID `json:"placeholderImageId,omitempty"` // Изображение, которое отдаётся вместо запрещённого, nil - ответ 403
+}
+
+// RegisterBucketOptions настройка физического бакета S3 при регистрации
+type RegisterBucketOptions struct {
+	CreateStorage bool       `json:"createStorage"`  // Создать бакет в S3, если его нет
+	Private       bool       `json:"private"`        // Закрыть публичный доступ на чтение вместо политики public-read
+	Cors          []CorsRule `json:"cors,omitempty"` // Правила CORS, пустой список - правила не задаются
+	// Владелец бакета, задаётся только ключом без владельца, иначе бакет получает владельца ключа
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// CorsRule правило CORS бакета
+type CorsRule struct {
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"` // Разрешённые источники
+	AllowedMethods []string `json:"allowedMethods,omitempty"` // Разрешённые методы
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"` // Разрешённые заголовки запроса
+	MaxAgeSeconds  int      `json:"maxAgeSeconds"`            // Время кеширования preflight ответа
 }
@@ -0,0 +1,15 @@
+package api_models
+
+// EncodingTarget ограничения, под которые подбирается качество кодирования
+type EncodingTarget struct {
+	MaxBytes       int     `json:"maxBytes"`       // Максимальный размер файла, 0 - не ограничен
+	MinSSIM        float32 `json:"minSsim"`        // Минимальное сходство с исходником от 0 до 1, 0 - не проверяется
+	AllowDownscale bool    `json:"allowDownscale"` // Уменьшать изображение, если размер не достигается на минимальном качестве
+}
+
+// Encoding параметры, с которыми закодировано изображение
+type Encoding struct {
+	Quality float32 `json:"quality"` // Качество кодирования
+	Size    int     `json:"size"`    // Размер файла в байтах
+	SSIM    float32 `json:"ssim"`    // Сходство с исходником, 0 - не вычислялось
+}
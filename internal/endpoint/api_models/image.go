@@ -1,7 +1,14 @@
 package api_models
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+)
 
 type Image struct {
-	ID uuid.UUID // Уникальный идентификатор изображения
+	ID        uuid.UUID  `json:"id"`                  // Уникальный идентификатор изображения
+	Revision  int        `json:"revision"`            // Номер ревизии содержимого
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // Время удаления изображения, nil - бессрочно
+	Slug      string     `json:"slug"`                // Читаемый адрес изображения в бакете, пустая строка - не задан
+	Encoding  *Encoding  `json:"encoding,omitempty"`  // Параметры кодирования, заполняются только при создании
 }
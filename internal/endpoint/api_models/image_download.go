@@ -0,0 +1,15 @@
+package api_models
+
+import "io"
+
+// ImageDownload содержимое ревизии изображения или его части, Body закрывает вызывающий
+type ImageDownload struct {
+	Image       *Image
+	Revision    int    // Ревизия, содержимое которой читается
+	ContentType string // MIME-тип файла
+	Offset      int64  // Смещение первого байта Body от начала файла
+	Length      int64  // Количество байт в Body
+	Size        int64  // Полный размер файла
+	Fallback    bool   // Запрошенное изображение не найдено, отдаётся запасное изображение бакета
+	Body        io.ReadCloser
+}
@@ -0,0 +1,8 @@
+package api_models
+
+type ImageInfo struct {
+	Format string `json:"format"` // Формат, определённый по содержимому файла
+	Width  int    `json:"width"`  // Ширина в пикселях
+	Height int    `json:"height"` // Высота в пикселях
+	Frames int    `json:"frames"` // Количество кадров, больше 1 у анимаций
+}
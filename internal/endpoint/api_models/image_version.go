@@ -0,0 +1,10 @@
+package api_models
+
+import "time"
+
+type ImageVersion struct {
+	Revision  int       `json:"revision"`  // Номер ревизии
+	Size      int       `json:"size"`      // Размер файла в байтах
+	CreatedAt time.Time `json:"createdAt"` // Время загрузки
+	Current   bool      `json:"current"`   // Ревизия отдаётся по ссылке без параметра версии
+}
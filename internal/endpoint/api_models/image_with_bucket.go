@@ -3,6 +3,6 @@ package api_models
 import "github.com/google/uuid"
 
 type ImageWithBucket struct {
-	ID         uuid.UUID // Уникальный идентификатор изображения
-	BucketName string
+	ID         uuid.UUID `json:"id"` // Уникальный идентификатор изображения
+	BucketName string    `json:"bucketName"`
 }
@@ -0,0 +1,24 @@
+package api_models
+
+// UnregisterMode поведение при удалении бакета, в котором есть изображения
+type UnregisterMode string
+
+const (
+	UnregisterRejectIfNotEmpty UnregisterMode = "reject-if-not-empty" // Отказать, если в бакете есть изображения
+	UnregisterCascade          UnregisterMode = "cascade"             // Удалить все изображения вместе с бакетом
+	UnregisterArchive          UnregisterMode = "archive"             // Оставить бакет только для чтения
+)
+
+func (m UnregisterMode) Valid() bool {
+	switch m {
+	case UnregisterRejectIfNotEmpty, UnregisterCascade, UnregisterArchive:
+		return true
+	}
+	return false
+}
+
+// UnregisterResult результат удаления бакета. Если удаление в режиме cascade прервано, бакет остаётся
+// в архиве без удалённых изображений, и запрос можно повторить
+type UnregisterResult struct {
+	DeletedImages int `json:"deletedImages"` // Количество изображений, удалённых в режиме cascade
+}
@@ -0,0 +1,18 @@
+package api_models
+
+// Quota ограничения бакета или владельца, 0 - без ограничения
+type Quota struct {
+	MaxImages           int   `json:"maxImages"`           // Количество изображений без учёта корзины
+	MaxBytes            int64 `json:"maxBytes"`            // Объём хранимых ревизий изображений без учёта корзины в байтах
+	MaxUploadsPerMinute int   `json:"maxUploadsPerMinute"` // Загрузки в минуту на экземпляр s3n
+}
+
+// Usage использование ресурсов бакетом или владельцем
+type Usage struct {
+	Tenant           string `json:"tenant,omitempty"`     // Владелец, пустая строка - все бакеты
+	BucketName       string `json:"bucketName,omitempty"` // Бакет, пустая строка - все бакеты владельца
+	Images           int    `json:"images"`               // Количество изображений без учёта корзины
+	Bytes            int64  `json:"bytes"`                // Объём хранимых ревизий изображений без учёта корзины
+	UploadsPerMinute int    `json:"uploadsPerMinute"`     // Загрузки за текущую минуту на этом экземпляре
+	Quota            *Quota `json:"quota,omitempty"`      // Квота, nil - без ограничений
+}
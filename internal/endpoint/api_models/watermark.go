@@ -0,0 +1,13 @@
+package api_models
+
+import "github.com/google/uuid"
+
+type Watermark struct {
+	ImageID  uuid.UUID `json:"imageId"`  // Изображение знака, хранящееся в s3n
+	Position string    `json:"position"` // Положение знака: top-left, top, ..., bottom-right
+	Margin   int       `json:"margin"`   // Отступ от краёв в пикселях
+	Opacity  float32   `json:"opacity"`  // Непрозрачность от 0 до 1
+	Scale    float32   `json:"scale"`    // Ширина знака относительно ширины изображения, 0 - исходный размер
+	Tiled    bool      `json:"tiled"`    // Заполнение изображения знаком по сетке
+	MinSize  int       `json:"minSize"`  // Минимальная большая сторона изображения для наложения знака
+}
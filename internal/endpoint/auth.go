@@ -0,0 +1,403 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"s3n/internal/db"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"time"
+)
+
+// apiKeyPrefix префикс ключей API, по нему ключ легко узнать в конфигах и переменных окружения
+const apiKeyPrefix = "s3n_"
+
+// maxApiKeyNameLength максимальная длина описания ключа, совпадает с размером столбца в БД
+const maxApiKeyNameLength = 128
+
+// maxTenantLength максимальная длина идентификатора владельца, совпадает с размером столбцов в БД
+const maxTenantLength = 64
+
+var (
+	errUnauthenticated  = errors.New("ключ API не передан или недействителен")
+	errPermissionDenied = errors.New("недостаточно прав")
+	// errAuthUnavailable ключ не удалось проверить, например из-за недоступности БД
+	errAuthUnavailable = errors.New("не удалось проверить ключ API")
+)
+
+// principal владелец проверенного ключа API
+type principal struct {
+	keyID   uuid.UUID // uuid.Nil - корневой ключ из конфига
+	name    string
+	tenant  string // пустая строка - ключ оператора с доступом ко всем бакетам
+	role    api_models.ApiKeyRole
+	buckets map[int16]api_models.ApiKeyRole
+	expires time.Time
+}
+
+// roleIn роль в бакете с учётом роли во всех бакетах
+func (p *principal) roleIn(bucketID int16) api_models.ApiKeyRole {
+	if role, ok := p.buckets[bucketID]; ok && role.Includes(p.role) {
+		return role
+	}
+	return p.role
+}
+
+// allowed проверяет роль в бакете, ключ владельца не получает доступа к чужим бакетам даже с ролью во всех бакетах
+func (p *principal) allowed(bucket *models.Bucket, role api_models.ApiKeyRole) bool {
+	if p.tenant != "" && !ownedBy(bucket, p.tenant) {
+		return false
+	}
+	return p.roleIn(bucket.ID).Includes(role)
+}
+
+func ownedBy(bucket *models.Bucket, tenant string) bool {
+	return bucket.Tenant != nil && *bucket.Tenant == tenant
+}
+
+type principalKey struct{}
+
+// withPrincipal сохраняет владельца ключа в контексте вызова, из него Endpoint берёт владельца бакетов
+func withPrincipal(ctx context.Context, p *principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFrom возвращает владельца ключа вызова, nil - проверка ключей отключена
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// tenantFrom возвращает владельца бакетов из ключа вызова, пустая строка - вызов без ограничения владельцем
+func tenantFrom(ctx context.Context) string {
+	if p := principalFrom(ctx); p != nil {
+		return p.tenant
+	}
+	return ""
+}
+
+func hashApiKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
+
+// newApiKey создаёт случайный ключ API и его хеш
+func newApiKey() (string, []byte, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, hashApiKey(key), nil
+}
+
+// authenticate проверяет ключ API. Проверенные ключи хранятся в памяти authCacheTTL,
+// недействительные каждый раз проверяются в БД. Если БД не ответила, возвращается errAuthUnavailable
+func (e *Endpoint) authenticate(ctx context.Context, key string) (*principal, error) {
+	const op = "Endpoint.authenticate"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if key == "" {
+		return nil, errUnauthenticated
+	}
+	hash := hashApiKey(key)
+	if e.rootKeyHash != nil && subtle.ConstantTimeCompare(hash, e.rootKeyHash) == 1 {
+		return &principal{name: "root", role: api_models.RoleAdmin}, nil
+	}
+
+	e.principalsLock.Lock()
+	p, ok := e.principals[string(hash)]
+	e.principalsLock.Unlock()
+	if ok && time.Now().Before(p.expires) {
+		return p, nil
+	}
+
+	apiKey, grants, err := e.dbService.GetActiveApiKeyByHash(ctx, hash)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, errUnauthenticated
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: не удалось получить ключ API из БД: %w", errAuthUnavailable, err)
+		e.logger.Error(ctx, err)
+		return nil, err
+	}
+
+	p = &principal{
+		keyID:   apiKey.ID,
+		name:    apiKey.Name,
+		tenant:  deref(apiKey.Tenant),
+		buckets: map[int16]api_models.ApiKeyRole{},
+		expires: time.Now().Add(e.authCacheTTL),
+	}
+	if apiKey.Role != nil {
+		p.role = api_models.ApiKeyRole(*apiKey.Role)
+	}
+	for _, grant := range grants {
+		p.buckets[grant.BucketID] = api_models.ApiKeyRole(grant.Role)
+	}
+
+	e.principalsLock.Lock()
+	e.principals[string(hash)] = p
+	e.principalsLock.Unlock()
+
+	return p, nil
+}
+
+// forgetPrincipals сбрасывает проверенные ключи, чтобы замена и отзыв действовали сразу
+func (e *Endpoint) forgetPrincipals() {
+	e.principalsLock.Lock()
+	e.principals = map[string]*principal{}
+	e.principalsLock.Unlock()
+}
+
+// authorizeGlobal проверяет роль p во всех бакетах
+func (e *Endpoint) authorizeGlobal(p *principal, role api_models.ApiKeyRole) error {
+	if !p.role.Includes(role) {
+		return errPermissionDenied
+	}
+	return nil
+}
+
+// authorizeOperator проверяет, что p - ключ оператора с ролью admin во всех бакетах. Так проверяются
+// действия, которые владелец бакетов не должен выполнять сам, например изменение квот
+func (e *Endpoint) authorizeOperator(p *principal) error {
+	if p.tenant != "" || !p.role.Includes(api_models.RoleAdmin) {
+		return errPermissionDenied
+	}
+	return nil
+}
+
+// authorizeBuckets проверяет роль p в бакетах. В незарегистрированном бакете действует только роль во всех бакетах
+func (e *Endpoint) authorizeBuckets(p *principal, role api_models.ApiKeyRole, bucketNames ...string) error {
+	for _, bucketName := range bucketNames {
+		bucket, ok := e.bucketByName(bucketName)
+		if ok && !p.allowed(bucket, role) || !ok && !p.role.Includes(role) {
+			return errPermissionDenied
+		}
+	}
+	return nil
+}
+
+// authorizeImages проверяет роль p в бакетах изображений, включая удалённые в корзину.
+// Ненайденные изображения пропускаются, для них вызываемый метод вернёт NotFound
+func (e *Endpoint) authorizeImages(ctx context.Context, p *principal, role api_models.ApiKeyRole, ids ...uuid.UUID) error {
+	if p.tenant == "" && p.role.Includes(role) || len(ids) == 0 {
+		return nil
+	}
+
+	bucketIDs, err := e.dbService.GetImageBucketIDs(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("не удалось получить бакеты изображений из БД: %w", err)
+	}
+	for _, bucketID := range bucketIDs {
+		bucket, ok := e.bucketByID(bucketID)
+		if !ok || !p.allowed(bucket, role) {
+			return errPermissionDenied
+		}
+	}
+	return nil
+}
+
+func (e *Endpoint) apiKeyToAPI(key *models.ApiKey, grants []models.ApiKeyGrant) *api_models.ApiKey {
+	apiKey := &api_models.ApiKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Tenant:    deref(key.Tenant),
+		CreatedAt: key.CreatedAt,
+		RotatedAt: key.RotatedAt,
+	}
+	if key.Role != nil {
+		apiKey.Role = api_models.ApiKeyRole(*key.Role)
+	}
+	for _, grant := range grants {
+		if bucket, ok := e.bucketByID(grant.BucketID); ok {
+			apiKey.Grants = append(apiKey.Grants, api_models.ApiKeyGrant{BucketName: bucket.BucketName, Role: api_models.ApiKeyRole(grant.Role)})
+		}
+	}
+	return apiKey
+}
+
+// IssueApiKey выпускает ключ API с ролью во всех бакетах и ролями в отдельных бакетах.
+// Ключ владельца выпускает ключи только своего владельца. Ключ возвращается только в ответе, в БД хранится его хеш
+func (e *Endpoint) IssueApiKey(ctx context.Context, name string, tenant string, role api_models.ApiKeyRole, grants []api_models.ApiKeyGrant) (*api_models.IssuedApiKey, status.Status) {
+	const op = "Endpoint.IssueApiKey"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if name == "" || len(name) > maxApiKeyNameLength {
+		err := fmt.Errorf("некорректное описание ключа")
+		e.logger.Error(ctx, err, zap.String("name", name))
+		return nil, status.IncorrectValue
+	}
+	if role != "" && !role.Valid() {
+		err := fmt.Errorf("некорректная роль ключа")
+		e.logger.Error(ctx, err, zap.String("role", string(role)))
+		return nil, status.IncorrectValue
+	}
+	if role == "" && len(grants) == 0 {
+		err := fmt.Errorf("ключу не выдано ни одной роли")
+		e.logger.Error(ctx, err, zap.String("name", name))
+		return nil, status.IncorrectValue
+	}
+
+	if len(tenant) > maxTenantLength {
+		err := fmt.Errorf("слишком длинный идентификатор владельца")
+		e.logger.Error(ctx, err, zap.String("tenant", tenant))
+		return nil, status.IncorrectValue
+	}
+	if callerTenant := tenantFrom(ctx); callerTenant != "" {
+		if tenant != "" && tenant != callerTenant {
+			err := fmt.Errorf("владелец ключа не совпадает с владельцем вызывающего ключа")
+			e.logger.Error(ctx, err, zap.String("name", name), zap.String("tenant", tenant))
+			return nil, status.IncorrectValue
+		}
+		tenant = callerTenant
+	}
+
+	key := &models.ApiKey{ID: uuid.New(), Name: name}
+	if tenant != "" {
+		key.Tenant = &tenant
+	}
+	if role != "" {
+		key.Role = (*string)(&role)
+	}
+	rows := make([]models.ApiKeyGrant, 0, len(grants))
+	seen := map[int16]bool{}
+	for _, grant := range grants {
+		if !grant.Role.Valid() {
+			err := fmt.Errorf("некорректная роль ключа")
+			e.logger.Error(ctx, err, zap.String("bucket_name", grant.BucketName), zap.String("role", string(grant.Role)))
+			return nil, status.IncorrectValue
+		}
+		bucket, ok := e.bucketByName(grant.BucketName)
+		if !ok {
+			err := fmt.Errorf("не удалось найти бакет в кеше")
+			e.logger.Error(ctx, err, zap.String("bucket_name", grant.BucketName))
+			return nil, status.NotFound
+		}
+		if tenant != "" && !ownedBy(bucket, tenant) {
+			err := fmt.Errorf("бакет принадлежит другому владельцу")
+			e.logger.Error(ctx, err, zap.String("bucket_name", grant.BucketName), zap.String("tenant", tenant))
+			return nil, status.IncorrectValue
+		}
+		if seen[bucket.ID] {
+			err := fmt.Errorf("роль в бакете повторяется")
+			e.logger.Error(ctx, err, zap.String("bucket_name", grant.BucketName))
+			return nil, status.IncorrectValue
+		}
+		seen[bucket.ID] = true
+		rows = append(rows, models.ApiKeyGrant{KeyID: key.ID, BucketID: bucket.ID, Role: string(grant.Role)})
+	}
+
+	secret, hash, err := newApiKey()
+	if err != nil {
+		err = fmt.Errorf("не удалось создать ключ API: %w", err)
+		e.logger.Error(ctx, err)
+		return nil, status.InternalError
+	}
+	key.KeyHash = hash
+
+	err = e.dbService.CreateApiKey(ctx, key, rows)
+	if err != nil {
+		err = fmt.Errorf("не удалось добавить ключ API в БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("name", name))
+		return nil, status.InternalError
+	}
+
+	return &api_models.IssuedApiKey{ApiKey: *e.apiKeyToAPI(key, rows), Key: secret}, status.OK
+}
+
+// RotateApiKey заменяет ключ API новым с теми же ролями, прежний ключ перестаёт действовать
+func (e *Endpoint) RotateApiKey(ctx context.Context, id uuid.UUID) (*api_models.IssuedApiKey, status.Status) {
+	const op = "Endpoint.RotateApiKey"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if s := e.checkKeyTenant(ctx, id); s != status.OK {
+		return nil, s
+	}
+
+	secret, hash, err := newApiKey()
+	if err != nil {
+		err = fmt.Errorf("не удалось создать ключ API: %w", err)
+		e.logger.Error(ctx, err)
+		return nil, status.InternalError
+	}
+
+	rotated, err := e.dbService.RotateApiKey(ctx, id, hash)
+	if err != nil {
+		err = fmt.Errorf("не удалось заменить ключ API в БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("key_id", id.String()))
+		return nil, status.InternalError
+	}
+	if !rotated {
+		err = fmt.Errorf("ключ API не найден или отозван")
+		e.logger.Error(ctx, err, zap.String("key_id", id.String()))
+		return nil, status.NotFound
+	}
+	e.forgetPrincipals()
+
+	key, grants, err := e.dbService.GetApiKey(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить ключ API из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("key_id", id.String()))
+		return nil, status.InternalError
+	}
+
+	return &api_models.IssuedApiKey{ApiKey: *e.apiKeyToAPI(key, grants), Key: secret}, status.OK
+}
+
+// RevokeApiKey отзывает ключ API. На других экземплярах ключ действует до конца срока хранения в памяти
+func (e *Endpoint) RevokeApiKey(ctx context.Context, id uuid.UUID) status.Status {
+	const op = "Endpoint.RevokeApiKey"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if s := e.checkKeyTenant(ctx, id); s != status.OK {
+		return s
+	}
+
+	revoked, err := e.dbService.RevokeApiKey(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось отозвать ключ API в БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("key_id", id.String()))
+		return status.InternalError
+	}
+	if !revoked {
+		err = fmt.Errorf("ключ API не найден или уже отозван")
+		e.logger.Error(ctx, err, zap.String("key_id", id.String()))
+		return status.NotFound
+	}
+	e.forgetPrincipals()
+
+	return status.OK
+}
+
+// checkKeyTenant проверяет, что ключ принадлежит владельцу вызывающего ключа. Чужие ключи не находятся
+func (e *Endpoint) checkKeyTenant(ctx context.Context, id uuid.UUID) status.Status {
+	tenant := tenantFrom(ctx)
+	if tenant == "" {
+		return status.OK
+	}
+
+	key, _, err := e.dbService.GetApiKey(ctx, id)
+	if err != nil || deref(key.Tenant) != tenant {
+		err = fmt.Errorf("ключ API не найден у владельца: %v", err)
+		e.logger.Error(ctx, err, zap.String("key_id", id.String()), zap.String("tenant", tenant))
+		return status.NotFound
+	}
+	return status.OK
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
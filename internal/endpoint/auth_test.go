@@ -0,0 +1,166 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/budka-tech/snip-common-go/contract/s3"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"testing"
+)
+
+func TestPrincipalAllowed(t *testing.T) {
+	acme, other := "acme", "other"
+	owned := &models.Bucket{ID: 1, BucketName: "owned", Tenant: &acme}
+	foreign := &models.Bucket{ID: 2, BucketName: "foreign", Tenant: &other}
+	shared := &models.Bucket{ID: 3, BucketName: "shared"}
+
+	tests := []struct {
+		name   string
+		p      *principal
+		bucket *models.Bucket
+		role   api_models.ApiKeyRole
+		want   bool
+	}{
+		{name: "оператор читает", p: &principal{role: api_models.RoleRead}, bucket: foreign, role: api_models.RoleRead, want: true},
+		{name: "оператор без роли записи", p: &principal{role: api_models.RoleRead}, bucket: shared, role: api_models.RoleWrite},
+		{name: "роль в бакете выше общей", p: &principal{role: api_models.RoleRead, buckets: map[int16]api_models.ApiKeyRole{3: api_models.RoleAdmin}}, bucket: shared, role: api_models.RoleAdmin, want: true},
+		{name: "роль в бакете не понижает общую", p: &principal{role: api_models.RoleWrite, buckets: map[int16]api_models.ApiKeyRole{3: api_models.RoleRead}}, bucket: shared, role: api_models.RoleWrite, want: true},
+		{name: "роль в другом бакете", p: &principal{buckets: map[int16]api_models.ApiKeyRole{1: api_models.RoleAdmin}}, bucket: shared, role: api_models.RoleRead},
+		{name: "владелец в своём бакете", p: &principal{tenant: acme, role: api_models.RoleWrite}, bucket: owned, role: api_models.RoleWrite, want: true},
+		{name: "владелец в чужом бакете", p: &principal{tenant: acme, role: api_models.RoleAdmin}, bucket: foreign, role: api_models.RoleRead},
+		{name: "владелец в бакете без владельца", p: &principal{tenant: acme, role: api_models.RoleAdmin}, bucket: shared, role: api_models.RoleRead},
+		{name: "роль владельца в чужом бакете", p: &principal{tenant: acme, buckets: map[int16]api_models.ApiKeyRole{2: api_models.RoleAdmin}}, bucket: foreign, role: api_models.RoleRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.allowed(tt.bucket, tt.role); got != tt.want {
+				t.Errorf("allowed() = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	acme := "acme"
+	owned := models.Bucket{ID: 1, BucketName: "owned", Tenant: &acme}
+	shared := models.Bucket{ID: 2, BucketName: "shared"}
+	ownedImage := models.Image{ID: uuid.New(), BucketID: 1}
+	sharedImage := models.Image{ID: uuid.New(), BucketID: 2}
+	missing := uuid.New()
+
+	tenantWriter := &principal{tenant: acme, role: api_models.RoleWrite}
+	reader := &principal{role: api_models.RoleRead}
+	admin := &principal{role: api_models.RoleAdmin}
+	tenantAdmin := &principal{tenant: acme, role: api_models.RoleAdmin}
+
+	tests := []struct {
+		name        string
+		p           *principal
+		request     any
+		wantErr     error
+		wantLookups int
+	}{
+		{name: "список бакетов без роли", p: &principal{}, request: &pb.GetAllBucketsRequest{}},
+		{name: "все изображения с ролью чтения", p: reader, request: &pb.GetAllImagesRequest{}},
+		{name: "все изображения без роли", p: &principal{buckets: map[int16]api_models.ApiKeyRole{2: api_models.RoleAdmin}}, request: &pb.GetAllImagesRequest{}, wantErr: errPermissionDenied},
+		{name: "чтение своего бакета", p: tenantWriter, request: &pb.GetBucketRequest{BucketName: "owned"}},
+		{name: "чтение чужого бакета", p: tenantWriter, request: &pb.GetBucketRequest{BucketName: "shared"}, wantErr: errPermissionDenied},
+		// в незарегистрированном бакете действует только роль во всех бакетах
+		{name: "незарегистрированный бакет", p: tenantWriter, request: &pb.HasBucketRequest{BucketName: "unknown"}},
+		{name: "незарегистрированный бакет без общей роли", p: &principal{buckets: map[int16]api_models.ApiKeyRole{2: api_models.RoleAdmin}}, request: &pb.HasBucketRequest{BucketName: "unknown"}, wantErr: errPermissionDenied},
+		{name: "загрузка без роли записи", p: reader, request: &pb.CreateImageRequest{BucketName: "shared"}, wantErr: errPermissionDenied},
+		{name: "изображение своего бакета", p: tenantWriter, request: &pb.DeleteImageRequest{Id: ownedImage.ID[:]}, wantLookups: 1},
+		{name: "изображение чужого бакета", p: tenantWriter, request: &pb.DeleteImageRequest{Id: sharedImage.ID[:]}, wantErr: errPermissionDenied, wantLookups: 1},
+		{name: "ненайденное изображение", p: tenantWriter, request: &pb.GetImageRequest{Id: missing[:]}, wantLookups: 1},
+		// оператору с достаточной ролью бакеты изображений не нужны
+		{name: "изображение оператору", p: admin, request: &pb.DeleteImageRequest{Id: sharedImage.ID[:]}},
+		{name: "перенос в чужой бакет", p: tenantWriter, request: &pb.MoveImageRequest{Id: ownedImage.ID[:], BucketName: "shared"}, wantErr: errPermissionDenied},
+		{name: "пакет больше лимита", p: tenantWriter, request: &pb.BatchGetImagesRequest{Ids: make([][]byte, batchLimit+1)}, wantErr: errBatchTooLarge},
+		{name: "регистрация бакета владельцем", p: tenantWriter, request: &pb.RegisterBucketRequest{BucketName: "new"}, wantErr: errPermissionDenied},
+		{name: "регистрация бакета администратором", p: admin, request: &pb.RegisterBucketRequest{BucketName: "new"}},
+		{name: "настройки своего бакета", p: tenantAdmin, request: &pb.UpdateBucketRequest{BucketName: "owned", Settings: &pb.BucketSettings{}}},
+		{name: "квота своего бакета", p: tenantAdmin, request: &pb.UpdateBucketRequest{BucketName: "owned", Settings: &pb.BucketSettings{Quota: &pb.Quota{MaxImages: 1000}}}, wantErr: errPermissionDenied},
+		{name: "квота бакета оператором", p: admin, request: &pb.UpdateBucketRequest{BucketName: "owned", Settings: &pb.BucketSettings{Quota: &pb.Quota{MaxImages: 1000}}}},
+		{name: "общая статистика владельца", p: tenantWriter, request: &pb.GetUsageRequest{}},
+		{name: "статистика чужого бакета", p: tenantWriter, request: &pb.GetUsageRequest{BucketName: "shared"}, wantErr: errPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			dbService.images[ownedImage.ID] = ownedImage
+			dbService.images[sharedImage.ID] = sharedImage
+			g := GrpcServer{endpoint: newTestEndpoint(dbService, &fakeS3{}, owned, shared)}
+
+			err := g.authorize(context.Background(), tt.p, tt.request)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if dbService.bucketIDLookups != tt.wantLookups {
+				t.Errorf("запросов бакетов изображений %d, ожидалось %d", dbService.bucketIDLookups, tt.wantLookups)
+			}
+		})
+	}
+}
+
+// errorLogger считает записи журнала уровня Error
+type errorLogger struct {
+	nopLogger
+	errors int
+}
+
+func (l *errorLogger) Error(context.Context, error, ...zap.Field) { l.errors++ }
+
+func TestAuthenticate(t *testing.T) {
+	key, hash, err := newApiKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := string(api_models.RoleRead)
+	stored := models.ApiKey{ID: uuid.New(), Name: "reader", KeyHash: hash, Role: &role}
+
+	tests := []struct {
+		name       string
+		key        string
+		fail       error
+		wantErr    error
+		wantCode   codes.Code
+		wantErrors int
+	}{
+		{name: "действующий ключ", key: key},
+		{name: "ключ не передан", wantErr: errUnauthenticated, wantCode: codes.Unauthenticated},
+		// подбор ключей не засоряет журнал ошибками
+		{name: "неизвестный ключ", key: apiKeyPrefix + "unknown", wantErr: errUnauthenticated, wantCode: codes.Unauthenticated},
+		{name: "БД недоступна", key: key, fail: fmt.Errorf("соединение с БД разорвано"), wantErr: errAuthUnavailable, wantCode: codes.Unavailable, wantErrors: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			dbService.apiKeys = []models.ApiKey{stored}
+			dbService.fail = tt.fail
+			logger := &errorLogger{}
+			e := newTestEndpoint(dbService, &fakeS3{})
+			e.logger = logger
+			e.principals = map[string]*principal{}
+
+			p, err := e.authenticate(context.Background(), tt.key)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if err == nil && (p.keyID != stored.ID || p.role != api_models.RoleRead) {
+				t.Errorf("неожиданный владелец ключа %+v", p)
+			}
+			if err != nil && grpcstatus.Code(authError(err)) != tt.wantCode {
+				t.Errorf("код gRPC %v, ожидался %v", grpcstatus.Code(authError(err)), tt.wantCode)
+			}
+			if logger.errors != tt.wantErrors {
+				t.Errorf("ошибок в журнале %d, ожидалось %d", logger.errors, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"s3n/internal/db/models"
+)
+
+// drainBatchSize количество изображений, удаляемых за один проход, совпадает с лимитом DeleteObjects
+const drainBatchSize = 1000
+
+// archiveBucket переводит бакет в режим только для чтения: загрузка запрещена, изображения продолжают отдаваться.
+// Вызывается под блокировкой бакета lockBucket
+func (e *Endpoint) archiveBucket(ctx context.Context, bucket *models.Bucket) status.Status {
+	if bucket.Archived {
+		return status.OK
+	}
+
+	archived := *bucket
+	archived.Archived = true
+	err := e.dbService.UpdateBucket(ctx, &archived)
+	if err != nil {
+		err = fmt.Errorf("не удалось перевести бакет в архив: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName))
+		return status.InternalError
+	}
+
+	e.bucketCacheLock.Lock()
+	e.bucketCache[bucket.BucketName] = &archived
+	e.bucketCacheLock.Unlock()
+
+	return status.OK
+}
+
+// usedAsForeignWatermark проверяет, используется ли изображение бакета как водяной знак другого бакета
+func (e *Endpoint) usedAsForeignWatermark(ctx context.Context, bucketID int16) (bool, error) {
+	e.watermarkLock.RLock()
+	var imageIDs []uuid.UUID
+	for id, watermark := range e.watermarks {
+		if id != bucketID {
+			imageIDs = append(imageIDs, watermark.ImageID)
+		}
+	}
+	e.watermarkLock.RUnlock()
+
+	for _, id := range imageIDs {
+		image, err := e.dbService.GetImage(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if image.BucketID == bucketID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// drainBucket удаляет все изображения бакета из S3 и БД партиями и возвращает количество удалённых,
+// в том числе при ошибке. На время удаления бакет переводится в архив, чтобы в него не загружались
+// новые изображения
+func (e *Endpoint) drainBucket(ctx context.Context, bucket *models.Bucket) (int, status.Status) {
+	used, err := e.usedAsForeignWatermark(ctx, bucket.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось проверить водяные знаки: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName))
+		return 0, status.InternalError
+	}
+	if used {
+		err = fmt.Errorf("изображение бакета используется как водяной знак другого бакета")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName))
+		return 0, status.FailedPrecondition
+	}
+
+	if s := e.archiveBucket(ctx, bucket); s != status.OK {
+		return 0, s
+	}
+
+	// собственный водяной знак ссылается на изображение бакета и мешает его удалению
+	err = e.dbService.DeleteWatermark(ctx, bucket.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось удалить водяной знак бакета: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName))
+		return 0, status.InternalError
+	}
+	e.watermarkLock.Lock()
+	delete(e.watermarks, bucket.ID)
+	delete(e.watermarkOverlays, bucket.ID)
+	e.watermarkLock.Unlock()
+
+	total, err := e.dbService.CountImagesInBucket(ctx, bucket.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить количество изображений в бакете: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName))
+		return 0, status.InternalError
+	}
+
+	deleted := 0
+	for {
+		// удалённые партии пропадают из выборки, поэтому каждый раз выбирается начало бакета вместе с корзиной
+		images, err := e.dbService.GetImagesByBucketIDAfter(ctx, bucket.ID, uuid.Nil, drainBatchSize)
+		if err != nil {
+			err = fmt.Errorf("не удалось получить изображения бакета: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.Int("deleted", deleted))
+			return deleted, status.InternalError
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		ids := make([]uuid.UUID, 0, len(images))
+		for _, image := range images {
+			ids = append(ids, image.ID)
+		}
+
+		keys, err := e.imageKeys(ctx, images...)
+		if err != nil {
+			err = fmt.Errorf("не удалось получить ревизии изображений: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.Int("deleted", deleted))
+			return deleted, status.InternalError
+		}
+
+		// объекты удаляются раньше записей, чтобы при сбое не осталось файлов без записей в БД
+		err = e.s3Service.DeleteFiles(ctx, bucket.StorageName, keys)
+		if err != nil {
+			err = fmt.Errorf("не удалось удалить изображения из S3: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.Int("deleted", deleted))
+			return deleted, status.InternalError
+		}
+
+		err = e.dbService.DeleteImages(ctx, ids)
+		if err != nil {
+			err = fmt.Errorf("не удалось удалить изображения из БД: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.Int("deleted", deleted))
+			return deleted, status.InternalError
+		}
+
+		deleted += len(images)
+		e.logger.Info(ctx, "удаление изображений бакета",
+			zap.String("bucket_name", bucket.BucketName),
+			zap.Int("deleted", deleted),
+			zap.Int("total", max(total, deleted)),
+		)
+	}
+
+	return deleted, status.OK
+}
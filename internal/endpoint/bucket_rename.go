@@ -0,0 +1,162 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+)
+
+// RenameBucket меняет название бакета. Если в конфиге включён перенос, объекты копируются
+// в бакет S3 с новым названием, иначе остаются в прежнем
+func (e *Endpoint) RenameBucket(ctx context.Context, bucketName string, newBucketName string) (*api_models.Bucket, status.Status) {
+	const op = "Endpoint.RenameBucket"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if newBucketName == "" {
+		err := fmt.Errorf("пустое название бакета")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.IncorrectValue
+	}
+
+	bucket, unlock, ok := e.lockBucket(bucketName)
+	if !ok {
+		err := fmt.Errorf("не удалось найти бакет в кеше")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.NotFound
+	}
+	defer unlock()
+
+	// бакет, переименованный без переноса, можно вернуть к названию его бакета S3
+	migrate := e.renameStorage && bucket.StorageName != newBucketName
+
+	e.bucketCacheLock.Lock()
+	exists := e.bucketNameTaken(newBucketName, bucket)
+	if !exists && migrate {
+		// на время переноса загрузка в бакет запрещена, чтобы не потерять новые изображения
+		locked := *bucket
+		locked.Archived = true
+		e.bucketCache[bucketName] = &locked
+	}
+	e.bucketCacheLock.Unlock()
+	if exists {
+		err := fmt.Errorf("бакет с таким названием уже зарегистрирован")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("new_bucket_name", newBucketName))
+		return nil, status.IncorrectValue
+	}
+
+	renamed := *bucket
+	renamed.BucketName = newBucketName
+
+	var movedKeys []string
+	if migrate {
+		var err error
+		movedKeys, err = e.migrateStorage(ctx, bucket, newBucketName)
+		if err != nil {
+			e.bucketCacheLock.Lock()
+			e.bucketCache[bucketName] = bucket
+			e.bucketCacheLock.Unlock()
+
+			err = fmt.Errorf("не удалось перенести объекты бакета: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("new_bucket_name", newBucketName))
+			return nil, status.InternalError
+		}
+		renamed.StorageName = newBucketName
+	}
+
+	err := e.dbService.UpdateBucket(ctx, &renamed)
+	if err != nil {
+		e.bucketCacheLock.Lock()
+		e.bucketCache[bucketName] = bucket
+		e.bucketCacheLock.Unlock()
+
+		err = fmt.Errorf("не удалось переименовать бакет в БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("new_bucket_name", newBucketName))
+		return nil, status.InternalError
+	}
+	e.bucketCacheLock.Lock()
+	delete(e.bucketCache, bucketName)
+	e.bucketCache[newBucketName] = &renamed
+	e.bucketCacheLock.Unlock()
+
+	if len(movedKeys) > 0 {
+		err = e.s3Service.DeleteFiles(ctx, bucket.StorageName, movedKeys)
+		if err != nil {
+			// бакет уже переименован, в прежнем бакете S3 остаются лишние объекты
+			err = fmt.Errorf("не удалось удалить перенесённые объекты: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", newBucketName), zap.String("storage_name", bucket.StorageName))
+		}
+	}
+
+	return bucketToAPI(&renamed), status.OK
+}
+
+// migrateStorage создаёт бакет S3 с новым названием с правилами CORS и жизненного цикла прежнего
+// и копирует в него объекты бакета. Возвращает ключи скопированных объектов, которые нужно удалить
+// из прежнего бакета S3
+func (e *Endpoint) migrateStorage(ctx context.Context, bucket *models.Bucket, storageName string) ([]string, error) {
+	cors, err := e.s3Service.GetBucketCors(ctx, bucket.StorageName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.prepareStorage(ctx, storageName, &api_models.RegisterBucketOptions{
+		CreateStorage: true,
+		Private:       bucket.Private,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(cors) > 0 {
+		err = e.s3Service.SetBucketCors(ctx, storageName, cors)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// метаданные объектов ссылаются на новое название бакета
+	target := *bucket
+	target.BucketName = storageName
+	target.StorageName = storageName
+	if target.DefaultTTL > 0 {
+		e.applyLifecycle(ctx, &target)
+	}
+
+	var keys []string
+	after := uuid.Nil
+	for {
+		images, err := e.dbService.GetImagesByBucketIDAfter(ctx, bucket.ID, after, drainBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(images) == 0 {
+			break
+		}
+
+		for _, image := range images {
+			imageKeys, err := e.imageKeys(ctx, image)
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range imageKeys {
+				err = e.s3Service.CopyFile(ctx, bucket.StorageName, key, storageName, key, e.objectAttributes(&target, &image))
+				if err != nil {
+					return nil, err
+				}
+				keys = append(keys, key)
+			}
+		}
+		after = images[len(images)-1].ID
+
+		e.logger.Info(ctx, "перенос объектов бакета",
+			zap.String("bucket_name", bucket.BucketName),
+			zap.String("storage_name", storageName),
+			zap.Int("copied_objects", len(keys)),
+		)
+	}
+
+	return keys, nil
+}
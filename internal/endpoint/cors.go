@@ -0,0 +1,153 @@
+package endpoint
+
+import (
+	chi "github.com/go-chi/chi/v5"
+	"net/http"
+	"s3n/internal/config"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsPolicy настройки CORS, разобранные из config.CorsConfig
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	methods   []string
+	headers   string // Access-Control-Allow-Headers, пустая строка при anyHeader
+	anyHeader bool
+	exposed   string
+	maxAge    string
+}
+
+// newCorsPolicy разбирает настройки CORS, nil - источники не заданы и CORS отключён
+func newCorsPolicy(config *config.CorsConfig) *corsPolicy {
+	if len(config.AllowedOrigins) == 0 {
+		return nil
+	}
+
+	p := &corsPolicy{
+		origins: map[string]bool{},
+		methods: []string{http.MethodGet, http.MethodHead},
+		exposed: strings.Join(config.ExposedHeaders, ", "),
+	}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+		}
+		p.origins[strings.ToLower(origin)] = true
+	}
+	if len(config.AllowedMethods) > 0 {
+		p.methods = p.methods[:0]
+		for _, method := range config.AllowedMethods {
+			p.methods = append(p.methods, strings.ToUpper(method))
+		}
+	}
+	if slices.Contains(config.AllowedHeaders, "*") {
+		p.anyHeader = true
+	} else {
+		p.headers = strings.Join(config.AllowedHeaders, ", ")
+	}
+	if config.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(config.MaxAge.Seconds()))
+	}
+	return p
+}
+
+// corsPolicies общие настройки CORS и настройки отдельных бакетов
+type corsPolicies struct {
+	global  *corsPolicy
+	buckets map[string]*corsPolicy
+}
+
+func newCorsPolicies(config *config.HttpRedirectConfig) *corsPolicies {
+	p := &corsPolicies{
+		global:  newCorsPolicy(&config.Cors),
+		buckets: map[string]*corsPolicy{},
+	}
+	for bucketName, bucketConfig := range config.BucketCors {
+		p.buckets[bucketName] = newCorsPolicy(&bucketConfig)
+	}
+	return p
+}
+
+// forBucket возвращает настройки CORS бакета, nil - CORS для бакета отключён
+func (p *corsPolicies) forBucket(bucketName string) *corsPolicy {
+	if policy, ok := p.buckets[bucketName]; ok {
+		return policy
+	}
+	return p.global
+}
+
+// corsMiddleware добавляет заголовки CORS к ответам и отвечает на preflight запросы.
+// Регистрируется после сопоставления маршрута, потому что настройки зависят от бакета в пути
+func (s *RedirectServer) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := s.cors.forBucket(chi.URLParam(r, "bucket"))
+		if policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed := policy.anyOrigin || policy.origins[strings.ToLower(origin)]
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+			method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+			if !allowed || !slices.Contains(policy.methods, method) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			policy.setOrigin(header, origin)
+			header.Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+			if policy.anyHeader {
+				if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+					header.Set("Access-Control-Allow-Headers", requested)
+				}
+			} else if policy.headers != "" {
+				header.Set("Access-Control-Allow-Headers", policy.headers)
+			}
+			if policy.maxAge != "" {
+				header.Set("Access-Control-Max-Age", policy.maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			policy.setOrigin(header, origin)
+			if policy.exposed != "" {
+				header.Set("Access-Control-Expose-Headers", policy.exposed)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *corsPolicy) setOrigin(header http.Header, origin string) {
+	if p.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+}
+
+// staticHeaders добавляет заданные в конфиге заголовки ко всем ответам
+func staticHeaders(headers map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range headers {
+				w.Header().Set(name, value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
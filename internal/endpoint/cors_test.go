@@ -0,0 +1,150 @@
+package endpoint
+
+import (
+	chi "github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"s3n/internal/config"
+	"testing"
+	"time"
+)
+
+func TestCorsMiddleware(t *testing.T) {
+	cfg := &config.HttpRedirectConfig{
+		Cors: config.CorsConfig{
+			AllowedOrigins: []string{"https://Example.com"},
+			AllowedHeaders: []string{"Range", "If-None-Match"},
+			ExposedHeaders: []string{"Content-Range", "ETag"},
+			MaxAge:         10 * time.Minute,
+		},
+		BucketCors: map[string]config.CorsConfig{
+			"public":  {AllowedOrigins: []string{"*"}, AllowedMethods: []string{"get"}, AllowedHeaders: []string{"*"}},
+			"private": {},
+		},
+		Headers: map[string]string{"X-Content-Type-Options": "nosniff"},
+	}
+	s := &RedirectServer{cors: newCorsPolicies(cfg)}
+	r := chi.NewRouter()
+	r.Use(staticHeaders(cfg.Headers))
+	r.With(s.corsMiddleware).HandleFunc("/{bucket}/{filename}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		header        map[string]string
+		wantCode      int
+		wantOrigin    string
+		wantMethods   string
+		wantHeaders   string
+		wantExposed   string
+		wantMaxAge    string
+		wantVaryEmpty bool
+	}{
+		{
+			name:        "разрешённый источник",
+			path:        "/b/image",
+			header:      map[string]string{"Origin": "https://example.com"},
+			wantCode:    http.StatusOK,
+			wantOrigin:  "https://example.com",
+			wantExposed: "Content-Range, ETag",
+		},
+		{
+			name:     "чужой источник",
+			path:     "/b/image",
+			header:   map[string]string{"Origin": "https://attacker.io"},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "без Origin",
+			path:     "/b/image",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:        "preflight",
+			method:      http.MethodOptions,
+			path:        "/b/image",
+			header:      map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "GET"},
+			wantCode:    http.StatusNoContent,
+			wantOrigin:  "https://example.com",
+			wantMethods: "GET, HEAD",
+			wantHeaders: "Range, If-None-Match",
+			wantMaxAge:  "600",
+		},
+		{
+			name:     "preflight с запрещённым методом",
+			method:   http.MethodOptions,
+			path:     "/b/image",
+			header:   map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "DELETE"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "preflight с чужого источника",
+			method:   http.MethodOptions,
+			path:     "/b/image",
+			header:   map[string]string{"Origin": "https://attacker.io", "Access-Control-Request-Method": "GET"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:       "любой источник бакета",
+			path:       "/public/image",
+			header:     map[string]string{"Origin": "https://attacker.io"},
+			wantCode:   http.StatusOK,
+			wantOrigin: "*",
+		},
+		{
+			name:        "любые заголовки бакета",
+			method:      http.MethodOptions,
+			path:        "/public/image",
+			header:      map[string]string{"Origin": "https://attacker.io", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Custom"},
+			wantCode:    http.StatusNoContent,
+			wantOrigin:  "*",
+			wantMethods: "GET",
+			wantHeaders: "X-Custom",
+		},
+		{
+			name:          "CORS бакета отключён",
+			path:          "/private/image",
+			header:        map[string]string{"Origin": "https://example.com"},
+			wantCode:      http.StatusOK,
+			wantVaryEmpty: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			header := w.Result().Header
+			if w.Code != tt.wantCode {
+				t.Fatalf("код %d, ожидался %d", w.Code, tt.wantCode)
+			}
+			checks := map[string]string{
+				"Access-Control-Allow-Origin":   tt.wantOrigin,
+				"Access-Control-Allow-Methods":  tt.wantMethods,
+				"Access-Control-Allow-Headers":  tt.wantHeaders,
+				"Access-Control-Expose-Headers": tt.wantExposed,
+				"Access-Control-Max-Age":        tt.wantMaxAge,
+				"X-Content-Type-Options":        "nosniff",
+			}
+			for name, want := range checks {
+				if got := header.Get(name); got != want {
+					t.Errorf("%s = %q, ожидалось %q", name, got, want)
+				}
+			}
+			if vary := header.Get("Vary"); (vary == "") != tt.wantVaryEmpty {
+				t.Errorf("Vary = %q", vary)
+			}
+		})
+	}
+}
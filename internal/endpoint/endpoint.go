@@ -7,13 +7,17 @@ import (
 	"github.com/budka-tech/snip-common-go/status"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"image"
 	"math"
+	"s3n/internal/config"
 	"s3n/internal/db"
 	"s3n/internal/db/models"
 	"s3n/internal/endpoint/api_models"
 	"s3n/internal/image_processing"
 	"s3n/internal/s3"
+	"slices"
 	"sync"
+	"time"
 )
 
 type Endpoint struct {
@@ -21,8 +25,28 @@ type Endpoint struct {
 	dbService       db.Service
 	imageService    image_processing.Service
 	logger          logit.Logger
-	bucketCache     map[string]int16
+	renameStorage   bool
+	keepRevisions   int
+	trashRetention  time.Duration
+	purgeInterval   time.Duration
+	expiryInterval  time.Duration
+	bucketCache     map[string]*models.Bucket
 	bucketCacheLock sync.RWMutex
+	// bucketLocks блокировки изменения бакетов по ID, чтобы запись в БД и S3 не удерживала bucketCacheLock
+	bucketLocks sync.Map
+
+	watermarks        map[int16]*models.Watermark
+	watermarkOverlays map[int16]image.Image
+	watermarkLock     sync.RWMutex
+
+	authEnabled    bool
+	rootKeyHash    []byte
+	authCacheTTL   time.Duration
+	principals     map[string]*principal
+	principalsLock sync.Mutex
+
+	tenantQuotas map[string]config.QuotaConfig
+	uploads      *uploadCounter
 }
 
 func imageToAPI(image *models.Image) *api_models.Image {
@@ -30,9 +54,15 @@ func imageToAPI(image *models.Image) *api_models.Image {
 		return nil
 	}
 
-	return &api_models.Image{
-		ID: image.ID,
+	apiImage := &api_models.Image{
+		ID:        image.ID,
+		Revision:  image.Revision,
+		ExpiresAt: image.ExpiresAt,
 	}
+	if image.Slug != nil {
+		apiImage.Slug = *image.Slug
+	}
+	return apiImage
 }
 
 func imageWithBucketToAPI(image *models.Image, bucketName string) *api_models.ImageWithBucket {
@@ -51,12 +81,78 @@ func bucketToAPI(bucket *models.Bucket) *api_models.Bucket {
 		return nil
 	}
 
-	return &api_models.Bucket{
-		BucketName: bucket.BucketName,
+	apiBucket := &api_models.Bucket{
+		BucketName:   bucket.BucketName,
+		CacheControl: bucket.CacheControl,
+		Private:      bucket.Private,
+		Archived:     bucket.Archived,
+		DefaultTTL:   bucket.DefaultTTL,
+
+		FallbackImageID: bucket.FallbackImageID,
 	}
+	if len(bucket.HotlinkHosts) > 0 {
+		apiBucket.Hotlink = &api_models.HotlinkProtection{
+			AllowedHosts:       bucket.HotlinkHosts,
+			AllowEmptyReferer:  bucket.HotlinkAllowEmpty,
+			PlaceholderImageID: bucket.HotlinkImageID,
+		}
+	}
+	if bucket.Tenant != nil {
+		apiBucket.Tenant = *bucket.Tenant
+	}
+	apiBucket.Quota = bucketQuota(bucket)
+	if bucket.TargetSize > 0 || bucket.MinSSIM > 0 {
+		apiBucket.Target = &api_models.EncodingTarget{
+			MaxBytes:       bucket.TargetSize,
+			MinSSIM:        bucket.MinSSIM,
+			AllowDownscale: bucket.AllowDownscale,
+		}
+	}
+
+	return apiBucket
+}
+
+// objectAttributes заголовки, метаданные и теги объекта изображения
+func (e *Endpoint) objectAttributes(bucket *models.Bucket, image *models.Image) *s3.ObjectAttributes {
+	attributes := &s3.ObjectAttributes{
+		ContentType:        image_processing.ContentType(image_processing.OutputFormat),
+		CacheControl:       bucket.CacheControl,
+		ContentDisposition: fmt.Sprintf("inline; filename=%q", e.s3Service.FileName(image.ID)),
+		Metadata: map[string]string{
+			"image-id": image.ID.String(),
+			"bucket":   bucket.BucketName,
+		},
+		Private: bucket.Private,
+	}
+
+	// правило жизненного цикла удаляет объект не раньше срока жизни бакета после загрузки,
+	// поэтому тег ставится только изображениям, которые истекают не позже этого срока
+	ttl := time.Duration(bucket.DefaultTTL) * time.Second
+	if image.ExpiresAt != nil && ttl > 0 && !image.ExpiresAt.After(time.Now().Add(ttl)) {
+		attributes.Tags = map[string]string{s3.ExpiringTag: "true"}
+	}
+
+	return attributes
 }
 
-func NewEndpoint(ctx context.Context, s3Service s3.Service, dbService db.Service, imageService image_processing.Service, logger logit.Logger) (*Endpoint, error) {
+// imageKey ключ объекта текущей ревизии изображения
+func (e *Endpoint) imageKey(image *models.Image) string {
+	return e.s3Service.FileNameRevision(image.ID, image.Revision)
+}
+
+func targetToOptions(target *api_models.EncodingTarget) *image_processing.Target {
+	if target == nil || (target.MaxBytes <= 0 && target.MinSSIM <= 0) {
+		return nil
+	}
+
+	return &image_processing.Target{
+		MaxBytes:       max(target.MaxBytes, 0),
+		MinSSIM:        float64(max(target.MinSSIM, 0)),
+		AllowDownscale: target.AllowDownscale,
+	}
+}
+
+func NewEndpoint(ctx context.Context, s3Service s3.Service, dbService db.Service, imageService image_processing.Service, config *config.EndpointConfig, logger logit.Logger) (*Endpoint, error) {
 	const op = "Endpoint.NewEndpoint"
 	ctx = logger.NewOpCtx(ctx, op)
 
@@ -66,25 +162,136 @@ func NewEndpoint(ctx context.Context, s3Service s3.Service, dbService db.Service
 		logger.Error(ctx, err)
 		return nil, err
 	}
-	bucketCache := map[string]int16{}
+	bucketCache := map[string]*models.Bucket{}
 	for _, bucket := range buckets {
-		bucketCache[bucket.BucketName] = bucket.ID
+		bucketCache[bucket.BucketName] = &bucket
+	}
+
+	e := &Endpoint{
+		s3Service:         s3Service,
+		dbService:         dbService,
+		imageService:      imageService,
+		logger:            logger,
+		renameStorage:     config.RenameStorage,
+		keepRevisions:     max(config.KeepRevisions, 1),
+		trashRetention:    config.TrashRetention,
+		purgeInterval:     config.PurgeInterval,
+		expiryInterval:    config.ExpirySweepInterval,
+		bucketCache:       bucketCache,
+		watermarks:        map[int16]*models.Watermark{},
+		watermarkOverlays: map[int16]image.Image{},
+		authEnabled:       config.Auth.Enabled,
+		authCacheTTL:      config.Auth.CacheTTL,
+		principals:        map[string]*principal{},
+		tenantQuotas:      config.TenantQuotas,
+		uploads:           newUploadCounter(),
+	}
+	if config.Auth.RootKey != "" {
+		e.rootKeyHash = hashApiKey(config.Auth.RootKey)
+	} else if config.Auth.Enabled {
+		logger.Warn(ctx, "корневой ключ API не задан, выпустить ключи можно только существующим ключом с ролью admin")
+	}
+
+	err = e.loadWatermarks(ctx)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить водяные знаки в БД: %w", err)
+		logger.Error(ctx, err)
+		return nil, err
 	}
 
-	return &Endpoint{
-		s3Service:    s3Service,
-		dbService:    dbService,
-		imageService: imageService,
-		logger:       logger,
-		bucketCache:  bucketCache,
-	}, nil
+	return e, nil
 }
 
-func (e *Endpoint) RegisterBucket(ctx context.Context, bucketName string) (*api_models.Bucket, status.Status) {
+// prepareStorage создаёт и настраивает бакет в S3 и проверяет, что в него можно загружать изображения
+func (e *Endpoint) prepareStorage(ctx context.Context, bucketName string, options *api_models.RegisterBucketOptions) error {
+	if options.CreateStorage {
+		err := e.s3Service.CreateBucket(ctx, bucketName)
+		if err != nil {
+			return err
+		}
+	}
+	// политика существующего бакета меняется, только если он должен быть закрытым,
+	// иначе приватные объекты остались бы доступны по публичной политике
+	if options.CreateStorage || options.Private {
+		err := e.s3Service.SetBucketPublic(ctx, bucketName, !options.Private)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(options.Cors) > 0 {
+		rules := make([]s3.CorsRule, 0, len(options.Cors))
+		for _, rule := range options.Cors {
+			rules = append(rules, s3.CorsRule{
+				AllowedOrigins: rule.AllowedOrigins,
+				AllowedMethods: rule.AllowedMethods,
+				AllowedHeaders: rule.AllowedHeaders,
+				MaxAgeSeconds:  rule.MaxAgeSeconds,
+			})
+		}
+
+		err := e.s3Service.SetBucketCors(ctx, bucketName, rules)
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.s3Service.CheckWriteAccess(ctx, bucketName)
+}
+
+func (e *Endpoint) RegisterBucket(ctx context.Context, bucketName string, options *api_models.RegisterBucketOptions) (*api_models.Bucket, status.Status) {
 	const op = "Endpoint.RegisterBucket"
 	ctx = e.logger.NewOpCtx(ctx, op)
 
-	bucket, err := e.dbService.CreateBucket(ctx, bucketName)
+	if options == nil {
+		options = &api_models.RegisterBucketOptions{}
+	}
+
+	for _, rule := range options.Cors {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 || rule.MaxAgeSeconds < 0 {
+			err := fmt.Errorf("некорректное правило CORS")
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.Any("rule", rule))
+			return nil, status.IncorrectValue
+		}
+	}
+
+	tenant := options.Tenant
+	if len(tenant) > maxTenantLength {
+		err := fmt.Errorf("слишком длинный идентификатор владельца")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("tenant", tenant))
+		return nil, status.IncorrectValue
+	}
+	if callerTenant := tenantFrom(ctx); callerTenant != "" {
+		if tenant != "" && tenant != callerTenant {
+			err := fmt.Errorf("владелец бакета не совпадает с владельцем ключа")
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("tenant", tenant))
+			return nil, status.IncorrectValue
+		}
+		tenant = callerTenant
+	}
+	var bucketTenant *string
+	if tenant != "" {
+		bucketTenant = &tenant
+	}
+
+	e.bucketCacheLock.RLock()
+	exists := e.bucketNameTaken(bucketName, nil)
+	e.bucketCacheLock.RUnlock()
+	if exists {
+		err := fmt.Errorf("бакет или бакет S3 с таким названием уже зарегистрирован")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.IncorrectValue
+	}
+
+	// запись в БД появляется только после того, как бакет в S3 готов к загрузке
+	err := e.prepareStorage(ctx, bucketName, options)
+	if err != nil {
+		err = fmt.Errorf("бакет S3 недоступен для загрузки: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.InternalError
+	}
+
+	bucket, err := e.dbService.CreateBucket(ctx, bucketName, options.Private, bucketTenant)
 	if err != nil {
 		err = fmt.Errorf("не удалось добавить бакет в БД: %w", err)
 		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
@@ -92,12 +299,26 @@ func (e *Endpoint) RegisterBucket(ctx context.Context, bucketName string) (*api_
 	}
 
 	e.bucketCacheLock.Lock()
-	e.bucketCache[bucket.BucketName] = bucket.ID
+	e.bucketCache[bucket.BucketName] = bucket
 	e.bucketCacheLock.Unlock()
 
 	return bucketToAPI(bucket), status.OK
 }
 
+// bucketNameTaken проверяет, занято ли название другим бакетом, кроме except: как название бакета
+// или как бакет S3 бакета, переименованного без переноса объектов. Вызывается под bucketCacheLock
+func (e *Endpoint) bucketNameTaken(name string, except *models.Bucket) bool {
+	for bucketName, bucket := range e.bucketCache {
+		if except != nil && bucket.ID == except.ID {
+			continue
+		}
+		if bucketName == name || bucket.StorageName == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *Endpoint) HasBucket(ctx context.Context, bucketName string) (bool, status.Status) {
 	const op = "Endpoint.GetBucket"
 	ctx = e.logger.NewOpCtx(ctx, op)
@@ -109,46 +330,286 @@ func (e *Endpoint) HasBucket(ctx context.Context, bucketName string) (bool, stat
 	return ok, status.OK
 }
 
-func (e *Endpoint) UnregisterBucket(ctx context.Context, bucketName string) status.Status {
-	const op = "Endpoint.UnregisterBucket"
+func (e *Endpoint) GetBucket(ctx context.Context, bucketName string) (*api_models.Bucket, status.Status) {
+	const op = "Endpoint.GetBucket"
 	ctx = e.logger.NewOpCtx(ctx, op)
 
-	e.bucketCacheLock.Lock()
-	{
-		id, ok := e.bucketCache[bucketName]
-		if !ok {
-			err := fmt.Errorf("не удалось найти бакет в кеше")
-			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
-			e.bucketCacheLock.Unlock()
-			return status.NotFound
+	e.bucketCacheLock.RLock()
+	bucket, ok := e.bucketCache[bucketName]
+	e.bucketCacheLock.RUnlock()
+	if !ok {
+		err := fmt.Errorf("не удалось найти бакет в кеше")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.NotFound
+	}
+
+	return bucketToAPI(bucket), status.OK
+}
+
+// UpdateBucket меняет поля настроек бакета из fields, пустой fields - только заданные в settings поля.
+// Чтобы сбросить поле, его нужно указать в fields
+func (e *Endpoint) UpdateBucket(ctx context.Context, bucketName string, settings api_models.BucketSettings, fields []string) (*api_models.Bucket, status.Status) {
+	const op = "Endpoint.UpdateBucket"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if len(fields) == 0 {
+		fields = bucketSettingsFields(settings)
+	}
+	update := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		switch field {
+		case api_models.BucketFieldTarget, api_models.BucketFieldCacheControl, api_models.BucketFieldDefaultTTL,
+			api_models.BucketFieldFallbackImageID, api_models.BucketFieldHotlink, api_models.BucketFieldQuota:
+			update[field] = true
+		default:
+			err := fmt.Errorf("неизвестное поле настроек бакета")
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("field", field))
+			return nil, status.IncorrectValue
+		}
+	}
+	// квоты ограничивают владельца, поэтому их меняет только оператор
+	if update[api_models.BucketFieldQuota] && tenantFrom(ctx) != "" {
+		err := fmt.Errorf("квоту бакета может изменить только ключ оператора")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("tenant", tenantFrom(ctx)))
+		return nil, status.IncorrectValue
+	}
+
+	if target := settings.Target; target != nil && (target.MaxBytes < 0 || target.MinSSIM < 0 || target.MinSSIM > 1) {
+		err := fmt.Errorf("некорректные параметры подбора качества")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.Any("target", target))
+		return nil, status.IncorrectValue
+	}
+	if settings.DefaultTTL < 0 {
+		err := fmt.Errorf("отрицательный срок жизни изображений")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.Int("default_ttl", settings.DefaultTTL))
+		return nil, status.IncorrectValue
+	}
+	if id := settings.FallbackImageID; id != nil {
+		_, err := e.dbService.GetImage(ctx, *id)
+		if err != nil {
+			err = fmt.Errorf("не удалось получить запасное изображение: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("image_id", id.String()))
+			return nil, status.NotFound
+		}
+	}
+	if quota := settings.Quota; quota != nil && (quota.MaxImages < 0 || quota.MaxBytes < 0 || quota.MaxUploadsPerMinute < 0) {
+		err := fmt.Errorf("отрицательная квота бакета")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.Any("quota", quota))
+		return nil, status.IncorrectValue
+	}
+	var hotlinkHosts []string
+	if hotlink := settings.Hotlink; hotlink != nil {
+		var err error
+		hotlinkHosts, err = normalizeHotlinkHosts(hotlink.AllowedHosts)
+		if err != nil {
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.Strings("allowed_hosts", hotlink.AllowedHosts))
+			return nil, status.IncorrectValue
+		}
+		if id := hotlink.PlaceholderImageID; id != nil {
+			_, err := e.dbService.GetImage(ctx, *id)
+			if err != nil {
+				err = fmt.Errorf("не удалось получить изображение для запрещённых запросов: %w", err)
+				e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("image_id", id.String()))
+				return nil, status.NotFound
+			}
+		}
+	}
+
+	bucket, unlock, ok := e.lockBucket(bucketName)
+	if !ok {
+		err := fmt.Errorf("не удалось найти бакет в кеше")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.NotFound
+	}
+	defer unlock()
+
+	// закешированные бакеты не изменяются, чтобы их можно было читать без блокировки
+	updated := *bucket
+	if update[api_models.BucketFieldTarget] {
+		updated.TargetSize = 0
+		updated.MinSSIM = 0
+		updated.AllowDownscale = false
+		if settings.Target != nil {
+			updated.TargetSize = settings.Target.MaxBytes
+			updated.MinSSIM = settings.Target.MinSSIM
+			updated.AllowDownscale = settings.Target.AllowDownscale
+		}
+	}
+	if update[api_models.BucketFieldCacheControl] {
+		updated.CacheControl = settings.CacheControl
+	}
+	if update[api_models.BucketFieldDefaultTTL] {
+		updated.DefaultTTL = settings.DefaultTTL
+	}
+	if update[api_models.BucketFieldFallbackImageID] {
+		updated.FallbackImageID = settings.FallbackImageID
+	}
+	if update[api_models.BucketFieldHotlink] {
+		updated.HotlinkHosts = hotlinkHosts
+		updated.HotlinkAllowEmpty = false
+		updated.HotlinkImageID = nil
+		if settings.Hotlink != nil {
+			updated.HotlinkAllowEmpty = settings.Hotlink.AllowEmptyReferer
+			updated.HotlinkImageID = settings.Hotlink.PlaceholderImageID
+		}
+	}
+	if update[api_models.BucketFieldQuota] {
+		updated.QuotaImages = 0
+		updated.QuotaBytes = 0
+		updated.QuotaUploads = 0
+		if settings.Quota != nil {
+			updated.QuotaImages = settings.Quota.MaxImages
+			updated.QuotaBytes = settings.Quota.MaxBytes
+			updated.QuotaUploads = settings.Quota.MaxUploadsPerMinute
 		}
+	}
+
+	err := e.dbService.UpdateBucket(ctx, &updated)
+	if err != nil {
+		err = fmt.Errorf("не удалось обновить бакет в БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.InternalError
+	}
+
+	e.bucketCacheLock.Lock()
+	e.bucketCache[bucketName] = &updated
+	e.bucketCacheLock.Unlock()
+
+	if updated.DefaultTTL != bucket.DefaultTTL {
+		e.applyLifecycle(ctx, &updated)
+	}
+
+	return bucketToAPI(&updated), status.OK
+}
+
+// bucketSettingsFields поля, заданные в settings
+func bucketSettingsFields(settings api_models.BucketSettings) []string {
+	var fields []string
+	if settings.Target != nil {
+		fields = append(fields, api_models.BucketFieldTarget)
+	}
+	if settings.CacheControl != "" {
+		fields = append(fields, api_models.BucketFieldCacheControl)
+	}
+	if settings.DefaultTTL != 0 {
+		fields = append(fields, api_models.BucketFieldDefaultTTL)
+	}
+	if settings.FallbackImageID != nil {
+		fields = append(fields, api_models.BucketFieldFallbackImageID)
+	}
+	if settings.Hotlink != nil {
+		fields = append(fields, api_models.BucketFieldHotlink)
+	}
+	if settings.Quota != nil {
+		fields = append(fields, api_models.BucketFieldQuota)
+	}
+	return fields
+}
+
+// updatesQuota проверяет, меняет ли обновление настроек квоту бакета. hasQuota - квота задана в настройках,
+// fields - обновляемые поля, пустой список - поля, заданные в настройках
+func updatesQuota(hasQuota bool, fields []string) bool {
+	if len(fields) == 0 {
+		return hasQuota
+	}
+	return slices.Contains(fields, api_models.BucketFieldQuota)
+}
 
-		err := e.dbService.DeleteBucket(ctx, id)
+// lockBucket находит бакет в кеше и запрещает другим запросам менять его до вызова unlock.
+// Чтение кеша при этом не блокируется
+func (e *Endpoint) lockBucket(bucketName string) (bucket *models.Bucket, unlock func(), ok bool) {
+	bucket, ok = e.bucketByName(bucketName)
+	if !ok {
+		return nil, nil, false
+	}
+	value, _ := e.bucketLocks.LoadOrStore(bucket.ID, &sync.Mutex{})
+	lock := value.(*sync.Mutex)
+	lock.Lock()
+	unlock = lock.Unlock
+
+	// бакет мог быть переименован или удалён, пока блокировка не удерживалась
+	current, ok := e.bucketByName(bucketName)
+	if !ok || current.ID != bucket.ID {
+		unlock()
+		return nil, nil, false
+	}
+	return current, unlock, true
+}
+
+func (e *Endpoint) UnregisterBucket(ctx context.Context, bucketName string, mode api_models.UnregisterMode) (*api_models.UnregisterResult, status.Status) {
+	const op = "Endpoint.UnregisterBucket"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if !mode.Valid() {
+		err := fmt.Errorf("неизвестный режим удаления бакета")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("mode", string(mode)))
+		return nil, status.IncorrectValue
+	}
+
+	bucket, unlock, ok := e.lockBucket(bucketName)
+	if !ok {
+		err := fmt.Errorf("не удалось найти бакет в кеше")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.NotFound
+	}
+	defer unlock()
+
+	result := &api_models.UnregisterResult{}
+	switch mode {
+	case api_models.UnregisterArchive:
+		return result, e.archiveBucket(ctx, bucket)
+	case api_models.UnregisterCascade:
+		var s status.Status
+		result.DeletedImages, s = e.drainBucket(ctx, bucket)
+		if s != status.OK {
+			return result, s
+		}
+	default:
+		count, err := e.dbService.CountImagesInBucket(ctx, bucket.ID)
 		if err != nil {
-			err = fmt.Errorf("не удалось удалить бакет из БД: %w", err)
+			err = fmt.Errorf("не удалось получить количество изображений в бакете: %w", err)
 			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
-			e.bucketCacheLock.Unlock()
-			return status.InternalError
+			return result, status.InternalError
 		}
+		if count > 0 {
+			err = fmt.Errorf("бакет содержит изображения")
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.Int("images", count))
+			return result, status.FailedPrecondition
+		}
+	}
 
-		delete(e.bucketCache, bucketName)
+	err := e.dbService.DeleteBucket(ctx, bucket.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось удалить бакет из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return result, status.InternalError
 	}
+
+	e.bucketCacheLock.Lock()
+	delete(e.bucketCache, bucketName)
 	e.bucketCacheLock.Unlock()
 
-	return status.OK
+	e.watermarkLock.Lock()
+	delete(e.watermarks, bucket.ID)
+	delete(e.watermarkOverlays, bucket.ID)
+	e.watermarkLock.Unlock()
+
+	return result, status.OK
 }
 
 func (e *Endpoint) GetAllBuckets(ctx context.Context) ([]api_models.Bucket, status.Status) {
 	const op = "Endpoint.GetAllBuckets"
 	ctx = e.logger.NewOpCtx(ctx, op)
 
+	// ключ владельца видит только его бакеты
+	tenant := tenantFrom(ctx)
 	var buckets []api_models.Bucket
 	e.bucketCacheLock.RLock()
 	{
-		for name, _ := range e.bucketCache {
-			buckets = append(buckets, api_models.Bucket{
-				BucketName: name,
-			})
+		for _, bucket := range e.bucketCache {
+			if tenant == "" || ownedBy(bucket, tenant) {
+				buckets = append(buckets, *bucketToAPI(bucket))
+			}
 		}
 	}
 	e.bucketCacheLock.RUnlock()
@@ -156,34 +617,89 @@ func (e *Endpoint) GetAllBuckets(ctx context.Context) ([]api_models.Bucket, stat
 	return buckets, status.OK
 }
 
-func (e *Endpoint) CreateImage(ctx context.Context, bucketName string, file []byte, fileExtension string, quality *float32, maxSize *int, id *uuid.UUID) (*api_models.Image, status.Status) {
-	const op = "Endpoint.CreateImage"
-	ctx = e.logger.NewOpCtx(ctx, op)
+// transform обрабатывает изображение с водяным знаком и подбором качества бакета
+func (e *Endpoint) transform(ctx context.Context, bucket *models.Bucket, file []byte, fileExtension string, quality *float32, maxSize *int, frame *int, target *api_models.EncodingTarget) (*image_processing.Result, status.Status) {
+	watermark, err := e.bucketWatermark(ctx, bucket.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить водяной знак бакета: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName))
+		return nil, status.InternalError
+	}
 
-	e.bucketCacheLock.RLock()
-	bucketId, ok := e.bucketCache[bucketName]
-	e.bucketCacheLock.RUnlock()
-	if !ok {
-		err := fmt.Errorf("не удалось найти бакет в кеше")
-		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
-		return nil, status.NotFound
+	// подбор качества бакета заменяет только качество по умолчанию
+	if target == nil && quality == nil {
+		target = bucketToAPI(bucket).Target
 	}
 
-	processedFile, err := e.imageService.Transform(ctx, file, fileExtension, quality, maxSize)
+	processedFile, err := e.imageService.Transform(ctx, file, fileExtension, &image_processing.Options{
+		Quality:   quality,
+		MaxSize:   maxSize,
+		Watermark: watermark,
+		Frame:     frame,
+		Target:    targetToOptions(target),
+	})
 	if err != nil {
 		err = fmt.Errorf("не удалось обработать изображение: %w", err)
-		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName))
 		return nil, status.InternalError
 	}
 
-	image, err := e.dbService.CreateImage(ctx, bucketId)
+	return processedFile, status.OK
+}
+
+func encodingToAPI(result *image_processing.Result) *api_models.Encoding {
+	return &api_models.Encoding{
+		Quality: result.Quality,
+		Size:    len(result.Data),
+		SSIM:    float32(result.SSIM),
+	}
+}
+
+func (e *Endpoint) CreateImage(ctx context.Context, bucketName string, file []byte, fileExtension string, quality *float32, maxSize *int, frame *int, target *api_models.EncodingTarget, id *uuid.UUID, expiresAt *time.Time, slug string) (*api_models.Image, status.Status) {
+	const op = "Endpoint.CreateImage"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		err := fmt.Errorf("срок жизни изображения уже истёк")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.Time("expires_at", *expiresAt))
+		return nil, status.IncorrectValue
+	}
+
+	bucket, s := e.writableBucket(ctx, bucketName)
+	if s != status.OK {
+		return nil, s
+	}
+
+	var imageSlug *string
+	if slug != "" {
+		s = e.checkSlug(ctx, bucket, slug)
+		if s != status.OK {
+			return nil, s
+		}
+		imageSlug = &slug
+	}
+
+	if expiresAt == nil {
+		expiresAt = e.defaultExpiry(bucket)
+	}
+
+	processedFile, s := e.transform(ctx, bucket, file, fileExtension, quality, maxSize, frame, target)
+	if s != status.OK {
+		return nil, s
+	}
+	s = e.checkQuota(ctx, bucket, 1, int64(len(processedFile.Data)), 1)
+	if s != status.OK {
+		return nil, s
+	}
+
+	image, err := e.dbService.CreateImage(ctx, bucket.ID, expiresAt, imageSlug)
 	if err != nil {
 		err = fmt.Errorf("не удалось добавить изображение в БД: %w", err)
 		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
 		return nil, status.InternalError
 	}
 
-	err = e.s3Service.UploadFileBytes(ctx, bucketName, e.s3Service.FileName(image.ID), processedFile)
+	err = e.s3Service.UploadFileBytes(ctx, bucket.StorageName, e.s3Service.FileName(image.ID), processedFile.Data, e.objectAttributes(bucket, image))
 	if err != nil {
 		err = fmt.Errorf("не удалось загрузить файл на S3: %w", err)
 		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("image_id", image.ID.String()))
@@ -198,7 +714,103 @@ func (e *Endpoint) CreateImage(ctx context.Context, bucketName string, file []by
 		return nil, status.InternalError
 	}
 
-	return imageToAPI(image), status.OK
+	e.recordVersion(ctx, image, len(processedFile.Data))
+
+	apiImage := imageToAPI(image)
+	apiImage.Encoding = encodingToAPI(processedFile)
+
+	return apiImage, status.OK
+}
+
+// ReplaceImage обрабатывает новое содержимое изображения и сохраняет его как следующую ревизию.
+// Ключ объекта меняется вместе с ревизией, поэтому закешированные копии прежнего файла не отдаются,
+// а прежние ревизии хранятся в истории
+func (e *Endpoint) ReplaceImage(ctx context.Context, id uuid.UUID, file []byte, fileExtension string, quality *float32, maxSize *int, frame *int, target *api_models.EncodingTarget) (*api_models.Image, status.Status) {
+	const op = "Endpoint.ReplaceImage"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	image, imageBucket, err := e.dbService.GetImageWithBucket(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, status.NotFound
+	}
+
+	bucket, s := e.writableBucket(ctx, imageBucket.BucketName)
+	if s != status.OK {
+		return nil, s
+	}
+
+	processedFile, s := e.transform(ctx, bucket, file, fileExtension, quality, maxSize, frame, target)
+	if s != status.OK {
+		return nil, s
+	}
+	// прежняя ревизия остаётся в истории, поэтому объём растёт на размер новой
+	s = e.checkQuota(ctx, bucket, 0, int64(len(processedFile.Data)), 1)
+	if s != status.OK {
+		return nil, s
+	}
+
+	// параллельные замены загружают файлы под разными ключами и не удаляют объекты друг друга
+	revision, err := e.dbService.ReserveImageRevision(ctx, image.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось выделить ревизию изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+		return nil, status.InternalError
+	}
+	replaced := *image
+	replaced.Revision = revision
+	key := e.imageKey(&replaced)
+	err = e.s3Service.UploadFileBytes(ctx, bucket.StorageName, key, processedFile.Data, e.objectAttributes(bucket, image))
+	if err != nil {
+		err = fmt.Errorf("не удалось загрузить файл на S3: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+		return nil, status.InternalError
+	}
+
+	updated, err := e.dbService.SetImageRevision(ctx, image.ID, image.BucketID, image.Revision, replaced.Revision)
+	if err != nil || !updated {
+		_ = e.s3Service.DeleteFile(ctx, bucket.StorageName, key)
+		if err != nil {
+			err = fmt.Errorf("не удалось обновить ревизию изображения в БД: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+			return nil, status.InternalError
+		}
+
+		err = fmt.Errorf("изображение было заменено другим запросом")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+		return nil, status.FailedPrecondition
+	}
+	e.invalidateOverlays(image.ID)
+	e.s3Service.Invalidate(bucket.StorageName, e.imageKey(image))
+
+	// прежняя ревизия остаётся в истории, пока не будет вытеснена новыми
+	e.recordVersion(ctx, &replaced, len(processedFile.Data))
+	e.pruneVersions(ctx, bucket, &replaced)
+
+	apiImage := imageToAPI(&replaced)
+	apiImage.Encoding = encodingToAPI(processedFile)
+
+	return apiImage, status.OK
+}
+
+func (e *Endpoint) ProbeImage(ctx context.Context, file []byte, fileExtension string) (*api_models.ImageInfo, status.Status) {
+	const op = "Endpoint.ProbeImage"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	info, err := e.imageService.Probe(ctx, file, fileExtension)
+	if err != nil {
+		err = fmt.Errorf("не удалось прочитать изображение: %w", err)
+		e.logger.Error(ctx, err, zap.String("file_extension", fileExtension))
+		return nil, status.IncorrectValue
+	}
+
+	return &api_models.ImageInfo{
+		Format: info.Format,
+		Width:  info.Width,
+		Height: info.Height,
+		Frames: info.Frames,
+	}, status.OK
 }
 
 func (e *Endpoint) GetImage(ctx context.Context, id uuid.UUID) (*api_models.Image, status.Status) {
@@ -240,9 +852,26 @@ func (e *Endpoint) DeleteImage(ctx context.Context, id uuid.UUID) status.Status
 		return status.NotFound
 	}
 
-	err = e.s3Service.DeleteFile(ctx, bucket.BucketName, e.s3Service.FileName(image.ID))
+	if e.isWatermarkImage(id) {
+		err := fmt.Errorf("изображение используется как водяной знак")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+		return status.IncorrectValue
+	}
+
+	if e.trashRetention > 0 {
+		return e.trashImage(ctx, image, bucket)
+	}
+
+	keys, err := e.imageKeys(ctx, *image)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить ревизии изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+		return status.InternalError
+	}
+
+	err = e.s3Service.DeleteFiles(ctx, bucket.StorageName, keys)
 	if err != nil {
-		err = fmt.Errorf("не удалось удалить изображение из БД: %w", err)
+		err = fmt.Errorf("не удалось удалить изображение из S3: %w", err)
 		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
 		return status.InternalError
 	}
@@ -261,7 +890,14 @@ func (e *Endpoint) GetAllImages(ctx context.Context, limit int) ([]api_models.Im
 	const op = "Endpoint.GetAllImages"
 	ctx = e.logger.NewOpCtx(ctx, op)
 
-	images, err := e.dbService.GetAllImages(ctx, limit)
+	var images []models.Image
+	var err error
+	if tenant := tenantFrom(ctx); tenant != "" {
+		// ключ владельца видит только изображения его бакетов
+		images, err = e.dbService.GetImagesByBucketIDs(ctx, e.tenantBucketIDs(tenant), limit)
+	} else {
+		images, err = e.dbService.GetAllImages(ctx, limit)
+	}
 	if err != nil {
 		err = fmt.Errorf("не удалось получить изображения зи БД: %w", err)
 		e.logger.Error(ctx, err)
@@ -281,7 +917,7 @@ func (e *Endpoint) GetImagesInBucket(ctx context.Context, bucketName string, lim
 	ctx = e.logger.NewOpCtx(ctx, op)
 
 	e.bucketCacheLock.RLock()
-	id, ok := e.bucketCache[bucketName]
+	bucket, ok := e.bucketCache[bucketName]
 	e.bucketCacheLock.RUnlock()
 	if !ok {
 		err := fmt.Errorf("не удалось найти бакет в кеше")
@@ -289,7 +925,7 @@ func (e *Endpoint) GetImagesInBucket(ctx context.Context, bucketName string, lim
 		return nil, status.NotFound
 	}
 
-	images, err := e.dbService.GetImagesByBucketID(ctx, id, limit)
+	images, err := e.dbService.GetImagesByBucketID(ctx, bucket.ID, limit)
 	if err != nil {
 		err := fmt.Errorf("не удалось получить изображения: %w", err)
 		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
@@ -0,0 +1,1068 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"io"
+	"s3n/internal/db"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"s3n/internal/image_processing"
+	"s3n/internal/s3"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) NewOpCtx(ctx context.Context, _ string) context.Context          { return ctx }
+func (nopLogger) NewTraceCtx(ctx context.Context, _ *string) context.Context      { return ctx }
+func (nopLogger) NewCtx(ctx context.Context, _ string, _ *string) context.Context { return ctx }
+func (nopLogger) Debug(context.Context, any, ...zap.Field)                        {}
+func (nopLogger) Info(context.Context, any, ...zap.Field)                         {}
+func (nopLogger) Warn(context.Context, any, ...zap.Field)                         {}
+func (nopLogger) Error(context.Context, error, ...zap.Field)                      {}
+func (nopLogger) Fatal(context.Context, error, ...zap.Field)                      {}
+
+// fakeDB хранит бакеты и изображения в памяти, остальные методы db.Service не реализованы
+type fakeDB struct {
+	db.Service
+	lock          sync.Mutex
+	buckets       map[int16]models.Bucket
+	images        map[uuid.UUID]models.Image
+	lastRevisions map[uuid.UUID]int
+	versions      []models.ImageVersion
+	trashed       map[uuid.UUID]models.Image
+	// aliases прежние адреса изображений, ключ - "ID бакета/адрес"
+	aliases map[string]uuid.UUID
+	usage   models.Usage
+	apiKeys []models.ApiKey
+	// fail ошибка недоступной БД, которую возвращают методы чтения изображений
+	fail error
+	// bucketIDLookups количество вызовов GetImageBucketIDs
+	bucketIDLookups int
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		buckets:       map[int16]models.Bucket{},
+		images:        map[uuid.UUID]models.Image{},
+		lastRevisions: map[uuid.UUID]int{},
+		trashed:       map[uuid.UUID]models.Image{},
+		aliases:       map[string]uuid.UUID{},
+	}
+}
+
+func (d *fakeDB) ReserveImageRevision(_ context.Context, id uuid.UUID) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.lastRevisions[id] = max(d.lastRevisions[id], d.images[id].Revision) + 1
+	return d.lastRevisions[id], nil
+}
+
+func (d *fakeDB) SetImageRevision(_ context.Context, id uuid.UUID, bucketID int16, expected int, revision int) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	image, ok := d.images[id]
+	if !ok || image.BucketID != bucketID || image.Revision != expected {
+		return false, nil
+	}
+	image.Revision = revision
+	d.images[id] = image
+	return true, nil
+}
+
+func (d *fakeDB) AddImageVersion(_ context.Context, version *models.ImageVersion) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.versions = append(d.versions, *version)
+	return nil
+}
+
+func (d *fakeDB) UpdateBucket(_ context.Context, bucket *models.Bucket) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.buckets[bucket.ID] = *bucket
+	return nil
+}
+
+func (d *fakeDB) GetImage(_ context.Context, id uuid.UUID) (*models.Image, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.fail != nil {
+		return nil, d.fail
+	}
+	image, ok := d.images[id]
+	if !ok {
+		return nil, fmt.Errorf("изображение не найдено: %w", db.ErrNotFound)
+	}
+	return &image, nil
+}
+
+func (d *fakeDB) GetImageWithBucket(_ context.Context, id uuid.UUID) (*models.Image, *models.Bucket, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.fail != nil {
+		return nil, nil, d.fail
+	}
+	image, ok := d.images[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("изображение не найдено: %w", db.ErrNotFound)
+	}
+	bucket := d.buckets[image.BucketID]
+	return &image, &bucket, nil
+}
+
+func (d *fakeDB) GetImageBucketIDs(_ context.Context, ids []uuid.UUID) (map[uuid.UUID]int16, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.bucketIDLookups++
+	bucketIDs := map[uuid.UUID]int16{}
+	for _, id := range ids {
+		if image, ok := d.images[id]; ok {
+			bucketIDs[id] = image.BucketID
+		}
+	}
+	return bucketIDs, nil
+}
+
+func (d *fakeDB) GetImageBySlug(_ context.Context, bucketID int16, slug string) (*models.Image, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.fail != nil {
+		return nil, d.fail
+	}
+	for _, image := range d.images {
+		if image.BucketID == bucketID && image.Slug != nil && *image.Slug == slug {
+			return &image, nil
+		}
+	}
+	return nil, fmt.Errorf("изображение не найдено: %w", db.ErrNotFound)
+}
+
+func (d *fakeDB) GetImageByAlias(_ context.Context, bucketID int16, slug string) (*models.Image, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.fail != nil {
+		return nil, d.fail
+	}
+	image, ok := d.images[d.aliases[fmt.Sprintf("%d/%s", bucketID, slug)]]
+	if !ok || image.BucketID != bucketID {
+		return nil, fmt.Errorf("изображение не найдено: %w", db.ErrNotFound)
+	}
+	return &image, nil
+}
+
+func (d *fakeDB) GetTrashedImageWithBucket(_ context.Context, id uuid.UUID) (*models.Image, *models.Bucket, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.fail != nil {
+		return nil, nil, d.fail
+	}
+	image, ok := d.trashed[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("изображение не найдено: %w", db.ErrNotFound)
+	}
+	bucket := d.buckets[image.BucketID]
+	return &image, &bucket, nil
+}
+
+func (d *fakeDB) MoveImage(_ context.Context, id uuid.UUID, fromBucketID int16, toBucketID int16, revision int) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	image, ok := d.images[id]
+	if !ok || image.BucketID != fromBucketID || image.Revision != revision {
+		return false, nil
+	}
+	image.BucketID = toBucketID
+	d.images[id] = image
+	return true, nil
+}
+
+func (d *fakeDB) GetUsage(context.Context, []int16) (*models.Usage, error) {
+	usage := d.usage
+	return &usage, nil
+}
+
+func (d *fakeDB) GetImageVersion(_ context.Context, imageID uuid.UUID, revision int) (*models.ImageVersion, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.fail != nil {
+		return nil, d.fail
+	}
+	for _, version := range d.versions {
+		if version.ImageID == imageID && version.Revision == revision {
+			return &version, nil
+		}
+	}
+	return nil, fmt.Errorf("ревизия не найдена: %w", db.ErrNotFound)
+}
+
+func (d *fakeDB) DeleteBucket(_ context.Context, id int16) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.buckets, id)
+	return nil
+}
+
+func (d *fakeDB) DeleteWatermark(context.Context, int16) error {
+	return nil
+}
+
+func (d *fakeDB) CountImagesInBucket(_ context.Context, bucketID int16) (int, error) {
+	images, _ := d.GetImagesByBucketIDAfter(context.Background(), bucketID, uuid.Nil, len(d.images))
+	return len(images), nil
+}
+
+func (d *fakeDB) GetImagesByBucketIDAfter(_ context.Context, bucketID int16, after uuid.UUID, limit int) ([]models.Image, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var images []models.Image
+	for _, image := range d.images {
+		if image.BucketID == bucketID && image.ID.String() > after.String() {
+			images = append(images, image)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].ID.String() < images[j].ID.String() })
+	return images[:min(limit, len(images))], nil
+}
+
+func (d *fakeDB) DeleteImages(_ context.Context, ids []uuid.UUID) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, id := range ids {
+		delete(d.images, id)
+	}
+	return nil
+}
+
+func (d *fakeDB) GetImageVersions(_ context.Context, imageIDs ...uuid.UUID) ([]models.ImageVersion, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var versions []models.ImageVersion
+	for _, version := range d.versions {
+		if slices.Contains(imageIDs, version.ImageID) {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+func (d *fakeDB) RestoreImage(_ context.Context, id uuid.UUID) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	image, ok := d.trashed[id]
+	if ok {
+		delete(d.trashed, id)
+		d.images[id] = image
+	}
+	return ok, nil
+}
+
+func (d *fakeDB) GetActiveApiKeyByHash(_ context.Context, keyHash []byte) (*models.ApiKey, []models.ApiKeyGrant, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.fail != nil {
+		return nil, nil, d.fail
+	}
+	for _, key := range d.apiKeys {
+		if string(key.KeyHash) == string(keyHash) {
+			return &key, nil, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("ключ не найден: %w", db.ErrNotFound)
+}
+
+func (d *fakeDB) CreateImages(_ context.Context, images []models.Image) ([]uuid.UUID, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var inserted []uuid.UUID
+	for _, image := range images {
+		if _, ok := d.images[image.ID]; !ok {
+			d.images[image.ID] = image
+			inserted = append(inserted, image.ID)
+		}
+	}
+	return inserted, nil
+}
+
+func (d *fakeDB) AddImageVersions(_ context.Context, versions []models.ImageVersion) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.versions = append(d.versions, versions...)
+	return nil
+}
+
+func (d *fakeDB) GetUnsizedImageVersions(_ context.Context, afterID uuid.UUID, afterRevision int, limit int) ([]models.UnsizedImageVersion, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var versions []models.UnsizedImageVersion
+	for _, version := range d.versions {
+		order := strings.Compare(version.ImageID.String(), afterID.String())
+		if version.Size == 0 && (order > 0 || order == 0 && version.Revision > afterRevision) {
+			versions = append(versions, models.UnsizedImageVersion{ImageVersion: version, BucketID: d.images[version.ImageID].BucketID})
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].ImageID != versions[j].ImageID {
+			return versions[i].ImageID.String() < versions[j].ImageID.String()
+		}
+		return versions[i].Revision < versions[j].Revision
+	})
+	return versions[:min(limit, len(versions))], nil
+}
+
+func (d *fakeDB) SetImageVersionSize(_ context.Context, imageID uuid.UUID, revision int, size int) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i, version := range d.versions {
+		if version.ImageID == imageID && version.Revision == revision && version.Size == 0 {
+			d.versions[i].Size = size
+		}
+	}
+	return nil
+}
+
+// fakeS3 записывает изменения настроек бакетов и копирования объектов и вызывает onCall при каждом
+// из них, остальные методы s3.Service не реализованы
+type fakeS3 struct {
+	s3.Service
+	lock   sync.Mutex
+	calls  []string
+	onCall func(method string)
+	// objects ключи объектов вида "бакет/ключ"
+	objects map[string]bool
+	cors    []s3.CorsRule
+	// sizes размеры объектов для StatObject, ключ как в objects
+	sizes map[string]int64
+	// failDelete номер вызова DeleteFiles, который завершается ошибкой, 0 - без ошибок
+	failDelete int
+}
+
+func (s *fakeS3) FileName(id uuid.UUID) string {
+	return id.String()
+}
+
+func (s *fakeS3) FileNameRevision(id uuid.UUID, revision int) string {
+	if revision == 0 {
+		return s.FileName(id)
+	}
+	return fmt.Sprintf("%s-%d", id, revision)
+}
+
+func (s *fakeS3) DeleteFiles(_ context.Context, bucket string, keys []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.failDelete > 0 {
+		s.failDelete--
+		if s.failDelete == 0 {
+			return fmt.Errorf("ошибка S3")
+		}
+	}
+	for _, key := range keys {
+		delete(s.objects, bucket+"/"+key)
+	}
+	return nil
+}
+
+func (s *fakeS3) UploadFileBytes(_ context.Context, bucket string, key string, _ []byte, _ *s3.ObjectAttributes) error {
+	s.lock.Lock()
+	s.objects[bucket+"/"+key] = true
+	s.lock.Unlock()
+
+	s.call("UploadFileBytes")
+	return nil
+}
+
+func (s *fakeS3) StatObject(_ context.Context, bucket string, key string, _ *s3.ObjectRequest) (*s3.Object, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.objects[bucket+"/"+key] {
+		return nil, s3.ErrObjectNotFound
+	}
+	return &s3.Object{Size: s.sizes[bucket+"/"+key]}, nil
+}
+
+func (s *fakeS3) ReadObject(_ context.Context, bucket string, key string, _ *s3.ObjectRequest) (*s3.Object, error) {
+	s.lock.Lock()
+	found := s.objects[bucket+"/"+key]
+	s.lock.Unlock()
+
+	s.call("ReadObject")
+	if !found {
+		return nil, s3.ErrObjectNotFound
+	}
+	body := bucket + "/" + key
+	return &s3.Object{Body: io.NopCloser(strings.NewReader(body)), Length: int64(len(body)), Size: int64(len(body))}, nil
+}
+
+func (s *fakeS3) DeleteFile(ctx context.Context, bucket string, key string) error {
+	return s.DeleteFiles(ctx, bucket, []string{key})
+}
+
+func (s *fakeS3) Invalidate(string, ...string) {}
+
+func (s *fakeS3) CopyFile(_ context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string, _ *s3.ObjectAttributes) error {
+	s.call("CopyFile")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.objects[srcBucket+"/"+srcKey] {
+		return fmt.Errorf("объект не найден")
+	}
+	s.objects[dstBucket+"/"+dstKey] = true
+	return nil
+}
+
+func (s *fakeS3) GetBucketCors(context.Context, string) ([]s3.CorsRule, error) {
+	return s.cors, nil
+}
+
+func (s *fakeS3) call(method string) {
+	s.lock.Lock()
+	s.calls = append(s.calls, method)
+	s.lock.Unlock()
+	if s.onCall != nil {
+		s.onCall(method)
+	}
+}
+
+func (s *fakeS3) CreateBucket(context.Context, string) error {
+	s.call("CreateBucket")
+	return nil
+}
+
+func (s *fakeS3) SetBucketPublic(_ context.Context, _ string, public bool) error {
+	s.call(fmt.Sprintf("SetBucketPublic(%v)", public))
+	return nil
+}
+
+func (s *fakeS3) SetBucketCors(context.Context, string, []s3.CorsRule) error {
+	s.call("SetBucketCors")
+	return nil
+}
+
+func (s *fakeS3) SetBucketExpiration(context.Context, string, int) error {
+	s.call("SetBucketExpiration")
+	return nil
+}
+
+func (s *fakeS3) CheckWriteAccess(context.Context, string) error {
+	s.call("CheckWriteAccess")
+	return nil
+}
+
+// fakeImageService возвращает файл без обработки
+type fakeImageService struct {
+	image_processing.Service
+}
+
+func (fakeImageService) Transform(_ context.Context, file []byte, _ string, _ *image_processing.Options) (*image_processing.Result, error) {
+	return &image_processing.Result{Data: file}, nil
+}
+
+func newTestEndpoint(dbService *fakeDB, s3Service *fakeS3, buckets ...models.Bucket) *Endpoint {
+	e := &Endpoint{
+		s3Service:     s3Service,
+		dbService:     dbService,
+		imageService:  fakeImageService{},
+		logger:        nopLogger{},
+		keepRevisions: 10,
+		bucketCache:   map[string]*models.Bucket{},
+		uploads:       newUploadCounter(),
+	}
+	for _, bucket := range buckets {
+		bucket := bucket
+		e.bucketCache[bucket.BucketName] = &bucket
+		dbService.buckets[bucket.ID] = bucket
+	}
+	return e
+}
+
+func TestUpdateBucket(t *testing.T) {
+	fallbackID := uuid.New()
+	initial := models.Bucket{
+		ID:              1,
+		BucketName:      "photos",
+		StorageName:     "photos",
+		CacheControl:    "max-age=60",
+		DefaultTTL:      3600,
+		FallbackImageID: &fallbackID,
+		TargetSize:      1000,
+		QuotaImages:     10,
+	}
+
+	tests := []struct {
+		name     string
+		settings api_models.BucketSettings
+		fields   []string
+		// tenant владелец вызывающего ключа, пустая строка - ключ оператора
+		tenant string
+		want   status.Status
+		check  func(t *testing.T, bucket *models.Bucket)
+	}{
+		{
+			name:     "без маски меняются только заданные поля",
+			settings: api_models.BucketSettings{CacheControl: "no-cache"},
+			want:     status.OK,
+			check: func(t *testing.T, bucket *models.Bucket) {
+				if bucket.CacheControl != "no-cache" || bucket.DefaultTTL != 3600 || bucket.TargetSize != 1000 ||
+					bucket.QuotaImages != 10 || bucket.FallbackImageID == nil {
+					t.Errorf("поля вне запроса изменились: %+v", bucket)
+				}
+			},
+		},
+		{
+			name:   "маска сбрасывает поля",
+			fields: []string{api_models.BucketFieldQuota, api_models.BucketFieldFallbackImageID},
+			want:   status.OK,
+			check: func(t *testing.T, bucket *models.Bucket) {
+				if bucket.QuotaImages != 0 || bucket.FallbackImageID != nil {
+					t.Errorf("поля из маски не сброшены: %+v", bucket)
+				}
+				if bucket.CacheControl != "max-age=60" || bucket.TargetSize != 1000 {
+					t.Errorf("поля вне маски изменились: %+v", bucket)
+				}
+			},
+		},
+		{
+			name:     "маска ограничивает заданные поля",
+			settings: api_models.BucketSettings{CacheControl: "no-cache", DefaultTTL: 60},
+			fields:   []string{api_models.BucketFieldDefaultTTL},
+			want:     status.OK,
+			check: func(t *testing.T, bucket *models.Bucket) {
+				if bucket.CacheControl != "max-age=60" || bucket.DefaultTTL != 60 {
+					t.Errorf("обновлены не те поля: %+v", bucket)
+				}
+			},
+		},
+		{
+			name:   "неизвестное поле",
+			fields: []string{"private"},
+			want:   status.IncorrectValue,
+		},
+		{
+			name:     "отрицательный срок жизни",
+			settings: api_models.BucketSettings{DefaultTTL: -1},
+			want:     status.IncorrectValue,
+		},
+		{
+			name:     "квота ключом владельца",
+			settings: api_models.BucketSettings{Quota: &api_models.Quota{MaxImages: 1000}},
+			tenant:   "acme",
+			want:     status.IncorrectValue,
+		},
+		{
+			name:   "сброс квоты ключом владельца",
+			fields: []string{api_models.BucketFieldQuota},
+			tenant: "acme",
+			want:   status.IncorrectValue,
+		},
+		{
+			name:     "настройки без квоты ключом владельца",
+			settings: api_models.BucketSettings{CacheControl: "no-cache"},
+			tenant:   "acme",
+			want:     status.OK,
+			check: func(t *testing.T, bucket *models.Bucket) {
+				if bucket.CacheControl != "no-cache" || bucket.QuotaImages != 10 {
+					t.Errorf("некорректное обновление: %+v", bucket)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			e := newTestEndpoint(dbService, &fakeS3{}, initial)
+
+			ctx := context.Background()
+			if tt.tenant != "" {
+				ctx = withPrincipal(ctx, &principal{tenant: tt.tenant, role: api_models.RoleAdmin})
+			}
+			_, s := e.UpdateBucket(ctx, initial.BucketName, tt.settings, tt.fields)
+			if s != tt.want {
+				t.Fatalf("статус %v, ожидался %v", s, tt.want)
+			}
+			if tt.check == nil {
+				return
+			}
+			cached, _ := e.bucketByName(initial.BucketName)
+			stored := dbService.buckets[initial.ID]
+			if cached.CacheControl != stored.CacheControl || cached.DefaultTTL != stored.DefaultTTL {
+				t.Errorf("кеш %+v не совпадает с БД %+v", cached, stored)
+			}
+			tt.check(t, cached)
+		})
+	}
+}
+
+func TestUpdateBucketLifecycleOutsideLock(t *testing.T) {
+	bucket := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos"}
+	s3Service := &fakeS3{}
+	e := newTestEndpoint(newFakeDB(), s3Service, bucket)
+
+	calls := 0
+	s3Service.onCall = func(string) {
+		calls++
+		// запрос к S3 не должен блокировать чтение кеша бакетов
+		if !e.bucketCacheLock.TryRLock() {
+			t.Error("запрос к S3 выполняется под блокировкой кеша")
+			return
+		}
+		e.bucketCacheLock.RUnlock()
+	}
+
+	_, s := e.UpdateBucket(context.Background(), bucket.BucketName, api_models.BucketSettings{DefaultTTL: 60}, nil)
+	if s != status.OK {
+		t.Fatalf("статус %v", s)
+	}
+	if calls != 1 {
+		t.Errorf("правило жизненного цикла задано %d раз, ожидался 1", calls)
+	}
+
+	// повторное обновление без изменения срока жизни не обращается к S3
+	_, _ = e.UpdateBucket(context.Background(), bucket.BucketName, api_models.BucketSettings{CacheControl: "no-cache"}, nil)
+	if calls != 1 {
+		t.Errorf("правило жизненного цикла задано %d раз, ожидался 1", calls)
+	}
+}
+
+func TestBucketFieldsFromProto(t *testing.T) {
+	got := bucketFieldsFromProto(&fieldmaskpb.FieldMask{Paths: []string{"cache_control", "fallback_image_id", "private"}})
+	want := []string{api_models.BucketFieldCacheControl, api_models.BucketFieldFallbackImageID, "private"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("поля %v, ожидалось %v", got, want)
+	}
+	if got := bucketFieldsFromProto(nil); len(got) != 0 {
+		t.Errorf("поля без маски %v", got)
+	}
+}
+
+func TestPrepareStorage(t *testing.T) {
+	cors := []api_models.CorsRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}}
+	tests := []struct {
+		name    string
+		options api_models.RegisterBucketOptions
+		want    []string
+	}{
+		{
+			name: "существующий бакет",
+			want: []string{"CheckWriteAccess"},
+		},
+		{
+			name:    "существующий бакет закрывается",
+			options: api_models.RegisterBucketOptions{Private: true},
+			want:    []string{"SetBucketPublic(false)", "CheckWriteAccess"},
+		},
+		{
+			name:    "создание публичного бакета",
+			options: api_models.RegisterBucketOptions{CreateStorage: true, Cors: cors},
+			want:    []string{"CreateBucket", "SetBucketPublic(true)", "SetBucketCors", "CheckWriteAccess"},
+		},
+		{
+			name:    "создание закрытого бакета",
+			options: api_models.RegisterBucketOptions{CreateStorage: true, Private: true},
+			want:    []string{"CreateBucket", "SetBucketPublic(false)", "CheckWriteAccess"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3Service := &fakeS3{}
+			e := newTestEndpoint(newFakeDB(), s3Service)
+			if err := e.prepareStorage(context.Background(), "photos", &tt.options); err != nil {
+				t.Fatalf("ошибка: %s", err)
+			}
+			if fmt.Sprint(s3Service.calls) != fmt.Sprint(tt.want) {
+				t.Errorf("вызовы %v, ожидалось %v", s3Service.calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnregisterBucketCascade(t *testing.T) {
+	bucket := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos"}
+	tests := []struct {
+		name        string
+		images      int
+		failDelete  int
+		want        status.Status
+		wantDeleted int
+	}{
+		{name: "пустой бакет", want: status.OK},
+		{name: "несколько партий", images: drainBatchSize + 5, want: status.OK, wantDeleted: drainBatchSize + 5},
+		// вторая партия не удаляется, первая уже удалена
+		{name: "ошибка на второй партии", images: drainBatchSize + 5, failDelete: 2, want: status.InternalError, wantDeleted: drainBatchSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			s3Service := &fakeS3{objects: map[string]bool{}, failDelete: tt.failDelete}
+			e := newTestEndpoint(dbService, s3Service, bucket)
+			for i := 0; i < tt.images; i++ {
+				image := models.Image{ID: uuid.New(), BucketID: bucket.ID, Revision: 1}
+				dbService.images[image.ID] = image
+				dbService.versions = append(dbService.versions, models.ImageVersion{ImageID: image.ID, Revision: 0})
+				s3Service.objects["photos/"+image.ID.String()] = true
+				s3Service.objects["photos/"+image.ID.String()+"-1"] = true
+			}
+
+			result, s := e.UnregisterBucket(context.Background(), bucket.BucketName, api_models.UnregisterCascade)
+			if s != tt.want || result == nil || result.DeletedImages != tt.wantDeleted {
+				t.Fatalf("статус %v, результат %+v; ожидалось %v, удалено %d", s, result, tt.want, tt.wantDeleted)
+			}
+			if len(dbService.images) != tt.images-tt.wantDeleted || len(s3Service.objects) != 2*len(dbService.images) {
+				t.Errorf("осталось изображений %d, объектов %d", len(dbService.images), len(s3Service.objects))
+			}
+
+			cached, ok := e.bucketByName(bucket.BucketName)
+			if tt.want == status.OK && ok {
+				t.Error("бакет остался в кеше")
+			}
+			// прерванное удаление можно повторить, загрузка в бакет уже запрещена
+			if tt.want != status.OK && (!ok || !cached.Archived) {
+				t.Error("бакет не переведён в архив")
+			}
+		})
+	}
+}
+
+func TestRegisterBucketNameTaken(t *testing.T) {
+	// бакет переименован без переноса объектов и по-прежнему использует бакет S3 "photos"
+	renamed := models.Bucket{ID: 1, BucketName: "pictures", StorageName: "photos"}
+	tests := []struct {
+		name       string
+		bucketName string
+		want       bool
+	}{
+		{name: "название бакета", bucketName: "pictures", want: true},
+		{name: "название бакета S3", bucketName: "photos", want: true},
+		{name: "свободное название", bucketName: "avatars"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3Service := &fakeS3{}
+			e := newTestEndpoint(newFakeDB(), s3Service, renamed)
+			if got := e.bucketNameTaken(tt.bucketName, nil); got != tt.want {
+				t.Errorf("bucketNameTaken() = %v, ожидалось %v", got, tt.want)
+			}
+			if e.bucketNameTaken(tt.bucketName, &renamed) {
+				t.Error("название занято самим бакетом")
+			}
+			if tt.want {
+				_, s := e.RegisterBucket(context.Background(), tt.bucketName, nil)
+				if s != status.IncorrectValue || len(s3Service.calls) != 0 {
+					t.Errorf("статус %v, вызовы S3 %v", s, s3Service.calls)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameBucketMigratesSettings(t *testing.T) {
+	bucket := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos", DefaultTTL: 3600}
+	cors := []s3.CorsRule{{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}}}
+	s3Service := &fakeS3{objects: map[string]bool{}, cors: cors}
+	dbService := newFakeDB()
+	e := newTestEndpoint(dbService, s3Service, bucket)
+	e.renameStorage = true
+
+	image := models.Image{ID: uuid.New(), BucketID: bucket.ID}
+	dbService.images[image.ID] = image
+	s3Service.objects["photos/"+image.ID.String()] = true
+
+	renamed, s := e.RenameBucket(context.Background(), "photos", "pictures")
+	if s != status.OK || renamed.BucketName != "pictures" {
+		t.Fatalf("статус %v, бакет %+v", s, renamed)
+	}
+	want := []string{"CreateBucket", "SetBucketPublic(true)", "CheckWriteAccess", "SetBucketCors", "SetBucketExpiration", "CopyFile"}
+	if fmt.Sprint(s3Service.calls) != fmt.Sprint(want) {
+		t.Errorf("вызовы %v, ожидалось %v", s3Service.calls, want)
+	}
+	if !s3Service.objects["pictures/"+image.ID.String()] || s3Service.objects["photos/"+image.ID.String()] {
+		t.Errorf("объекты не перенесены: %v", s3Service.objects)
+	}
+	if cached, ok := e.bucketByName("pictures"); !ok || cached.StorageName != "pictures" {
+		t.Errorf("бакет в кеше %+v", cached)
+	}
+}
+
+func TestMoveImageConcurrentReplace(t *testing.T) {
+	source := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos"}
+	target := models.Bucket{ID: 2, BucketName: "avatars", StorageName: "avatars"}
+	storages := map[int16]string{source.ID: source.StorageName, target.ID: target.StorageName}
+	tests := []struct {
+		name    string
+		replace bool
+		want    status.Status
+	}{
+		{name: "перенос", want: status.OK},
+		{name: "замена во время копирования", replace: true, want: status.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			s3Service := &fakeS3{objects: map[string]bool{}}
+			e := newTestEndpoint(dbService, s3Service, source, target)
+
+			image := models.Image{ID: uuid.New(), BucketID: source.ID, Revision: 1}
+			dbService.images[image.ID] = image
+			s3Service.objects["photos/"+image.ID.String()+"-1"] = true
+			if tt.replace {
+				// ReplaceImage загружает новую ревизию в исходный бакет, пока объекты копируются
+				s3Service.onCall = func(string) {
+					replaced := image
+					replaced.Revision = 2
+					dbService.images[image.ID] = replaced
+					s3Service.objects["photos/"+image.ID.String()+"-2"] = true
+				}
+			}
+
+			_, s := e.MoveImage(context.Background(), image.ID, target.BucketName)
+			if s != tt.want {
+				t.Fatalf("статус %v, ожидался %v", s, tt.want)
+			}
+
+			moved := dbService.images[image.ID]
+			for key := range s3Service.objects {
+				storage := key[:strings.Index(key, "/")]
+				if storage != storages[moved.BucketID] {
+					t.Errorf("объект %s вне бакета изображения", key)
+				}
+			}
+			if !s3Service.objects[fmt.Sprintf("%s/%s-%d", storages[moved.BucketID], image.ID, moved.Revision)] {
+				t.Errorf("нет объекта текущей ревизии: %v", s3Service.objects)
+			}
+		})
+	}
+}
+
+func TestReplaceImageConcurrent(t *testing.T) {
+	bucket := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos"}
+	dbService := newFakeDB()
+	s3Service := &fakeS3{objects: map[string]bool{}}
+	e := newTestEndpoint(dbService, s3Service, bucket)
+
+	image := models.Image{ID: uuid.New(), BucketID: bucket.ID}
+	dbService.images[image.ID] = image
+	s3Service.objects["photos/"+image.ID.String()] = true
+
+	// вторая замена выполняется целиком, пока первая загружает файл
+	var second *api_models.Image
+	var secondStatus status.Status
+	s3Service.onCall = func(string) {
+		s3Service.onCall = nil
+		second, secondStatus = e.ReplaceImage(context.Background(), image.ID, []byte("second"), "webp", nil, nil, nil, nil)
+	}
+	_, firstStatus := e.ReplaceImage(context.Background(), image.ID, []byte("first"), "webp", nil, nil, nil, nil)
+
+	if secondStatus != status.OK || firstStatus != status.FailedPrecondition {
+		t.Fatalf("статусы %v и %v, ожидались %v и %v", firstStatus, secondStatus, status.FailedPrecondition, status.OK)
+	}
+	current := dbService.images[image.ID]
+	if current.Revision != second.Revision {
+		t.Fatalf("текущая ревизия %d, ожидалась %d", current.Revision, second.Revision)
+	}
+	// проигравшая замена удаляет только свой объект
+	want := map[string]bool{"photos/" + image.ID.String(): true, "photos/" + e.imageKey(&current): true}
+	if fmt.Sprint(s3Service.objects) != fmt.Sprint(want) {
+		t.Errorf("объекты %v, ожидалось %v", s3Service.objects, want)
+	}
+}
+
+func TestReplaceImageAfterMove(t *testing.T) {
+	source := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos"}
+	target := models.Bucket{ID: 2, BucketName: "avatars", StorageName: "avatars"}
+	dbService := newFakeDB()
+	s3Service := &fakeS3{objects: map[string]bool{}}
+	e := newTestEndpoint(dbService, s3Service, source, target)
+
+	image := models.Image{ID: uuid.New(), BucketID: source.ID}
+	dbService.images[image.ID] = image
+	s3Service.objects["photos/"+image.ID.String()] = true
+
+	// изображение переносится, пока замена загружает файл в исходный бакет
+	var moveStatus status.Status
+	s3Service.onCall = func(method string) {
+		if method == "UploadFileBytes" {
+			s3Service.onCall = nil
+			_, moveStatus = e.MoveImage(context.Background(), image.ID, target.BucketName)
+		}
+	}
+	_, s := e.ReplaceImage(context.Background(), image.ID, []byte("new"), "webp", nil, nil, nil, nil)
+
+	if moveStatus != status.OK || s != status.FailedPrecondition {
+		t.Fatalf("статус переноса %v, замены %v", moveStatus, s)
+	}
+	want := map[string]bool{"avatars/" + image.ID.String(): true}
+	if fmt.Sprint(s3Service.objects) != fmt.Sprint(want) {
+		t.Errorf("объекты %v, ожидалось %v", s3Service.objects, want)
+	}
+}
+
+func TestRestoreImageVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		quotaBytes int64
+		replace    bool
+		want       status.Status
+	}{
+		{name: "восстановление", want: status.OK},
+		{name: "превышена квота", quotaBytes: 150, want: status.FailedPrecondition},
+		{name: "замена во время копирования", replace: true, want: status.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos", QuotaBytes: tt.quotaBytes}
+			dbService := newFakeDB()
+			s3Service := &fakeS3{objects: map[string]bool{}}
+			e := newTestEndpoint(dbService, s3Service, bucket)
+
+			image := models.Image{ID: uuid.New(), BucketID: bucket.ID, Revision: 1}
+			dbService.images[image.ID] = image
+			dbService.lastRevisions[image.ID] = 1
+			dbService.versions = []models.ImageVersion{{ImageID: image.ID, Revision: 0, Size: 100}, {ImageID: image.ID, Revision: 1, Size: 50}}
+			dbService.usage = models.Usage{Images: 1, Bytes: 150}
+			s3Service.objects["photos/"+image.ID.String()] = true
+			s3Service.objects["photos/"+image.ID.String()+"-1"] = true
+
+			var replaced *api_models.Image
+			if tt.replace {
+				s3Service.onCall = func(string) {
+					s3Service.onCall = nil
+					replaced, _ = e.ReplaceImage(context.Background(), image.ID, []byte("new"), "webp", nil, nil, nil, nil)
+				}
+			}
+
+			restored, s := e.RestoreImageVersion(context.Background(), image.ID, 0)
+			if s != tt.want {
+				t.Fatalf("статус %v, ожидался %v", s, tt.want)
+			}
+			current := dbService.images[image.ID]
+			switch {
+			case tt.want == status.OK:
+				if restored.Revision != 2 || current.Revision != 2 || !s3Service.objects["photos/"+image.ID.String()+"-2"] {
+					t.Errorf("ревизия %d, объекты %v", current.Revision, s3Service.objects)
+				}
+			case tt.replace:
+				// объект замены не удаляется проигравшим восстановлением
+				if current.Revision != replaced.Revision || !s3Service.objects["photos/"+e.imageKey(&current)] || len(s3Service.objects) != 3 {
+					t.Errorf("ревизия %d, объекты %v", current.Revision, s3Service.objects)
+				}
+			default:
+				if current.Revision != 1 || len(s3Service.objects) != 2 {
+					t.Errorf("ревизия %d, объекты %v", current.Revision, s3Service.objects)
+				}
+			}
+		})
+	}
+}
+
+func TestBackfillVersionSizes(t *testing.T) {
+	bucket := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos"}
+	dbService := newFakeDB()
+	s3Service := &fakeS3{objects: map[string]bool{}, sizes: map[string]int64{}}
+	e := newTestEndpoint(dbService, s3Service, bucket)
+
+	// ревизий больше партии, чтобы проход продолжился после первой партии
+	count := drainBatchSize + 2
+	for i := 0; i < count; i++ {
+		image := models.Image{ID: uuid.New(), BucketID: bucket.ID}
+		dbService.images[image.ID] = image
+		dbService.versions = append(dbService.versions, models.ImageVersion{ImageID: image.ID})
+		s3Service.objects["photos/"+image.ID.String()] = true
+		s3Service.sizes["photos/"+image.ID.String()] = int64(i + 1)
+	}
+	// объект ревизии удалён, её размер остаётся неизвестным
+	lost := dbService.versions[0]
+	delete(s3Service.objects, "photos/"+lost.ImageID.String())
+
+	filled, err := e.backfillVersionSizes(context.Background())
+	if err != nil {
+		t.Fatalf("ошибка: %s", err)
+	}
+	if filled != count-1 {
+		t.Errorf("заполнено %d, ожидалось %d", filled, count-1)
+	}
+	for _, version := range dbService.versions {
+		want := int(s3Service.sizes["photos/"+version.ImageID.String()])
+		if version.ImageID == lost.ImageID {
+			want = 0
+		}
+		if version.Size != want {
+			t.Errorf("размер ревизии %s = %d, ожидалось %d", version.ImageID, version.Size, want)
+		}
+	}
+}
+
+func TestBatchCreateImagesSlug(t *testing.T) {
+	photos := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos"}
+	other := models.Bucket{ID: 2, BucketName: "other", StorageName: "other"}
+	dbService := newFakeDB()
+	e := newTestEndpoint(dbService, &fakeS3{objects: map[string]bool{}}, photos, other)
+	taken := "dog"
+	dbService.images[uuid.New()] = models.Image{BucketID: photos.ID, Slug: &taken}
+
+	items := []api_models.CreateImageItem{
+		{BucketName: "photos", Slug: "cat"},
+		{BucketName: "photos", Slug: "cat"},
+		{BucketName: "photos", Slug: "dog"},
+		{BucketName: "photos", Slug: "Bad Slug"},
+		{BucketName: "photos"},
+		{BucketName: "other", Slug: "cat"},
+	}
+	want := []status.Status{status.OK, status.IncorrectValue, status.IncorrectValue, status.IncorrectValue, status.OK, status.OK}
+	for i := range items {
+		items[i].File = []byte("image")
+		items[i].FileExtension = "webp"
+	}
+
+	results, s := e.BatchCreateImages(context.Background(), items)
+	if s != status.OK {
+		t.Fatalf("статус %v", s)
+	}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("изображение %d: статус %v, ожидался %v", i, result.Status, want[i])
+			continue
+		}
+		if result.Status != status.OK {
+			continue
+		}
+		stored := dbService.images[result.ID]
+		if got := deref(stored.Slug); got != items[i].Slug || result.Image.Slug != items[i].Slug {
+			t.Errorf("изображение %d: адрес %q, в ответе %q, ожидался %q", i, got, result.Image.Slug, items[i].Slug)
+		}
+	}
+}
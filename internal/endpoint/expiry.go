@@ -0,0 +1,97 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"math"
+	"s3n/internal/db/models"
+	"time"
+)
+
+// lifecycleMargin запас в днях, чтобы правило жизненного цикла срабатывало после очистки,
+// и записи в БД не ссылались на удалённые объекты
+const lifecycleMargin = 1
+
+// defaultExpiry время удаления нового изображения по сроку жизни бакета, nil - бессрочно
+func (e *Endpoint) defaultExpiry(bucket *models.Bucket) *time.Time {
+	if bucket.DefaultTTL <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(time.Duration(bucket.DefaultTTL) * time.Second)
+	return &expiresAt
+}
+
+// applyLifecycle переносит срок жизни бакета в правило жизненного цикла S3. Правило только
+// подстраховывает очистку, поэтому ошибка, например у провайдера без поддержки правил, не прерывает запрос
+func (e *Endpoint) applyLifecycle(ctx context.Context, bucket *models.Bucket) {
+	days := 0
+	if bucket.DefaultTTL > 0 {
+		days = int(math.Ceil(float64(bucket.DefaultTTL)/(24*60*60))) + lifecycleMargin
+	}
+
+	err := e.s3Service.SetBucketExpiration(ctx, bucket.StorageName, days)
+	if err != nil {
+		e.logger.Warn(ctx, fmt.Sprintf("не удалось задать правило жизненного цикла: %s", err),
+			zap.String("bucket_name", bucket.BucketName),
+			zap.Int("days", days),
+		)
+	}
+}
+
+// RunExpirySweep периодически удаляет изображения с истёкшим сроком жизни, пока не отменён ctx
+func (e *Endpoint) RunExpirySweep(ctx context.Context) {
+	const op = "Endpoint.RunExpirySweep"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if e.expiryInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := e.sweepExpired(ctx)
+		if err != nil {
+			err = fmt.Errorf("не удалось удалить изображения с истёкшим сроком жизни: %w", err)
+			e.logger.Error(ctx, err, zap.Int("deleted", deleted))
+		} else if deleted > 0 {
+			e.logger.Info(ctx, "удалены изображения с истёкшим сроком жизни", zap.Int("deleted", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepExpired удаляет изображения с истёкшим сроком жизни партиями, минуя корзину
+func (e *Endpoint) sweepExpired(ctx context.Context) (int, error) {
+	deleted := 0
+	for {
+		now := time.Now()
+		images, err := e.dbService.GetExpiredImages(ctx, now, drainBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		if len(images) == 0 {
+			return deleted, nil
+		}
+
+		removed, err := e.hardDelete(ctx, images, func(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+			return e.dbService.DeleteExpiredImages(ctx, ids, now)
+		})
+		deleted += len(removed)
+		if err != nil {
+			return deleted, err
+		}
+		if len(removed) == 0 {
+			// все выбранные изображения продлены или стали водяными знаками
+			return deleted, nil
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"s3n/internal/db"
+	"s3n/internal/db/models"
+)
+
+// fallbackHeader заголовок ответа redirect сервера, по которому видно, что вместо запрошенного
+// изображения отдано запасное изображение бакета
+const fallbackHeader = "X-S3n-Fallback"
+
+// substituteImage возвращает изображение id, которое отдаётся вместо запрошенного изображения бакета,
+// и бакет, в котором оно хранится. false - изображение не задано или удалено
+func (e *Endpoint) substituteImage(ctx context.Context, bucket *models.Bucket, id *uuid.UUID) (*models.Image, *models.Bucket, bool) {
+	if id == nil {
+		return nil, nil, false
+	}
+
+	image, imageBucket, err := e.dbService.GetImageWithBucket(ctx, *id)
+	if err != nil {
+		e.logger.Warn(ctx, fmt.Sprintf("не удалось получить изображение для замены запрошенного: %s", err),
+			zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+		return nil, nil, false
+	}
+	return image, imageBucket, true
+}
+
+// missingImageFallback возвращает запасное изображение вместо ненайденного изображения id.
+// Бакет изображения в корзине известен, для остальных используется bucketName.
+// Если БД не ответила, наличие изображения неизвестно и запасное изображение не отдаётся
+func (e *Endpoint) missingImageFallback(ctx context.Context, id uuid.UUID, bucketName string) (*models.Image, *models.Bucket, bool) {
+	_, trashedBucket, err := e.dbService.GetTrashedImageWithBucket(ctx, id)
+	switch {
+	case err == nil:
+		bucketName = trashedBucket.BucketName
+	case !errors.Is(err, db.ErrNotFound):
+		err = fmt.Errorf("не удалось проверить корзину: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, nil, false
+	}
+
+	bucket, ok := e.bucketByName(bucketName)
+	if !ok {
+		return nil, nil, false
+	}
+	return e.substituteImage(ctx, bucket, bucket.FallbackImageID)
+}
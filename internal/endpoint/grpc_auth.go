@@ -0,0 +1,255 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/budka-tech/snip-common-go/contract/s3"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"s3n/internal/endpoint/api_models"
+	"slices"
+	"strings"
+)
+
+// bearerKey возвращает ключ API из значения заголовка Authorization: "Bearer <ключ>"
+func bearerKey(header string) string {
+	scheme, key, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
+func zapMethod(method string) zap.Field {
+	return zap.String("method", method)
+}
+
+func zapPrincipal(p *principal) zap.Field {
+	if p == nil {
+		return zap.Skip()
+	}
+	return zap.String("api_key", p.name)
+}
+
+// authError переводит ошибку проверки ключа в статус gRPC
+func authError(err error) error {
+	switch {
+	case errors.Is(err, errUnauthenticated):
+		return grpcstatus.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, errPermissionDenied):
+		return grpcstatus.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, errBatchTooLarge):
+		return grpcstatus.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errAuthUnavailable):
+		return grpcstatus.Error(codes.Unavailable, errAuthUnavailable.Error())
+	}
+	return grpcstatus.Error(codes.Internal, "не удалось проверить права")
+}
+
+// authenticateCall проверяет ключ API из метаданных вызова
+func (g GrpcServer) authenticateCall(ctx context.Context) (*principal, error) {
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			key = bearerKey(values[0])
+		}
+	}
+	return g.endpoint.authenticate(ctx, key)
+}
+
+// unaryAuth проверяет ключ API и роль вызывающего до вызова метода,
+// из списка бакетов убираются бакеты, которые вызывающему недоступны
+func (g GrpcServer) unaryAuth(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	const op = "GrpcServer.unaryAuth"
+	logCtx := g.logger.NewOpCtx(g.logger.NewTraceCtx(ctx, nil), op)
+
+	p, err := g.authenticateCall(ctx)
+	if err == nil {
+		err = g.authorize(ctx, p, request)
+	}
+	if err != nil {
+		g.logger.Warn(logCtx, fmt.Sprintf("вызов отклонён: %s", err), zapMethod(info.FullMethod), zapPrincipal(p))
+		return nil, authError(err)
+	}
+
+	response, err := handler(withPrincipal(ctx, p), request)
+	if buckets, ok := response.(*pb.GetAllBucketsResponse); ok && buckets != nil {
+		buckets.Buckets = slices.DeleteFunc(buckets.Buckets, func(bucket *pb.Bucket) bool {
+			return g.endpoint.authorizeBuckets(p, api_models.RoleRead, bucket.BucketName) != nil
+		})
+	}
+	return response, err
+}
+
+// authStream проверяет роль вызывающего после получения запроса потокового метода
+type authStream struct {
+	grpc.ServerStream
+	g   *GrpcServer
+	p   *principal
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	err = s.g.authorize(s.Context(), s.p, m)
+	if err != nil {
+		const op = "authStream.RecvMsg"
+		ctx := s.g.logger.NewOpCtx(s.g.logger.NewTraceCtx(s.Context(), nil), op)
+		s.g.logger.Warn(ctx, fmt.Sprintf("вызов отклонён: %s", err), zapPrincipal(s.p))
+		return authError(err)
+	}
+	return nil
+}
+
+// streamAuth проверяет ключ API потокового метода, роль проверяется в authStream
+func (g GrpcServer) streamAuth(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	const op = "GrpcServer.streamAuth"
+
+	p, err := g.authenticateCall(stream.Context())
+	if err != nil {
+		ctx := g.logger.NewOpCtx(g.logger.NewTraceCtx(stream.Context(), nil), op)
+		g.logger.Warn(ctx, fmt.Sprintf("вызов отклонён: %s", err), zapMethod(info.FullMethod))
+		return authError(err)
+	}
+	return handler(server, &authStream{ServerStream: stream, g: &g, p: p, ctx: withPrincipal(stream.Context(), p)})
+}
+
+// idsFromBytes разбирает ID изображений, некорректные ID пропускаются, их отклонит вызываемый метод
+func idsFromBytes(raw ...[]byte) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(raw))
+	for _, bytes := range raw {
+		if id, err := uuid.FromBytes(bytes); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// authorize проверяет, что у p есть роль, которая нужна для запроса. Методы, которых нет в списке,
+// требуют роль admin во всех бакетах
+func (g GrpcServer) authorize(ctx context.Context, p *principal, request any) error {
+	e := g.endpoint
+	switch r := request.(type) {
+	case *pb.GetAllBucketsRequest, *pb.ProbeImageRequest:
+		return nil
+	case *pb.GetAllImagesRequest:
+		return e.authorizeGlobal(p, api_models.RoleRead)
+
+	case *pb.HasBucketRequest:
+		return e.authorizeBuckets(p, api_models.RoleRead, r.BucketName)
+	case *pb.GetBucketRequest:
+		return e.authorizeBuckets(p, api_models.RoleRead, r.BucketName)
+	case *pb.GetImagesInBucketRequest:
+		return e.authorizeBuckets(p, api_models.RoleRead, r.BucketName)
+	case *pb.GetBucketWatermarkRequest:
+		return e.authorizeBuckets(p, api_models.RoleRead, r.BucketName)
+
+	case *pb.CreateImageRequest:
+		return e.authorizeBuckets(p, api_models.RoleWrite, r.BucketName)
+	case *pb.BatchCreateImagesRequest:
+		bucketNames := make([]string, 0, len(r.Items))
+		for _, item := range r.Items {
+			bucketNames = append(bucketNames, item.BucketName)
+		}
+		return e.authorizeBuckets(p, api_models.RoleWrite, bucketNames...)
+
+	case *pb.UpdateBucketRequest:
+		err := e.authorizeBuckets(p, api_models.RoleAdmin, r.BucketName)
+		if err == nil && updatesQuota(r.Settings != nil && r.Settings.Quota != nil, bucketFieldsFromProto(r.UpdateMask)) {
+			err = e.authorizeOperator(p)
+		}
+		if err != nil || r.Settings == nil {
+			return err
+		}
+		// запасное изображение и заглушка становятся доступны всем, кто читает бакет
+		raw := [][]byte{r.Settings.FallbackImageId}
+		if r.Settings.Hotlink != nil {
+			raw = append(raw, r.Settings.Hotlink.PlaceholderImageId)
+		}
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(raw...)...)
+	case *pb.SetBucketWatermarkRequest:
+		err := e.authorizeBuckets(p, api_models.RoleAdmin, r.BucketName)
+		if err != nil || r.Watermark == nil {
+			return err
+		}
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(r.Watermark.ImageId)...)
+	case *pb.UnregisterBucketRequest:
+		return e.authorizeBuckets(p, api_models.RoleAdmin, r.BucketName)
+	case *pb.RenameBucketRequest:
+		return e.authorizeBuckets(p, api_models.RoleAdmin, r.BucketName)
+	case *pb.RemoveBucketWatermarkRequest:
+		return e.authorizeBuckets(p, api_models.RoleAdmin, r.BucketName)
+
+	case *pb.GetImageRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(r.Id)...)
+	case *pb.GetImageWithBucketRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(r.Id)...)
+	case *pb.ListImageVersionsRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(r.Id)...)
+	case *pb.GetImageVersionRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(r.Id)...)
+	case *pb.BatchGetImagesRequest:
+		if len(r.Ids) > batchLimit {
+			return errBatchTooLarge
+		}
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(r.Ids...)...)
+	case *pb.DownloadImageRequest:
+		// бакет запроса отдаёт своё запасное изображение вместо ненайденного
+		if r.BucketName != "" {
+			err := e.authorizeBuckets(p, api_models.RoleRead, r.BucketName)
+			if err != nil {
+				return err
+			}
+		}
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(r.Id)...)
+
+	case *pb.ReplaceImageRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleWrite, idsFromBytes(r.Id)...)
+	case *pb.DeleteImageRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleWrite, idsFromBytes(r.Id)...)
+	case *pb.BatchDeleteImagesRequest:
+		if len(r.Ids) > batchLimit {
+			return errBatchTooLarge
+		}
+		return e.authorizeImages(ctx, p, api_models.RoleWrite, idsFromBytes(r.Ids...)...)
+	case *pb.SetImageAliasRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleWrite, idsFromBytes(r.Id)...)
+	case *pb.RestoreImageRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleWrite, idsFromBytes(r.Id)...)
+	case *pb.RestoreImageVersionRequest:
+		return e.authorizeImages(ctx, p, api_models.RoleWrite, idsFromBytes(r.Id)...)
+	case *pb.MoveImageRequest:
+		err := e.authorizeBuckets(p, api_models.RoleWrite, r.BucketName)
+		if err != nil {
+			return err
+		}
+		return e.authorizeImages(ctx, p, api_models.RoleWrite, idsFromBytes(r.Id)...)
+	case *pb.CopyImageRequest:
+		err := e.authorizeBuckets(p, api_models.RoleWrite, r.BucketName)
+		if err != nil {
+			return err
+		}
+		return e.authorizeImages(ctx, p, api_models.RoleRead, idsFromBytes(r.Id)...)
+
+	case *pb.GetUsageRequest:
+		if r.BucketName != "" {
+			return e.authorizeBuckets(p, api_models.RoleRead, r.BucketName)
+		}
+		return e.authorizeGlobal(p, api_models.RoleRead)
+	}
+
+	// RegisterBucket, управление ключами API и новые методы
+	return e.authorizeGlobal(p, api_models.RoleAdmin)
+}
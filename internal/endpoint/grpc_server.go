@@ -9,9 +9,14 @@ import (
 	"github.com/budka-tech/snip-common-go/port"
 	st "github.com/budka-tech/snip-common-go/status"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
 	"net"
 	"s3n/internal/endpoint/api_models"
+	"time"
 )
 
 type GrpcServer struct {
@@ -38,7 +43,13 @@ func (g GrpcServer) Run(ctx context.Context) error {
 	}
 	g.logger.Info(ctx, fmt.Sprintf("сервер запущен на: %v", lis.Addr()))
 
-	grpcServer := grpc.NewServer()
+	var options []grpc.ServerOption
+	if g.endpoint.authEnabled {
+		options = append(options, grpc.UnaryInterceptor(g.unaryAuth), grpc.StreamInterceptor(g.streamAuth))
+	} else {
+		g.logger.Warn(ctx, "проверка ключей API отключена, gRPC доступен любому клиенту")
+	}
+	grpcServer := grpc.NewServer(options...)
 	pb.RegisterEndpointServer(grpcServer, &g)
 	if err := grpcServer.Serve(lis); err != nil {
 		g.logger.Fatal(ctx, fmt.Errorf("ошибка grpc: %s", err))
@@ -51,8 +62,104 @@ func bucketToProto(bucket *api_models.Bucket) *pb.Bucket {
 	if bucket == nil {
 		return nil
 	}
-	return &pb.Bucket{
-		BucketName: bucket.BucketName,
+	protoBucket := &pb.Bucket{
+		BucketName:   bucket.BucketName,
+		Target:       targetToProto(bucket.Target),
+		CacheControl: bucket.CacheControl,
+		Private:      bucket.Private,
+		Archived:     bucket.Archived,
+		DefaultTtl:   int32(bucket.DefaultTTL),
+		Tenant:       bucket.Tenant,
+		Quota:        quotaToProto(bucket.Quota),
+	}
+	if bucket.FallbackImageID != nil {
+		protoBucket.FallbackImageId = bucket.FallbackImageID[:]
+	}
+	if hotlink := bucket.Hotlink; hotlink != nil {
+		protoBucket.Hotlink = &pb.HotlinkProtection{
+			AllowedHosts:      hotlink.AllowedHosts,
+			AllowEmptyReferer: hotlink.AllowEmptyReferer,
+		}
+		if hotlink.PlaceholderImageID != nil {
+			protoBucket.Hotlink.PlaceholderImageId = hotlink.PlaceholderImageID[:]
+		}
+	}
+	return protoBucket
+}
+
+func quotaToProto(quota *api_models.Quota) *pb.Quota {
+	if quota == nil {
+		return nil
+	}
+	return &pb.Quota{
+		MaxImages:           int32(quota.MaxImages),
+		MaxBytes:            quota.MaxBytes,
+		MaxUploadsPerMinute: int32(quota.MaxUploadsPerMinute),
+	}
+}
+
+func quotaFromProto(quota *pb.Quota) *api_models.Quota {
+	if quota == nil {
+		return nil
+	}
+	return &api_models.Quota{
+		MaxImages:           int(quota.MaxImages),
+		MaxBytes:            quota.MaxBytes,
+		MaxUploadsPerMinute: int(quota.MaxUploadsPerMinute),
+	}
+}
+
+// bucketFieldsFromProto переводит пути маски обновления BucketSettings в названия полей api_models,
+// неизвестные пути сохраняются, чтобы Endpoint отклонил запрос
+func bucketFieldsFromProto(mask *fieldmaskpb.FieldMask) []string {
+	names := map[string]string{
+		"target":            api_models.BucketFieldTarget,
+		"cache_control":     api_models.BucketFieldCacheControl,
+		"default_ttl":       api_models.BucketFieldDefaultTTL,
+		"fallback_image_id": api_models.BucketFieldFallbackImageID,
+		"hotlink":           api_models.BucketFieldHotlink,
+		"quota":             api_models.BucketFieldQuota,
+	}
+	var fields []string
+	for _, path := range mask.GetPaths() {
+		if name, ok := names[path]; ok {
+			path = name
+		}
+		fields = append(fields, path)
+	}
+	return fields
+}
+
+func targetToProto(target *api_models.EncodingTarget) *pb.EncodingTarget {
+	if target == nil {
+		return nil
+	}
+	return &pb.EncodingTarget{
+		MaxBytes:       int32(target.MaxBytes),
+		MinSsim:        target.MinSSIM,
+		AllowDownscale: target.AllowDownscale,
+	}
+}
+
+func targetFromProto(target *pb.EncodingTarget) *api_models.EncodingTarget {
+	if target == nil {
+		return nil
+	}
+	return &api_models.EncodingTarget{
+		MaxBytes:       int(target.MaxBytes),
+		MinSSIM:        target.MinSsim,
+		AllowDownscale: target.AllowDownscale,
+	}
+}
+
+func encodingToProto(encoding *api_models.Encoding) *pb.Encoding {
+	if encoding == nil {
+		return nil
+	}
+	return &pb.Encoding{
+		Quality: encoding.Quality,
+		Size:    int32(encoding.Size),
+		Ssim:    encoding.SSIM,
 	}
 }
 
@@ -68,9 +175,15 @@ func imageToProto(image *api_models.Image) *pb.Image {
 	if image == nil {
 		return nil
 	}
-	return &pb.Image{
-		Id: image.ID[:],
+	proto := &pb.Image{
+		Id:       image.ID[:],
+		Revision: int32(image.Revision),
+		Slug:     image.Slug,
+	}
+	if image.ExpiresAt != nil {
+		proto.ExpiresAt = timestamppb.New(*image.ExpiresAt)
 	}
+	return proto
 }
 
 func imagesToProto(image []api_models.Image) []*pb.Image {
@@ -91,9 +204,37 @@ func imageWithBucketToProto(imageWithBucket *api_models.ImageWithBucket) *pb.Ima
 	}
 }
 
+func watermarkToProto(watermark *api_models.Watermark) *pb.Watermark {
+	if watermark == nil {
+		return nil
+	}
+	return &pb.Watermark{
+		ImageId:  watermark.ImageID[:],
+		Position: watermark.Position,
+		Margin:   int32(watermark.Margin),
+		Opacity:  watermark.Opacity,
+		Scale:    watermark.Scale,
+		Tiled:    watermark.Tiled,
+		MinSize:  int32(watermark.MinSize),
+	}
+}
+
 func (g GrpcServer) RegisterBucket(ctx context.Context, request *pb.RegisterBucketRequest) (*pb.RegisterBucketResponse, error) {
 	ctx = g.logger.NewTraceCtx(ctx, nil)
-	bucket, status := g.endpoint.RegisterBucket(ctx, request.BucketName)
+	options := &api_models.RegisterBucketOptions{
+		CreateStorage: request.CreateStorage,
+		Private:       request.Private,
+		Tenant:        request.Tenant,
+	}
+	for _, rule := range request.CorsRules {
+		options.Cors = append(options.Cors, api_models.CorsRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			MaxAgeSeconds:  int(rule.MaxAgeSeconds),
+		})
+	}
+	bucket, status := g.endpoint.RegisterBucket(ctx, request.BucketName, options)
 	return &pb.RegisterBucketResponse{
 		Bucket: bucketToProto(bucket),
 		Status: status,
@@ -109,10 +250,78 @@ func (g GrpcServer) HasBucket(ctx context.Context, request *pb.HasBucketRequest)
 	}, nil
 }
 
-func (g GrpcServer) UnregisterBucket(ctx context.Context, request *pb.UnregisterBucketRequest) (*commonv1.Response, error) {
+func (g GrpcServer) GetBucket(ctx context.Context, request *pb.GetBucketRequest) (*pb.GetBucketResponse, error) {
 	ctx = g.logger.NewTraceCtx(ctx, nil)
-	status := g.endpoint.UnregisterBucket(ctx, request.BucketName)
-	return &commonv1.Response{
+	bucket, status := g.endpoint.GetBucket(ctx, request.BucketName)
+	return &pb.GetBucketResponse{
+		Bucket: bucketToProto(bucket),
+		Status: status,
+	}, nil
+}
+
+func (g GrpcServer) UpdateBucket(ctx context.Context, request *pb.UpdateBucketRequest) (*pb.UpdateBucketResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	var settings api_models.BucketSettings
+	if request.Settings != nil {
+		settings.Target = targetFromProto(request.Settings.Target)
+		settings.CacheControl = request.Settings.CacheControl
+		settings.DefaultTTL = int(request.Settings.DefaultTtl)
+		settings.Quota = quotaFromProto(request.Settings.Quota)
+		if len(request.Settings.FallbackImageId) > 0 {
+			id, err := uuid.FromBytes(request.Settings.FallbackImageId)
+			if err != nil {
+				g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+				return &pb.UpdateBucketResponse{
+					Status: st.IncorrectValue,
+				}, nil
+			}
+			settings.FallbackImageID = &id
+		}
+		if hotlink := request.Settings.Hotlink; hotlink != nil {
+			settings.Hotlink = &api_models.HotlinkProtection{
+				AllowedHosts:      hotlink.AllowedHosts,
+				AllowEmptyReferer: hotlink.AllowEmptyReferer,
+			}
+			if len(hotlink.PlaceholderImageId) > 0 {
+				id, err := uuid.FromBytes(hotlink.PlaceholderImageId)
+				if err != nil {
+					g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+					return &pb.UpdateBucketResponse{
+						Status: st.IncorrectValue,
+					}, nil
+				}
+				settings.Hotlink.PlaceholderImageID = &id
+			}
+		}
+	}
+	bucket, status := g.endpoint.UpdateBucket(ctx, request.BucketName, settings, bucketFieldsFromProto(request.UpdateMask))
+	return &pb.UpdateBucketResponse{
+		Bucket: bucketToProto(bucket),
+		Status: status,
+	}, nil
+}
+
+func (g GrpcServer) UnregisterBucket(ctx context.Context, request *pb.UnregisterBucketRequest) (*pb.UnregisterBucketResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	mode := api_models.UnregisterMode(request.Mode)
+	if mode == "" {
+		mode = api_models.UnregisterRejectIfNotEmpty
+	}
+	result, status := g.endpoint.UnregisterBucket(ctx, request.BucketName, mode)
+	response := &pb.UnregisterBucketResponse{
+		Status: status,
+	}
+	if result != nil {
+		response.DeletedImages = int32(result.DeletedImages)
+	}
+	return response, nil
+}
+
+func (g GrpcServer) RenameBucket(ctx context.Context, request *pb.RenameBucketRequest) (*pb.RenameBucketResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	bucket, status := g.endpoint.RenameBucket(ctx, request.BucketName, request.NewBucketName)
+	return &pb.RenameBucketResponse{
+		Bucket: bucketToProto(bucket),
 		Status: status,
 	}, nil
 }
@@ -133,6 +342,11 @@ func (g GrpcServer) CreateImage(ctx context.Context, request *pb.CreateImageRequ
 		maxSize := int(*request.MaxSize)
 		MaxSize = &maxSize
 	}
+	var Frame *int
+	if request.Frame != nil {
+		frame := int(*request.Frame)
+		Frame = &frame
+	}
 	var Id *uuid.UUID
 	if request.Id != nil && len(request.Id) != 0 {
 		id, err := uuid.FromBytes(request.Id)
@@ -144,13 +358,217 @@ func (g GrpcServer) CreateImage(ctx context.Context, request *pb.CreateImageRequ
 		}
 		Id = &id
 	}
-	img, status := g.endpoint.CreateImage(ctx, request.BucketName, request.File, request.FileExtension, request.Quality, MaxSize, Id)
+	var ExpiresAt *time.Time
+	if request.ExpiresAt != nil {
+		expiresAt := request.ExpiresAt.AsTime()
+		ExpiresAt = &expiresAt
+	}
+	img, status := g.endpoint.CreateImage(ctx, request.BucketName, request.File, request.FileExtension, request.Quality, MaxSize, Frame, targetFromProto(request.Target), Id, ExpiresAt, request.Slug)
+	if img == nil {
+		return &pb.CreateImageResponse{
+			Status: status,
+		}, nil
+	}
 	return &pb.CreateImageResponse{
+		Image:    imageToProto(img),
+		Encoding: encodingToProto(img.Encoding),
+		Status:   status,
+	}, nil
+}
+
+func (g GrpcServer) ReplaceImage(ctx context.Context, request *pb.ReplaceImageRequest) (*pb.ReplaceImageResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.ReplaceImage"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.ReplaceImageResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	var MaxSize *int
+	if request.MaxSize != nil {
+		maxSize := int(*request.MaxSize)
+		MaxSize = &maxSize
+	}
+	var Frame *int
+	if request.Frame != nil {
+		frame := int(*request.Frame)
+		Frame = &frame
+	}
+	img, status := g.endpoint.ReplaceImage(ctx, Id, request.File, request.FileExtension, request.Quality, MaxSize, Frame, targetFromProto(request.Target))
+	if img == nil {
+		return &pb.ReplaceImageResponse{
+			Status: status,
+		}, nil
+	}
+	return &pb.ReplaceImageResponse{
+		Image:    imageToProto(img),
+		Encoding: encodingToProto(img.Encoding),
+		Status:   status,
+	}, nil
+}
+
+func imageVersionToProto(version *api_models.ImageVersion) *pb.ImageVersion {
+	if version == nil {
+		return nil
+	}
+	return &pb.ImageVersion{
+		Revision:  int32(version.Revision),
+		Size:      int32(version.Size),
+		CreatedAt: timestamppb.New(version.CreatedAt),
+		Current:   version.Current,
+	}
+}
+
+func (g GrpcServer) ListImageVersions(ctx context.Context, request *pb.ListImageVersionsRequest) (*pb.ListImageVersionsResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.ListImageVersions"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.ListImageVersionsResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	versions, status := g.endpoint.ListImageVersions(ctx, Id)
+	var protoVersions []*pb.ImageVersion
+	for _, version := range versions {
+		protoVersions = append(protoVersions, imageVersionToProto(&version))
+	}
+	return &pb.ListImageVersionsResponse{
+		Versions: protoVersions,
+		Status:   status,
+	}, nil
+}
+
+func (g GrpcServer) GetImageVersion(ctx context.Context, request *pb.GetImageVersionRequest) (*pb.GetImageVersionResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.GetImageVersion"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.GetImageVersionResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	version, file, status := g.endpoint.GetImageVersion(ctx, Id, int(request.Revision))
+	return &pb.GetImageVersionResponse{
+		Version: imageVersionToProto(version),
+		File:    file,
+		Status:  status,
+	}, nil
+}
+
+func (g GrpcServer) RestoreImageVersion(ctx context.Context, request *pb.RestoreImageVersionRequest) (*pb.RestoreImageVersionResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.RestoreImageVersion"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.RestoreImageVersionResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	img, status := g.endpoint.RestoreImageVersion(ctx, Id, int(request.Revision))
+	return &pb.RestoreImageVersionResponse{
 		Image:  imageToProto(img),
 		Status: status,
 	}, nil
 }
 
+// downloadChunkSize размер части файла в одном сообщении потока DownloadImage
+const downloadChunkSize = 64 * 1024
+
+// DownloadImage передаёт файл изображения потоком: первое сообщение содержит статус и сведения о файле,
+// следующие - части файла по downloadChunkSize байт
+func (g GrpcServer) DownloadImage(request *pb.DownloadImageRequest, stream pb.Endpoint_DownloadImageServer) error {
+	ctx := g.logger.NewTraceCtx(stream.Context(), nil)
+	const op = "GrpcServer.DownloadImage"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return stream.Send(&pb.DownloadImageResponse{
+			Status: st.IncorrectValue,
+		})
+	}
+	var Revision *int
+	if request.Revision != nil {
+		revision := int(*request.Revision)
+		Revision = &revision
+	}
+
+	download, status := g.endpoint.DownloadImage(ctx, request.BucketName, Id, Revision, request.Offset, request.Length)
+	if download == nil {
+		return stream.Send(&pb.DownloadImageResponse{
+			Status: status,
+		})
+	}
+	defer download.Body.Close()
+
+	err = stream.Send(&pb.DownloadImageResponse{
+		Image:       imageToProto(download.Image),
+		Revision:    int32(download.Revision),
+		ContentType: download.ContentType,
+		Offset:      download.Offset,
+		Length:      download.Length,
+		Size:        download.Size,
+		Fallback:    download.Fallback,
+		Status:      status,
+	})
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, err := io.ReadFull(download.Body, buf)
+		if n > 0 {
+			sendErr := stream.Send(&pb.DownloadImageResponse{
+				Chunk: buf[:n],
+			})
+			if sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			err = fmt.Errorf("ошибка чтения файла изображения: %w", err)
+			g.logger.Error(ctx, err, zap.String("image_id", Id.String()))
+			return err
+		}
+	}
+}
+
+func (g GrpcServer) ProbeImage(ctx context.Context, request *pb.ProbeImageRequest) (*pb.ProbeImageResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	info, status := g.endpoint.ProbeImage(ctx, request.File, request.FileExtension)
+	if info == nil {
+		return &pb.ProbeImageResponse{
+			Status: status,
+		}, nil
+	}
+	return &pb.ProbeImageResponse{
+		Format: info.Format,
+		Width:  int32(info.Width),
+		Height: int32(info.Height),
+		Frames: int32(info.Frames),
+		Status: status,
+	}, nil
+}
+
 func (g GrpcServer) GetImage(ctx context.Context, request *pb.GetImageRequest) (*pb.GetImageResponse, error) {
 	ctx = g.logger.NewTraceCtx(ctx, nil)
 	const op = "GrpcServer.GetImage"
@@ -211,6 +629,45 @@ func (g GrpcServer) DeleteImage(ctx context.Context, request *pb.DeleteImageRequ
 	}, nil
 }
 
+func (g GrpcServer) SetImageAlias(ctx context.Context, request *pb.SetImageAliasRequest) (*commonv1.Response, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.SetImageAlias"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &commonv1.Response{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+
+	status := g.endpoint.SetImageAlias(ctx, Id, request.Slug)
+
+	return &commonv1.Response{
+		Status: status,
+	}, nil
+}
+
+func (g GrpcServer) RestoreImage(ctx context.Context, request *pb.RestoreImageRequest) (*pb.RestoreImageResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.RestoreImage"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.RestoreImageResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	img, status := g.endpoint.RestoreImage(ctx, Id)
+	return &pb.RestoreImageResponse{
+		Image:  imageWithBucketToProto(img),
+		Status: status,
+	}, nil
+}
+
 func (g GrpcServer) GetAllImages(ctx context.Context, request *pb.GetAllImagesRequest) (*pb.GetAllImagesResponse, error) {
 	ctx = g.logger.NewTraceCtx(ctx, nil)
 	images, status := g.endpoint.GetAllImages(ctx, int(request.Limit))
@@ -228,3 +685,310 @@ func (g GrpcServer) GetImagesInBucket(ctx context.Context, request *pb.GetImages
 		Status: status,
 	}, nil
 }
+
+func imageResultsToProto(results []api_models.ImageResult) []*pb.ImageResult {
+	var protoResults []*pb.ImageResult
+	for _, result := range results {
+		protoResult := &pb.ImageResult{
+			Id:         result.ID[:],
+			BucketName: result.BucketName,
+			Image:      imageToProto(result.Image),
+			Status:     result.Status,
+		}
+		if result.Image != nil {
+			protoResult.Encoding = encodingToProto(result.Image.Encoding)
+		}
+		protoResults = append(protoResults, protoResult)
+	}
+	return protoResults
+}
+
+// idsFromProto разбирает список ID, ошибка в любом из них отклоняет весь запрос
+func idsFromProto(protoIds [][]byte) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, 0, len(protoIds))
+	for _, protoId := range protoIds {
+		id, err := uuid.FromBytes(protoId)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (g GrpcServer) BatchGetImages(ctx context.Context, request *pb.BatchGetImagesRequest) (*pb.BatchGetImagesResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.BatchGetImages"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Ids, err := idsFromProto(request.Ids)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.BatchGetImagesResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	results, status := g.endpoint.BatchGetImages(ctx, Ids)
+	return &pb.BatchGetImagesResponse{
+		Results: imageResultsToProto(results),
+		Status:  status,
+	}, nil
+}
+
+func (g GrpcServer) BatchDeleteImages(ctx context.Context, request *pb.BatchDeleteImagesRequest) (*pb.BatchDeleteImagesResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.BatchDeleteImages"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Ids, err := idsFromProto(request.Ids)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.BatchDeleteImagesResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	results, status := g.endpoint.BatchDeleteImages(ctx, Ids)
+	return &pb.BatchDeleteImagesResponse{
+		Results: imageResultsToProto(results),
+		Status:  status,
+	}, nil
+}
+
+func (g GrpcServer) BatchCreateImages(ctx context.Context, request *pb.BatchCreateImagesRequest) (*pb.BatchCreateImagesResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.BatchCreateImages"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	items := make([]api_models.CreateImageItem, 0, len(request.Items))
+	for _, protoItem := range request.Items {
+		item := api_models.CreateImageItem{
+			BucketName:    protoItem.BucketName,
+			File:          protoItem.File,
+			FileExtension: protoItem.FileExtension,
+			Quality:       protoItem.Quality,
+			Target:        targetFromProto(protoItem.Target),
+			Slug:          protoItem.Slug,
+		}
+		if protoItem.MaxSize != nil {
+			maxSize := int(*protoItem.MaxSize)
+			item.MaxSize = &maxSize
+		}
+		if protoItem.Frame != nil {
+			frame := int(*protoItem.Frame)
+			item.Frame = &frame
+		}
+		if len(protoItem.Id) != 0 {
+			id, err := uuid.FromBytes(protoItem.Id)
+			if err != nil {
+				g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+				return &pb.BatchCreateImagesResponse{
+					Status: st.IncorrectValue,
+				}, nil
+			}
+			item.ID = &id
+		}
+		if protoItem.ExpiresAt != nil {
+			expiresAt := protoItem.ExpiresAt.AsTime()
+			item.ExpiresAt = &expiresAt
+		}
+		items = append(items, item)
+	}
+
+	results, status := g.endpoint.BatchCreateImages(ctx, items)
+	return &pb.BatchCreateImagesResponse{
+		Results: imageResultsToProto(results),
+		Status:  status,
+	}, nil
+}
+
+func (g GrpcServer) MoveImage(ctx context.Context, request *pb.MoveImageRequest) (*pb.MoveImageResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.MoveImage"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.MoveImageResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	img, status := g.endpoint.MoveImage(ctx, Id, request.BucketName)
+	return &pb.MoveImageResponse{
+		Image:  imageWithBucketToProto(img),
+		Status: status,
+	}, nil
+}
+
+func (g GrpcServer) CopyImage(ctx context.Context, request *pb.CopyImageRequest) (*pb.CopyImageResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.CopyImage"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	Id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.CopyImageResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	img, status := g.endpoint.CopyImage(ctx, Id, request.BucketName)
+	return &pb.CopyImageResponse{
+		Image:  imageWithBucketToProto(img),
+		Status: status,
+	}, nil
+}
+
+func (g GrpcServer) GetBucketWatermark(ctx context.Context, request *pb.GetBucketWatermarkRequest) (*pb.GetBucketWatermarkResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	watermark, status := g.endpoint.GetBucketWatermark(ctx, request.BucketName)
+	return &pb.GetBucketWatermarkResponse{
+		Watermark: watermarkToProto(watermark),
+		Status:    status,
+	}, nil
+}
+
+func (g GrpcServer) SetBucketWatermark(ctx context.Context, request *pb.SetBucketWatermarkRequest) (*pb.SetBucketWatermarkResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.SetBucketWatermark"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	if request.Watermark == nil {
+		g.logger.Error(ctx, fmt.Errorf("не передан водяной знак"))
+		return &pb.SetBucketWatermarkResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	ImageId, err := uuid.FromBytes(request.Watermark.ImageId)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.SetBucketWatermarkResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+
+	watermark, status := g.endpoint.SetBucketWatermark(ctx, request.BucketName, api_models.Watermark{
+		ImageID:  ImageId,
+		Position: request.Watermark.Position,
+		Margin:   int(request.Watermark.Margin),
+		Opacity:  request.Watermark.Opacity,
+		Scale:    request.Watermark.Scale,
+		Tiled:    request.Watermark.Tiled,
+		MinSize:  int(request.Watermark.MinSize),
+	})
+	return &pb.SetBucketWatermarkResponse{
+		Watermark: watermarkToProto(watermark),
+		Status:    status,
+	}, nil
+}
+
+func (g GrpcServer) RemoveBucketWatermark(ctx context.Context, request *pb.RemoveBucketWatermarkRequest) (*commonv1.Response, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	status := g.endpoint.RemoveBucketWatermark(ctx, request.BucketName)
+	return &commonv1.Response{
+		Status: status,
+	}, nil
+}
+
+func apiKeyToProto(key *api_models.ApiKey) *pb.ApiKey {
+	if key == nil {
+		return nil
+	}
+	proto := &pb.ApiKey{
+		Id:        key.ID[:],
+		Name:      key.Name,
+		Role:      string(key.Role),
+		Tenant:    key.Tenant,
+		CreatedAt: timestamppb.New(key.CreatedAt),
+	}
+	for _, grant := range key.Grants {
+		proto.Grants = append(proto.Grants, &pb.ApiKeyGrant{BucketName: grant.BucketName, Role: string(grant.Role)})
+	}
+	if key.RotatedAt != nil {
+		proto.RotatedAt = timestamppb.New(*key.RotatedAt)
+	}
+	return proto
+}
+
+func (g GrpcServer) IssueApiKey(ctx context.Context, request *pb.IssueApiKeyRequest) (*pb.IssueApiKeyResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	grants := make([]api_models.ApiKeyGrant, 0, len(request.Grants))
+	for _, grant := range request.Grants {
+		grants = append(grants, api_models.ApiKeyGrant{BucketName: grant.BucketName, Role: api_models.ApiKeyRole(grant.Role)})
+	}
+	issued, status := g.endpoint.IssueApiKey(ctx, request.Name, request.Tenant, api_models.ApiKeyRole(request.Role), grants)
+	if status != st.OK {
+		return &pb.IssueApiKeyResponse{
+			Status: status,
+		}, nil
+	}
+	return &pb.IssueApiKeyResponse{
+		ApiKey: apiKeyToProto(&issued.ApiKey),
+		Key:    issued.Key,
+		Status: status,
+	}, nil
+}
+
+func (g GrpcServer) RotateApiKey(ctx context.Context, request *pb.RotateApiKeyRequest) (*pb.RotateApiKeyResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.RotateApiKey"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &pb.RotateApiKeyResponse{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	issued, status := g.endpoint.RotateApiKey(ctx, id)
+	if status != st.OK {
+		return &pb.RotateApiKeyResponse{
+			Status: status,
+		}, nil
+	}
+	return &pb.RotateApiKeyResponse{
+		ApiKey: apiKeyToProto(&issued.ApiKey),
+		Key:    issued.Key,
+		Status: status,
+	}, nil
+}
+
+func (g GrpcServer) RevokeApiKey(ctx context.Context, request *pb.RevokeApiKeyRequest) (*commonv1.Response, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	const op = "GrpcServer.RevokeApiKey"
+	ctx = g.logger.NewOpCtx(ctx, op)
+
+	id, err := uuid.FromBytes(request.Id)
+	if err != nil {
+		g.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		return &commonv1.Response{
+			Status: st.IncorrectValue,
+		}, nil
+	}
+	status := g.endpoint.RevokeApiKey(ctx, id)
+	return &commonv1.Response{
+		Status: status,
+	}, nil
+}
+
+func (g GrpcServer) GetUsage(ctx context.Context, request *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	ctx = g.logger.NewTraceCtx(ctx, nil)
+	usage, status := g.endpoint.GetUsage(ctx, request.Tenant, request.BucketName)
+	if status != st.OK {
+		return &pb.GetUsageResponse{
+			Status: status,
+		}, nil
+	}
+	return &pb.GetUsageResponse{
+		Usage: &pb.Usage{
+			Tenant:           usage.Tenant,
+			BucketName:       usage.BucketName,
+			Images:           int32(usage.Images),
+			Bytes:            usage.Bytes,
+			UploadsPerMinute: int32(usage.UploadsPerMinute),
+			Quota:            quotaToProto(usage.Quota),
+		},
+		Status: status,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"s3n/internal/db/models"
+	"strings"
+)
+
+// hotlinkHeader заголовок ответа redirect сервера, по которому видно, что запрос с чужого сайта
+// получил изображение-заглушку бакета
+const hotlinkHeader = "X-S3n-Hotlink-Placeholder"
+
+// normalizeHotlinkHosts проверяет список разрешённых хостов и приводит их к нижнему регистру.
+// Хост задаётся без схемы и порта, "*." в начале разрешает все поддомены
+func normalizeHotlinkHosts(hosts []string) ([]string, error) {
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("список разрешённых хостов пуст")
+	}
+
+	normalized := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		name := strings.TrimPrefix(host, "*.")
+		if name == "" || strings.ContainsAny(name, "*/:@ ") {
+			return nil, fmt.Errorf("некорректный хост %q", host)
+		}
+		normalized = append(normalized, host)
+	}
+	return normalized, nil
+}
+
+// hotlinkAllowed проверяет, что запрос пришёл с разрешённого сайта. Хост берётся из Origin,
+// а если его нет - из Referer. Запрос без обоих заголовков разрешён, только если это задано в бакете
+func hotlinkAllowed(bucket *models.Bucket, r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return bucket.HotlinkAllowEmpty
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, allowed := range bucket.HotlinkHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectHotlink отвечает на запрос с запрещённого сайта изображением-заглушкой бакета, а если она не задана - 403
+func (s *RedirectServer) rejectHotlink(w http.ResponseWriter, r *http.Request, bucket *models.Bucket) {
+	storage, key, ok := s.substituteLocation(r.Context(), bucket, bucket.HotlinkImageID)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set(hotlinkHeader, "true")
+	s.serveObject(w, r, storage, key, true)
+}
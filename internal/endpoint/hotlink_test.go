@@ -0,0 +1,81 @@
+package endpoint
+
+import (
+	"net/http/httptest"
+	"s3n/internal/db/models"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeHotlinkHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "регистр и пробелы", hosts: []string{" Example.COM ", "*.Cdn.example.com"}, want: []string{"example.com", "*.cdn.example.com"}},
+		{name: "пустой список", wantErr: true},
+		{name: "пустой хост", hosts: []string{"example.com", " "}, wantErr: true},
+		{name: "только маска", hosts: []string{"*."}, wantErr: true},
+		{name: "схема", hosts: []string{"https://example.com"}, wantErr: true},
+		{name: "порт", hosts: []string{"example.com:8080"}, wantErr: true},
+		{name: "маска в середине", hosts: []string{"cdn.*.example.com"}, wantErr: true},
+		{name: "пользователь", hosts: []string{"user@example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeHotlinkHosts(tt.hosts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка %v, ожидалась ошибка: %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("получено %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHotlinkAllowed(t *testing.T) {
+	bucket := &models.Bucket{HotlinkHosts: []string{"example.com", "*.cdn.example.org"}}
+	allowEmpty := &models.Bucket{HotlinkHosts: bucket.HotlinkHosts, HotlinkAllowEmpty: true}
+
+	tests := []struct {
+		name    string
+		bucket  *models.Bucket
+		origin  string
+		referer string
+		want    bool
+	}{
+		{name: "разрешённый referer", bucket: bucket, referer: "https://example.com/page?id=1", want: true},
+		{name: "разрешённый origin", bucket: bucket, origin: "https://example.com", want: true},
+		{name: "регистр и порт", bucket: bucket, referer: "http://EXAMPLE.com:8080/", want: true},
+		{name: "поддомен по маске", bucket: bucket, referer: "https://img.cdn.example.org/", want: true},
+		{name: "вложенный поддомен по маске", bucket: bucket, referer: "https://a.b.cdn.example.org/", want: true},
+		{name: "маска не включает сам домен", bucket: bucket, referer: "https://cdn.example.org/"},
+		{name: "поддомен без маски", bucket: bucket, referer: "https://www.example.com/"},
+		{name: "похожий домен", bucket: bucket, referer: "https://evilcdn.example.org.attacker.io/"},
+		{name: "суффикс без точки", bucket: bucket, referer: "https://notcdn.example.org/"},
+		{name: "чужой origin важнее referer", bucket: bucket, origin: "https://attacker.io", referer: "https://example.com/"},
+		{name: "origin null", bucket: bucket, origin: "null", referer: "https://example.com/", want: true},
+		{name: "некорректный referer", bucket: bucket, referer: "::not a url"},
+		{name: "referer без хоста", bucket: bucket, referer: "/relative/path"},
+		{name: "без заголовков", bucket: bucket},
+		{name: "без заголовков разрешено", bucket: allowEmpty, want: true},
+		{name: "чужой сайт при разрешённых пустых", bucket: allowEmpty, referer: "https://attacker.io/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/b/image", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			if got := hotlinkAllowed(tt.bucket, r); got != tt.want {
+				t.Errorf("hotlinkAllowed() = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,36 +2,77 @@ package endpoint
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/budka-tech/logit-go"
 	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"io"
 	"net/http"
+	"path"
 	"s3n/internal/config"
+	"s3n/internal/db"
+	"s3n/internal/db/models"
 	"s3n/internal/s3"
 	"strconv"
 )
 
 type RedirectServer struct {
 	router    *chi.Mux
+	endpoint  *Endpoint
 	s3Service s3.Service
 	logger    logit.Logger
 	port      int
+	proxy     bool
+	missing   *missingCache
+	cors      *corsPolicies
 }
 
-func NewRedirectServer(s3Service s3.Service, config *config.HttpRedirectConfig, logger logit.Logger) *RedirectServer {
+func NewRedirectServer(endpoint *Endpoint, s3Service s3.Service, config *config.HttpRedirectConfig, logger logit.Logger) *RedirectServer {
 	r := chi.NewRouter()
 
 	s := &RedirectServer{
 		router:    r,
+		endpoint:  endpoint,
 		s3Service: s3Service,
 		logger:    logger,
 		port:      config.Port,
+		proxy:     config.Proxy,
+		missing:   newMissingCache(config.MissingCacheTTL, config.MissingCacheSize),
+		cors:      newCorsPolicies(config),
 	}
-	r.HandleFunc(config.PathPrefix+"/{bucket}/{filename}", s.redirectHandler)
+	r.Use(staticHeaders(config.Headers))
+	if s.apiEnabled(config) {
+		r.Mount(config.ApiPrefix, NewRestApi(endpoint, config, logger).Routes())
+	}
+	r.With(s.corsMiddleware).HandleFunc(config.PathPrefix+"/{bucket}/{filename}", s.redirectHandler)
 
 	return s
 }
 
+// apiEnabled проверяет, можно ли подключить HTTP API. Без проверки ключей любой клиент, которому доступен
+// порт redirect сервера, получил бы полный доступ, поэтому API подключается только с явного разрешения
+func (s *RedirectServer) apiEnabled(config *config.HttpRedirectConfig) bool {
+	const op = "RedirectServer.apiEnabled"
+	ctx := s.logger.NewOpCtx(context.Background(), op)
+
+	if config.ApiPrefix == "" {
+		return false
+	}
+	if s.endpoint.authEnabled {
+		return true
+	}
+	if !config.ApiAllowUnauthenticated {
+		err := fmt.Errorf("HTTP API не подключён: проверка ключей выключена, а apiAllowUnauthenticated не задан")
+		s.logger.Error(ctx, err, zap.String("api_prefix", config.ApiPrefix))
+		return false
+	}
+	s.logger.Warn(ctx, "HTTP API подключён без проверки ключей и доступен всем клиентам redirect сервера",
+		zap.String("api_prefix", config.ApiPrefix))
+	return true
+}
+
 func (s *RedirectServer) Run(ctx context.Context) error {
 	const op = "RedirectServer.Run"
 	ctx = s.logger.NewOpCtx(ctx, op)
@@ -47,9 +88,263 @@ func (s *RedirectServer) Run(ctx context.Context) error {
 }
 
 func (s *RedirectServer) redirectHandler(w http.ResponseWriter, r *http.Request) {
-	bucket := chi.URLParam(r, "bucket")
+	bucketName := chi.URLParam(r, "bucket")
+	bucket, known := s.endpoint.bucketByName(bucketName)
+	// S3 не отдаёт объекты закрытого бакета по ссылке, а в режиме proxy сервер читает их своими ключами,
+	// поэтому изображения закрытого бакета по HTTP не отдаются
+	if known && bucket.Private {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if known && len(bucket.HotlinkHosts) > 0 {
+		// ответ зависит от сайта, на котором встроено изображение
+		w.Header().Add("Vary", "Origin, Referer")
+		if !hotlinkAllowed(bucket, r) {
+			s.rejectHotlink(w, r, bucket)
+			return
+		}
+	}
+
 	filename := chi.URLParam(r, "filename")
+	if _, err := uuid.Parse(filename); err != nil {
+		target, moved, err := s.resolveSlug(r.Context(), bucketName, filename)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if moved {
+			// прежний адрес навсегда перенаправляется на текущий
+			location := path.Join(path.Dir(r.URL.Path), target)
+			if r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, location, http.StatusMovedPermanently)
+			return
+		}
+		if target != "" {
+			filename = target
+		}
+	}
+
+	storage, key, code := s.objectLocation(r.Context(), bucketName, filename, r.URL.Query().Get("v"))
+
+	fallback := false
+	if known && (code == http.StatusNotFound || code == http.StatusGone) {
+		storage, key, fallback = s.substituteLocation(r.Context(), bucket, bucket.FallbackImageID)
+	}
+	if code != http.StatusOK && !fallback {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	if fallback {
+		w.Header().Set(fallbackHeader, "true")
+	}
+
+	s.serveObject(w, r, storage, key, fallback)
+}
+
+// serveObject перенаправляет на объект в S3, а в режиме proxy отдаёт его через сервер.
+// substitute - объект отдаётся вместо запрошенного изображения
+func (s *RedirectServer) serveObject(w http.ResponseWriter, r *http.Request, bucket string, key string, substitute bool) {
+	if s.proxy {
+		s.proxyObject(w, r, bucket, key, substitute)
+		return
+	}
 
 	// Perform the redirect
-	http.Redirect(w, r, s.s3Service.RedirectPath(bucket, s.s3Service.FileNameS(filename)), http.StatusFound) // StatusFound (302) for temporary redirects
+	http.Redirect(w, r, s.s3Service.RedirectPath(bucket, key), http.StatusFound) // StatusFound (302) for temporary redirects
+}
+
+// resolveSlug возвращает ID изображения по его читаемому адресу в бакете. Для прежнего адреса moved = true
+// и возвращается текущий адрес изображения, а если его нет - ID. Пустая строка - адрес не найден.
+// Ошибка возвращается, если адрес не удалось найти из-за недоступности БД, такой ответ не запоминается
+func (s *RedirectServer) resolveSlug(ctx context.Context, bucketName string, slug string) (string, bool, error) {
+	const op = "RedirectServer.resolveSlug"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	bucket, ok := s.endpoint.bucketByName(bucketName)
+	if !ok || validateSlug(slug) != nil {
+		return "", false, nil
+	}
+
+	missingKey := bucketName + "/" + slug
+	if _, ok := s.missing.get(missingKey); ok {
+		return "", false, nil
+	}
+
+	image, moved, err := s.endpoint.imageBySlug(ctx, bucket, slug)
+	if errors.Is(err, db.ErrNotFound) {
+		s.missing.add(missingKey, http.StatusNotFound)
+		return "", false, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("не удалось найти изображение по адресу: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("slug", slug))
+		return "", false, err
+	}
+	if moved && image.Slug != nil {
+		return *image.Slug, true, nil
+	}
+	return image.ID.String(), moved, nil
+}
+
+// objectLocation возвращает бакет S3 и ключ объекта ревизии изображения, пустая version - текущая ревизия.
+// Код ответа отличается от 200, если объект отдавать нельзя: 404 - бакет, изображение или ревизия не найдены,
+// 410 - изображение в корзине, 503 - БД недоступна. Отсутствующие изображения запоминаются в missing,
+// ошибки БД не запоминаются. Вместо 404 и 410 redirectHandler отдаёт запасное изображение бакета, если оно задано
+func (s *RedirectServer) objectLocation(ctx context.Context, bucketName string, filename string, version string) (string, string, int) {
+	const op = "RedirectServer.objectLocation"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	bucket, ok := s.endpoint.bucketByName(bucketName)
+	if !ok {
+		return "", "", http.StatusNotFound
+	}
+
+	id, err := uuid.Parse(filename)
+	if err != nil {
+		return "", "", http.StatusNotFound
+	}
+
+	missingKey := bucketName + "/" + id.String()
+	if code, ok := s.missing.get(missingKey); ok {
+		return "", "", code
+	}
+
+	image, err := s.endpoint.dbService.GetImage(ctx, id)
+	if err == nil && image.BucketID != bucket.ID {
+		s.missing.add(missingKey, http.StatusNotFound)
+		return "", "", http.StatusNotFound
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		code := http.StatusNotFound
+		var trashedBucket *models.Bucket
+		_, trashedBucket, err = s.endpoint.dbService.GetTrashedImageWithBucket(ctx, id)
+		if err == nil && trashedBucket.ID == bucket.ID {
+			code = http.StatusGone
+		}
+		if err == nil || errors.Is(err, db.ErrNotFound) {
+			s.missing.add(missingKey, code)
+			return "", "", code
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("image_id", id.String()))
+		return "", "", http.StatusServiceUnavailable
+	}
+
+	// после переименования без переноса объекты остаются в бакете S3 с прежним названием
+	if version == "" {
+		return bucket.StorageName, s.endpoint.imageKey(image), http.StatusOK
+	}
+
+	revision, err := strconv.Atoi(version)
+	if err != nil {
+		return "", "", http.StatusNotFound
+	}
+	if _, err := s.endpoint.dbService.GetImageVersion(ctx, id, revision); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return "", "", http.StatusNotFound
+		}
+		err = fmt.Errorf("не удалось получить ревизию изображения: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("image_id", id.String()), zap.Int("revision", revision))
+		return "", "", http.StatusServiceUnavailable
+	}
+	return bucket.StorageName, s.s3Service.FileNameRevision(id, revision), http.StatusOK
+}
+
+// substituteLocation возвращает бакет S3 и ключ объекта изображения id, которое отдаётся вместо
+// запрошенного изображения бакета, false - изображение не задано или хранится в закрытом бакете
+func (s *RedirectServer) substituteLocation(ctx context.Context, bucket *models.Bucket, id *uuid.UUID) (string, string, bool) {
+	image, imageBucket, ok := s.endpoint.substituteImage(ctx, bucket, id)
+	if !ok || imageBucket.Private {
+		return "", "", false
+	}
+	return imageBucket.StorageName, s.endpoint.imageKey(image), true
+}
+
+// proxyObject отдаёт объект из S3 через сервер. Range и условные заголовки передаются в S3,
+// поэтому ответы 206, 304 и 416 формирует хранилище. Объект, отдаваемый вместо запрошенного (substitute),
+// не кешируется надолго, чтобы клиент увидел настоящее изображение, когда оно станет доступно
+func (s *RedirectServer) proxyObject(w http.ResponseWriter, r *http.Request, bucket string, key string, substitute bool) {
+	const op = "RedirectServer.proxyObject"
+	ctx := s.logger.NewOpCtx(r.Context(), op)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	request := &s3.ObjectRequest{
+		Range:       r.Header.Get("Range"),
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+	}
+	// If-Modified-Since не учитывается вместе с If-None-Match
+	if since := r.Header.Get("If-Modified-Since"); since != "" && request.IfNoneMatch == "" {
+		if t, err := http.ParseTime(since); err == nil {
+			request.IfModifiedSince = &t
+		}
+	}
+
+	var object *s3.Object
+	var err error
+	if r.Method == http.MethodHead {
+		object, err = s.s3Service.StatObject(ctx, bucket, key, request)
+	} else {
+		object, err = s.s3Service.ReadObject(ctx, bucket, key, request)
+	}
+	switch {
+	case errors.Is(err, s3.ErrNotModified):
+		w.WriteHeader(http.StatusNotModified)
+		return
+	case errors.Is(err, s3.ErrObjectNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case errors.Is(err, s3.ErrInvalidRange):
+		http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+		return
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if object.Body != nil {
+		defer object.Body.Close()
+	}
+
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Length", strconv.FormatInt(object.Length, 10))
+	if object.ContentType != "" {
+		header.Set("Content-Type", object.ContentType)
+	}
+	if substitute {
+		header.Set("Cache-Control", "no-cache")
+	} else if object.CacheControl != "" {
+		header.Set("Cache-Control", object.CacheControl)
+	}
+	if object.ETag != "" {
+		header.Set("ETag", object.ETag)
+	}
+	if !object.LastModified.IsZero() {
+		header.Set("Last-Modified", object.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	code := http.StatusOK
+	if object.ContentRange != "" {
+		header.Set("Content-Range", object.ContentRange)
+		code = http.StatusPartialContent
+	}
+	w.WriteHeader(code)
+
+	if object.Body == nil {
+		return
+	}
+	_, err = io.Copy(w, object.Body)
+	if err != nil {
+		// заголовки уже отправлены, клиент получит оборванный ответ
+		err = fmt.Errorf("не удалось передать объект: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket), zap.String("key", key))
+	}
 }
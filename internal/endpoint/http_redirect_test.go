@@ -0,0 +1,194 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"s3n/internal/db/models"
+	"testing"
+	"time"
+)
+
+func newTestRedirectServer(e *Endpoint) *RedirectServer {
+	return &RedirectServer{
+		endpoint:  e,
+		s3Service: e.s3Service,
+		logger:    nopLogger{},
+		missing:   newMissingCache(time.Minute, 100),
+	}
+}
+
+func TestObjectLocation(t *testing.T) {
+	bucket := models.Bucket{ID: 1, BucketName: "b", StorageName: "storage-b"}
+	other := models.Bucket{ID: 2, BucketName: "other", StorageName: "other"}
+	image := models.Image{ID: uuid.New(), BucketID: 1, Revision: 3}
+	foreign := models.Image{ID: uuid.New(), BucketID: 2}
+	trashed := models.Image{ID: uuid.New(), BucketID: 1}
+	missing := uuid.New()
+	outage := fmt.Errorf("соединение с БД разорвано")
+
+	tests := []struct {
+		name     string
+		filename string
+		version  string
+		fail     error
+		wantKey  string
+		wantCode int
+		// wantCached ответ запоминается в missing
+		wantCached bool
+	}{
+		{name: "текущая ревизия", filename: image.ID.String(), wantKey: image.ID.String() + "-3", wantCode: http.StatusOK},
+		{name: "хранимая ревизия", filename: image.ID.String(), version: "2", wantKey: image.ID.String() + "-2", wantCode: http.StatusOK},
+		{name: "ревизия не найдена", filename: image.ID.String(), version: "1", wantCode: http.StatusNotFound},
+		{name: "не uuid", filename: "image.png", wantCode: http.StatusNotFound},
+		{name: "не найдено", filename: missing.String(), wantCode: http.StatusNotFound, wantCached: true},
+		{name: "другой бакет", filename: foreign.ID.String(), wantCode: http.StatusNotFound, wantCached: true},
+		{name: "в корзине", filename: trashed.ID.String(), wantCode: http.StatusGone, wantCached: true},
+		{name: "БД недоступна", filename: missing.String(), fail: outage, wantCode: http.StatusServiceUnavailable},
+		{name: "БД недоступна при чтении ревизии", filename: image.ID.String(), version: "2", fail: outage, wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			dbService.images[image.ID] = image
+			dbService.images[foreign.ID] = foreign
+			dbService.trashed[trashed.ID] = trashed
+			dbService.versions = []models.ImageVersion{{ImageID: image.ID, Revision: 2}}
+			s := newTestRedirectServer(newTestEndpoint(dbService, &fakeS3{}, bucket, other))
+
+			dbService.fail = tt.fail
+			storage, key, code := s.objectLocation(context.Background(), "b", tt.filename, tt.version)
+			if code != tt.wantCode || key != tt.wantKey {
+				t.Fatalf("код %d, ключ %q, ожидалось %d и %q", code, key, tt.wantCode, tt.wantKey)
+			}
+			if code == http.StatusOK && storage != "storage-b" {
+				t.Errorf("бакет S3 %q", storage)
+			}
+
+			if _, cached := s.missing.get("b/" + tt.filename); cached != tt.wantCached {
+				t.Errorf("ответ запомнен: %v, ожидалось %v", cached, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestResolveSlug(t *testing.T) {
+	bucket := models.Bucket{ID: 1, BucketName: "b"}
+	slug := "summer-2026"
+	image := models.Image{ID: uuid.New(), BucketID: 1, Slug: &slug}
+	unnamed := models.Image{ID: uuid.New(), BucketID: 1}
+	outage := fmt.Errorf("соединение с БД разорвано")
+
+	tests := []struct {
+		name       string
+		slug       string
+		fail       error
+		want       string
+		wantMoved  bool
+		wantErr    bool
+		wantCached bool
+	}{
+		{name: "текущий адрес", slug: slug, want: image.ID.String()},
+		{name: "прежний адрес", slug: "summer", want: slug, wantMoved: true},
+		{name: "прежний адрес без текущего", slug: "old-unnamed", want: unnamed.ID.String(), wantMoved: true},
+		{name: "не найден", slug: "winter", wantCached: true},
+		{name: "некорректный адрес", slug: "Winter!"},
+		{name: "БД недоступна", slug: "winter", fail: outage, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			dbService.images[image.ID] = image
+			dbService.images[unnamed.ID] = unnamed
+			dbService.aliases["1/summer"] = image.ID
+			dbService.aliases["1/old-unnamed"] = unnamed.ID
+			s := newTestRedirectServer(newTestEndpoint(dbService, &fakeS3{}, bucket))
+
+			dbService.fail = tt.fail
+			got, moved, err := s.resolveSlug(context.Background(), "b", tt.slug)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка %v, ожидалась ошибка: %v", err, tt.wantErr)
+			}
+			if got != tt.want || moved != tt.wantMoved {
+				t.Errorf("получено %q, %v; ожидалось %q, %v", got, moved, tt.want, tt.wantMoved)
+			}
+			if _, cached := s.missing.get("b/" + tt.slug); cached != tt.wantCached {
+				t.Errorf("ответ запомнен: %v, ожидалось %v", cached, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestDownloadImageDBUnavailable(t *testing.T) {
+	fallbackID := uuid.New()
+	bucket := models.Bucket{ID: 1, BucketName: "b", FallbackImageID: &fallbackID}
+	dbService := newFakeDB()
+	dbService.images[fallbackID] = models.Image{ID: fallbackID, BucketID: 1}
+	e := newTestEndpoint(dbService, &fakeS3{}, bucket)
+
+	// при недоступной БД запасное изображение не подменяет существующее
+	dbService.fail = fmt.Errorf("соединение с БД разорвано")
+	_, st := e.DownloadImage(context.Background(), "b", uuid.New(), nil, 0, 0)
+	if st != status.InternalError {
+		t.Errorf("статус %v, ожидался InternalError", st)
+	}
+	if _, _, ok := e.missingImageFallback(context.Background(), uuid.New(), "b"); ok {
+		t.Error("запасное изображение отдано при недоступной БД")
+	}
+
+	dbService.fail = nil
+	if image, _, ok := e.missingImageFallback(context.Background(), uuid.New(), "b"); !ok || image.ID != fallbackID {
+		t.Errorf("запасное изображение не отдано для ненайденного изображения")
+	}
+}
+
+func TestProxyPrivateBucket(t *testing.T) {
+	public := models.Bucket{ID: 1, BucketName: "public", StorageName: "public"}
+	private := models.Bucket{ID: 2, BucketName: "private", StorageName: "private", Private: true}
+	publicImage := models.Image{ID: uuid.New(), BucketID: public.ID}
+	privateImage := models.Image{ID: uuid.New(), BucketID: private.ID}
+	// запасное изображение хранится в закрытом бакете
+	withFallback := models.Bucket{ID: 3, BucketName: "fallback", StorageName: "fallback", FallbackImageID: &privateImage.ID}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "открытый бакет", path: "/public/" + publicImage.ID.String(), wantCode: http.StatusOK, wantBody: "public/" + publicImage.ID.String()},
+		{name: "закрытый бакет", path: "/private/" + privateImage.ID.String(), wantCode: http.StatusNotFound},
+		{name: "запасное изображение из закрытого бакета", path: "/fallback/" + uuid.New().String(), wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			dbService.images[publicImage.ID] = publicImage
+			dbService.images[privateImage.ID] = privateImage
+			s3Service := &fakeS3{objects: map[string]bool{
+				"public/" + publicImage.ID.String():   true,
+				"private/" + privateImage.ID.String(): true,
+			}}
+			s := newTestRedirectServer(newTestEndpoint(dbService, s3Service, public, private, withFallback))
+			s.proxy = true
+			router := chi.NewRouter()
+			router.HandleFunc("/{bucket}/{filename}", s.redirectHandler)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("код %d, ожидался %d", w.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("тело %q, ожидалось %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.wantCode != http.StatusOK && len(s3Service.calls) > 0 {
+				t.Errorf("объект прочитан из S3: %v", s3Service.calls)
+			}
+		})
+	}
+}
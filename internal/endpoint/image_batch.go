@@ -0,0 +1,382 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"runtime"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"s3n/internal/image_processing"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// batchLimit максимальное количество изображений в одном пакетном запросе, совпадает с лимитом DeleteObjects
+const batchLimit = drainBatchSize
+
+// errBatchTooLarge в пакетном запросе больше batchLimit изображений. Размер проверяется до проверки прав,
+// чтобы большой запрос не нагружал БД поиском бакетов изображений
+var errBatchTooLarge = fmt.Errorf("слишком много изображений в запросе, максимум %d", batchLimit)
+
+// parallel вызывает fn для индексов от 0 до n, одновременно выполняется не больше GOMAXPROCS вызовов
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// BatchGetImages получает изображения по списку ID одним запросом к БД.
+// Результаты возвращаются в порядке ID, у каждого свой статус
+func (e *Endpoint) BatchGetImages(ctx context.Context, ids []uuid.UUID) ([]api_models.ImageResult, status.Status) {
+	const op = "Endpoint.BatchGetImages"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if len(ids) > batchLimit {
+		e.logger.Error(ctx, errBatchTooLarge, zap.Int("count", len(ids)), zap.Int("limit", batchLimit))
+		return nil, status.IncorrectValue
+	}
+
+	images, err := e.dbService.GetImages(ctx, ids)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображения из БД: %w", err)
+		e.logger.Error(ctx, err, zap.Int("count", len(ids)))
+		return nil, status.InternalError
+	}
+
+	found := make(map[uuid.UUID]*models.Image, len(images))
+	for i := range images {
+		found[images[i].ID] = &images[i]
+	}
+
+	results := make([]api_models.ImageResult, 0, len(ids))
+	for _, id := range ids {
+		result := api_models.ImageResult{ID: id, Status: status.NotFound}
+		if image, ok := found[id]; ok {
+			if bucket, ok := e.bucketByID(image.BucketID); ok {
+				result.BucketName = bucket.BucketName
+				result.Image = imageToAPI(image)
+				result.Status = status.OK
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, status.OK
+}
+
+// BatchDeleteImages удаляет изображения по списку ID так же, как DeleteImage:
+// в корзину, если она включена, иначе вместе с объектами всех ревизий
+func (e *Endpoint) BatchDeleteImages(ctx context.Context, ids []uuid.UUID) ([]api_models.ImageResult, status.Status) {
+	const op = "Endpoint.BatchDeleteImages"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if len(ids) > batchLimit {
+		e.logger.Error(ctx, errBatchTooLarge, zap.Int("count", len(ids)), zap.Int("limit", batchLimit))
+		return nil, status.IncorrectValue
+	}
+
+	images, err := e.dbService.GetImages(ctx, ids)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображения из БД: %w", err)
+		e.logger.Error(ctx, err, zap.Int("count", len(ids)))
+		return nil, status.InternalError
+	}
+
+	bucketNames := map[uuid.UUID]string{}
+	statuses := map[uuid.UUID]status.Status{}
+	var candidates []models.Image
+	for _, image := range images {
+		if bucket, ok := e.bucketByID(image.BucketID); ok {
+			bucketNames[image.ID] = bucket.BucketName
+		}
+		if e.isWatermarkImage(image.ID) {
+			statuses[image.ID] = status.IncorrectValue
+			continue
+		}
+		candidates = append(candidates, image)
+	}
+
+	if len(candidates) > 0 {
+		var deleted []uuid.UUID
+		if e.trashRetention > 0 {
+			candidateIDs := make([]uuid.UUID, 0, len(candidates))
+			for _, image := range candidates {
+				candidateIDs = append(candidateIDs, image.ID)
+			}
+			deleted, err = e.dbService.TrashImages(ctx, candidateIDs)
+			if err == nil {
+				e.invalidateImages(candidates, deleted)
+			}
+		} else {
+			deleted, err = e.hardDelete(ctx, candidates, e.dbService.DeleteActiveImages)
+		}
+		if err != nil {
+			err = fmt.Errorf("не удалось удалить изображения: %w", err)
+			e.logger.Error(ctx, err, zap.Int("count", len(candidates)))
+			return nil, status.InternalError
+		}
+		for _, id := range deleted {
+			statuses[id] = status.OK
+		}
+	}
+
+	results := make([]api_models.ImageResult, 0, len(ids))
+	for _, id := range ids {
+		s, ok := statuses[id]
+		if !ok {
+			// не найдено или удалено другим запросом во время обработки
+			s = status.NotFound
+		}
+		results = append(results, api_models.ImageResult{ID: id, BucketName: bucketNames[id], Status: s})
+	}
+
+	return results, status.OK
+}
+
+// invalidateImages сбрасывает в кеше объекты текущих ревизий изображений с ID из ids
+func (e *Endpoint) invalidateImages(images []models.Image, ids []uuid.UUID) {
+	selected := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		selected[id] = true
+	}
+	for _, image := range images {
+		if bucket, ok := e.bucketByID(image.BucketID); ok && selected[image.ID] {
+			e.s3Service.Invalidate(bucket.StorageName, e.imageKey(&image))
+		}
+	}
+}
+
+// BatchCreateImages обрабатывает и загружает несколько изображений. Записи добавляются в БД одним запросом,
+// файлы обрабатываются и загружаются параллельно. Ошибка одного изображения не влияет на остальные
+func (e *Endpoint) BatchCreateImages(ctx context.Context, items []api_models.CreateImageItem) ([]api_models.ImageResult, status.Status) {
+	const op = "Endpoint.BatchCreateImages"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if len(items) > batchLimit {
+		e.logger.Error(ctx, errBatchTooLarge, zap.Int("count", len(items)), zap.Int("limit", batchLimit))
+		return nil, status.IncorrectValue
+	}
+
+	type pending struct {
+		bucket    *models.Bucket
+		image     *models.Image
+		processed *image_processing.Result
+	}
+
+	results := make([]api_models.ImageResult, len(items))
+	prepared := make([]pending, len(items))
+	seen := map[uuid.UUID]bool{}
+	// slugs адреса изображений запроса, ключ - "ID бакета/адрес"
+	slugs := map[string]bool{}
+	for i, item := range items {
+		results[i] = api_models.ImageResult{BucketName: item.BucketName, Status: status.IncorrectValue}
+		if item.ID != nil {
+			results[i].ID = *item.ID
+		} else {
+			results[i].ID = uuid.New()
+		}
+
+		if seen[results[i].ID] {
+			err := fmt.Errorf("ID изображения повторяется в запросе")
+			e.logger.Error(ctx, err, zap.String("bucket_name", item.BucketName), zap.String("image_id", results[i].ID.String()))
+			continue
+		}
+		seen[results[i].ID] = true
+
+		if item.ExpiresAt != nil && !item.ExpiresAt.After(time.Now()) {
+			err := fmt.Errorf("срок жизни изображения уже истёк")
+			e.logger.Error(ctx, err, zap.String("bucket_name", item.BucketName), zap.Time("expires_at", *item.ExpiresAt))
+			continue
+		}
+
+		bucket, s := e.writableBucket(ctx, item.BucketName)
+		if s != status.OK {
+			results[i].Status = s
+			continue
+		}
+
+		var slug *string
+		if item.Slug != "" {
+			key := strconv.Itoa(int(bucket.ID)) + "/" + item.Slug
+			if slugs[key] {
+				err := fmt.Errorf("адрес изображения повторяется в запросе")
+				e.logger.Error(ctx, err, zap.String("bucket_name", item.BucketName), zap.String("slug", item.Slug))
+				continue
+			}
+			slugs[key] = true
+
+			results[i].Status = e.checkSlug(ctx, bucket, item.Slug)
+			if results[i].Status != status.OK {
+				continue
+			}
+			slug = &item.Slug
+		}
+
+		expiresAt := item.ExpiresAt
+		if expiresAt == nil {
+			expiresAt = e.defaultExpiry(bucket)
+		}
+
+		prepared[i] = pending{
+			bucket: bucket,
+			image:  &models.Image{ID: results[i].ID, BucketID: bucket.ID, ExpiresAt: expiresAt, Slug: slug},
+		}
+	}
+
+	parallel(len(items), func(i int) {
+		if prepared[i].image == nil {
+			return
+		}
+		item := items[i]
+		prepared[i].processed, results[i].Status = e.transform(ctx, prepared[i].bucket, item.File, item.FileExtension, item.Quality, item.MaxSize, item.Frame, item.Target)
+	})
+
+	// квоты проверяются для всех изображений бакета сразу, при превышении отклоняются все изображения бакета.
+	// Квота владельца проверяется по сумме всех его бакетов в запросе, при превышении отклоняются все его изображения
+	type usage struct {
+		bucket *models.Bucket
+		images int
+		bytes  int64
+	}
+	usages := map[int16]*usage{}
+	tenantUsages := map[string]*usage{}
+	for _, p := range prepared {
+		if p.processed == nil {
+			continue
+		}
+		u, ok := usages[p.bucket.ID]
+		if !ok {
+			u = &usage{bucket: p.bucket}
+			usages[p.bucket.ID] = u
+		}
+		u.images++
+		u.bytes += int64(len(p.processed.Data))
+
+		tenant := deref(p.bucket.Tenant)
+		t, ok := tenantUsages[tenant]
+		if !ok {
+			t = &usage{}
+			tenantUsages[tenant] = t
+		}
+		t.images++
+		t.bytes += int64(len(p.processed.Data))
+	}
+	reject := func(s status.Status, match func(bucket *models.Bucket) bool) {
+		for i := range prepared {
+			if prepared[i].processed != nil && match(prepared[i].bucket) {
+				prepared[i].processed = nil
+				results[i].Status = s
+			}
+		}
+	}
+	rejected := map[string]bool{}
+	for tenant, t := range tenantUsages {
+		s := e.checkTenantQuota(ctx, tenant, t.images, t.bytes)
+		if s != status.OK {
+			rejected[tenant] = true
+			reject(s, func(bucket *models.Bucket) bool { return deref(bucket.Tenant) == tenant })
+		}
+	}
+	for bucketID, u := range usages {
+		if rejected[deref(u.bucket.Tenant)] {
+			continue
+		}
+		s := e.checkStorageQuota(ctx, u.bucket, false, u.images, u.bytes)
+		if s == status.OK {
+			s = e.takeUploads(ctx, u.bucket, u.images)
+		}
+		if s != status.OK {
+			reject(s, func(bucket *models.Bucket) bool { return bucket.ID == bucketID })
+		}
+	}
+
+	var rows []models.Image
+	for i := range prepared {
+		if prepared[i].processed != nil {
+			rows = append(rows, *prepared[i].image)
+		}
+	}
+	if len(rows) == 0 {
+		return results, status.OK
+	}
+
+	inserted, err := e.dbService.CreateImages(ctx, rows)
+	if err != nil {
+		err = fmt.Errorf("не удалось добавить изображения в БД: %w", err)
+		e.logger.Error(ctx, err, zap.Int("count", len(rows)))
+		return nil, status.InternalError
+	}
+	added := make(map[uuid.UUID]bool, len(inserted))
+	for _, id := range inserted {
+		added[id] = true
+	}
+
+	// файл загружается только для добавленной записи, иначе он перезапишет объект существующего изображения
+	var failed []uuid.UUID
+	var failedLock sync.Mutex
+	parallel(len(items), func(i int) {
+		p := prepared[i]
+		if p.processed == nil {
+			return
+		}
+		if !added[p.image.ID] {
+			// адрес мог занять другой запрос после проверки
+			err := fmt.Errorf("ID или адрес изображения уже заняты")
+			e.logger.Error(ctx, err, zap.String("bucket_name", p.bucket.BucketName), zap.String("image_id", p.image.ID.String()))
+			results[i].Status = status.IncorrectValue
+			return
+		}
+
+		err := e.s3Service.UploadFileBytes(ctx, p.bucket.StorageName, e.s3Service.FileName(p.image.ID), p.processed.Data, e.objectAttributes(p.bucket, p.image))
+		if err != nil {
+			err = fmt.Errorf("не удалось загрузить файл на S3: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", p.bucket.BucketName), zap.String("image_id", p.image.ID.String()))
+			results[i].Status = status.InternalError
+			failedLock.Lock()
+			failed = append(failed, p.image.ID)
+			failedLock.Unlock()
+			return
+		}
+
+		results[i].Image = imageToAPI(p.image)
+		results[i].Image.Encoding = encodingToAPI(p.processed)
+		results[i].Status = status.OK
+	})
+
+	if len(failed) > 0 {
+		err = e.dbService.DeleteImages(ctx, failed)
+		if err != nil {
+			err = fmt.Errorf("не удалось очистить изображения в БД: %w", err)
+			e.logger.Error(ctx, err, zap.Int("count", len(failed)))
+		}
+	}
+
+	var versions []models.ImageVersion
+	for i, p := range prepared {
+		if results[i].Status == status.OK {
+			versions = append(versions, models.ImageVersion{ImageID: p.image.ID, Revision: p.image.Revision, Size: len(p.processed.Data)})
+		}
+	}
+	if len(versions) > 0 {
+		err = e.dbService.AddImageVersions(ctx, versions)
+		if err != nil {
+			err = fmt.Errorf("не удалось сохранить ревизии изображений: %w", err)
+			e.logger.Error(ctx, err, zap.Int("count", len(versions)))
+		}
+	}
+
+	return results, status.OK
+}
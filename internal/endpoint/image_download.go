@@ -0,0 +1,84 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"s3n/internal/db"
+	"s3n/internal/endpoint/api_models"
+	"s3n/internal/s3"
+)
+
+// DownloadImage открывает файл изображения для чтения. revision nil - текущая ревизия,
+// иначе одна из хранимых в истории. offset и length задают диапазон байт, length 0 - до конца файла.
+// Вместо ненайденного изображения отдаётся запасное изображение его бакета, bucketName - бакет,
+// в котором искалось изображение, пустая строка - известен только по корзине
+func (e *Endpoint) DownloadImage(ctx context.Context, bucketName string, id uuid.UUID, revision *int, offset int64, length int64) (*api_models.ImageDownload, status.Status) {
+	const op = "Endpoint.DownloadImage"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if offset < 0 || length < 0 {
+		err := fmt.Errorf("некорректный диапазон байт")
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int64("offset", offset), zap.Int64("length", length))
+		return nil, status.IncorrectValue
+	}
+
+	image, bucket, err := e.dbService.GetImageWithBucket(ctx, id)
+	fallback := false
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		if !errors.Is(err, db.ErrNotFound) {
+			return nil, status.InternalError
+		}
+
+		image, bucket, fallback = e.missingImageFallback(ctx, id, bucketName)
+		if !fallback {
+			return nil, status.NotFound
+		}
+		id = image.ID
+		revision = nil
+	}
+
+	key := e.imageKey(image)
+	selected := image.Revision
+	if revision != nil && *revision != image.Revision {
+		_, err = e.dbService.GetImageVersion(ctx, id, *revision)
+		if err != nil {
+			err = fmt.Errorf("не удалось получить ревизию изображения: %w", err)
+			e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", *revision))
+			if !errors.Is(err, db.ErrNotFound) {
+				return nil, status.InternalError
+			}
+			return nil, status.NotFound
+		}
+		key = e.s3Service.FileNameRevision(id, *revision)
+		selected = *revision
+	}
+
+	object, err := e.s3Service.GetObject(ctx, bucket.StorageName, key, offset, length)
+	if err != nil {
+		if errors.Is(err, s3.ErrInvalidRange) {
+			err = fmt.Errorf("диапазон начинается за концом файла: %w", err)
+			e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int64("offset", offset))
+			return nil, status.IncorrectValue
+		}
+		err = fmt.Errorf("не удалось прочитать файл изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()), zap.Int("revision", selected))
+		return nil, status.InternalError
+	}
+
+	return &api_models.ImageDownload{
+		Image:       imageToAPI(image),
+		Revision:    selected,
+		ContentType: object.ContentType,
+		Offset:      object.Offset,
+		Length:      object.Length,
+		Size:        object.Size,
+		Fallback:    fallback,
+		Body:        object.Body,
+	}, status.OK
+}
@@ -0,0 +1,171 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+)
+
+// writableBucket возвращает бакет из кеша, в который разрешена загрузка изображений
+func (e *Endpoint) writableBucket(ctx context.Context, bucketName string) (*models.Bucket, status.Status) {
+	e.bucketCacheLock.RLock()
+	bucket, ok := e.bucketCache[bucketName]
+	e.bucketCacheLock.RUnlock()
+	if !ok {
+		err := fmt.Errorf("не удалось найти бакет в кеше")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.NotFound
+	}
+
+	if bucket.Archived {
+		err := fmt.Errorf("бакет в архиве, загрузка запрещена")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.FailedPrecondition
+	}
+
+	return bucket, status.OK
+}
+
+// MoveImage переносит изображение в другой бакет с сохранением ID
+func (e *Endpoint) MoveImage(ctx context.Context, id uuid.UUID, targetBucketName string) (*api_models.ImageWithBucket, status.Status) {
+	const op = "Endpoint.MoveImage"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	image, source, err := e.dbService.GetImageWithBucket(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, status.NotFound
+	}
+
+	if source.BucketName == targetBucketName {
+		return imageWithBucketToAPI(image, source.BucketName), status.OK
+	}
+
+	if _, s := e.writableBucket(ctx, source.BucketName); s != status.OK {
+		return nil, s
+	}
+	target, s := e.writableBucket(ctx, targetBucketName)
+	if s != status.OK {
+		return nil, s
+	}
+
+	keys, err := e.imageKeys(ctx, *image)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить ревизии изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", targetBucketName), zap.String("image_id", id.String()))
+		return nil, status.InternalError
+	}
+
+	versions, err := e.dbService.GetImageVersions(ctx, image.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить ревизии изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", targetBucketName), zap.String("image_id", id.String()))
+		return nil, status.InternalError
+	}
+	var size int64
+	for _, version := range versions {
+		size += int64(version.Size)
+	}
+	// перенос между бакетами одного владельца не меняет его использование
+	s = e.checkStorageQuota(ctx, target, deref(source.Tenant) != deref(target.Tenant), 1, size)
+	if s != status.OK {
+		return nil, s
+	}
+
+	// бакеты могут использовать один бакет S3, тогда объекты уже на месте и удалять их нельзя
+	sameStorage := source.StorageName == target.StorageName
+
+	// ревизии переносятся вместе с текущей, чтобы история изображения сохранилась
+	for i, key := range keys {
+		err = e.s3Service.CopyFile(ctx, source.StorageName, key, target.StorageName, key, e.objectAttributes(target, image))
+		if err != nil {
+			if !sameStorage {
+				_ = e.s3Service.DeleteFiles(ctx, target.StorageName, keys[:i])
+			}
+			err = fmt.Errorf("не удалось скопировать изображение: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", targetBucketName), zap.String("image_id", id.String()))
+			return nil, status.InternalError
+		}
+	}
+
+	moved, err := e.dbService.MoveImage(ctx, image.ID, source.ID, target.ID, image.Revision)
+	if err != nil || !moved {
+		if !sameStorage {
+			_ = e.s3Service.DeleteFiles(ctx, target.StorageName, keys)
+		}
+		if err != nil {
+			err = fmt.Errorf("не удалось перенести изображение в БД: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", targetBucketName), zap.String("image_id", id.String()))
+			return nil, status.InternalError
+		}
+
+		// изображение перенесено или заменено другим запросом, новая ревизия не скопирована
+		err = fmt.Errorf("изображение было изменено во время переноса")
+		e.logger.Error(ctx, err, zap.String("bucket_name", targetBucketName), zap.String("image_id", id.String()))
+		return nil, status.FailedPrecondition
+	}
+
+	if !sameStorage {
+		err = e.s3Service.DeleteFiles(ctx, source.StorageName, keys)
+		if err != nil {
+			// изображение уже перенесено, в исходном бакете остаются лишние объекты
+			err = fmt.Errorf("не удалось удалить изображение из исходного бакета: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", source.BucketName), zap.String("image_id", id.String()))
+		}
+	}
+
+	return imageWithBucketToAPI(image, target.BucketName), status.OK
+}
+
+// CopyImage копирует текущую ревизию изображения в бакет под новым ID
+func (e *Endpoint) CopyImage(ctx context.Context, id uuid.UUID, targetBucketName string) (*api_models.ImageWithBucket, status.Status) {
+	const op = "Endpoint.CopyImage"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	image, source, err := e.dbService.GetImageWithBucket(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, status.NotFound
+	}
+
+	target, s := e.writableBucket(ctx, targetBucketName)
+	if s != status.OK {
+		return nil, s
+	}
+
+	size := 0
+	if version, err := e.dbService.GetImageVersion(ctx, image.ID, image.Revision); err == nil {
+		size = version.Size
+	}
+	s = e.checkQuota(ctx, target, 1, int64(size), 0)
+	if s != status.OK {
+		return nil, s
+	}
+
+	copied := &models.Image{ID: uuid.New(), BucketID: target.ID, ExpiresAt: e.defaultExpiry(target)}
+	key := e.imageKey(copied)
+	err = e.s3Service.CopyFile(ctx, source.StorageName, e.imageKey(image), target.StorageName, key, e.objectAttributes(target, copied))
+	if err != nil {
+		err = fmt.Errorf("не удалось скопировать изображение: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", targetBucketName), zap.String("image_id", id.String()))
+		return nil, status.InternalError
+	}
+
+	err = e.dbService.AddImage(ctx, target.ID, copied.ID, copied.ExpiresAt)
+	if err != nil {
+		_ = e.s3Service.DeleteFile(ctx, target.StorageName, key)
+		err = fmt.Errorf("не удалось добавить изображение в БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", targetBucketName), zap.String("image_id", id.String()))
+		return nil, status.InternalError
+	}
+
+	e.recordVersion(ctx, copied, size)
+
+	return imageWithBucketToAPI(copied, target.BucketName), status.OK
+}
@@ -0,0 +1,310 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"s3n/internal/s3"
+)
+
+func imageVersionToAPI(version *models.ImageVersion, current int) *api_models.ImageVersion {
+	return &api_models.ImageVersion{
+		Revision:  version.Revision,
+		Size:      version.Size,
+		CreatedAt: version.CreatedAt,
+		Current:   version.Revision == current,
+	}
+}
+
+// imageKeys возвращает ключи объектов всех хранимых ревизий изображений
+func (e *Endpoint) imageKeys(ctx context.Context, images ...models.Image) ([]string, error) {
+	byID, err := e.imageKeysByID(ctx, images...)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, image := range images {
+		keys = append(keys, byID[image.ID]...)
+		delete(byID, image.ID)
+	}
+	return keys, nil
+}
+
+// imageKeysByID ключи объектов всех ревизий каждого изображения, ревизии читаются одним запросом
+func (e *Endpoint) imageKeysByID(ctx context.Context, images ...models.Image) (map[uuid.UUID][]string, error) {
+	ids := make([]uuid.UUID, 0, len(images))
+	for _, image := range images {
+		ids = append(ids, image.ID)
+	}
+
+	versions, err := e.dbService.GetImageVersions(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	// текущая ревизия входит в список, даже если запись о ней не была сохранена
+	keys := map[uuid.UUID][]string{}
+	seen := map[string]bool{}
+	add := func(id uuid.UUID, key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys[id] = append(keys[id], key)
+		}
+	}
+	for _, image := range images {
+		add(image.ID, e.imageKey(&image))
+	}
+	for _, version := range versions {
+		add(version.ImageID, e.s3Service.FileNameRevision(version.ImageID, version.Revision))
+	}
+
+	return keys, nil
+}
+
+// recordVersion сохраняет запись о ревизии. Ошибка не прерывает загрузку:
+// без записи ревизия не попадёт в историю, но текущая ревизия всегда известна по image
+func (e *Endpoint) recordVersion(ctx context.Context, image *models.Image, size int) {
+	err := e.dbService.AddImageVersion(ctx, &models.ImageVersion{
+		ImageID:  image.ID,
+		Revision: image.Revision,
+		Size:     size,
+	})
+	if err != nil {
+		err = fmt.Errorf("не удалось сохранить ревизию изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", image.ID.String()), zap.Int("revision", image.Revision))
+	}
+}
+
+// pruneVersions удаляет ревизии сверх keepRevisions, текущая ревизия не удаляется
+func (e *Endpoint) pruneVersions(ctx context.Context, bucket *models.Bucket, image *models.Image) {
+	versions, err := e.dbService.GetImageVersions(ctx, image.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить ревизии изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", image.ID.String()))
+		return
+	}
+
+	var revisions []int
+	var keys []string
+	kept := 0
+	for _, version := range versions {
+		if version.Revision == image.Revision || kept < e.keepRevisions-1 {
+			if version.Revision != image.Revision {
+				kept++
+			}
+			continue
+		}
+		revisions = append(revisions, version.Revision)
+		keys = append(keys, e.s3Service.FileNameRevision(image.ID, version.Revision))
+	}
+	if len(revisions) == 0 {
+		return
+	}
+
+	err = e.s3Service.DeleteFiles(ctx, bucket.StorageName, keys)
+	if err != nil {
+		err = fmt.Errorf("не удалось удалить устаревшие ревизии: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", image.ID.String()))
+		return
+	}
+
+	err = e.dbService.DeleteImageVersions(ctx, image.ID, revisions)
+	if err != nil {
+		err = fmt.Errorf("не удалось удалить записи об устаревших ревизиях: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", image.ID.String()))
+	}
+}
+
+func (e *Endpoint) ListImageVersions(ctx context.Context, id uuid.UUID) ([]api_models.ImageVersion, status.Status) {
+	const op = "Endpoint.ListImageVersions"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	image, err := e.dbService.GetImage(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, status.NotFound
+	}
+
+	versions, err := e.dbService.GetImageVersions(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить ревизии изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, status.InternalError
+	}
+
+	var apiVersions []api_models.ImageVersion
+	for _, version := range versions {
+		apiVersions = append(apiVersions, *imageVersionToAPI(&version, image.Revision))
+	}
+
+	return apiVersions, status.OK
+}
+
+// GetImageVersion возвращает сведения о ревизии вместе с её содержимым
+func (e *Endpoint) GetImageVersion(ctx context.Context, id uuid.UUID, revision int) (*api_models.ImageVersion, []byte, status.Status) {
+	const op = "Endpoint.GetImageVersion"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	image, bucket, err := e.dbService.GetImageWithBucket(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, nil, status.NotFound
+	}
+
+	version, err := e.dbService.GetImageVersion(ctx, id, revision)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить ревизию изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", revision))
+		return nil, nil, status.NotFound
+	}
+
+	file, err := e.s3Service.DownloadFileBytes(ctx, bucket.StorageName, e.s3Service.FileNameRevision(id, revision))
+	if err != nil {
+		err = fmt.Errorf("не удалось скачать ревизию изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", revision))
+		return nil, nil, status.InternalError
+	}
+
+	return imageVersionToAPI(version, image.Revision), file, status.OK
+}
+
+// RestoreImageVersion делает прежнюю ревизию текущей, копируя её в новую ревизию,
+// чтобы история оставалась неизменной и ключ объекта снова поменялся
+func (e *Endpoint) RestoreImageVersion(ctx context.Context, id uuid.UUID, revision int) (*api_models.Image, status.Status) {
+	const op = "Endpoint.RestoreImageVersion"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	image, imageBucket, err := e.dbService.GetImageWithBucket(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, status.NotFound
+	}
+
+	bucket, s := e.writableBucket(ctx, imageBucket.BucketName)
+	if s != status.OK {
+		return nil, s
+	}
+
+	version, err := e.dbService.GetImageVersion(ctx, id, revision)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить ревизию изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", revision))
+		return nil, status.NotFound
+	}
+	if version.Revision == image.Revision {
+		return imageToAPI(image), status.OK
+	}
+	// копия ревизии хранится отдельно от прежней, поэтому учитывается в объёме
+	s = e.checkQuota(ctx, bucket, 0, int64(version.Size), 0)
+	if s != status.OK {
+		return nil, s
+	}
+
+	// параллельные восстановления и замены копируют ревизии под разными ключами
+	newRevision, err := e.dbService.ReserveImageRevision(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось выделить ревизию изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", revision))
+		return nil, status.InternalError
+	}
+	restored := *image
+	restored.Revision = newRevision
+	key := e.imageKey(&restored)
+	err = e.s3Service.CopyFile(ctx, bucket.StorageName, e.s3Service.FileNameRevision(id, revision), bucket.StorageName, key, e.objectAttributes(bucket, image))
+	if err != nil {
+		err = fmt.Errorf("не удалось скопировать ревизию изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", revision))
+		return nil, status.InternalError
+	}
+
+	updated, err := e.dbService.SetImageRevision(ctx, id, image.BucketID, image.Revision, restored.Revision)
+	if err != nil || !updated {
+		_ = e.s3Service.DeleteFile(ctx, bucket.StorageName, key)
+		if err != nil {
+			err = fmt.Errorf("не удалось обновить ревизию изображения в БД: %w", err)
+			e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", revision))
+			return nil, status.InternalError
+		}
+
+		err = fmt.Errorf("изображение было заменено другим запросом")
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", revision))
+		return nil, status.FailedPrecondition
+	}
+	e.invalidateOverlays(id)
+
+	e.recordVersion(ctx, &restored, version.Size)
+	e.pruneVersions(ctx, bucket, &restored)
+
+	return imageToAPI(&restored), status.OK
+}
+
+// RunSizeBackfill заполняет размеры ревизий, записанных без размера миграцией image_version.
+// Размер берётся из сведений об объекте в S3, ревизии без объекта пропускаются
+func (e *Endpoint) RunSizeBackfill(ctx context.Context) {
+	const op = "Endpoint.RunSizeBackfill"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	filled, err := e.backfillVersionSizes(ctx)
+	if err != nil {
+		err = fmt.Errorf("не удалось заполнить размеры ревизий: %w", err)
+		e.logger.Error(ctx, err, zap.Int("filled", filled))
+		return
+	}
+	if filled > 0 {
+		e.logger.Info(ctx, "заполнены размеры ревизий", zap.Int("filled", filled))
+	}
+}
+
+// backfillVersionSizes проходит ревизии без размера партиями по порядку ключа и записывает размеры объектов
+func (e *Endpoint) backfillVersionSizes(ctx context.Context) (int, error) {
+	filled := 0
+	afterID, afterRevision := uuid.Nil, 0
+	for {
+		versions, err := e.dbService.GetUnsizedImageVersions(ctx, afterID, afterRevision, drainBatchSize)
+		if err != nil {
+			return filled, err
+		}
+
+		for _, version := range versions {
+			afterID, afterRevision = version.ImageID, version.Revision
+			if ctx.Err() != nil {
+				return filled, ctx.Err()
+			}
+
+			bucket, ok := e.bucketByID(version.BucketID)
+			if !ok {
+				continue
+			}
+			key := e.s3Service.FileNameRevision(version.ImageID, version.Revision)
+			object, err := e.s3Service.StatObject(ctx, bucket.StorageName, key, &s3.ObjectRequest{})
+			if errors.Is(err, s3.ErrObjectNotFound) {
+				e.logger.Warn(ctx, "объект ревизии не найден, размер не заполнен", zap.String("image_id", version.ImageID.String()), zap.Int("revision", version.Revision))
+				continue
+			}
+			if err != nil {
+				return filled, err
+			}
+			if object.Size == 0 {
+				continue
+			}
+
+			if err := e.dbService.SetImageVersionSize(ctx, version.ImageID, version.Revision, int(object.Size)); err != nil {
+				return filled, err
+			}
+			filled++
+		}
+
+		if len(versions) < drainBatchSize {
+			return filled, nil
+		}
+	}
+}
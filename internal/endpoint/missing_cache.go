@@ -0,0 +1,73 @@
+package endpoint
+
+import (
+	"sync"
+	"time"
+)
+
+// missingCache запоминает ответы на запросы отсутствующих изображений на время ttl,
+// чтобы повторные запросы несуществующих адресов не доходили до БД
+type missingCache struct {
+	ttl   time.Duration
+	limit int
+
+	lock    sync.Mutex
+	entries map[string]missingEntry
+}
+
+type missingEntry struct {
+	code    int
+	expires time.Time
+}
+
+func newMissingCache(ttl time.Duration, limit int) *missingCache {
+	return &missingCache{
+		ttl:     ttl,
+		limit:   limit,
+		entries: map[string]missingEntry{},
+	}
+}
+
+// get возвращает код ответа для отсутствующего изображения, false - записи нет или она устарела
+func (c *missingCache) get(key string) (int, bool) {
+	if c.ttl <= 0 {
+		return 0, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return 0, false
+	}
+	return entry.code, true
+}
+
+// add запоминает код ответа. Если кеш заполнен, удаляются устаревшие записи,
+// а если их нет - все записи, чтобы поток случайных адресов не занимал память
+func (c *missingCache) add(key string, code int) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= c.limit {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.limit {
+			c.entries = map[string]missingEntry{}
+		}
+	}
+	c.entries[key] = missingEntry{code: code, expires: now.Add(c.ttl)}
+}
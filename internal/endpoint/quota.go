@@ -0,0 +1,242 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"go.uber.org/zap"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// allUploadsKey ключ счётчика загрузок во все бакеты
+const allUploadsKey = "*"
+
+func bucketUploadsKey(bucketID int16) string {
+	return "bucket:" + strconv.Itoa(int(bucketID))
+}
+
+func tenantUploadsKey(tenant string) string {
+	if tenant == "" {
+		return allUploadsKey
+	}
+	return "tenant:" + tenant
+}
+
+// uploadCounter считает загрузки за текущую минуту. Счётчики хранятся в памяти экземпляра
+// и сбрасываются в начале каждой минуты
+type uploadCounter struct {
+	lock   sync.Mutex
+	minute int64
+	counts map[string]int
+}
+
+func newUploadCounter() *uploadCounter {
+	return &uploadCounter{counts: map[string]int{}}
+}
+
+// reset сбрасывает счётчики, если началась новая минута. Вызывается под lock
+func (c *uploadCounter) reset() {
+	minute := time.Now().Unix() / 60
+	if minute != c.minute {
+		c.minute = minute
+		c.counts = map[string]int{}
+	}
+}
+
+// take учитывает n загрузок по всем ключам, если ни один ограниченный ключ не превысит лимит.
+// Лимит 0 - без ограничения
+func (c *uploadCounter) take(n int, limits map[string]int) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.reset()
+	for key, limit := range limits {
+		if limit > 0 && c.counts[key]+n > limit {
+			return false
+		}
+	}
+	for key := range limits {
+		c.counts[key] += n
+	}
+	c.counts[allUploadsKey] += n
+	return true
+}
+
+func (c *uploadCounter) count(key string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.reset()
+	return c.counts[key]
+}
+
+// tenantBucketIDs ID бакетов владельца из кеша, пустой tenant - все бакеты
+func (e *Endpoint) tenantBucketIDs(tenant string) []int16 {
+	e.bucketCacheLock.RLock()
+	defer e.bucketCacheLock.RUnlock()
+
+	ids := make([]int16, 0, len(e.bucketCache))
+	for _, bucket := range e.bucketCache {
+		if tenant == "" || ownedBy(bucket, tenant) {
+			ids = append(ids, bucket.ID)
+		}
+	}
+	return ids
+}
+
+func bucketQuota(bucket *models.Bucket) *api_models.Quota {
+	if bucket.QuotaImages <= 0 && bucket.QuotaBytes <= 0 && bucket.QuotaUploads <= 0 {
+		return nil
+	}
+	return &api_models.Quota{
+		MaxImages:           bucket.QuotaImages,
+		MaxBytes:            bucket.QuotaBytes,
+		MaxUploadsPerMinute: bucket.QuotaUploads,
+	}
+}
+
+func (e *Endpoint) tenantQuota(tenant string) *api_models.Quota {
+	quota, ok := e.tenantQuotas[tenant]
+	if tenant == "" || !ok {
+		return nil
+	}
+	return &api_models.Quota{
+		MaxImages:           quota.MaxImages,
+		MaxBytes:            quota.MaxBytes,
+		MaxUploadsPerMinute: quota.MaxUploadsPerMinute,
+	}
+}
+
+// exceeds проверяет, превысит ли использование квоту после добавления images изображений и bytes байт
+func (e *Endpoint) exceeds(ctx context.Context, quota *api_models.Quota, bucketIDs []int16, images int, bytes int64) (bool, error) {
+	if quota == nil || (quota.MaxImages <= 0 || images == 0) && (quota.MaxBytes <= 0 || bytes == 0) {
+		return false, nil
+	}
+
+	usage, err := e.dbService.GetUsage(ctx, bucketIDs)
+	if err != nil {
+		return false, err
+	}
+	return quota.MaxImages > 0 && images > 0 && usage.Images+images > quota.MaxImages ||
+		quota.MaxBytes > 0 && bytes > 0 && usage.Bytes+bytes > quota.MaxBytes, nil
+}
+
+// checkStorageQuota проверяет квоты на количество и объём изображений бакета и, если withTenant, его владельца
+func (e *Endpoint) checkStorageQuota(ctx context.Context, bucket *models.Bucket, withTenant bool, images int, bytes int64) status.Status {
+	tenant := deref(bucket.Tenant)
+
+	exceeded, err := e.exceeds(ctx, bucketQuota(bucket), []int16{bucket.ID}, images, bytes)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить использование бакета из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName))
+		return status.InternalError
+	}
+	if exceeded {
+		err := fmt.Errorf("превышена квота бакета на количество или объём изображений")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("tenant", tenant))
+		return status.FailedPrecondition
+	}
+	if !withTenant {
+		return status.OK
+	}
+	return e.checkTenantQuota(ctx, tenant, images, bytes)
+}
+
+// checkTenantQuota проверяет квоту владельца на количество и объём изображений во всех его бакетах
+func (e *Endpoint) checkTenantQuota(ctx context.Context, tenant string, images int, bytes int64) status.Status {
+	exceeded, err := e.exceeds(ctx, e.tenantQuota(tenant), e.tenantBucketIDs(tenant), images, bytes)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить использование бакетов владельца из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("tenant", tenant))
+		return status.InternalError
+	}
+	if exceeded {
+		err := fmt.Errorf("превышена квота владельца на количество или объём изображений")
+		e.logger.Error(ctx, err, zap.String("tenant", tenant))
+		return status.FailedPrecondition
+	}
+	return status.OK
+}
+
+// checkQuota проверяет квоты бакета и его владельца перед добавлением images изображений, bytes байт
+// и uploads загрузок. Загрузки учитываются, только если все квоты соблюдены
+func (e *Endpoint) checkQuota(ctx context.Context, bucket *models.Bucket, images int, bytes int64, uploads int) status.Status {
+	s := e.checkStorageQuota(ctx, bucket, true, images, bytes)
+	if s != status.OK {
+		return s
+	}
+	return e.takeUploads(ctx, bucket, uploads)
+}
+
+// takeUploads учитывает uploads загрузок в бакет, если это не превысит квоты бакета и его владельца
+func (e *Endpoint) takeUploads(ctx context.Context, bucket *models.Bucket, uploads int) status.Status {
+	if uploads == 0 {
+		return status.OK
+	}
+
+	tenant := deref(bucket.Tenant)
+	tenantLimit := e.tenantQuota(tenant)
+	limits := map[string]int{bucketUploadsKey(bucket.ID): bucket.QuotaUploads}
+	if tenant != "" {
+		limits[tenantUploadsKey(tenant)] = 0
+		if tenantLimit != nil {
+			limits[tenantUploadsKey(tenant)] = tenantLimit.MaxUploadsPerMinute
+		}
+	}
+	if !e.uploads.take(uploads, limits) {
+		err := fmt.Errorf("превышена квота на количество загрузок в минуту")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("tenant", tenant))
+		return status.FailedPrecondition
+	}
+	return status.OK
+}
+
+// GetUsage возвращает использование бакета или всех бакетов владельца вместе с квотой.
+// Ключ владельца видит только своё использование, пустой tenant у ключа оператора - все бакеты
+func (e *Endpoint) GetUsage(ctx context.Context, tenant string, bucketName string) (*api_models.Usage, status.Status) {
+	const op = "Endpoint.GetUsage"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if callerTenant := tenantFrom(ctx); callerTenant != "" {
+		if tenant != "" && tenant != callerTenant {
+			err := fmt.Errorf("владелец не совпадает с владельцем вызывающего ключа")
+			e.logger.Error(ctx, err, zap.String("tenant", tenant))
+			return nil, status.IncorrectValue
+		}
+		tenant = callerTenant
+	}
+
+	result := &api_models.Usage{Tenant: tenant, BucketName: bucketName}
+	var bucketIDs []int16
+	if bucketName != "" {
+		bucket, ok := e.bucketByName(bucketName)
+		if !ok || tenant != "" && !ownedBy(bucket, tenant) {
+			err := fmt.Errorf("не удалось найти бакет в кеше")
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("tenant", tenant))
+			return nil, status.NotFound
+		}
+		bucketIDs = []int16{bucket.ID}
+		result.Tenant = deref(bucket.Tenant)
+		result.UploadsPerMinute = e.uploads.count(bucketUploadsKey(bucket.ID))
+		result.Quota = bucketQuota(bucket)
+	} else {
+		bucketIDs = e.tenantBucketIDs(tenant)
+		result.UploadsPerMinute = e.uploads.count(tenantUploadsKey(tenant))
+		result.Quota = e.tenantQuota(tenant)
+	}
+
+	usage, err := e.dbService.GetUsage(ctx, bucketIDs)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить использование бакетов из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("tenant", tenant))
+		return nil, status.InternalError
+	}
+	result.Images = usage.Images
+	result.Bytes = usage.Bytes
+
+	return result, status.OK
+}
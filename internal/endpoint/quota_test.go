@@ -0,0 +1,122 @@
+package endpoint
+
+import (
+	"context"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"s3n/internal/config"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"testing"
+)
+
+func TestUploadCounter(t *testing.T) {
+	c := newUploadCounter()
+	limits := map[string]int{bucketUploadsKey(1): 3, tenantUploadsKey("acme"): 0}
+
+	if !c.take(2, limits) {
+		t.Fatal("загрузки в пределах квоты отклонены")
+	}
+	if c.take(2, limits) {
+		t.Error("превышение квоты бакета не отклонено")
+	}
+	// отклонённые загрузки не учитываются ни в одном счётчике
+	if got := c.count(bucketUploadsKey(1)); got != 2 {
+		t.Errorf("загрузок в бакет %d, ожидалось 2", got)
+	}
+	if got := c.count(tenantUploadsKey("acme")); got != 2 {
+		t.Errorf("загрузок владельца %d, ожидалось 2", got)
+	}
+	if !c.take(1, map[string]int{bucketUploadsKey(2): 0}) {
+		t.Error("загрузка без ограничения отклонена")
+	}
+	if got := c.count(allUploadsKey); got != 3 {
+		t.Errorf("всех загрузок %d, ожидалось 3", got)
+	}
+}
+
+func TestBatchCreateImagesTenantQuota(t *testing.T) {
+	acme := "acme"
+	first := models.Bucket{ID: 1, BucketName: "first", StorageName: "first", Tenant: &acme}
+	second := models.Bucket{ID: 2, BucketName: "second", StorageName: "second", Tenant: &acme}
+	shared := models.Bucket{ID: 3, BucketName: "shared", StorageName: "shared", QuotaUploads: 1}
+
+	tests := []struct {
+		name      string
+		maxImages int
+		buckets   []string
+		want      []status.Status
+	}{
+		{
+			name:      "квота владельца по сумме бакетов",
+			maxImages: 2,
+			buckets:   []string{"first", "second", "second", "shared"},
+			want:      []status.Status{status.FailedPrecondition, status.FailedPrecondition, status.FailedPrecondition, status.OK},
+		},
+		{
+			name:      "в пределах квоты владельца",
+			maxImages: 3,
+			buckets:   []string{"first", "second", "second"},
+			want:      []status.Status{status.OK, status.OK, status.OK},
+		},
+		{
+			name:      "квота загрузок бакета",
+			maxImages: 3,
+			buckets:   []string{"first", "shared", "shared"},
+			want:      []status.Status{status.OK, status.FailedPrecondition, status.FailedPrecondition},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			e := newTestEndpoint(dbService, &fakeS3{objects: map[string]bool{}}, first, second, shared)
+			e.tenantQuotas = map[string]config.QuotaConfig{acme: {MaxImages: tt.maxImages}}
+
+			items := make([]api_models.CreateImageItem, len(tt.buckets))
+			for i, bucketName := range tt.buckets {
+				items[i] = api_models.CreateImageItem{BucketName: bucketName, File: []byte("image"), FileExtension: "webp"}
+			}
+			results, s := e.BatchCreateImages(context.Background(), items)
+			if s != status.OK {
+				t.Fatalf("статус %v", s)
+			}
+			for i, result := range results {
+				if result.Status != tt.want[i] {
+					t.Errorf("изображение %d в %s: статус %v, ожидался %v", i, tt.buckets[i], result.Status, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreImageQuota(t *testing.T) {
+	tests := []struct {
+		name       string
+		quotaBytes int64
+		want       status.Status
+	}{
+		{name: "в пределах квоты", quotaBytes: 200, want: status.OK},
+		// объём изображения в корзине не входит в использование и учитывается при восстановлении
+		{name: "превышена квота", quotaBytes: 150, want: status.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := models.Bucket{ID: 1, BucketName: "photos", StorageName: "photos", QuotaBytes: tt.quotaBytes}
+			dbService := newFakeDB()
+			e := newTestEndpoint(dbService, &fakeS3{}, bucket)
+
+			image := models.Image{ID: uuid.New(), BucketID: bucket.ID}
+			dbService.trashed[image.ID] = image
+			dbService.versions = []models.ImageVersion{{ImageID: image.ID, Size: 100}}
+			dbService.usage = models.Usage{Images: 1, Bytes: 100}
+
+			_, s := e.RestoreImage(context.Background(), image.ID)
+			if s != tt.want {
+				t.Fatalf("статус %v, ожидался %v", s, tt.want)
+			}
+			if _, restored := dbService.images[image.ID]; restored != (tt.want == status.OK) {
+				t.Errorf("изображение восстановлено: %v", restored)
+			}
+		})
+	}
+}
@@ -0,0 +1,902 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/budka-tech/logit-go"
+	"github.com/budka-tech/snip-common-go/status"
+	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"s3n/internal/config"
+	"s3n/internal/endpoint/api_models"
+	"s3n/internal/image_processing"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultListLimit количество элементов в ответе списка, если limit не задан
+const defaultListLimit = 100
+
+// RestApi HTTP API с JSON ответами для клиентов без gRPC, повторяет методы Endpoint
+type RestApi struct {
+	endpoint    *Endpoint
+	logger      logit.Logger
+	maxBodySize int64
+}
+
+func NewRestApi(endpoint *Endpoint, config *config.HttpRedirectConfig, logger logit.Logger) *RestApi {
+	return &RestApi{
+		endpoint:    endpoint,
+		logger:      logger,
+		maxBodySize: config.ApiMaxBodySize,
+	}
+}
+
+// Routes возвращает маршруты API относительно префикса, под которым они подключаются
+func (a *RestApi) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(a.traceMiddleware, a.authMiddleware)
+
+	r.Route("/buckets", func(r chi.Router) {
+		r.Get("/", a.getAllBuckets)
+		r.Post("/", a.registerBucket)
+		r.Route("/{bucket}", func(r chi.Router) {
+			r.Get("/", a.getBucket)
+			r.Patch("/", a.updateBucket)
+			r.Delete("/", a.unregisterBucket)
+			r.Post("/rename", a.renameBucket)
+			r.Get("/images", a.getImagesInBucket)
+			r.Post("/images", a.createImage)
+			r.Post("/images/batch", a.batchCreateImages)
+			r.Get("/watermark", a.getBucketWatermark)
+			r.Put("/watermark", a.setBucketWatermark)
+			r.Delete("/watermark", a.removeBucketWatermark)
+		})
+	})
+
+	r.Route("/images", func(r chi.Router) {
+		r.Get("/", a.getAllImages)
+		r.Post("/probe", a.probeImage)
+		r.Post("/batch-get", a.batchGetImages)
+		r.Post("/batch-delete", a.batchDeleteImages)
+		r.Route("/{id}", func(r chi.Router) {
+			r.Get("/", a.getImage)
+			r.Put("/", a.replaceImage)
+			r.Delete("/", a.deleteImage)
+			r.Get("/content", a.downloadImage)
+			r.Post("/restore", a.restoreImage)
+			r.Post("/move", a.moveImage)
+			r.Post("/copy", a.copyImage)
+			r.Put("/alias", a.setImageAlias)
+			r.Get("/versions", a.listImageVersions)
+			r.Get("/versions/{revision}", a.getImageVersion)
+			r.Post("/versions/{revision}/restore", a.restoreImageVersion)
+		})
+	})
+
+	r.Route("/keys", func(r chi.Router) {
+		r.Post("/", a.issueApiKey)
+		r.Post("/{keyId}/rotate", a.rotateApiKey)
+		r.Delete("/{keyId}", a.revokeApiKey)
+	})
+
+	r.Get("/usage", a.getUsage)
+
+	return r
+}
+
+// traceMiddleware создаёт контекст трассировки запроса, как gRPC сервер для каждого вызова
+func (a *RestApi) traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, a.maxBodySize)
+		next.ServeHTTP(w, r.WithContext(a.logger.NewTraceCtx(r.Context(), nil)))
+	})
+}
+
+// httpStatus код HTTP ответа для статуса Endpoint
+func httpStatus(s status.Status) int {
+	switch s {
+	case status.OK:
+		return http.StatusOK
+	case status.NotFound:
+		return http.StatusNotFound
+	case status.IncorrectValue:
+		return http.StatusBadRequest
+	case status.FailedPrecondition:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// apiError тело ответа с ошибкой, status не передаётся для ошибок проверки ключа
+type apiError struct {
+	Status status.Status `json:"status,omitempty"`
+	Error  string        `json:"error"`
+}
+
+// respond отвечает value с кодом code, если статус OK, иначе ошибкой с кодом из httpStatus.
+// nil value - ответ без тела
+func (a *RestApi) respond(w http.ResponseWriter, r *http.Request, s status.Status, code int, value any) {
+	if s != status.OK {
+		code = httpStatus(s)
+		value = apiError{Status: s, Error: http.StatusText(code)}
+	}
+	if value == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	a.writeJSON(w, r, code, value)
+}
+
+func (a *RestApi) writeJSON(w http.ResponseWriter, r *http.Request, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		const op = "RestApi.writeJSON"
+		ctx := a.logger.NewOpCtx(r.Context(), op)
+		err = fmt.Errorf("не удалось записать ответ: %w", err)
+		a.logger.Error(ctx, err, zap.String("path", r.URL.Path))
+	}
+}
+
+// decodeJSON читает тело запроса в value, при ошибке отвечает 400
+func (a *RestApi) decodeJSON(w http.ResponseWriter, r *http.Request, value any) bool {
+	const op = "RestApi.decodeJSON"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	err := json.NewDecoder(r.Body).Decode(value)
+	if err != nil {
+		err = fmt.Errorf("ошибка разбора JSON: %w", err)
+		a.logger.Error(ctx, err, zap.String("path", r.URL.Path))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return false
+	}
+	return true
+}
+
+// imageID разбирает ID изображения из пути, при ошибке отвечает 400
+func (a *RestApi) imageID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	const op = "RestApi.imageID"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		a.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// formInt читает необязательный целочисленный параметр из формы или строки запроса
+func formInt(r *http.Request, name string) (*int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный параметр %s: %w", name, err)
+	}
+	return &n, nil
+}
+
+// formFloat читает необязательный дробный параметр из формы или строки запроса
+func formFloat(r *http.Request, name string) (*float32, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный параметр %s: %w", name, err)
+	}
+	f32 := float32(f)
+	return &f32, nil
+}
+
+// limit читает параметр limit списков, по умолчанию defaultListLimit
+func limit(r *http.Request) (int, error) {
+	n, err := formInt(r, "limit")
+	if err != nil || n == nil {
+		return defaultListLimit, err
+	}
+	return *n, nil
+}
+
+// upload файл и параметры обработки, общие для CreateImage, ReplaceImage и BatchCreateImages
+type upload struct {
+	files      [][]byte
+	extensions []string
+	quality    *float32
+	maxSize    *int
+	frame      *int
+	target     *api_models.EncodingTarget
+}
+
+// readUpload читает файлы из частей file тела multipart/form-data или всё тело запроса как один файл.
+// Расширение берётся из имени части, параметра extension или типа содержимого тела.
+// Параметры обработки читаются из полей формы или строки запроса
+func readUpload(r *http.Request) (*upload, error) {
+	u := &upload{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка разбора multipart/form-data: %w", err)
+		}
+		for _, header := range r.MultipartForm.File["file"] {
+			file, err := readPart(header)
+			if err != nil {
+				return nil, err
+			}
+			extension := filepath.Ext(header.Filename)
+			if extension == "" {
+				extension = r.FormValue("extension")
+			}
+			u.files = append(u.files, file)
+			u.extensions = append(u.extensions, extension)
+		}
+	} else {
+		file, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать тело запроса: %w", err)
+		}
+		extension := r.FormValue("extension")
+		if extension == "" {
+			extension, _ = strings.CutPrefix(mediaType, "image/")
+		}
+		u.files = append(u.files, file)
+		u.extensions = append(u.extensions, extension)
+	}
+	if len(u.files) == 0 {
+		return nil, fmt.Errorf("файл не передан")
+	}
+
+	var err error
+	if u.quality, err = formFloat(r, "quality"); err != nil {
+		return nil, err
+	}
+	if u.maxSize, err = formInt(r, "maxSize"); err != nil {
+		return nil, err
+	}
+	if u.frame, err = formInt(r, "frame"); err != nil {
+		return nil, err
+	}
+
+	maxBytes, err := formInt(r, "targetMaxBytes")
+	if err != nil {
+		return nil, err
+	}
+	minSSIM, err := formFloat(r, "targetMinSsim")
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes != nil || minSSIM != nil {
+		u.target = &api_models.EncodingTarget{AllowDownscale: r.FormValue("targetAllowDownscale") == "true"}
+		if maxBytes != nil {
+			u.target.MaxBytes = *maxBytes
+		}
+		if minSSIM != nil {
+			u.target.MinSSIM = *minSSIM
+		}
+	}
+
+	return u, nil
+}
+
+func readPart(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть файл %s: %w", header.Filename, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать файл %s: %w", header.Filename, err)
+	}
+	return data, nil
+}
+
+// readSingleUpload читает один файл загрузки, при ошибке отвечает 400
+func (a *RestApi) readSingleUpload(w http.ResponseWriter, r *http.Request) (*upload, bool) {
+	const op = "RestApi.readSingleUpload"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	u, err := readUpload(r)
+	if err == nil && len(u.files) != 1 {
+		err = fmt.Errorf("передано %d файлов вместо одного", len(u.files))
+	}
+	if err != nil {
+		a.logger.Error(ctx, err, zap.String("path", r.URL.Path))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return nil, false
+	}
+	return u, true
+}
+
+func (a *RestApi) getAllBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, s := a.endpoint.GetAllBuckets(r.Context())
+	if p := principalFrom(r.Context()); p != nil {
+		buckets = slices.DeleteFunc(buckets, func(bucket api_models.Bucket) bool {
+			return a.endpoint.authorizeBuckets(p, api_models.RoleRead, bucket.BucketName) != nil
+		})
+	}
+	a.respond(w, r, s, http.StatusOK, map[string]any{"buckets": buckets})
+}
+
+type registerBucketRequest struct {
+	BucketName string                            `json:"bucketName"`
+	Options    *api_models.RegisterBucketOptions `json:"options,omitempty"`
+}
+
+func (a *RestApi) registerBucket(w http.ResponseWriter, r *http.Request) {
+	if !a.allowGlobal(w, r, api_models.RoleAdmin) {
+		return
+	}
+	var request registerBucketRequest
+	if !a.decodeJSON(w, r, &request) {
+		return
+	}
+	bucket, s := a.endpoint.RegisterBucket(r.Context(), request.BucketName, request.Options)
+	a.respond(w, r, s, http.StatusCreated, bucket)
+}
+
+func (a *RestApi) getBucket(w http.ResponseWriter, r *http.Request) {
+	if !a.allowBuckets(w, r, api_models.RoleRead, chi.URLParam(r, "bucket")) {
+		return
+	}
+	bucket, s := a.endpoint.GetBucket(r.Context(), chi.URLParam(r, "bucket"))
+	a.respond(w, r, s, http.StatusOK, bucket)
+}
+
+func (a *RestApi) updateBucket(w http.ResponseWriter, r *http.Request) {
+	const op = "RestApi.updateBucket"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	if !a.allowBuckets(w, r, api_models.RoleAdmin, chi.URLParam(r, "bucket")) {
+		return
+	}
+	// обновляются только поля, которые есть в теле запроса, null сбрасывает поле
+	var body json.RawMessage
+	if !a.decodeJSON(w, r, &body) {
+		return
+	}
+	var settings api_models.BucketSettings
+	var present map[string]json.RawMessage
+	err := json.Unmarshal(body, &settings)
+	if err == nil {
+		err = json.Unmarshal(body, &present)
+	}
+	if err != nil {
+		a.logger.Error(ctx, fmt.Errorf("ошибка разбора JSON: %w", err), zap.String("path", r.URL.Path))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return
+	}
+	fields := make([]string, 0, len(present))
+	for field := range present {
+		fields = append(fields, field)
+	}
+	if updatesQuota(settings.Quota != nil, fields) && !a.allow(w, r, a.endpoint.authorizeOperator) {
+		return
+	}
+	// запасное изображение и заглушка становятся доступны всем, кто читает бакет
+	var ids []uuid.UUID
+	if settings.FallbackImageID != nil {
+		ids = append(ids, *settings.FallbackImageID)
+	}
+	if settings.Hotlink != nil && settings.Hotlink.PlaceholderImageID != nil {
+		ids = append(ids, *settings.Hotlink.PlaceholderImageID)
+	}
+	if !a.allowImages(w, r, api_models.RoleRead, ids...) {
+		return
+	}
+	bucket, s := a.endpoint.UpdateBucket(r.Context(), chi.URLParam(r, "bucket"), settings, fields)
+	a.respond(w, r, s, http.StatusOK, bucket)
+}
+
+func (a *RestApi) unregisterBucket(w http.ResponseWriter, r *http.Request) {
+	if !a.allowBuckets(w, r, api_models.RoleAdmin, chi.URLParam(r, "bucket")) {
+		return
+	}
+	mode := api_models.UnregisterMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = api_models.UnregisterRejectIfNotEmpty
+	}
+	result, s := a.endpoint.UnregisterBucket(r.Context(), chi.URLParam(r, "bucket"), mode)
+	a.respond(w, r, s, http.StatusOK, result)
+}
+
+type renameBucketRequest struct {
+	NewBucketName string `json:"newBucketName"`
+}
+
+func (a *RestApi) renameBucket(w http.ResponseWriter, r *http.Request) {
+	if !a.allowBuckets(w, r, api_models.RoleAdmin, chi.URLParam(r, "bucket")) {
+		return
+	}
+	var request renameBucketRequest
+	if !a.decodeJSON(w, r, &request) {
+		return
+	}
+	bucket, s := a.endpoint.RenameBucket(r.Context(), chi.URLParam(r, "bucket"), request.NewBucketName)
+	a.respond(w, r, s, http.StatusOK, bucket)
+}
+
+func (a *RestApi) getImagesInBucket(w http.ResponseWriter, r *http.Request) {
+	if !a.allowBuckets(w, r, api_models.RoleRead, chi.URLParam(r, "bucket")) {
+		return
+	}
+	n, err := limit(r)
+	if err != nil {
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return
+	}
+	images, s := a.endpoint.GetImagesInBucket(r.Context(), chi.URLParam(r, "bucket"), n)
+	a.respond(w, r, s, http.StatusOK, map[string]any{"images": images})
+}
+
+func (a *RestApi) createImage(w http.ResponseWriter, r *http.Request) {
+	const op = "RestApi.createImage"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	if !a.allowBuckets(w, r, api_models.RoleWrite, chi.URLParam(r, "bucket")) {
+		return
+	}
+
+	u, ok := a.readSingleUpload(w, r)
+	if !ok {
+		return
+	}
+
+	var id *uuid.UUID
+	if value := r.FormValue("id"); value != "" {
+		parsed, err := uuid.Parse(value)
+		if err != nil {
+			a.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+			a.respond(w, r, status.IncorrectValue, 0, nil)
+			return
+		}
+		id = &parsed
+	}
+	var expiresAt *time.Time
+	if value := r.FormValue("expiresAt"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			a.logger.Error(ctx, fmt.Errorf("ошибка парсинга времени: %s", err))
+			a.respond(w, r, status.IncorrectValue, 0, nil)
+			return
+		}
+		expiresAt = &parsed
+	}
+
+	image, s := a.endpoint.CreateImage(ctx, chi.URLParam(r, "bucket"), u.files[0], u.extensions[0], u.quality, u.maxSize, u.frame, u.target, id, expiresAt, r.FormValue("slug"))
+	a.respond(w, r, s, http.StatusCreated, image)
+}
+
+func (a *RestApi) batchCreateImages(w http.ResponseWriter, r *http.Request) {
+	const op = "RestApi.batchCreateImages"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	if !a.allowBuckets(w, r, api_models.RoleWrite, chi.URLParam(r, "bucket")) {
+		return
+	}
+
+	u, err := readUpload(r)
+	if err != nil {
+		a.logger.Error(ctx, err, zap.String("path", r.URL.Path))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return
+	}
+
+	// поля slug сопоставляются файлам по порядку, пустое поле - адрес не задаётся
+	slugs := r.Form["slug"]
+	if len(slugs) > len(u.files) {
+		a.logger.Error(ctx, fmt.Errorf("адресов изображений больше, чем файлов"), zap.Int("slugs", len(slugs)), zap.Int("files", len(u.files)))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return
+	}
+
+	bucketName := chi.URLParam(r, "bucket")
+	items := make([]api_models.CreateImageItem, 0, len(u.files))
+	for i, file := range u.files {
+		item := api_models.CreateImageItem{
+			BucketName:    bucketName,
+			File:          file,
+			FileExtension: u.extensions[i],
+			Quality:       u.quality,
+			MaxSize:       u.maxSize,
+			Frame:         u.frame,
+			Target:        u.target,
+		}
+		if i < len(slugs) {
+			item.Slug = slugs[i]
+		}
+		items = append(items, item)
+	}
+
+	results, s := a.endpoint.BatchCreateImages(ctx, items)
+	a.respond(w, r, s, http.StatusOK, map[string]any{"results": results})
+}
+
+func (a *RestApi) getBucketWatermark(w http.ResponseWriter, r *http.Request) {
+	if !a.allowBuckets(w, r, api_models.RoleRead, chi.URLParam(r, "bucket")) {
+		return
+	}
+	watermark, s := a.endpoint.GetBucketWatermark(r.Context(), chi.URLParam(r, "bucket"))
+	a.respond(w, r, s, http.StatusOK, watermark)
+}
+
+func (a *RestApi) setBucketWatermark(w http.ResponseWriter, r *http.Request) {
+	if !a.allowBuckets(w, r, api_models.RoleAdmin, chi.URLParam(r, "bucket")) {
+		return
+	}
+	var watermark api_models.Watermark
+	if !a.decodeJSON(w, r, &watermark) {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleRead, watermark.ImageID) {
+		return
+	}
+	result, s := a.endpoint.SetBucketWatermark(r.Context(), chi.URLParam(r, "bucket"), watermark)
+	a.respond(w, r, s, http.StatusOK, result)
+}
+
+func (a *RestApi) removeBucketWatermark(w http.ResponseWriter, r *http.Request) {
+	if !a.allowBuckets(w, r, api_models.RoleAdmin, chi.URLParam(r, "bucket")) {
+		return
+	}
+	s := a.endpoint.RemoveBucketWatermark(r.Context(), chi.URLParam(r, "bucket"))
+	a.respond(w, r, s, http.StatusNoContent, nil)
+}
+
+func (a *RestApi) getAllImages(w http.ResponseWriter, r *http.Request) {
+	if !a.allowGlobal(w, r, api_models.RoleRead) {
+		return
+	}
+	n, err := limit(r)
+	if err != nil {
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return
+	}
+	images, s := a.endpoint.GetAllImages(r.Context(), n)
+	a.respond(w, r, s, http.StatusOK, map[string]any{"images": images})
+}
+
+func (a *RestApi) probeImage(w http.ResponseWriter, r *http.Request) {
+	u, ok := a.readSingleUpload(w, r)
+	if !ok {
+		return
+	}
+	info, s := a.endpoint.ProbeImage(r.Context(), u.files[0], u.extensions[0])
+	a.respond(w, r, s, http.StatusOK, info)
+}
+
+type batchRequest struct {
+	IDs []uuid.UUID `json:"ids"`
+}
+
+// batchSize проверяет количество изображений в пакетном запросе до проверки прав, при превышении отвечает 400
+func (a *RestApi) batchSize(w http.ResponseWriter, r *http.Request, count int) bool {
+	const op = "RestApi.batchSize"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	if count > batchLimit {
+		a.logger.Error(ctx, errBatchTooLarge, zap.Int("count", count))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return false
+	}
+	return true
+}
+
+func (a *RestApi) batchGetImages(w http.ResponseWriter, r *http.Request) {
+	var request batchRequest
+	if !a.decodeJSON(w, r, &request) || !a.batchSize(w, r, len(request.IDs)) {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleRead, request.IDs...) {
+		return
+	}
+	results, s := a.endpoint.BatchGetImages(r.Context(), request.IDs)
+	a.respond(w, r, s, http.StatusOK, map[string]any{"results": results})
+}
+
+func (a *RestApi) batchDeleteImages(w http.ResponseWriter, r *http.Request) {
+	var request batchRequest
+	if !a.decodeJSON(w, r, &request) || !a.batchSize(w, r, len(request.IDs)) {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleWrite, request.IDs...) {
+		return
+	}
+	results, s := a.endpoint.BatchDeleteImages(r.Context(), request.IDs)
+	a.respond(w, r, s, http.StatusOK, map[string]any{"results": results})
+}
+
+// imageResponse изображение вместе с названием бакета, ответ GET /images/{id}
+type imageResponse struct {
+	*api_models.Image
+	BucketName string `json:"bucketName"`
+}
+
+func (a *RestApi) getImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleRead, id) {
+		return
+	}
+	withBucket, s := a.endpoint.GetImageWithBucket(r.Context(), id)
+	if s != status.OK {
+		a.respond(w, r, s, 0, nil)
+		return
+	}
+	image, s := a.endpoint.GetImage(r.Context(), id)
+	if s != status.OK {
+		a.respond(w, r, s, 0, nil)
+		return
+	}
+	a.respond(w, r, s, http.StatusOK, imageResponse{Image: image, BucketName: withBucket.BucketName})
+}
+
+func (a *RestApi) replaceImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleWrite, id) {
+		return
+	}
+	u, ok := a.readSingleUpload(w, r)
+	if !ok {
+		return
+	}
+	image, s := a.endpoint.ReplaceImage(r.Context(), id, u.files[0], u.extensions[0], u.quality, u.maxSize, u.frame, u.target)
+	a.respond(w, r, s, http.StatusOK, image)
+}
+
+func (a *RestApi) deleteImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleWrite, id) {
+		return
+	}
+	s := a.endpoint.DeleteImage(r.Context(), id)
+	a.respond(w, r, s, http.StatusNoContent, nil)
+}
+
+// downloadImage отдаёт файл изображения. Параметры revision, offset и length совпадают с DownloadImage,
+// bucket - бакет, запасное изображение которого отдаётся вместо ненайденного
+func (a *RestApi) downloadImage(w http.ResponseWriter, r *http.Request) {
+	const op = "RestApi.downloadImage"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleRead, id) {
+		return
+	}
+	// бакет запроса отдаёт своё запасное изображение вместо ненайденного
+	if bucketName := r.URL.Query().Get("bucket"); bucketName != "" && !a.allowBuckets(w, r, api_models.RoleRead, bucketName) {
+		return
+	}
+	revision, err := formInt(r, "revision")
+	if err != nil {
+		a.logger.Error(ctx, err)
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return
+	}
+	var offset, length int64
+	for name, value := range map[string]*int64{"offset": &offset, "length": &length} {
+		if query := r.URL.Query().Get(name); query != "" {
+			*value, err = strconv.ParseInt(query, 10, 64)
+			if err != nil {
+				a.logger.Error(ctx, fmt.Errorf("некорректный параметр %s: %w", name, err))
+				a.respond(w, r, status.IncorrectValue, 0, nil)
+				return
+			}
+		}
+	}
+
+	download, s := a.endpoint.DownloadImage(ctx, r.URL.Query().Get("bucket"), id, revision, offset, length)
+	if s != status.OK {
+		a.respond(w, r, s, 0, nil)
+		return
+	}
+	defer download.Body.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", download.ContentType)
+	header.Set("Content-Length", strconv.FormatInt(download.Length, 10))
+	header.Set("X-S3n-Revision", strconv.Itoa(download.Revision))
+	if download.Fallback {
+		header.Set(fallbackHeader, "true")
+	}
+	code := http.StatusOK
+	if download.Length < download.Size {
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", download.Offset, download.Offset+download.Length-1, download.Size))
+		code = http.StatusPartialContent
+	}
+	w.WriteHeader(code)
+
+	_, err = io.Copy(w, download.Body)
+	if err != nil {
+		// заголовки уже отправлены, клиент получит оборванный ответ
+		err = fmt.Errorf("не удалось передать файл изображения: %w", err)
+		a.logger.Error(ctx, err, zap.String("image_id", id.String()))
+	}
+}
+
+func (a *RestApi) restoreImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleWrite, id) {
+		return
+	}
+	image, s := a.endpoint.RestoreImage(r.Context(), id)
+	a.respond(w, r, s, http.StatusOK, image)
+}
+
+type transferImageRequest struct {
+	BucketName string `json:"bucketName"`
+}
+
+func (a *RestApi) moveImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleWrite, id) {
+		return
+	}
+	var request transferImageRequest
+	if !a.decodeJSON(w, r, &request) {
+		return
+	}
+	if !a.allowBuckets(w, r, api_models.RoleWrite, request.BucketName) {
+		return
+	}
+	image, s := a.endpoint.MoveImage(r.Context(), id, request.BucketName)
+	a.respond(w, r, s, http.StatusOK, image)
+}
+
+func (a *RestApi) copyImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleRead, id) {
+		return
+	}
+	var request transferImageRequest
+	if !a.decodeJSON(w, r, &request) {
+		return
+	}
+	if !a.allowBuckets(w, r, api_models.RoleWrite, request.BucketName) {
+		return
+	}
+	image, s := a.endpoint.CopyImage(r.Context(), id, request.BucketName)
+	a.respond(w, r, s, http.StatusCreated, image)
+}
+
+type setImageAliasRequest struct {
+	Slug string `json:"slug"`
+}
+
+func (a *RestApi) setImageAlias(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleWrite, id) {
+		return
+	}
+	var request setImageAliasRequest
+	if !a.decodeJSON(w, r, &request) {
+		return
+	}
+	s := a.endpoint.SetImageAlias(r.Context(), id, request.Slug)
+	a.respond(w, r, s, http.StatusNoContent, nil)
+}
+
+func (a *RestApi) listImageVersions(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleRead, id) {
+		return
+	}
+	versions, s := a.endpoint.ListImageVersions(r.Context(), id)
+	a.respond(w, r, s, http.StatusOK, map[string]any{"versions": versions})
+}
+
+// revision разбирает номер ревизии из пути, при ошибке отвечает 400
+func (a *RestApi) revision(w http.ResponseWriter, r *http.Request) (int, bool) {
+	revision, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil {
+		const op = "RestApi.revision"
+		ctx := a.logger.NewOpCtx(r.Context(), op)
+		a.logger.Error(ctx, fmt.Errorf("некорректный номер ревизии: %w", err))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return 0, false
+	}
+	return revision, true
+}
+
+// getImageVersion отдаёт файл ревизии изображения
+func (a *RestApi) getImageVersion(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleRead, id) {
+		return
+	}
+	revision, ok := a.revision(w, r)
+	if !ok {
+		return
+	}
+
+	_, file, s := a.endpoint.GetImageVersion(r.Context(), id, revision)
+	if s != status.OK {
+		a.respond(w, r, s, 0, nil)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", image_processing.ContentType(image_processing.OutputFormat))
+	header.Set("Content-Length", strconv.Itoa(len(file)))
+	header.Set("X-S3n-Revision", strconv.Itoa(revision))
+	_, err := w.Write(file)
+	if err != nil && !errors.Is(err, http.ErrHandlerTimeout) {
+		const op = "RestApi.getImageVersion"
+		ctx := a.logger.NewOpCtx(r.Context(), op)
+		err = fmt.Errorf("не удалось передать файл ревизии: %w", err)
+		a.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.Int("revision", revision))
+	}
+}
+
+func (a *RestApi) restoreImageVersion(w http.ResponseWriter, r *http.Request) {
+	id, ok := a.imageID(w, r)
+	if !ok {
+		return
+	}
+	if !a.allowImages(w, r, api_models.RoleWrite, id) {
+		return
+	}
+	revision, ok := a.revision(w, r)
+	if !ok {
+		return
+	}
+	image, s := a.endpoint.RestoreImageVersion(r.Context(), id, revision)
+	a.respond(w, r, s, http.StatusOK, image)
+}
+
+func (a *RestApi) getUsage(w http.ResponseWriter, r *http.Request) {
+	bucketName := r.URL.Query().Get("bucket")
+	if bucketName != "" && !a.allowBuckets(w, r, api_models.RoleRead, bucketName) ||
+		bucketName == "" && !a.allowGlobal(w, r, api_models.RoleRead) {
+		return
+	}
+	usage, s := a.endpoint.GetUsage(r.Context(), r.URL.Query().Get("tenant"), bucketName)
+	a.respond(w, r, s, http.StatusOK, usage)
+}
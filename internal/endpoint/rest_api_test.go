@@ -0,0 +1,151 @@
+package endpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"s3n/internal/config"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"testing"
+)
+
+func TestHttpStatus(t *testing.T) {
+	tests := map[status.Status]int{
+		status.OK:                 http.StatusOK,
+		status.NotFound:           http.StatusNotFound,
+		status.IncorrectValue:     http.StatusBadRequest,
+		status.FailedPrecondition: http.StatusConflict,
+		status.InternalError:      http.StatusInternalServerError,
+	}
+	for s, want := range tests {
+		if got := httpStatus(s); got != want {
+			t.Errorf("httpStatus(%v) = %d, ожидалось %d", s, got, want)
+		}
+	}
+}
+
+func TestRespond(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   status.Status
+		code     int
+		value    any
+		wantCode int
+		wantBody string
+	}{
+		{name: "значение", status: status.OK, code: http.StatusCreated, value: map[string]int{"a": 1}, wantCode: http.StatusCreated, wantBody: `{"a":1}`},
+		{name: "без тела", status: status.OK, code: http.StatusOK, wantCode: http.StatusNoContent},
+		{name: "ошибка вместо значения", status: status.NotFound, code: http.StatusOK, value: map[string]int{"a": 1}, wantCode: http.StatusNotFound, wantBody: fmt.Sprintf(`{"status":%d,"error":"Not Found"}`, status.NotFound)},
+		{name: "ошибка без тела", status: status.FailedPrecondition, wantCode: http.StatusConflict, wantBody: fmt.Sprintf(`{"status":%d,"error":"Conflict"}`, status.FailedPrecondition)},
+	}
+	a := &RestApi{logger: nopLogger{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			a.respond(w, httptest.NewRequest(http.MethodGet, "/", nil), tt.status, tt.code, tt.value)
+			if w.Code != tt.wantCode || string(bytes.TrimSpace(w.Body.Bytes())) != tt.wantBody {
+				t.Errorf("ответ %d %s, ожидалось %d %s", w.Code, w.Body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRestApiDenied(t *testing.T) {
+	tests := []struct {
+		err      error
+		wantCode int
+	}{
+		{err: errUnauthenticated, wantCode: http.StatusUnauthorized},
+		{err: fmt.Errorf("ключ отозван: %w", errUnauthenticated), wantCode: http.StatusUnauthorized},
+		{err: errPermissionDenied, wantCode: http.StatusForbidden},
+		{err: fmt.Errorf("%w: БД недоступна", errAuthUnavailable), wantCode: http.StatusServiceUnavailable},
+		{err: fmt.Errorf("неизвестная ошибка"), wantCode: http.StatusInternalServerError},
+	}
+	a := &RestApi{logger: nopLogger{}}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			a.denied(w, httptest.NewRequest(http.MethodGet, "/", nil), nil, tt.err)
+			if w.Code != tt.wantCode {
+				t.Errorf("код %d, ожидался %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); (got != "") != (tt.wantCode == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate = %q", got)
+			}
+		})
+	}
+}
+
+func TestBatchSizeBeforeAuthorize(t *testing.T) {
+	tenant := "acme"
+	bucket := models.Bucket{ID: 1, BucketName: "b", Tenant: &tenant}
+	foreign := models.Image{ID: uuid.New(), BucketID: 2}
+
+	tests := []struct {
+		name        string
+		count       int
+		handler     func(a *RestApi) http.HandlerFunc
+		wantCode    int
+		wantLookups int
+	}{
+		{name: "чтение больше лимита", count: batchLimit + 1, handler: func(a *RestApi) http.HandlerFunc { return a.batchGetImages }, wantCode: http.StatusBadRequest},
+		{name: "удаление больше лимита", count: batchLimit + 1, handler: func(a *RestApi) http.HandlerFunc { return a.batchDeleteImages }, wantCode: http.StatusBadRequest},
+		{name: "чужое изображение", count: 1, handler: func(a *RestApi) http.HandlerFunc { return a.batchDeleteImages }, wantCode: http.StatusForbidden, wantLookups: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbService := newFakeDB()
+			dbService.images[foreign.ID] = foreign
+			e := newTestEndpoint(dbService, &fakeS3{}, bucket, models.Bucket{ID: 2, BucketName: "other"})
+			a := &RestApi{endpoint: e, logger: nopLogger{}}
+
+			ids := make([]uuid.UUID, tt.count)
+			for i := range ids {
+				ids[i] = foreign.ID
+			}
+			body, _ := json.Marshal(batchRequest{IDs: ids})
+			r := httptest.NewRequest(http.MethodPost, "/images/batch", bytes.NewReader(body))
+			r = r.WithContext(withPrincipal(r.Context(), &principal{name: "tenant", tenant: tenant, role: api_models.RoleWrite}))
+			w := httptest.NewRecorder()
+			tt.handler(a)(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("код %d, ожидался %d", w.Code, tt.wantCode)
+			}
+			if dbService.bucketIDLookups != tt.wantLookups {
+				t.Errorf("запросов бакетов изображений %d, ожидалось %d", dbService.bucketIDLookups, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestApiEnabled(t *testing.T) {
+	tests := []struct {
+		name          string
+		prefix        string
+		authEnabled   bool
+		allowUnauthed bool
+		wantEnabled   bool
+	}{
+		{name: "без префикса", authEnabled: true},
+		{name: "с проверкой ключей", prefix: "/api", authEnabled: true, wantEnabled: true},
+		{name: "без проверки ключей", prefix: "/api"},
+		{name: "без проверки ключей с разрешением", prefix: "/api", allowUnauthed: true, wantEnabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEndpoint(newFakeDB(), &fakeS3{})
+			e.authEnabled = tt.authEnabled
+			s := &RedirectServer{endpoint: e, logger: nopLogger{}}
+			cfg := &config.HttpRedirectConfig{ApiPrefix: tt.prefix, ApiAllowUnauthenticated: tt.allowUnauthed}
+			if got := s.apiEnabled(cfg); got != tt.wantEnabled {
+				t.Errorf("apiEnabled() = %v, ожидалось %v", got, tt.wantEnabled)
+			}
+		})
+	}
+}
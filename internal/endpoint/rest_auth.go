@@ -0,0 +1,137 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	chi "github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"net/http"
+	"s3n/internal/endpoint/api_models"
+)
+
+// authMiddleware проверяет ключ API из заголовка Authorization: Bearer, роли проверяются в обработчиках
+func (a *RestApi) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.endpoint.authEnabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := a.endpoint.authenticate(r.Context(), bearerKey(r.Header.Get("Authorization")))
+		if err != nil {
+			a.denied(w, r, nil, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
+	})
+}
+
+// denied отвечает 401, 403, 503 или 500 на ошибку проверки ключа
+func (a *RestApi) denied(w http.ResponseWriter, r *http.Request, p *principal, err error) {
+	const op = "RestApi.denied"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+	a.logger.Warn(ctx, fmt.Sprintf("запрос отклонён: %s", err), zap.String("path", r.URL.Path), zapPrincipal(p))
+
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errUnauthenticated):
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case errors.Is(err, errPermissionDenied):
+		code = http.StatusForbidden
+	case errors.Is(err, errAuthUnavailable):
+		code = http.StatusServiceUnavailable
+	}
+	a.writeJSON(w, r, code, apiError{Error: http.StatusText(code)})
+}
+
+// allow выполняет проверку check, если проверка ключей включена, и отвечает ошибкой, если она не пройдена
+func (a *RestApi) allow(w http.ResponseWriter, r *http.Request, check func(p *principal) error) bool {
+	p := principalFrom(r.Context())
+	if p == nil {
+		return true
+	}
+	err := check(p)
+	if err != nil {
+		a.denied(w, r, p, err)
+		return false
+	}
+	return true
+}
+
+func (a *RestApi) allowGlobal(w http.ResponseWriter, r *http.Request, role api_models.ApiKeyRole) bool {
+	return a.allow(w, r, func(p *principal) error {
+		return a.endpoint.authorizeGlobal(p, role)
+	})
+}
+
+func (a *RestApi) allowBuckets(w http.ResponseWriter, r *http.Request, role api_models.ApiKeyRole, bucketNames ...string) bool {
+	return a.allow(w, r, func(p *principal) error {
+		return a.endpoint.authorizeBuckets(p, role, bucketNames...)
+	})
+}
+
+func (a *RestApi) allowImages(w http.ResponseWriter, r *http.Request, role api_models.ApiKeyRole, ids ...uuid.UUID) bool {
+	return a.allow(w, r, func(p *principal) error {
+		return a.endpoint.authorizeImages(r.Context(), p, role, ids...)
+	})
+}
+
+// keyID разбирает ID ключа API из пути, при ошибке отвечает 400
+func (a *RestApi) keyID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	const op = "RestApi.keyID"
+	ctx := a.logger.NewOpCtx(r.Context(), op)
+
+	id, err := uuid.Parse(chi.URLParam(r, "keyId"))
+	if err != nil {
+		a.logger.Error(ctx, fmt.Errorf("ошибка парсинга uuid: %s", err))
+		a.respond(w, r, status.IncorrectValue, 0, nil)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+type issueApiKeyRequest struct {
+	Name   string                   `json:"name"`
+	Tenant string                   `json:"tenant,omitempty"`
+	Role   api_models.ApiKeyRole    `json:"role,omitempty"`
+	Grants []api_models.ApiKeyGrant `json:"grants,omitempty"`
+}
+
+func (a *RestApi) issueApiKey(w http.ResponseWriter, r *http.Request) {
+	if !a.allowGlobal(w, r, api_models.RoleAdmin) {
+		return
+	}
+	var request issueApiKeyRequest
+	if !a.decodeJSON(w, r, &request) {
+		return
+	}
+	issued, s := a.endpoint.IssueApiKey(r.Context(), request.Name, request.Tenant, request.Role, request.Grants)
+	a.respond(w, r, s, http.StatusCreated, issued)
+}
+
+func (a *RestApi) rotateApiKey(w http.ResponseWriter, r *http.Request) {
+	if !a.allowGlobal(w, r, api_models.RoleAdmin) {
+		return
+	}
+	id, ok := a.keyID(w, r)
+	if !ok {
+		return
+	}
+	issued, s := a.endpoint.RotateApiKey(r.Context(), id)
+	a.respond(w, r, s, http.StatusOK, issued)
+}
+
+func (a *RestApi) revokeApiKey(w http.ResponseWriter, r *http.Request) {
+	if !a.allowGlobal(w, r, api_models.RoleAdmin) {
+		return
+	}
+	id, ok := a.keyID(w, r)
+	if !ok {
+		return
+	}
+	s := a.endpoint.RevokeApiKey(r.Context(), id)
+	a.respond(w, r, s, http.StatusNoContent, nil)
+}
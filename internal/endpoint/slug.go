@@ -0,0 +1,112 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"regexp"
+	"s3n/internal/db"
+	"s3n/internal/db/models"
+)
+
+// maxSlugLength максимальная длина адреса изображения, совпадает с размером столбца image.slug
+const maxSlugLength = 128
+
+// slugPattern адрес из строчных латинских букв и цифр, разделённых одиночными дефисами
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// validateSlug проверяет, что адрес можно использовать в пути вместо ID изображения
+func validateSlug(slug string) error {
+	if len(slug) > maxSlugLength {
+		return fmt.Errorf("адрес изображения длиннее %d символов", maxSlugLength)
+	}
+	if !slugPattern.MatchString(slug) {
+		return fmt.Errorf("адрес изображения содержит недопустимые символы")
+	}
+	// адрес, похожий на uuid, путался бы с ID изображений
+	if _, err := uuid.Parse(slug); err == nil {
+		return fmt.Errorf("адрес изображения не должен быть uuid")
+	}
+	return nil
+}
+
+// checkSlug проверяет, что адрес допустим и не занят другим изображением бакета
+func (e *Endpoint) checkSlug(ctx context.Context, bucket *models.Bucket, slug string) status.Status {
+	err := validateSlug(slug)
+	if err != nil {
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("slug", slug))
+		return status.IncorrectValue
+	}
+
+	_, err = e.dbService.GetImageBySlug(ctx, bucket.ID, slug)
+	if err == nil {
+		err := fmt.Errorf("адрес занят другим изображением бакета")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("slug", slug))
+		return status.IncorrectValue
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		err = fmt.Errorf("не удалось проверить адрес изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("slug", slug))
+		return status.InternalError
+	}
+	return status.OK
+}
+
+// SetImageAlias задаёт изображению читаемый адрес в его бакете, пустая строка - адрес удаляется.
+// Прежний адрес продолжает указывать на изображение
+func (e *Endpoint) SetImageAlias(ctx context.Context, id uuid.UUID, slug string) status.Status {
+	const op = "Endpoint.SetImageAlias"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	var newSlug *string
+	if slug != "" {
+		err := validateSlug(slug)
+		if err != nil {
+			e.logger.Error(ctx, err, zap.String("image_id", id.String()), zap.String("slug", slug))
+			return status.IncorrectValue
+		}
+		newSlug = &slug
+	}
+
+	_, bucket, err := e.dbService.GetImageWithBucket(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось получить изображение из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return status.NotFound
+	}
+
+	ok, err := e.dbService.SetImageSlug(ctx, id, newSlug)
+	if err != nil {
+		err = fmt.Errorf("не удалось изменить адрес изображения: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()), zap.String("slug", slug))
+		return status.InternalError
+	}
+	if !ok {
+		err := fmt.Errorf("адрес занят другим изображением бакета")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()), zap.String("slug", slug))
+		return status.IncorrectValue
+	}
+
+	return status.OK
+}
+
+// imageBySlug находит изображение бакета по текущему или прежнему адресу.
+// moved - адрес прежний, и изображение доступно по другому пути. Если адрес не найден, возвращается db.ErrNotFound
+func (e *Endpoint) imageBySlug(ctx context.Context, bucket *models.Bucket, slug string) (*models.Image, bool, error) {
+	image, err := e.dbService.GetImageBySlug(ctx, bucket.ID, slug)
+	if err == nil {
+		return image, false, nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, false, err
+	}
+
+	image, err = e.dbService.GetImageByAlias(ctx, bucket.ID, slug)
+	if err != nil {
+		return nil, false, err
+	}
+	return image, true, nil
+}
@@ -0,0 +1,40 @@
+package endpoint
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSlug(t *testing.T) {
+	tests := []struct {
+		slug    string
+		wantErr bool
+	}{
+		{slug: "summer"},
+		{slug: "summer-2026"},
+		{slug: "a-b-c-1"},
+		{slug: "2026"},
+		{slug: strings.Repeat("a", maxSlugLength)},
+		{slug: strings.Repeat("a", maxSlugLength+1), wantErr: true},
+		{slug: "", wantErr: true},
+		{slug: "Summer", wantErr: true},
+		{slug: "summer--2026", wantErr: true},
+		{slug: "-summer", wantErr: true},
+		{slug: "summer-", wantErr: true},
+		{slug: "summer_2026", wantErr: true},
+		{slug: "summer.png", wantErr: true},
+		{slug: "летo", wantErr: true},
+		{slug: "../summer", wantErr: true},
+		// адрес в виде uuid путался бы с ID изображения
+		{slug: "0b8a2e3c-7f3c-4c41-9d61-0f0d9f2a1b7e", wantErr: true},
+		{slug: "0b8a2e3c7f3c4c419d610f0d9f2a1b7e", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.slug, func(t *testing.T) {
+			err := validateSlug(tt.slug)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ошибка %v, ожидалась ошибка: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
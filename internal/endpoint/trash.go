@@ -0,0 +1,191 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"time"
+)
+
+// trashImage помечает изображение удалённым, объекты остаются в S3 до окончания срока хранения
+func (e *Endpoint) trashImage(ctx context.Context, image *models.Image, bucket *models.Bucket) status.Status {
+	trashed, err := e.dbService.TrashImage(ctx, image.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось переместить изображение в корзину: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", image.ID.String()))
+		return status.InternalError
+	}
+	if !trashed {
+		err = fmt.Errorf("изображение уже удалено")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", image.ID.String()))
+		return status.NotFound
+	}
+	// объекты остаются в S3 до очистки корзины, их копии в кеше больше не нужны
+	e.s3Service.Invalidate(bucket.StorageName, e.imageKey(image))
+
+	return status.OK
+}
+
+// RestoreImage возвращает изображение из корзины
+func (e *Endpoint) RestoreImage(ctx context.Context, id uuid.UUID) (*api_models.ImageWithBucket, status.Status) {
+	const op = "Endpoint.RestoreImage"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	image, bucket, err := e.dbService.GetTrashedImageWithBucket(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось найти изображение в корзине: %w", err)
+		e.logger.Error(ctx, err, zap.String("image_id", id.String()))
+		return nil, status.NotFound
+	}
+
+	// изображения в корзине не учитываются в квотах, поэтому восстановление проверяет квоту как загрузка
+	if cached, ok := e.bucketByID(bucket.ID); ok {
+		versions, err := e.dbService.GetImageVersions(ctx, id)
+		if err != nil {
+			err = fmt.Errorf("не удалось получить ревизии изображения: %w", err)
+			e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+			return nil, status.InternalError
+		}
+		var size int64
+		for _, version := range versions {
+			size += int64(version.Size)
+		}
+		s := e.checkQuota(ctx, cached, 1, size, 0)
+		if s != status.OK {
+			return nil, s
+		}
+	}
+
+	restored, err := e.dbService.RestoreImage(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("не удалось восстановить изображение: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+		return nil, status.InternalError
+	}
+	if !restored {
+		err = fmt.Errorf("изображение удалено из корзины во время восстановления")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucket.BucketName), zap.String("image_id", id.String()))
+		return nil, status.NotFound
+	}
+
+	return imageWithBucketToAPI(image, bucket.BucketName), status.OK
+}
+
+// bucketByID возвращает бакет из кеша по ID
+func (e *Endpoint) bucketByID(id int16) (*models.Bucket, bool) {
+	e.bucketCacheLock.RLock()
+	defer e.bucketCacheLock.RUnlock()
+
+	for _, bucket := range e.bucketCache {
+		if bucket.ID == id {
+			return bucket, true
+		}
+	}
+	return nil, false
+}
+
+// bucketByName возвращает бакет из кеша по названию
+func (e *Endpoint) bucketByName(name string) (*models.Bucket, bool) {
+	e.bucketCacheLock.RLock()
+	defer e.bucketCacheLock.RUnlock()
+
+	bucket, ok := e.bucketCache[name]
+	return bucket, ok
+}
+
+// RunPurge периодически удаляет из корзины изображения с истёкшим сроком хранения, пока не отменён ctx
+func (e *Endpoint) RunPurge(ctx context.Context) {
+	const op = "Endpoint.RunPurge"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	if e.trashRetention <= 0 || e.purgeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := e.purgeTrash(ctx)
+		if err != nil {
+			err = fmt.Errorf("не удалось очистить корзину: %w", err)
+			e.logger.Error(ctx, err, zap.Int("purged", purged))
+		} else if purged > 0 {
+			e.logger.Info(ctx, "корзина очищена", zap.Int("purged", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeTrash окончательно удаляет изображения, пролежавшие в корзине дольше trashRetention
+func (e *Endpoint) purgeTrash(ctx context.Context) (int, error) {
+	purged := 0
+	for {
+		images, err := e.dbService.GetTrashedImages(ctx, time.Now().Add(-e.trashRetention), drainBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		if len(images) == 0 {
+			return purged, nil
+		}
+
+		deleted, err := e.hardDelete(ctx, images, e.dbService.PurgeImages)
+		purged += len(deleted)
+		if err != nil {
+			return purged, err
+		}
+	}
+}
+
+// hardDelete удаляет записи изображений функцией remove, а затем объекты всех их ревизий.
+// remove возвращает ID действительно удалённых записей, они же возвращаются из hardDelete.
+// Записи удаляются раньше объектов, чтобы изображение, изменённое во время удаления, не осталось без файлов
+func (e *Endpoint) hardDelete(ctx context.Context, images []models.Image, remove func(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)) ([]uuid.UUID, error) {
+	keys, err := e.imageKeysByID(ctx, images...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(images))
+	for _, image := range images {
+		ids = append(ids, image.ID)
+	}
+
+	deleted, err := remove(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := map[uuid.UUID]bool{}
+	for _, id := range deleted {
+		removed[id] = true
+	}
+
+	byStorage := map[string][]string{}
+	for _, image := range images {
+		bucket, ok := e.bucketByID(image.BucketID)
+		if !ok || !removed[image.ID] {
+			continue
+		}
+		byStorage[bucket.StorageName] = append(byStorage[bucket.StorageName], keys[image.ID]...)
+	}
+	for storage, storageKeys := range byStorage {
+		err = e.s3Service.DeleteFiles(ctx, storage, storageKeys)
+		if err != nil {
+			// записи уже удалены, объекты остаются в S3
+			err = fmt.Errorf("не удалось удалить объекты изображений: %w", err)
+			e.logger.Error(ctx, err, zap.String("storage_name", storage))
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,231 @@
+package endpoint
+
+import (
+	"context"
+	"fmt"
+	"github.com/budka-tech/snip-common-go/status"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"image"
+	"s3n/internal/db/models"
+	"s3n/internal/endpoint/api_models"
+	"s3n/internal/image_processing"
+)
+
+func watermarkToAPI(watermark *models.Watermark) *api_models.Watermark {
+	if watermark == nil {
+		return nil
+	}
+
+	return &api_models.Watermark{
+		ImageID:  watermark.ImageID,
+		Position: watermark.Position,
+		Margin:   watermark.Margin,
+		Opacity:  watermark.Opacity,
+		Scale:    watermark.Scale,
+		Tiled:    watermark.Tiled,
+		MinSize:  watermark.MinSize,
+	}
+}
+
+func (e *Endpoint) loadWatermarks(ctx context.Context) error {
+	watermarks, err := e.dbService.GetAllWatermarks(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.watermarkLock.Lock()
+	for _, watermark := range watermarks {
+		e.watermarks[watermark.BucketID] = &watermark
+	}
+	e.watermarkLock.Unlock()
+
+	return nil
+}
+
+// loadOverlay скачивает и декодирует изображение водяного знака
+func (e *Endpoint) loadOverlay(ctx context.Context, id uuid.UUID) (image.Image, error) {
+	img, bucket, err := e.dbService.GetImageWithBucket(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось получить изображение знака из БД: %w", err)
+	}
+
+	file, err := e.s3Service.DownloadFileBytes(ctx, bucket.StorageName, e.imageKey(img))
+	if err != nil {
+		return nil, fmt.Errorf("не удалось скачать изображение знака: %w", err)
+	}
+
+	overlay, err := e.imageService.Decode(ctx, file, image_processing.OutputFormat)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось прочитать изображение знака: %w", err)
+	}
+
+	return overlay, nil
+}
+
+// isWatermarkImage проверяет, используется ли изображение как водяной знак какого-либо бакета
+func (e *Endpoint) isWatermarkImage(id uuid.UUID) bool {
+	e.watermarkLock.RLock()
+	defer e.watermarkLock.RUnlock()
+
+	for _, watermark := range e.watermarks {
+		if watermark.ImageID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidateOverlays сбрасывает закешированные знаки, построенные из изображения
+func (e *Endpoint) invalidateOverlays(id uuid.UUID) {
+	e.watermarkLock.Lock()
+	defer e.watermarkLock.Unlock()
+
+	for bucketID, watermark := range e.watermarks {
+		if watermark.ImageID == id {
+			delete(e.watermarkOverlays, bucketID)
+		}
+	}
+}
+
+// bucketWatermark возвращает водяной знак бакета или nil, если он не настроен
+func (e *Endpoint) bucketWatermark(ctx context.Context, bucketId int16) (*image_processing.Watermark, error) {
+	e.watermarkLock.RLock()
+	settings, ok := e.watermarks[bucketId]
+	overlay := e.watermarkOverlays[bucketId]
+	e.watermarkLock.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	if overlay == nil {
+		var err error
+		overlay, err = e.loadOverlay(ctx, settings.ImageID)
+		if err != nil {
+			return nil, err
+		}
+
+		e.watermarkLock.Lock()
+		if e.watermarks[bucketId] == settings {
+			e.watermarkOverlays[bucketId] = overlay
+		}
+		e.watermarkLock.Unlock()
+	}
+
+	return &image_processing.Watermark{
+		Overlay:  overlay,
+		Position: image_processing.WatermarkPosition(settings.Position),
+		Margin:   settings.Margin,
+		Opacity:  settings.Opacity,
+		Scale:    settings.Scale,
+		Tiled:    settings.Tiled,
+		MinSize:  settings.MinSize,
+	}, nil
+}
+
+func (e *Endpoint) GetBucketWatermark(ctx context.Context, bucketName string) (*api_models.Watermark, status.Status) {
+	const op = "Endpoint.GetBucketWatermark"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	e.bucketCacheLock.RLock()
+	bucket, ok := e.bucketCache[bucketName]
+	e.bucketCacheLock.RUnlock()
+	if !ok {
+		err := fmt.Errorf("не удалось найти бакет в кеше")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.NotFound
+	}
+
+	e.watermarkLock.RLock()
+	watermark, ok := e.watermarks[bucket.ID]
+	e.watermarkLock.RUnlock()
+	if !ok {
+		return nil, status.NotFound
+	}
+
+	return watermarkToAPI(watermark), status.OK
+}
+
+func (e *Endpoint) SetBucketWatermark(ctx context.Context, bucketName string, watermark api_models.Watermark) (*api_models.Watermark, status.Status) {
+	const op = "Endpoint.SetBucketWatermark"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	e.bucketCacheLock.RLock()
+	bucket, ok := e.bucketCache[bucketName]
+	e.bucketCacheLock.RUnlock()
+	if !ok {
+		err := fmt.Errorf("не удалось найти бакет в кеше")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.NotFound
+	}
+
+	if watermark.Position == "" {
+		watermark.Position = string(image_processing.WatermarkBottomRight)
+	}
+	if !image_processing.WatermarkPosition(watermark.Position).Valid() ||
+		watermark.Opacity <= 0 || watermark.Opacity > 1 ||
+		watermark.Scale < 0 || watermark.Scale > 1 ||
+		watermark.Margin < 0 || watermark.MinSize < 0 {
+		err := fmt.Errorf("некорректные параметры водяного знака")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.Any("watermark", watermark))
+		return nil, status.IncorrectValue
+	}
+
+	overlay, err := e.loadOverlay(ctx, watermark.ImageID)
+	if err != nil {
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName), zap.String("image_id", watermark.ImageID.String()))
+		return nil, status.NotFound
+	}
+
+	settings := &models.Watermark{
+		BucketID: bucket.ID,
+		ImageID:  watermark.ImageID,
+		Position: watermark.Position,
+		Margin:   watermark.Margin,
+		Opacity:  watermark.Opacity,
+		Scale:    watermark.Scale,
+		Tiled:    watermark.Tiled,
+		MinSize:  watermark.MinSize,
+	}
+	err = e.dbService.SetWatermark(ctx, settings)
+	if err != nil {
+		err = fmt.Errorf("не удалось сохранить водяной знак в БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return nil, status.InternalError
+	}
+
+	e.watermarkLock.Lock()
+	e.watermarks[bucket.ID] = settings
+	e.watermarkOverlays[bucket.ID] = overlay
+	e.watermarkLock.Unlock()
+
+	return watermarkToAPI(settings), status.OK
+}
+
+func (e *Endpoint) RemoveBucketWatermark(ctx context.Context, bucketName string) status.Status {
+	const op = "Endpoint.RemoveBucketWatermark"
+	ctx = e.logger.NewOpCtx(ctx, op)
+
+	e.bucketCacheLock.RLock()
+	bucket, ok := e.bucketCache[bucketName]
+	e.bucketCacheLock.RUnlock()
+	if !ok {
+		err := fmt.Errorf("не удалось найти бакет в кеше")
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return status.NotFound
+	}
+
+	err := e.dbService.DeleteWatermark(ctx, bucket.ID)
+	if err != nil {
+		err = fmt.Errorf("не удалось удалить водяной знак из БД: %w", err)
+		e.logger.Error(ctx, err, zap.String("bucket_name", bucketName))
+		return status.InternalError
+	}
+
+	e.watermarkLock.Lock()
+	delete(e.watermarks, bucket.ID)
+	delete(e.watermarkOverlays, bucket.ID)
+	e.watermarkLock.Unlock()
+
+	return status.OK
+}
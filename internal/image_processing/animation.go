@@ -0,0 +1,292 @@
+package image_processing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/kolesa-team/go-webp/decoder"
+	"github.com/kolesa-team/go-webp/encoder"
+	"github.com/kolesa-team/go-webp/webp"
+	"image"
+	"image/draw"
+	"image/gif"
+)
+
+// animation анимированное изображение, каждый кадр которого занимает весь холст
+type animation struct {
+	Frames    []image.Image
+	Durations []int // длительность кадров в миллисекундах
+	LoopCount int   // количество повторов, 0 - бесконечно
+}
+
+// defaultFrameDuration используется браузерами для кадров GIF с нулевой задержкой
+const defaultFrameDuration = 100
+
+// decodeAnimation возвращает кадры анимированного GIF или WebP, nil - изображение не анимировано
+func decodeAnimation(file []byte, format string) (*animation, error) {
+	switch format {
+	case FormatGIF:
+		return decodeGIFAnimation(file)
+	case FormatWebP:
+		return decodeWebPAnimation(file)
+	}
+	return nil, nil
+}
+
+// countFrames возвращает количество кадров изображения без декодирования пикселей
+func countFrames(file []byte, format string) (int, error) {
+	switch format {
+	case FormatGIF:
+		return countGIFFrames(file)
+	case FormatWebP:
+		chunks, err := readWebPChunks(file)
+		if err != nil {
+			return 0, err
+		}
+		frames := 0
+		for _, chunk := range chunks {
+			if chunk.FourCC == "ANMF" {
+				frames++
+			}
+		}
+		return max(frames, 1), nil
+	}
+	return 1, nil
+}
+
+// countGIFFrames считает дескрипторы кадров GIF, пропуская сжатые данные кадров и расширения
+func countGIFFrames(file []byte) (int, error) {
+	const (
+		flagColorTable = 0x80
+		headerSize     = 13
+	)
+	colorTable := func(flags byte) int {
+		if flags&flagColorTable == 0 {
+			return 0
+		}
+		return 3 << (flags&0x07 + 1)
+	}
+	// skipBlocks пропускает последовательность подблоков до блока нулевой длины
+	skipBlocks := func(pos int) (int, error) {
+		for pos < len(file) {
+			size := int(file[pos])
+			pos += 1 + size
+			if size == 0 {
+				return pos, nil
+			}
+		}
+		return 0, fmt.Errorf("обрезанный блок gif")
+	}
+
+	if len(file) < headerSize {
+		return 0, fmt.Errorf("обрезанный заголовок gif")
+	}
+	pos := headerSize + colorTable(file[10])
+	frames := 0
+	for pos < len(file) {
+		var err error
+		switch file[pos] {
+		case 0x2C:
+			if pos+10 > len(file) {
+				return 0, fmt.Errorf("обрезанный кадр gif")
+			}
+			frames++
+			// дескриптор, локальная палитра и минимальный размер кода LZW
+			pos, err = skipBlocks(pos + 10 + colorTable(file[pos+9]) + 1)
+		case 0x21:
+			pos, err = skipBlocks(pos + 2)
+		case 0x3B:
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("некорректный блок gif")
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return frames, nil
+}
+
+func decodeGIFAnimation(file []byte) (*animation, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) < 2 {
+		return nil, nil
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	anim := &animation{}
+	switch {
+	case g.LoopCount == -1:
+		anim.LoopCount = 1
+	case g.LoopCount > 0:
+		anim.LoopCount = g.LoopCount + 1
+	}
+
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		if i < len(g.Disposal) && g.Disposal[i] == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.Frames = append(anim.Frames, cloneRGBA(canvas))
+
+		duration := defaultFrameDuration
+		if i < len(g.Delay) && g.Delay[i] > 1 {
+			duration = g.Delay[i] * 10
+		}
+		anim.Durations = append(anim.Durations, duration)
+
+		if i < len(g.Disposal) {
+			switch g.Disposal[i] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+
+	return anim, nil
+}
+
+func decodeWebPAnimation(file []byte) (*animation, error) {
+	chunks, err := readWebPChunks(file)
+	if err != nil {
+		return nil, err
+	}
+
+	header := findChunk(chunks, "VP8X")
+	if header == nil || len(header.Data) < 10 || header.Data[0]&vp8xFlagAnimation == 0 {
+		return nil, nil
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, uint24(header.Data[4:7])+1, uint24(header.Data[7:10])+1))
+	anim := &animation{}
+	if params := findChunk(chunks, "ANIM"); params != nil && len(params.Data) >= 6 {
+		anim.LoopCount = int(binary.LittleEndian.Uint16(params.Data[4:6]))
+	}
+
+	for _, chunk := range chunks {
+		if chunk.FourCC != "ANMF" {
+			continue
+		}
+		if len(chunk.Data) < 16 {
+			return nil, fmt.Errorf("обрезанный кадр анимации")
+		}
+
+		offset := image.Pt(uint24(chunk.Data[0:3])*2, uint24(chunk.Data[3:6])*2)
+		width := uint24(chunk.Data[6:9]) + 1
+		height := uint24(chunk.Data[9:12]) + 1
+		duration := uint24(chunk.Data[12:15])
+		noBlend := chunk.Data[15]&0x02 != 0
+		dispose := chunk.Data[15]&0x01 != 0
+
+		frame, err := decodeWebPFrame(chunk.Data[16:], width, height)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось прочитать кадр анимации: %w", err)
+		}
+
+		rect := image.Rectangle{Min: offset, Max: offset.Add(image.Pt(width, height))}
+		op := draw.Over
+		if noBlend {
+			op = draw.Src
+		}
+		draw.Draw(canvas, rect, frame, frame.Bounds().Min, op)
+		anim.Frames = append(anim.Frames, cloneRGBA(canvas))
+		anim.Durations = append(anim.Durations, duration)
+
+		if dispose {
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+
+	if len(anim.Frames) == 0 {
+		return nil, fmt.Errorf("анимация не содержит кадров")
+	}
+
+	return anim, nil
+}
+
+// decodeWebPFrame декодирует данные кадра ANMF, упаковывая их в отдельный WebP файл
+func decodeWebPFrame(data []byte, width int, height int) (image.Image, error) {
+	chunks, err := readChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var frame []riffChunk
+	if alpha := findChunk(chunks, "ALPH"); alpha != nil {
+		frame = append(frame, vp8xChunk(vp8xFlagAlpha, width, height), *alpha)
+	}
+	for _, chunk := range chunks {
+		if chunk.FourCC == "VP8 " || chunk.FourCC == "VP8L" {
+			frame = append(frame, chunk)
+			break
+		}
+	}
+
+	return webp.Decode(bytes.NewReader(writeWebP(frame)), &decoder.Options{})
+}
+
+// encodeAnimation собирает анимированный WebP из кадров одинакового размера
+func encodeAnimation(anim *animation, options *encoder.Options) ([]byte, error) {
+	bounds := anim.Frames[0].Bounds()
+
+	params := make([]byte, 6)
+	binary.LittleEndian.PutUint16(params[4:6], uint16(anim.LoopCount))
+
+	chunks := []riffChunk{
+		vp8xChunk(vp8xFlagAnimation|vp8xFlagAlpha, bounds.Dx(), bounds.Dy()),
+		{FourCC: "ANIM", Data: params},
+	}
+
+	for i, frame := range anim.Frames {
+		encoded := &bytes.Buffer{}
+		if err := webp.Encode(encoded, frame, options); err != nil {
+			return nil, err
+		}
+
+		frameChunks, err := readWebPChunks(encoded.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		data := &bytes.Buffer{}
+		header := make([]byte, 16)
+		putUint24(header[6:9], uint32(frame.Bounds().Dx()-1))
+		putUint24(header[9:12], uint32(frame.Bounds().Dy()-1))
+		putUint24(header[12:15], uint32(anim.Durations[i]))
+		// кадры занимают весь холст, поэтому смешивание с предыдущим не нужно
+		header[15] = 0x02
+		data.Write(header)
+		for _, chunk := range frameChunks {
+			switch chunk.FourCC {
+			case "ALPH", "VP8 ", "VP8L":
+				writeChunk(data, chunk)
+			}
+		}
+
+		chunks = append(chunks, riffChunk{FourCC: "ANMF", Data: data.Bytes()})
+	}
+
+	return writeWebP(chunks), nil
+}
+
+// frame возвращает кадр по индексу, отрицательный индекс отсчитывается с конца
+func (a *animation) frame(index int) image.Image {
+	if index < 0 {
+		index += len(a.Frames)
+	}
+	index = min(max(index, 0), len(a.Frames)-1)
+	return a.Frames[index]
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
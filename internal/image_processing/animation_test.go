@@ -0,0 +1,228 @@
+package image_processing
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+var testPalette = color.Palette{color.Transparent, color.RGBA{R: 255, A: 255}, color.RGBA{G: 255, A: 255}}
+
+// testGIF кодирует анимацию из frames кадров size x size, каждый следующий кадр закрашивает на пиксель больше
+func testGIF(t *testing.T, frames int, size int, loopCount int, disposal byte) []byte {
+	t.Helper()
+
+	g := &gif.GIF{LoopCount: loopCount, Config: image.Config{Width: size, Height: size, ColorModel: testPalette}}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, size, size), testPalette)
+		frame.SetColorIndex(i%size, 0, uint8(1+i%2))
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, i)
+		g.Disposal = append(g.Disposal, disposal)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := gif.EncodeAll(buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCountGIFFrames(t *testing.T) {
+	single := testGIF(t, 1, 4, 0, gif.DisposalNone)
+	tests := []struct {
+		name    string
+		file    []byte
+		want    int
+		wantErr bool
+	}{
+		{name: "один кадр", file: single, want: 1},
+		{name: "анимация", file: testGIF(t, 7, 4, 0, gif.DisposalNone), want: 7},
+		{name: "с расширениями повтора", file: testGIF(t, 3, 16, 2, gif.DisposalBackground), want: 3},
+		{name: "без завершающего блока", file: single[:len(single)-1], want: 1},
+		{name: "обрезанный кадр", file: single[:len(single)-5], wantErr: true},
+		{name: "короткий заголовок", file: single[:10], wantErr: true},
+		{name: "неизвестный блок", file: append(append([]byte{}, single[:len(single)-1]...), 0x42), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := countGIFFrames(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка %v, ожидалась ошибка: %v", err, tt.wantErr)
+			}
+			if got != tt.want && !tt.wantErr {
+				t.Errorf("кадров %d, ожидалось %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeGIFAnimation(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          []byte
+		wantFrames    int
+		wantLoopCount int
+		wantDurations []int
+		// wantLast пиксели (0..2, 0) последнего кадра: 0 - прозрачный, иначе непрозрачный
+		wantLast []uint8
+	}{
+		{name: "один кадр не анимация", file: testGIF(t, 1, 4, 0, gif.DisposalNone)},
+		{
+			name:          "кадры накапливаются",
+			file:          testGIF(t, 3, 4, 0, gif.DisposalNone),
+			wantFrames:    3,
+			wantDurations: []int{defaultFrameDuration, defaultFrameDuration, 20},
+			wantLast:      []uint8{255, 255, 255},
+		},
+		{
+			name:          "очистка фона и повторы",
+			file:          testGIF(t, 3, 4, 2, gif.DisposalBackground),
+			wantFrames:    3,
+			wantLoopCount: 3,
+			wantDurations: []int{defaultFrameDuration, defaultFrameDuration, 20},
+			wantLast:      []uint8{0, 0, 255},
+		},
+		{
+			name:          "без повторов",
+			file:          testGIF(t, 2, 4, -1, gif.DisposalNone),
+			wantFrames:    2,
+			wantLoopCount: 1,
+			wantDurations: []int{defaultFrameDuration, defaultFrameDuration},
+			wantLast:      []uint8{255, 255, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anim, err := decodeGIFAnimation(tt.file)
+			if err != nil {
+				t.Fatalf("ошибка: %s", err)
+			}
+			if tt.wantFrames == 0 {
+				if anim != nil {
+					t.Fatalf("ожидалось неанимированное изображение, получено %d кадров", len(anim.Frames))
+				}
+				return
+			}
+			if len(anim.Frames) != tt.wantFrames || anim.LoopCount != tt.wantLoopCount {
+				t.Fatalf("кадров %d, повторов %d, ожидалось %d и %d", len(anim.Frames), anim.LoopCount, tt.wantFrames, tt.wantLoopCount)
+			}
+			for i, duration := range tt.wantDurations {
+				if anim.Durations[i] != duration {
+					t.Errorf("длительность кадра %d = %d, ожидалось %d", i, anim.Durations[i], duration)
+				}
+			}
+			last := anim.frame(-1).(*image.RGBA)
+			for x, alpha := range tt.wantLast {
+				if got := last.RGBAAt(x, 0).A; got != alpha {
+					t.Errorf("альфа пикселя %d = %d, ожидалось %d", x, got, alpha)
+				}
+			}
+		})
+	}
+}
+
+func testAnimatedWebP(frames int, width int, height int) []byte {
+	chunks := []riffChunk{vp8xChunk(vp8xFlagAnimation, width, height), {FourCC: "ANIM", Data: make([]byte, 6)}}
+	for i := 0; i < frames; i++ {
+		chunks = append(chunks, riffChunk{FourCC: "ANMF", Data: make([]byte, 16)})
+	}
+	return writeWebP(chunks)
+}
+
+func TestCheckPixels(t *testing.T) {
+	service := &ImageService{MaxPixels: 1000}
+	tests := []struct {
+		name    string
+		file    []byte
+		format  string
+		wantErr bool
+	}{
+		{name: "маленькая анимация gif", file: testGIF(t, 10, 10, 0, gif.DisposalNone), format: FormatGIF},
+		{name: "много кадров gif", file: testGIF(t, 11, 10, 0, gif.DisposalNone), format: FormatGIF, wantErr: true},
+		{name: "маленькая анимация webp", file: testAnimatedWebP(10, 10, 10), format: FormatWebP},
+		{name: "много кадров webp", file: testAnimatedWebP(100, 10, 10), format: FormatWebP, wantErr: true},
+		// заголовок VP8X допускает холст 2^24 x 2^24
+		{name: "огромный холст webp", file: testAnimatedWebP(1, 1<<24, 1<<24), format: FormatWebP, wantErr: true},
+		{name: "некорректный файл", file: []byte("GIF89a"), format: FormatGIF, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.checkPixels(tt.file, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка %v, ожидалась ошибка: %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	unlimited := &ImageService{}
+	if err := unlimited.checkPixels(testAnimatedWebP(100, 10, 10), FormatWebP); err != nil {
+		t.Errorf("без ограничения: %s", err)
+	}
+}
+
+func TestReadChunks(t *testing.T) {
+	odd := riffChunk{FourCC: "ICCP", Data: []byte{1, 2, 3}}
+	even := riffChunk{FourCC: "VP8L", Data: []byte{4, 5}}
+	file := writeWebP([]riffChunk{odd, even})
+	// размер RIFF включает два лишних байта, которых не хватает на заголовок чанка
+	trailing := append(append([]byte{}, file...), 'V', 'P')
+	trailing[4] += 2
+
+	tests := []struct {
+		name    string
+		file    []byte
+		want    []riffChunk
+		wantErr bool
+	}{
+		{name: "выравнивание нечётного чанка", file: file, want: []riffChunk{odd, even}},
+		{name: "не WebP", file: append([]byte("RIFX"), file[4:]...), wantErr: true},
+		{name: "обрезанный чанк", file: file[:len(file)-1], wantErr: true},
+		{name: "обрезанный заголовок", file: trailing, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := readWebPChunks(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка %v, ожидалась ошибка: %v", err, tt.wantErr)
+			}
+			if len(chunks) != len(tt.want) {
+				t.Fatalf("чанков %d, ожидалось %d", len(chunks), len(tt.want))
+			}
+			for i := range chunks {
+				if chunks[i].FourCC != tt.want[i].FourCC || !bytes.Equal(chunks[i].Data, tt.want[i].Data) {
+					t.Errorf("чанк %d = %v, ожидалось %v", i, chunks[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedICC(t *testing.T) {
+	simple := writeWebP([]riffChunk{{FourCC: "VP8 ", Data: make([]byte, 10)}})
+	extended := writeWebP([]riffChunk{vp8xChunk(vp8xFlagAlpha, 3, 2), {FourCC: "ICCP", Data: []byte("old")}, {FourCC: "VP8 ", Data: make([]byte, 10)}})
+
+	for name, file := range map[string][]byte{"простой": simple, "расширенный": extended} {
+		t.Run(name, func(t *testing.T) {
+			out, err := embedICC(file, srgbProfile, 3, 2)
+			if err != nil {
+				t.Fatalf("ошибка: %s", err)
+			}
+			chunks, err := readWebPChunks(out)
+			if err != nil {
+				t.Fatalf("ошибка: %s", err)
+			}
+			if len(chunks) != 3 || chunks[0].FourCC != "VP8X" || chunks[1].FourCC != "ICCP" || chunks[2].FourCC != "VP8 " {
+				t.Fatalf("неожиданные чанки %v", chunks)
+			}
+			if chunks[0].Data[0]&vp8xFlagICC == 0 || uint24(chunks[0].Data[4:7])+1 != 3 || uint24(chunks[0].Data[7:10])+1 != 2 {
+				t.Errorf("некорректный заголовок VP8X %v", chunks[0].Data)
+			}
+			if !bytes.Equal(chunks[1].Data, srgbProfile) {
+				t.Error("профиль не заменён")
+			}
+		})
+	}
+}
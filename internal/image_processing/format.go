@@ -0,0 +1,65 @@
+package image_processing
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatGIF  = "gif"
+	FormatBMP  = "bmp"
+	FormatTIFF = "tiff"
+)
+
+// DetectFormat определяет формат изображения по сигнатуре файла, пустая строка - формат не распознан
+func DetectFormat(file []byte) string {
+	switch {
+	case bytes.HasPrefix(file, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(file, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case len(file) >= 12 && string(file[0:4]) == "RIFF" && string(file[8:12]) == "WEBP":
+		return FormatWebP
+	case bytes.HasPrefix(file, []byte("GIF87a")), bytes.HasPrefix(file, []byte("GIF89a")):
+		return FormatGIF
+	case bytes.HasPrefix(file, []byte("BM")):
+		return FormatBMP
+	case bytes.HasPrefix(file, []byte("II*\x00")), bytes.HasPrefix(file, []byte("MM\x00*")):
+		return FormatTIFF
+	}
+	return ""
+}
+
+// ContentType возвращает MIME тип формата
+func ContentType(format string) string {
+	switch format {
+	case FormatPNG, FormatJPEG, FormatWebP, FormatGIF, FormatBMP, FormatTIFF:
+		return "image/" + format
+	}
+	return "application/octet-stream"
+}
+
+// metricFormat название формата для ключа метрики, неизвестные расширения от клиента объединяются в "other"
+func metricFormat(format string) string {
+	if ContentType(format) == "application/octet-stream" {
+		return "other"
+	}
+	return format
+}
+
+// NormalizeFormat приводит расширение файла от клиента (".JPG", "jpeg ", "tif") к названию формата
+func NormalizeFormat(fileExtension string) string {
+	format := strings.ToLower(strings.TrimSpace(fileExtension))
+	format = strings.TrimPrefix(format, ".")
+
+	switch format {
+	case "jpg", "jpe", "jfif":
+		return FormatJPEG
+	case "tif":
+		return FormatTIFF
+	}
+	return format
+}
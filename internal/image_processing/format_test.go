@@ -0,0 +1,76 @@
+package image_processing
+
+import "testing"
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want string
+	}{
+		{name: "png", file: []byte("\x89PNG\r\n\x1a\n...."), want: FormatPNG},
+		{name: "jpeg", file: []byte{0xFF, 0xD8, 0xFF, 0xE0}, want: FormatJPEG},
+		{name: "webp", file: []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), want: FormatWebP},
+		{name: "riff не webp", file: []byte("RIFF\x00\x00\x00\x00WAVEfmt "), want: ""},
+		{name: "gif87a", file: []byte("GIF87a.."), want: FormatGIF},
+		{name: "gif89a", file: []byte("GIF89a.."), want: FormatGIF},
+		{name: "bmp", file: []byte("BM......"), want: FormatBMP},
+		{name: "tiff little endian", file: []byte("II*\x00...."), want: FormatTIFF},
+		{name: "tiff big endian", file: []byte("MM\x00*...."), want: FormatTIFF},
+		{name: "обрезанный jpeg", file: []byte{0xFF, 0xD8}, want: ""},
+		{name: "пустой файл", file: nil, want: ""},
+		{name: "текст", file: []byte("<svg></svg>"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat(tt.file); got != tt.want {
+				t.Errorf("DetectFormat() = %q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeFormat(t *testing.T) {
+	tests := []struct {
+		extension  string
+		want       string
+		wantMetric string
+	}{
+		{extension: ".JPG", want: FormatJPEG, wantMetric: FormatJPEG},
+		{extension: "jpeg ", want: FormatJPEG, wantMetric: FormatJPEG},
+		{extension: "jfif", want: FormatJPEG, wantMetric: FormatJPEG},
+		{extension: "tif", want: FormatTIFF, wantMetric: FormatTIFF},
+		{extension: ".webp", want: FormatWebP, wantMetric: FormatWebP},
+		{extension: "", want: "", wantMetric: "other"},
+		// неизвестные расширения сохраняются, но не становятся отдельными ключами метрик
+		{extension: ".heic", want: "heic", wantMetric: "other"},
+		{extension: "x-random-9f2c", want: "x-random-9f2c", wantMetric: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.extension, func(t *testing.T) {
+			got := NormalizeFormat(tt.extension)
+			if got != tt.want {
+				t.Errorf("NormalizeFormat() = %q, ожидалось %q", got, tt.want)
+			}
+			if metric := metricFormat(got); metric != tt.wantMetric {
+				t.Errorf("metricFormat() = %q, ожидалось %q", metric, tt.wantMetric)
+			}
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := map[string]string{
+		FormatPNG:  "image/png",
+		FormatJPEG: "image/jpeg",
+		FormatWebP: "image/webp",
+		FormatTIFF: "image/tiff",
+		"heic":     "application/octet-stream",
+		"":         "application/octet-stream",
+	}
+	for format, want := range tests {
+		if got := ContentType(format); got != want {
+			t.Errorf("ContentType(%q) = %q, ожидалось %q", format, got, want)
+		}
+	}
+}
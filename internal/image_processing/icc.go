@@ -0,0 +1,495 @@
+package image_processing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+)
+
+// toneCurve передаточная функция канала из профиля, переводит значение в линейное
+type toneCurve func(x float64) float64
+
+// iccProfile RGB профиль вида matrix/TRC, к которому относятся Display P3, Adobe RGB и sRGB
+type iccProfile struct {
+	colorants [3][3]float64 // столбцы - XYZ (D50) основных цветов r, g, b
+	curves    [3]toneCurve
+}
+
+// maxICCSize максимальный размер распакованного профиля, реальные профили не больше нескольких сотен килобайт
+const maxICCSize = 4 << 20
+
+// srgbColorants основные цвета sRGB, адаптированные к D50, как их хранят ICC профили
+var srgbColorants = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// extractICC возвращает встроенный ICC профиль изображения, nil - профиль отсутствует
+func extractICC(file []byte, format string) ([]byte, error) {
+	switch format {
+	case FormatJPEG:
+		return extractJPEGICC(file)
+	case FormatPNG:
+		return extractPNGICC(file)
+	case FormatWebP:
+		chunks, err := readWebPChunks(file)
+		if err != nil {
+			return nil, err
+		}
+		if chunk := findChunk(chunks, "ICCP"); chunk != nil {
+			return chunk.Data, nil
+		}
+	}
+	return nil, nil
+}
+
+// extractJPEGICC собирает профиль из сегментов APP2, профиль может быть разбит на несколько сегментов
+func extractJPEGICC(file []byte) ([]byte, error) {
+	const signature = "ICC_PROFILE\x00"
+
+	parts := map[byte][]byte{}
+	pos := 2
+	for pos+4 <= len(file) {
+		if file[pos] != 0xFF {
+			return nil, fmt.Errorf("некорректный маркер jpeg")
+		}
+		marker := file[pos+1]
+		switch {
+		case marker == 0xFF:
+			pos++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD8):
+			pos += 2
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			pos = len(file)
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(file[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(file) {
+			return nil, fmt.Errorf("обрезанный сегмент jpeg")
+		}
+		data := file[pos+4 : pos+2+length]
+		if marker == 0xE2 && len(data) > len(signature)+2 && string(data[:len(signature)]) == signature {
+			parts[data[len(signature)]] = data[len(signature)+2:]
+		}
+		pos += 2 + length
+	}
+
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	seqs := make([]int, 0, len(parts))
+	for seq := range parts {
+		seqs = append(seqs, int(seq))
+	}
+	sort.Ints(seqs)
+
+	profile := &bytes.Buffer{}
+	for _, seq := range seqs {
+		profile.Write(parts[byte(seq)])
+	}
+	return profile.Bytes(), nil
+}
+
+// extractPNGICC распаковывает профиль из чанка iCCP
+func extractPNGICC(file []byte) ([]byte, error) {
+	pos := 8
+	for pos+8 <= len(file) {
+		length := int(binary.BigEndian.Uint32(file[pos : pos+4]))
+		kind := string(file[pos+4 : pos+8])
+		if pos+12+length > len(file) {
+			return nil, fmt.Errorf("обрезанный чанк png")
+		}
+		data := file[pos+8 : pos+8+length]
+
+		switch kind {
+		case "iCCP":
+			name := bytes.IndexByte(data, 0)
+			if name < 0 || name+2 > len(data) {
+				return nil, fmt.Errorf("некорректный чанк iCCP")
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(data[name+2:]))
+			if err != nil {
+				return nil, err
+			}
+			defer reader.Close()
+			// чанк сжат, без ограничения маленький файл распаковывается в гигабайты
+			profile, err := io.ReadAll(io.LimitReader(reader, maxICCSize+1))
+			if err != nil {
+				return nil, err
+			}
+			if len(profile) > maxICCSize {
+				return nil, fmt.Errorf("слишком большой ICC профиль")
+			}
+			return profile, nil
+		case "IDAT", "IEND":
+			return nil, nil
+		}
+		pos += 12 + length
+	}
+	return nil, nil
+}
+
+// parseICC разбирает RGB профиль, для профилей других видов возвращается ошибка
+func parseICC(data []byte) (*iccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("некорректный ICC профиль")
+	}
+	if string(data[16:20]) != "RGB " {
+		return nil, fmt.Errorf("неподдерживаемое цветовое пространство %q", data[16:20])
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:132]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(data) {
+			return nil, fmt.Errorf("обрезанная таблица тегов")
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4 : entry+8]))
+		size := int(binary.BigEndian.Uint32(data[entry+8 : entry+12]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, fmt.Errorf("тег вне профиля")
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	profile := &iccProfile{}
+	for channel, name := range []string{"r", "g", "b"} {
+		xyz, ok := tags[name+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[0:4]) != "XYZ " {
+			return nil, fmt.Errorf("профиль не содержит основных цветов")
+		}
+		for row := 0; row < 3; row++ {
+			profile.colorants[row][channel] = s15Fixed16(xyz[8+row*4:])
+		}
+
+		curve, err := parseCurve(tags[name+"TRC"])
+		if err != nil {
+			return nil, err
+		}
+		profile.curves[channel] = curve
+	}
+
+	// кривые применяются только к значениям пикселей, поэтому достаточно проверить их все
+	for _, curve := range profile.curves {
+		for i := 0; i < 256; i++ {
+			v := curve(float64(i) / 255)
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("кривая профиля даёт некорректные значения")
+			}
+		}
+	}
+
+	return profile, nil
+}
+
+func parseCurve(data []byte) (toneCurve, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("профиль не содержит кривых")
+	}
+
+	switch string(data[0:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(data[8:12]))
+		if len(data) < 12+count*2 {
+			return nil, fmt.Errorf("обрезанная кривая")
+		}
+		switch count {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(data[12:14])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(data[12+i*2:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(count-1)
+			i := int(pos)
+			if i >= count-1 {
+				return table[count-1]
+			}
+			frac := pos - float64(i)
+			return table[i]*(1-frac) + table[i+1]*frac
+		}, nil
+	case "para":
+		var params [7]float64
+		kind := binary.BigEndian.Uint16(data[8:10])
+		counts := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+		n, ok := counts[kind]
+		if !ok || len(data) < 12+n*4 {
+			return nil, fmt.Errorf("неподдерживаемая параметрическая кривая")
+		}
+		for i := 0; i < n; i++ {
+			params[i] = s15Fixed16(data[12+i*4:])
+		}
+		g, a, b, c, d, e, f := params[0], params[1], params[2], params[3], params[4], params[5], params[6]
+		if kind != 0 && a <= 0 {
+			return nil, fmt.Errorf("некорректные параметры кривой")
+		}
+		// pow с отрицательным основанием и дробной степенью даёт NaN, основание не опускается ниже 0
+		pow := func(base float64) float64 {
+			return math.Pow(max(base, 0), g)
+		}
+		return func(x float64) float64 {
+			switch kind {
+			case 1:
+				if x >= -b/a {
+					return pow(a*x + b)
+				}
+				return 0
+			case 2:
+				if x >= -b/a {
+					return pow(a*x+b) + c
+				}
+				return c
+			case 3:
+				if x >= d {
+					return pow(a*x + b)
+				}
+				return c * x
+			case 4:
+				if x >= d {
+					return pow(a*x+b) + e
+				}
+				return c*x + f
+			}
+			return pow(x)
+		}, nil
+	}
+	return nil, fmt.Errorf("неподдерживаемый тип кривой %q", data[0:4])
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// isSRGB проверяет, совпадает ли профиль с sRGB настолько, что преобразование ничего не изменит
+func (p *iccProfile) isSRGB() bool {
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			if math.Abs(p.colorants[row][col]-srgbColorants[row][col]) > 0.002 {
+				return false
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		for _, x := range []float64{0.1, 0.25, 0.5, 0.75, 0.9} {
+			if math.Abs(curve(x)-srgbDecode(x)) > 0.005 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// convertToSRGB переводит пиксели из пространства профиля в sRGB
+func convertToSRGB(img image.Image, profile *iccProfile) image.Image {
+	matrix := multiply(invert(srgbColorants), profile.colorants)
+
+	var linear [3][256]float64
+	for channel, curve := range profile.curves {
+		for i := range linear[channel] {
+			linear[channel][i] = curve(float64(i) / 255)
+		}
+	}
+
+	const encodeSteps = 4095
+	var encode [encodeSteps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(srgbEncode(float64(i)/encodeSteps) * 255))
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	for i := 0; i+3 < len(dst.Pix); i += 4 {
+		r := linear[0][dst.Pix[i]]
+		g := linear[1][dst.Pix[i+1]]
+		b := linear[2][dst.Pix[i+2]]
+		for channel := 0; channel < 3; channel++ {
+			v := matrix[channel][0]*r + matrix[channel][1]*g + matrix[channel][2]*b
+			if math.IsNaN(v) {
+				v = 0
+			}
+			v = min(max(v, 0), 1)
+			dst.Pix[i+channel] = encode[int(v*encodeSteps+0.5)]
+		}
+	}
+
+	return dst
+}
+
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func multiply(a [3][3]float64, b [3][3]float64) [3][3]float64 {
+	var res [3][3]float64
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			for k := 0; k < 3; k++ {
+				res[row][col] += a[row][k] * b[k][col]
+			}
+		}
+	}
+	return res
+}
+
+func invert(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	return [3][3]float64{
+		{
+			(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det,
+			(m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det,
+			(m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det,
+		},
+		{
+			(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det,
+			(m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det,
+			(m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det,
+		},
+		{
+			(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det,
+			(m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det,
+			(m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det,
+		},
+	}
+}
+
+// srgbProfile ICC v2 профиль sRGB, встраиваемый в результат
+var srgbProfile = buildSRGBProfile()
+
+func buildSRGBProfile() []byte {
+	xyz := func(x, y, z float64) []byte {
+		data := make([]byte, 20)
+		copy(data, "XYZ ")
+		for i, v := range []float64{x, y, z} {
+			binary.BigEndian.PutUint32(data[8+i*4:], uint32(int32(math.Round(v*65536))))
+		}
+		return data
+	}
+
+	const curvePoints = 1024
+	curve := make([]byte, 12+curvePoints*2)
+	copy(curve, "curv")
+	binary.BigEndian.PutUint32(curve[8:], curvePoints)
+	for i := 0; i < curvePoints; i++ {
+		v := srgbDecode(float64(i) / (curvePoints - 1))
+		binary.BigEndian.PutUint16(curve[12+i*2:], uint16(math.Round(v*65535)))
+	}
+
+	description := "sRGB"
+	desc := &bytes.Buffer{}
+	desc.WriteString("desc\x00\x00\x00\x00")
+	_ = binary.Write(desc, binary.BigEndian, uint32(len(description)+1))
+	desc.WriteString(description + "\x00")
+	// пустые unicode и scriptcode описания
+	desc.Write(make([]byte, 4+4+2+1+67))
+
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", desc.Bytes()},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"rXYZ", xyz(srgbColorants[0][0], srgbColorants[1][0], srgbColorants[2][0])},
+		{"gXYZ", xyz(srgbColorants[0][1], srgbColorants[1][1], srgbColorants[2][1])},
+		{"bXYZ", xyz(srgbColorants[0][2], srgbColorants[1][2], srgbColorants[2][2])},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	table := &bytes.Buffer{}
+	body := &bytes.Buffer{}
+	offset := 128 + 4 + len(tags)*12
+	offsets := map[*byte]int{}
+	for _, tag := range tags {
+		// одинаковые данные кривых хранятся один раз
+		at, ok := offsets[&tag.data[0]]
+		if !ok {
+			at = offset + body.Len()
+			offsets[&tag.data[0]] = at
+			body.Write(tag.data)
+			for body.Len()%4 != 0 {
+				body.WriteByte(0)
+			}
+		}
+		table.WriteString(tag.signature)
+		_ = binary.Write(table, binary.BigEndian, uint32(at))
+		_ = binary.Write(table, binary.BigEndian, uint32(len(tag.data)))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(offset+body.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	copy(header[68:], xyz(0.9642, 1, 0.8249)[8:])
+
+	profile := &bytes.Buffer{}
+	profile.Write(header)
+	_ = binary.Write(profile, binary.BigEndian, uint32(len(tags)))
+	profile.Write(table.Bytes())
+	profile.Write(body.Bytes())
+	return profile.Bytes()
+}
+
+// embedICC встраивает ICC профиль в WebP, при необходимости переводя файл в расширенный формат
+func embedICC(file []byte, profile []byte, width int, height int) ([]byte, error) {
+	chunks, err := readWebPChunks(file)
+	if err != nil {
+		return nil, err
+	}
+
+	iccp := riffChunk{FourCC: "ICCP", Data: profile}
+	if header := findChunk(chunks, "VP8X"); header != nil {
+		header.Data[0] |= vp8xFlagICC
+		res := []riffChunk{chunks[0], iccp}
+		for _, chunk := range chunks[1:] {
+			if chunk.FourCC != "ICCP" {
+				res = append(res, chunk)
+			}
+		}
+		return writeWebP(res), nil
+	}
+
+	flags := byte(vp8xFlagICC)
+	if lossless := findChunk(chunks, "VP8L"); lossless != nil && len(lossless.Data) >= 5 &&
+		binary.LittleEndian.Uint32(lossless.Data[1:5])>>28&1 == 1 {
+		flags |= vp8xFlagAlpha
+	}
+
+	return writeWebP(append([]riffChunk{vp8xChunk(flags, width, height), iccp}, chunks...)), nil
+}
@@ -0,0 +1,214 @@
+package image_processing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func fixed(v float64) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(int32(math.Round(v*65536))))
+	return b
+}
+
+func paraCurve(kind uint16, params ...float64) []byte {
+	data := make([]byte, 12)
+	copy(data, "para")
+	binary.BigEndian.PutUint16(data[8:], kind)
+	for _, p := range params {
+		data = append(data, fixed(p)...)
+	}
+	return data
+}
+
+// testProfile собирает RGB профиль с основными цветами colorants и одной кривой для всех каналов
+func testProfile(colorants [3][3]float64, curve []byte) []byte {
+	xyz := func(channel int) []byte {
+		data := []byte("XYZ \x00\x00\x00\x00")
+		for row := 0; row < 3; row++ {
+			data = append(data, fixed(colorants[row][channel])...)
+		}
+		return data
+	}
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"rXYZ", xyz(0)}, {"gXYZ", xyz(1)}, {"bXYZ", xyz(2)},
+		{"rTRC", curve}, {"gTRC", curve}, {"bTRC", curve},
+	}
+
+	header := make([]byte, 128)
+	copy(header[16:], "RGB ")
+	copy(header[36:], "acsp")
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var body []byte
+	offset := 128 + 4 + len(tags)*12
+	for _, tag := range tags {
+		table = append(table, tag.signature...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(body)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+		body = append(body, tag.data...)
+	}
+	return append(append(header, table...), body...)
+}
+
+func TestParseCurve(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+		x       float64
+		want    float64
+	}{
+		{name: "пустая кривая", data: []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00"), x: 0.4, want: 0.4},
+		{name: "гамма", data: []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x00"), x: 0.5, want: 0.25},
+		{name: "таблица", data: []byte("curv\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\xff\xff"), x: 0.5, want: 0.5},
+		{name: "обрезанная таблица", data: []byte("curv\x00\x00\x00\x00\x00\x00\x00\x05\x00\x00"), wantErr: true},
+		{name: "параметрическая гамма", data: paraCurve(0, 2), x: 0.5, want: 0.25},
+		{name: "sRGB", data: paraCurve(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045), x: 0.5, want: srgbDecode(0.5)},
+		{name: "нулевой a", data: paraCurve(1, 2.2, 0, 0.5), wantErr: true},
+		{name: "отрицательный a", data: paraCurve(1, 2.2, -1, 0.5), wantErr: true},
+		// при x < d основание a*x+b отрицательное, pow должен вернуть 0, а не NaN
+		{name: "отрицательное основание", data: paraCurve(3, 2.2, 1, -0.5, 0, 0), x: 0.1, want: 0},
+		{name: "неизвестный вид", data: paraCurve(7, 1), wantErr: true},
+		{name: "неизвестный тип", data: []byte("mAB \x00\x00\x00\x00\x00\x00\x00\x00"), wantErr: true},
+		{name: "короткие данные", data: []byte("curv"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			curve, err := parseCurve(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ожидалась ошибка")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ошибка: %s", err)
+			}
+			if got := curve(tt.x); math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("curve(%v) = %v, ожидалось %v", tt.x, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseICC(t *testing.T) {
+	displayP3 := [3][3]float64{
+		{0.5151, 0.2920, 0.1571},
+		{0.2412, 0.6922, 0.0666},
+		{-0.0011, 0.0419, 0.7841},
+	}
+	gamma := paraCurve(0, 2.2)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantErr  bool
+		wantSRGB bool
+	}{
+		{name: "встроенный sRGB", data: srgbProfile, wantSRGB: true},
+		{name: "Display P3", data: testProfile(displayP3, gamma)},
+		{name: "бесконечная кривая", data: testProfile(displayP3, paraCurve(0, -2)), wantErr: true},
+		{name: "короткий профиль", data: srgbProfile[:100], wantErr: true},
+		{name: "не RGB", data: append(append(append([]byte{}, srgbProfile[:16]...), "GRAY"...), srgbProfile[20:]...), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := parseICC(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ожидалась ошибка")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ошибка: %s", err)
+			}
+			if profile.isSRGB() != tt.wantSRGB {
+				t.Errorf("isSRGB() = %v, ожидалось %v", !tt.wantSRGB, tt.wantSRGB)
+			}
+		})
+	}
+}
+
+func TestConvertToSRGB(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{R: 255, G: 128, B: 0, A: 255})
+	img.Set(1, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 128})
+
+	identity := &iccProfile{colorants: srgbColorants}
+	for i := range identity.curves {
+		identity.curves[i] = srgbDecode
+	}
+	// NaN в кривой не должен приводить к выходу за границы таблицы
+	broken := &iccProfile{colorants: srgbColorants}
+	for i := range broken.curves {
+		broken.curves[i] = func(float64) float64 { return math.NaN() }
+	}
+
+	tests := []struct {
+		name    string
+		profile *iccProfile
+		want    []uint8
+	}{
+		{name: "sRGB не меняет пиксели", profile: identity, want: img.Pix},
+		{name: "NaN", profile: broken, want: []uint8{0, 0, 0, 255, 0, 0, 0, 128}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertToSRGB(img, tt.profile).(*image.NRGBA).Pix
+			for i := range got {
+				if diff := int(got[i]) - int(tt.want[i]); diff < -1 || diff > 1 {
+					t.Fatalf("пиксели %v, ожидалось %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func pngWithICC(profile []byte) []byte {
+	compressed := &bytes.Buffer{}
+	writer := zlib.NewWriter(compressed)
+	_, _ = writer.Write(profile)
+	_ = writer.Close()
+
+	data := append([]byte("icc\x00\x00"), compressed.Bytes()...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, "iCCP"...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return append([]byte("\x89PNG\r\n\x1a\n"), chunk...)
+}
+
+func TestExtractPNGICC(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{name: "профиль", file: pngWithICC(srgbProfile), want: srgbProfile},
+		{name: "без профиля", file: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00IEND\xaeB`\x82")},
+		{name: "сжатая бомба", file: pngWithICC(make([]byte, maxICCSize+1)), wantErr: true},
+		{name: "обрезанный чанк", file: pngWithICC(srgbProfile)[:40], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractPNGICC(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ошибка %v, ожидалась ошибка: %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("профиль %d байт, ожидалось %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
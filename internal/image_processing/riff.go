@@ -0,0 +1,114 @@
+package image_processing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// riffChunk чанк контейнера RIFF, в котором хранится WebP
+type riffChunk struct {
+	FourCC string
+	Data   []byte
+}
+
+// readWebPChunks разбирает WebP файл на чанки верхнего уровня
+func readWebPChunks(file []byte) ([]riffChunk, error) {
+	if len(file) < 12 || string(file[0:4]) != "RIFF" || string(file[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("файл не является WebP")
+	}
+
+	size := int(binary.LittleEndian.Uint32(file[4:8])) + 8
+	if size > len(file) {
+		size = len(file)
+	}
+
+	return readChunks(file[12:size])
+}
+
+// readChunks разбирает последовательность чанков RIFF
+func readChunks(data []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("обрезанный заголовок чанка")
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			return nil, fmt.Errorf("обрезанный чанк %q", data[0:4])
+		}
+
+		chunks = append(chunks, riffChunk{
+			FourCC: string(data[0:4]),
+			Data:   data[8 : 8+size],
+		})
+
+		next := 8 + size + size%2
+		if next > len(data) {
+			next = len(data)
+		}
+		data = data[next:]
+	}
+
+	return chunks, nil
+}
+
+func writeChunk(buf *bytes.Buffer, chunk riffChunk) {
+	buf.WriteString(chunk.FourCC)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(len(chunk.Data)))
+	buf.Write(chunk.Data)
+	if len(chunk.Data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// writeWebP собирает WebP файл из чанков
+func writeWebP(chunks []riffChunk) []byte {
+	body := &bytes.Buffer{}
+	body.WriteString("WEBP")
+	for _, chunk := range chunks {
+		writeChunk(body, chunk)
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString("RIFF")
+	_ = binary.Write(out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+
+	return out.Bytes()
+}
+
+func findChunk(chunks []riffChunk, fourCC string) *riffChunk {
+	for i := range chunks {
+		if chunks[i].FourCC == fourCC {
+			return &chunks[i]
+		}
+	}
+	return nil
+}
+
+const (
+	vp8xFlagAnimation = 0x02
+	vp8xFlagAlpha     = 0x10
+	vp8xFlagICC       = 0x20
+)
+
+// vp8xChunk формирует расширенный заголовок WebP
+func vp8xChunk(flags byte, width int, height int) riffChunk {
+	data := make([]byte, 10)
+	data[0] = flags
+	putUint24(data[4:7], uint32(width-1))
+	putUint24(data[7:10], uint32(height-1))
+	return riffChunk{FourCC: "VP8X", Data: data}
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
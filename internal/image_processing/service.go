@@ -10,62 +10,353 @@ import (
 	"github.com/kolesa-team/go-webp/webp"
 	"github.com/nfnt/resize"
 	"go.uber.org/zap"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	xwebp "golang.org/x/image/webp"
 	"image"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"s3n/internal/config"
+	"s3n/internal/metrics"
 )
 
+// OutputFormat формат, в котором сохраняются все обработанные изображения
+const OutputFormat = "webp"
+
+// Options параметры обработки изображения, nil поля заменяются значениями по умолчанию
+type Options struct {
+	Quality   *float32
+	MaxSize   *int
+	Watermark *Watermark
+	// Frame кадр, до которого сокращается анимированное изображение, nil - анимация сохраняется
+	Frame *int
+	// Target подбор качества под размер файла или сходство с исходником, Quality становится верхней границей
+	Target *Target
+}
+
+// Result обработанное изображение и параметры, с которыми оно закодировано
+type Result struct {
+	Data    []byte
+	Quality float32
+	SSIM    float64 // сходство с исходником, 0 - не вычислялось
+}
+
+// Info сведения об изображении, полученные без его обработки
+type Info struct {
+	Format string // формат, определённый по содержимому файла
+	Width  int
+	Height int
+	Frames int // количество кадров, больше 1 у анимаций
+}
+
 type ImageService struct {
-	DefaultQuality float32
-	DefaultMaxSize int
+	DefaultQuality   float32
+	DefaultMaxSize   int
+	ConvertToSRGB    bool
+	EmbedSRGBProfile bool
+	TargetMinQuality float32
+	MaxPixels        int64
 
 	logger logit.Logger
 }
 
 func NewImageService(config *config.ImageProcessingConfig, logger logit.Logger) Service {
 	return &ImageService{
-		DefaultQuality: config.DefaultQuality,
-		DefaultMaxSize: config.DefaultMaxSize,
-		logger:         logger,
+		DefaultQuality:   config.DefaultQuality,
+		DefaultMaxSize:   config.DefaultMaxSize,
+		ConvertToSRGB:    config.ConvertToSRGB,
+		EmbedSRGBProfile: config.EmbedSRGBProfile,
+		TargetMinQuality: config.TargetMinQuality,
+		MaxPixels:        config.MaxPixels,
+		logger:           logger,
+	}
+}
+
+// decode декодирует неанимированное изображение или первый кадр анимации
+func decode(file []byte, format string) (image.Image, error) {
+	reader := bytes.NewReader(file)
+	switch format {
+	case FormatPNG:
+		return png.Decode(reader)
+	case FormatJPEG:
+		return jpeg.Decode(reader)
+	case FormatWebP:
+		return webp.Decode(reader, &decoder.Options{})
+	case FormatGIF:
+		return gif.Decode(reader)
+	case FormatBMP:
+		return bmp.Decode(reader)
+	case FormatTIFF:
+		return tiff.Decode(reader)
+	}
+	return nil, fmt.Errorf("неизвестный формат изображения")
+}
+
+// decodeConfig читает размеры изображения без декодирования пикселей
+func decodeConfig(file []byte, format string) (image.Config, error) {
+	reader := bytes.NewReader(file)
+	switch format {
+	case FormatPNG:
+		return png.DecodeConfig(reader)
+	case FormatJPEG:
+		return jpeg.DecodeConfig(reader)
+	case FormatWebP:
+		return xwebp.DecodeConfig(reader)
+	case FormatGIF:
+		return gif.DecodeConfig(reader)
+	case FormatBMP:
+		return bmp.DecodeConfig(reader)
+	case FormatTIFF:
+		return tiff.DecodeConfig(reader)
+	}
+	return image.Config{}, fmt.Errorf("неизвестный формат изображения")
+}
+
+// resolveFormat определяет формат по сигнатуре файла, расширение от клиента используется только как подсказка
+func (s *ImageService) resolveFormat(ctx context.Context, file []byte, fileExtension string) string {
+	hint := NormalizeFormat(fileExtension)
+
+	detected := DetectFormat(file)
+	if detected == "" {
+		metrics.FormatUndetected.Add(1)
+		return hint
+	}
+
+	if hint != "" && hint != detected {
+		metrics.FormatMismatches.Add(metricFormat(hint)+"->"+detected, 1)
+		s.logger.Warn(ctx, "расширение файла не совпадает с форматом изображения",
+			zap.String("file_extension", fileExtension),
+			zap.String("format", detected),
+		)
+	}
+
+	return detected
+}
+
+// colorProfile возвращает профиль, из которого нужно перевести изображение в sRGB, nil - перевод не нужен
+func (s *ImageService) colorProfile(ctx context.Context, file []byte, format string) *iccProfile {
+	if !s.ConvertToSRGB {
+		return nil
+	}
+
+	data, err := extractICC(file, format)
+	if err == nil && data == nil {
+		return nil
+	}
+
+	var profile *iccProfile
+	if err == nil {
+		profile, err = parseICC(data)
+	}
+	if err != nil {
+		// изображение остаётся без преобразования, как и до поддержки профилей
+		s.logger.Warn(ctx, fmt.Sprintf("не удалось прочитать ICC профиль: %s", err), zap.String("format", format))
+		return nil
+	}
+
+	if profile.isSRGB() {
+		return nil
+	}
+	return profile
+}
+
+// checkPixels проверяет по заголовкам, что все кадры изображения вместе не больше MaxPixels.
+// Каждый кадр анимации декодируется в отдельный холст, поэтому память растёт с количеством кадров
+func (s *ImageService) checkPixels(file []byte, format string) error {
+	if s.MaxPixels <= 0 {
+		return nil
+	}
+
+	cfg, err := decodeConfig(file, format)
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать заголовок изображения: %w", err)
+	}
+	frames, err := countFrames(file, format)
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать анимацию: %w", err)
+	}
+
+	pixels := int64(cfg.Width) * int64(cfg.Height) * int64(max(frames, 1))
+	if cfg.Width < 0 || cfg.Height < 0 || pixels > s.MaxPixels {
+		return fmt.Errorf("изображение слишком большое: %d кадров %dx%d", frames, cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+func (s *ImageService) Decode(ctx context.Context, file []byte, fileFormat string) (image.Image, error) {
+	const op = "ImageService.Decode"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	format := s.resolveFormat(ctx, file, fileFormat)
+	img, err := decode(file, format)
+	if err != nil {
+		err = fmt.Errorf("не удалось прочитать изображение: %w", err)
+		s.logger.Error(ctx, err, zap.String("format", format), zap.Int("file_size", len(file)))
+		return nil, err
+	}
+
+	return img, nil
+}
+
+func (s *ImageService) Probe(ctx context.Context, file []byte, fileFormat string) (*Info, error) {
+	const op = "ImageService.Probe"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	format := s.resolveFormat(ctx, file, fileFormat)
+	cfg, err := decodeConfig(file, format)
+	if err != nil {
+		err = fmt.Errorf("не удалось прочитать заголовок изображения: %w", err)
+		s.logger.Error(ctx, err, zap.String("format", format), zap.Int("file_size", len(file)))
+		return nil, err
+	}
+
+	frames, err := countFrames(file, format)
+	if err != nil {
+		err = fmt.Errorf("не удалось прочитать анимацию: %w", err)
+		s.logger.Error(ctx, err, zap.String("format", format), zap.Int("file_size", len(file)))
+		return nil, err
 	}
+
+	return &Info{
+		Format: format,
+		Width:  cfg.Width,
+		Height: cfg.Height,
+		Frames: frames,
+	}, nil
 }
 
-func (s *ImageService) Transform(ctx context.Context, file []byte, fileFormat string, quality *float32, maxSize *int) ([]byte, error) {
+func (s *ImageService) Transform(ctx context.Context, file []byte, fileFormat string, options *Options) (*Result, error) {
 	const op = "ImageService.Transform"
 	ctx = s.logger.NewOpCtx(ctx, op)
 
+	if options == nil {
+		options = &Options{}
+	}
+
 	var resQuality float32
-	if quality != nil {
-		resQuality = *quality
+	if options.Quality != nil {
+		resQuality = *options.Quality
 	} else {
 		resQuality = s.DefaultQuality
 	}
 	var resMaxSize int
-	if maxSize != nil {
-		resMaxSize = *maxSize
+	if options.MaxSize != nil {
+		resMaxSize = *options.MaxSize
 	} else {
 		resMaxSize = s.DefaultMaxSize
 	}
 
-	var img image.Image
-	var err error
-	switch fileFormat {
-	case "png":
-		img, err = png.Decode(bytes.NewReader(file))
-	case "jpeg", "jpg":
-		img, err = jpeg.Decode(bytes.NewReader(file))
-	case "webp":
-		img, err = webp.Decode(bytes.NewReader(file), &decoder.Options{})
-	default:
-		err = fmt.Errorf("неизвестный формат изображения")
+	fileFormat = s.resolveFormat(ctx, file, fileFormat)
+	err := s.checkPixels(file, fileFormat)
+	if err != nil {
+		s.logger.Error(ctx, err, zap.String("format", fileFormat), zap.Int("file_size", len(file)))
+		return nil, err
 	}
+
+	anim, err := decodeAnimation(file, fileFormat)
 	if err != nil {
-		err = fmt.Errorf("не удалось прочитать изображение: %w", err)
+		err = fmt.Errorf("не удалось прочитать анимацию: %w", err)
 		s.logger.Error(ctx, err, zap.String("format", fileFormat), zap.Int("file_size", len(file)))
 		return nil, err
 	}
 
+	var frames []image.Image
+	switch {
+	case anim != nil && options.Frame == nil:
+		frames = anim.Frames
+	case anim != nil:
+		frames = []image.Image{anim.frame(*options.Frame)}
+		anim = nil
+	default:
+		img, err := decode(file, fileFormat)
+		if err != nil {
+			err = fmt.Errorf("не удалось прочитать изображение: %w", err)
+			s.logger.Error(ctx, err, zap.String("format", fileFormat), zap.Int("file_size", len(file)))
+			return nil, err
+		}
+		frames = []image.Image{img}
+	}
+
+	profile := s.colorProfile(ctx, file, fileFormat)
+	for i, frame := range frames {
+		if profile != nil {
+			frame = convertToSRGB(frame, profile)
+		}
+		frames[i] = process(frame, resMaxSize, options.Watermark)
+	}
+
+	encode := func(frames []image.Image, quality float32) ([]byte, error) {
+		encoderOptions, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, quality)
+		if err != nil {
+			return nil, fmt.Errorf("не удалось создать webp encoder: %w", err)
+		}
+
+		var outBytes []byte
+		if anim != nil {
+			outBytes, err = encodeAnimation(&animation{
+				Frames:    frames,
+				Durations: anim.Durations,
+				LoopCount: anim.LoopCount,
+			}, encoderOptions)
+			if err != nil {
+				return nil, fmt.Errorf("не удался экспорт анимированного webp: %w", err)
+			}
+		} else {
+			buf := &bytes.Buffer{}
+			if err := webp.Encode(buf, frames[0], encoderOptions); err != nil {
+				return nil, fmt.Errorf("не удался экспорт webp: %w", err)
+			}
+			outBytes = buf.Bytes()
+		}
+
+		if s.EmbedSRGBProfile {
+			size := frames[0].Bounds().Size()
+			outBytes, err = embedICC(outBytes, srgbProfile, size.X, size.Y)
+			if err != nil {
+				return nil, fmt.Errorf("не удалось встроить профиль sRGB: %w", err)
+			}
+		}
+
+		return outBytes, nil
+	}
+
+	if options.Target == nil {
+		outBytes, err := encode(frames, resQuality)
+		if err != nil {
+			s.logger.Error(ctx, err, zap.Int("frames", len(frames)))
+			return nil, err
+		}
+
+		return &Result{
+			Data:    outBytes,
+			Quality: resQuality,
+		}, nil
+	}
+
+	res, fits, err := s.encodeTarget(frames, resQuality, options.Target, encode)
+	if err != nil {
+		s.logger.Error(ctx, err, zap.Int("frames", len(frames)))
+		return nil, err
+	}
+	if !fits {
+		s.logger.Warn(ctx, "не удалось подобрать качество под ограничения, использован ближайший результат",
+			zap.Int("max_bytes", options.Target.MaxBytes),
+			zap.Float64("min_ssim", options.Target.MinSSIM),
+			zap.Int("size", len(res.data)),
+			zap.Float64("ssim", res.ssim),
+		)
+	}
+
+	return &Result{
+		Data:    res.data,
+		Quality: float32(res.quality),
+		SSIM:    res.ssim,
+	}, nil
+}
+
+// process уменьшает изображение до максимального размера и накладывает водяной знак
+func process(img image.Image, resMaxSize int, watermark *Watermark) image.Image {
 	if img.Bounds().Size().X > resMaxSize || img.Bounds().Size().Y > resMaxSize {
 		var newXSize int
 		var newYSize int
@@ -93,20 +384,9 @@ func (s *ImageService) Transform(ctx context.Context, file []byte, fileFormat st
 		img = resize.Resize(uint(newXSize), uint(newYSize), img, resize.Lanczos3)
 	}
 
-	options, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, resQuality)
-	if err != nil {
-		err = fmt.Errorf("не удалось создать webp encoder: %w", err)
-		s.logger.Error(ctx, err)
-		return nil, err
-	}
-
-	outBytes := &bytes.Buffer{}
-
-	if err := webp.Encode(outBytes, img, options); err != nil {
-		err = fmt.Errorf("не удался экспорт webp: %w", err)
-		s.logger.Error(ctx, err)
-		return nil, err
+	if watermark != nil {
+		img = applyWatermark(img, watermark)
 	}
 
-	return outBytes.Bytes(), nil
+	return img
 }
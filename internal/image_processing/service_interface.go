@@ -1,7 +1,12 @@
 package image_processing
 
-import "context"
+import (
+	"context"
+	"image"
+)
 
 type Service interface {
-	Transform(ctx context.Context, file []byte, fileFormat string, quality *float32, maxSize *int) ([]byte, error)
+	Decode(ctx context.Context, file []byte, fileFormat string) (image.Image, error)
+	Probe(ctx context.Context, file []byte, fileFormat string) (*Info, error)
+	Transform(ctx context.Context, file []byte, fileFormat string, options *Options) (*Result, error)
 }
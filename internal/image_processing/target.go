@@ -0,0 +1,197 @@
+package image_processing
+
+import (
+	"bytes"
+	"github.com/kolesa-team/go-webp/decoder"
+	"github.com/kolesa-team/go-webp/webp"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Target ограничения на результат, под которые подбирается качество вместо фиксированного
+type Target struct {
+	MaxBytes       int     // максимальный размер файла, 0 - не ограничен
+	MinSSIM        float64 // минимальное сходство с исходником от 0 до 1, 0 - не проверяется
+	AllowDownscale bool    // уменьшать изображение, если размер не достигается на минимальном качестве
+}
+
+const (
+	// downscaleStep во сколько раз уменьшается изображение за одну попытку уложиться в размер
+	downscaleStep = 0.8
+	// maxDownscaleSteps ограничивает уменьшение примерно до 10% исходной площади
+	maxDownscaleSteps = 5
+)
+
+// encoding результат кодирования с одним значением качества
+type encoding struct {
+	data    []byte
+	quality int
+	ssim    float64
+}
+
+// encodeFunc кодирует подготовленные кадры с заданным качеством
+type encodeFunc func(frames []image.Image, quality float32) ([]byte, error)
+
+// encodeTarget подбирает качество, а при необходимости и размер, под ограничения target.
+// Второе значение false, если ограничения выполнить не удалось и возвращён ближайший результат
+func (s *ImageService) encodeTarget(frames []image.Image, maxQuality float32, target *Target, encode encodeFunc) (*encoding, bool, error) {
+	scaled := frames
+	for step := 0; ; step++ {
+		res, fits, err := s.searchQuality(scaled, maxQuality, target, encode)
+		if err != nil {
+			return nil, false, err
+		}
+		if fits || !target.AllowDownscale || target.MaxBytes == 0 || step == maxDownscaleSteps {
+			return res, fits, nil
+		}
+
+		factor := math.Pow(downscaleStep, float64(step+1))
+		scaled = make([]image.Image, len(frames))
+		for i, frame := range frames {
+			width := uint(math.Max(1, float64(frame.Bounds().Dx())*factor))
+			height := uint(math.Max(1, float64(frame.Bounds().Dy())*factor))
+			scaled[i] = resize.Resize(width, height, frame, resize.Lanczos3)
+		}
+	}
+}
+
+// searchQuality двоичным поиском находит качество: минимальное, дающее нужное сходство,
+// и не выше максимального, при котором файл укладывается в размер
+func (s *ImageService) searchQuality(frames []image.Image, maxQuality float32, target *Target, encode encodeFunc) (*encoding, bool, error) {
+	tries := map[int]*encoding{}
+	try := func(quality int) (*encoding, error) {
+		if res, ok := tries[quality]; ok {
+			return res, nil
+		}
+		data, err := encode(frames, float32(quality))
+		if err != nil {
+			return nil, err
+		}
+		res := &encoding{data: data, quality: quality}
+		// сходство проверяется только для неанимированных изображений
+		if target.MinSSIM > 0 && len(frames) == 1 {
+			res.ssim, err = ssim(frames[0], data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		tries[quality] = res
+		return res, nil
+	}
+	fitsSize := func(res *encoding) bool {
+		return target.MaxBytes == 0 || len(res.data) <= target.MaxBytes
+	}
+
+	low := int(math.Min(float64(s.TargetMinQuality), float64(maxQuality)))
+	high := int(maxQuality)
+
+	if target.MinSSIM > 0 && len(frames) == 1 {
+		var best *encoding
+		for lo, hi := low, high; lo <= hi; {
+			mid := (lo + hi) / 2
+			res, err := try(mid)
+			if err != nil {
+				return nil, false, err
+			}
+			if res.ssim >= target.MinSSIM {
+				best = res
+				hi = mid - 1
+			} else {
+				lo = mid + 1
+			}
+		}
+
+		if best != nil && fitsSize(best) {
+			return best, true, nil
+		}
+		if best == nil && target.MaxBytes == 0 {
+			res, err := try(high)
+			return res, false, err
+		}
+		if best != nil {
+			// сходство достижимо только с превышением размера, размер важнее
+			high = best.quality
+		}
+	}
+
+	var best *encoding
+	for lo, hi := low, high; lo <= hi; {
+		mid := (lo + hi) / 2
+		res, err := try(mid)
+		if err != nil {
+			return nil, false, err
+		}
+		if fitsSize(res) {
+			best = res
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	if best == nil {
+		res, err := try(low)
+		return res, false, err
+	}
+	return best, target.MinSSIM == 0 || len(frames) != 1 || best.ssim >= target.MinSSIM, nil
+}
+
+// ssim вычисляет среднее структурное сходство яркости исходника и закодированного WebP по блокам 8x8
+func ssim(reference image.Image, encoded []byte) (float64, error) {
+	decoded, err := webp.Decode(bytes.NewReader(encoded), &decoder.Options{})
+	if err != nil {
+		return 0, err
+	}
+
+	const (
+		block = 8
+		c1    = (0.01 * 255) * (0.01 * 255)
+		c2    = (0.03 * 255) * (0.03 * 255)
+	)
+
+	refBounds := reference.Bounds()
+	decBounds := decoded.Bounds()
+	width := min(refBounds.Dx(), decBounds.Dx())
+	height := min(refBounds.Dy(), decBounds.Dy())
+
+	var total float64
+	var blocks int
+	for by := 0; by+block <= height; by += block {
+		for bx := 0; bx+block <= width; bx += block {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := by; y < by+block; y++ {
+				for x := bx; x < bx+block; x++ {
+					a := luma(reference.At(refBounds.Min.X+x, refBounds.Min.Y+y))
+					b := luma(decoded.At(decBounds.Min.X+x, decBounds.Min.Y+y))
+					sumA += a
+					sumB += b
+					sumAA += a * a
+					sumBB += b * b
+					sumAB += a * b
+				}
+			}
+
+			const n = block * block
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			blocks++
+		}
+	}
+
+	if blocks == 0 {
+		return 1, nil
+	}
+	return total / float64(blocks), nil
+}
+
+func luma(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+}
@@ -0,0 +1,64 @@
+package image_processing
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+// sizeEncoder возвращает файл размером quality байт на каждый пиксель ширины кадра
+func sizeEncoder(calls *int) encodeFunc {
+	return func(frames []image.Image, quality float32) ([]byte, error) {
+		*calls++
+		return make([]byte, int(quality)*frames[0].Bounds().Dx()), nil
+	}
+}
+
+func TestEncodeTarget(t *testing.T) {
+	frame := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	tests := []struct {
+		name        string
+		target      Target
+		maxQuality  float32
+		wantQuality int
+		wantWidth   int
+		wantFits    bool
+	}{
+		{name: "без ограничения размера", target: Target{}, maxQuality: 80, wantQuality: 80, wantWidth: 10, wantFits: true},
+		{name: "максимальное качество в размере", target: Target{MaxBytes: 555}, maxQuality: 80, wantQuality: 55, wantWidth: 10, wantFits: true},
+		{name: "размер не достигается", target: Target{MaxBytes: 50}, maxQuality: 80, wantQuality: 10, wantWidth: 10},
+		// при ширине 10 минимальный размер 100 байт, уменьшение 0.8^3 даёт ширину 5
+		{name: "уменьшение", target: Target{MaxBytes: 50, AllowDownscale: true}, maxQuality: 80, wantQuality: 10, wantWidth: 5, wantFits: true},
+		{name: "уменьшения недостаточно", target: Target{MaxBytes: 10, AllowDownscale: true}, maxQuality: 80, wantQuality: 10, wantWidth: 3},
+		{name: "максимальное качество ниже минимального", target: Target{MaxBytes: 1000}, maxQuality: 5, wantQuality: 5, wantWidth: 10, wantFits: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &ImageService{TargetMinQuality: 10}
+			calls := 0
+			res, fits, err := service.encodeTarget([]image.Image{frame}, tt.maxQuality, &tt.target, sizeEncoder(&calls))
+			if err != nil {
+				t.Fatalf("ошибка: %s", err)
+			}
+			if res.quality != tt.wantQuality || len(res.data) != tt.wantQuality*tt.wantWidth || fits != tt.wantFits {
+				t.Errorf("качество %d, размер %d, уложились: %v; ожидалось %d, %d, %v",
+					res.quality, len(res.data), fits, tt.wantQuality, tt.wantQuality*tt.wantWidth, tt.wantFits)
+			}
+			// двоичный поиск на каждом шаге уменьшения
+			if calls > (maxDownscaleSteps+1)*8 {
+				t.Errorf("слишком много попыток кодирования: %d", calls)
+			}
+		})
+	}
+}
+
+func TestEncodeTargetError(t *testing.T) {
+	service := &ImageService{TargetMinQuality: 10}
+	failing := func([]image.Image, float32) ([]byte, error) {
+		return nil, fmt.Errorf("ошибка кодирования")
+	}
+	_, _, err := service.encodeTarget([]image.Image{image.NewRGBA(image.Rect(0, 0, 1, 1))}, 80, &Target{MaxBytes: 10}, failing)
+	if err == nil {
+		t.Error("ожидалась ошибка")
+	}
+}
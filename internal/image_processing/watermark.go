@@ -0,0 +1,120 @@
+package image_processing
+
+import (
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+type WatermarkPosition string
+
+const (
+	WatermarkTopLeft     WatermarkPosition = "top-left"
+	WatermarkTop         WatermarkPosition = "top"
+	WatermarkTopRight    WatermarkPosition = "top-right"
+	WatermarkLeft        WatermarkPosition = "left"
+	WatermarkCenter      WatermarkPosition = "center"
+	WatermarkRight       WatermarkPosition = "right"
+	WatermarkBottomLeft  WatermarkPosition = "bottom-left"
+	WatermarkBottom      WatermarkPosition = "bottom"
+	WatermarkBottomRight WatermarkPosition = "bottom-right"
+)
+
+func (p WatermarkPosition) Valid() bool {
+	switch p {
+	case WatermarkTopLeft, WatermarkTop, WatermarkTopRight,
+		WatermarkLeft, WatermarkCenter, WatermarkRight,
+		WatermarkBottomLeft, WatermarkBottom, WatermarkBottomRight:
+		return true
+	}
+	return false
+}
+
+// Watermark изображение, накладываемое поверх результата обработки
+type Watermark struct {
+	Overlay  image.Image
+	Position WatermarkPosition // игнорируется при Tiled
+	Margin   int               // отступ от краёв и между плитками в пикселях
+	Opacity  float32           // непрозрачность от 0 до 1
+	Scale    float32           // ширина знака относительно ширины результата, 0 - исходный размер
+	Tiled    bool              // заполнить изображение знаком по сетке
+	MinSize  int               // знак не накладывается, если большая сторона результата меньше
+}
+
+func applyWatermark(img image.Image, watermark *Watermark) image.Image {
+	bounds := img.Bounds()
+	if watermark.Overlay == nil || max(bounds.Dx(), bounds.Dy()) < watermark.MinSize {
+		return img
+	}
+
+	overlay := watermark.Overlay
+	if watermark.Scale > 0 {
+		width := uint(float32(bounds.Dx()) * watermark.Scale)
+		if width == 0 {
+			return img
+		}
+		overlay = resize.Resize(width, 0, overlay, resize.Lanczos3)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	mask := image.NewUniform(color.Alpha{A: uint8(clamp(watermark.Opacity, 0, 1) * 255)})
+	size := overlay.Bounds().Size()
+
+	if watermark.Tiled {
+		step := size.Add(image.Pt(watermark.Margin, watermark.Margin))
+		if step.X <= 0 || step.Y <= 0 {
+			return dst
+		}
+		for y := watermark.Margin; y < dst.Bounds().Dy(); y += step.Y {
+			for x := watermark.Margin; x < dst.Bounds().Dx(); x += step.X {
+				drawOverlay(dst, overlay, mask, image.Pt(x, y))
+			}
+		}
+		return dst
+	}
+
+	drawOverlay(dst, overlay, mask, watermarkOrigin(dst.Bounds().Size(), size, watermark.Position, watermark.Margin))
+	return dst
+}
+
+func drawOverlay(dst *image.RGBA, overlay image.Image, mask image.Image, at image.Point) {
+	rect := image.Rectangle{Min: at, Max: at.Add(overlay.Bounds().Size())}
+	draw.DrawMask(dst, rect, overlay, overlay.Bounds().Min, mask, image.Point{}, draw.Over)
+}
+
+func watermarkOrigin(canvas image.Point, size image.Point, position WatermarkPosition, margin int) image.Point {
+	left := margin
+	centerX := (canvas.X - size.X) / 2
+	right := canvas.X - size.X - margin
+	top := margin
+	centerY := (canvas.Y - size.Y) / 2
+	bottom := canvas.Y - size.Y - margin
+
+	switch position {
+	case WatermarkTopLeft:
+		return image.Pt(left, top)
+	case WatermarkTop:
+		return image.Pt(centerX, top)
+	case WatermarkTopRight:
+		return image.Pt(right, top)
+	case WatermarkLeft:
+		return image.Pt(left, centerY)
+	case WatermarkCenter:
+		return image.Pt(centerX, centerY)
+	case WatermarkRight:
+		return image.Pt(right, centerY)
+	case WatermarkBottomLeft:
+		return image.Pt(left, bottom)
+	case WatermarkBottom:
+		return image.Pt(centerX, bottom)
+	default:
+		return image.Pt(right, bottom)
+	}
+}
+
+func clamp(value float32, low float32, high float32) float32 {
+	return min(max(value, low), high)
+}
@@ -0,0 +1,75 @@
+package image_processing
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestWatermarkOrigin(t *testing.T) {
+	canvas := image.Pt(100, 50)
+	size := image.Pt(20, 10)
+	tests := map[WatermarkPosition]image.Point{
+		WatermarkTopLeft:     image.Pt(5, 5),
+		WatermarkTop:         image.Pt(40, 5),
+		WatermarkTopRight:    image.Pt(75, 5),
+		WatermarkLeft:        image.Pt(5, 20),
+		WatermarkCenter:      image.Pt(40, 20),
+		WatermarkRight:       image.Pt(75, 20),
+		WatermarkBottomLeft:  image.Pt(5, 35),
+		WatermarkBottom:      image.Pt(40, 35),
+		WatermarkBottomRight: image.Pt(75, 35),
+		// неизвестная позиция совпадает с позицией по умолчанию
+		"": image.Pt(75, 35),
+	}
+	for position, want := range tests {
+		if got := watermarkOrigin(canvas, size, position, 5); got != want {
+			t.Errorf("watermarkOrigin(%q) = %v, ожидалось %v", position, got, want)
+		}
+	}
+}
+
+func TestApplyWatermark(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	overlay := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(overlay, overlay.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name      string
+		watermark Watermark
+		// wantRects области, закрытые знаком, остальные пиксели не меняются
+		wantRects []image.Rectangle
+	}{
+		{
+			name:      "правый нижний угол",
+			watermark: Watermark{Overlay: overlay, Position: WatermarkBottomRight, Margin: 1, Opacity: 1},
+			wantRects: []image.Rectangle{image.Rect(5, 5, 7, 7)},
+		},
+		{
+			name:      "плитки",
+			watermark: Watermark{Overlay: overlay, Margin: 2, Opacity: 1, Tiled: true},
+			wantRects: []image.Rectangle{image.Rect(2, 2, 4, 4), image.Rect(6, 2, 8, 4), image.Rect(2, 6, 4, 8), image.Rect(6, 6, 8, 8)},
+		},
+		{name: "меньше минимального размера", watermark: Watermark{Overlay: overlay, Opacity: 1, MinSize: 10}},
+		{name: "прозрачный знак", watermark: Watermark{Overlay: overlay, Position: WatermarkTopLeft}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+			out := applyWatermark(img, &tt.watermark).(*image.RGBA)
+
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					want := false
+					for _, rect := range tt.wantRects {
+						want = want || image.Pt(x, y).In(rect)
+					}
+					if painted := out.RGBAAt(x, y).R == 255; painted != want {
+						t.Errorf("пиксель (%d, %d) закрашен: %v, ожидалось %v", x, y, painted, want)
+					}
+				}
+			}
+		})
+	}
+}
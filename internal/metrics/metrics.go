@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// Метрики публикуются через expvar и доступны на отдельном http сервере метрик по пути /debug/vars
+
+var (
+	// FormatMismatches количество загрузок, расширение которых не совпало с форматом файла, ключ - "расширение->формат"
+	FormatMismatches = expvar.NewMap("s3n_format_mismatches")
+	// FormatUndetected количество загрузок, формат которых не удалось определить по содержимому
+	FormatUndetected = expvar.NewInt("s3n_format_undetected")
+
+	// CacheHits количество чтений объектов из кеша, ключ - уровень кеша (memory, disk)
+	CacheHits = expvar.NewMap("s3n_cache_hits")
+	// CacheMisses количество чтений объектов, отсутствующих в кеше
+	CacheMisses = expvar.NewInt("s3n_cache_misses")
+	// CacheBypasses количество чтений объектов больше максимального размера, которые читаются мимо кеша
+	// и не учитываются в доле попаданий
+	CacheBypasses = expvar.NewInt("s3n_cache_bypasses")
+	// CacheEvictions количество вытесненных из кеша объектов, ключ - уровень кеша
+	CacheEvictions = expvar.NewMap("s3n_cache_evictions")
+	// CacheBytes занятый кешем объём в байтах, ключ - уровень кеша
+	CacheBytes = expvar.NewMap("s3n_cache_bytes")
+)
+
+func init() {
+	// доля чтений, обслуженных кешем любого уровня
+	expvar.Publish("s3n_cache_hit_ratio", expvar.Func(func() any {
+		var hits int64
+		CacheHits.Do(func(kv expvar.KeyValue) {
+			if v, ok := kv.Value.(*expvar.Int); ok {
+				hits += v.Value()
+			}
+		})
+		total := hits + CacheMisses.Value()
+		if total == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(total)
+	}))
+}
+
+// Serve запускает сервер метрик на addr, он не должен быть доступен снаружи
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,280 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// CorsRule правило CORS физического бакета
+type CorsRule struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	MaxAgeSeconds  int
+}
+
+// probePrefix префикс временных объектов, которыми проверяется доступ на запись
+const probePrefix = ".s3n-probe-"
+
+func (s *S3Service) CreateBucket(ctx context.Context, bucket string) error {
+	const op = "S3Service.CreateBucket"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}
+	// вне us-east-1 бакет без LocationConstraint создаётся в us-east-1 или запрос отклоняется
+	if s.region != "" && s.region != "us-east-1" {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(s.region),
+		}
+	}
+	_, err := s.client.CreateBucket(context.TODO(), input)
+	if err != nil {
+		var owned *types.BucketAlreadyOwnedByYou
+		if errors.As(err, &owned) {
+			return nil
+		}
+
+		err = fmt.Errorf("не удалось создать бакет: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+		return err
+	}
+
+	return nil
+}
+
+func (s *S3Service) SetBucketPublic(ctx context.Context, bucket string, public bool) error {
+	const op = "S3Service.SetBucketPublic"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	if !public {
+		_, err := s.client.DeleteBucketPolicy(context.TODO(), &s3.DeleteBucketPolicyInput{
+			Bucket: aws.String(bucket),
+		})
+		if err != nil {
+			err = fmt.Errorf("не удалось удалить политику бакета: %w", err)
+			s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+			return err
+		}
+		return nil
+	}
+
+	policy, err := json.Marshal(map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Sid":       "PublicRead",
+			"Effect":    "Allow",
+			"Principal": "*",
+			"Action":    []string{"s3:GetObject"},
+			"Resource":  []string{fmt.Sprintf("arn:aws:s3:::%s/*", bucket)},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutBucketPolicy(context.TODO(), &s3.PutBucketPolicyInput{
+		Bucket: aws.String(bucket),
+		Policy: aws.String(string(policy)),
+	})
+	if err != nil {
+		err = fmt.Errorf("не удалось установить политику бакета: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+		return err
+	}
+
+	return nil
+}
+
+func (s *S3Service) SetBucketCors(ctx context.Context, bucket string, rules []CorsRule) error {
+	const op = "S3Service.SetBucketCors"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	if len(rules) == 0 {
+		_, err := s.client.DeleteBucketCors(context.TODO(), &s3.DeleteBucketCorsInput{
+			Bucket: aws.String(bucket),
+		})
+		if err != nil {
+			err = fmt.Errorf("не удалось удалить правила CORS: %w", err)
+			s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+			return err
+		}
+		return nil
+	}
+
+	var corsRules []types.CORSRule
+	for _, rule := range rules {
+		corsRules = append(corsRules, types.CORSRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			MaxAgeSeconds:  aws.Int32(int32(rule.MaxAgeSeconds)),
+		})
+	}
+
+	_, err := s.client.PutBucketCors(context.TODO(), &s3.PutBucketCorsInput{
+		Bucket: aws.String(bucket),
+		CORSConfiguration: &types.CORSConfiguration{
+			CORSRules: corsRules,
+		},
+	})
+	if err != nil {
+		err = fmt.Errorf("не удалось установить правила CORS: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+		return err
+	}
+
+	return nil
+}
+
+// GetBucketCors возвращает правила CORS бакета, пустой список - правила не заданы
+func (s *S3Service) GetBucketCors(ctx context.Context, bucket string) ([]CorsRule, error) {
+	const op = "S3Service.GetBucketCors"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	output, err := s.client.GetBucketCors(context.TODO(), &s3.GetBucketCorsInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		// бакет без правил отвечает NoSuchCORSConfiguration с кодом 404
+		if errors.Is(objectError(err), ErrObjectNotFound) {
+			return nil, nil
+		}
+
+		err = fmt.Errorf("не удалось получить правила CORS: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+		return nil, err
+	}
+
+	rules := make([]CorsRule, 0, len(output.CORSRules))
+	for _, rule := range output.CORSRules {
+		rules = append(rules, CorsRule{
+			AllowedOrigins: rule.AllowedOrigins,
+			AllowedMethods: rule.AllowedMethods,
+			AllowedHeaders: rule.AllowedHeaders,
+			MaxAgeSeconds:  int(aws.ToInt32(rule.MaxAgeSeconds)),
+		})
+	}
+	return rules, nil
+}
+
+// lifecycleRuleID идентификатор правила, удаляющего объекты с тегом ExpiringTag
+const lifecycleRuleID = "s3n-expiring"
+
+// ExpiringTag тег объектов, которые удаляются правилом жизненного цикла бакета
+const ExpiringTag = "s3n-expiring"
+
+// SetBucketExpiration задаёт правило жизненного цикла, удаляющее объекты с тегом ExpiringTag
+// через days дней после создания, 0 - правило удаляется. Остальные правила бакета сохраняются
+func (s *S3Service) SetBucketExpiration(ctx context.Context, bucket string, days int) error {
+	const op = "S3Service.SetBucketExpiration"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	// правила задаются только целиком, поэтому текущие читаются и объединяются с нашим
+	var current []types.LifecycleRule
+	output, err := s.client.GetBucketLifecycleConfiguration(context.TODO(), &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+	switch {
+	case err == nil:
+		current = output.Rules
+	// бакет без правил отвечает NoSuchLifecycleConfiguration с кодом 404
+	case !errors.Is(objectError(err), ErrObjectNotFound):
+		err = fmt.Errorf("не удалось получить правила жизненного цикла: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+		return err
+	}
+
+	rules, changed := mergeExpirationRule(current, days)
+	if !changed {
+		return nil
+	}
+
+	if len(rules) == 0 {
+		_, err = s.client.DeleteBucketLifecycle(context.TODO(), &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(bucket),
+		})
+		if err != nil {
+			err = fmt.Errorf("не удалось удалить правила жизненного цикла: %w", err)
+			s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+			return err
+		}
+		return nil
+	}
+
+	_, err = s.client.PutBucketLifecycleConfiguration(context.TODO(), &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{
+			Rules: rules,
+		},
+	})
+	if err != nil {
+		err = fmt.Errorf("не удалось установить правила жизненного цикла: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+		return err
+	}
+
+	return nil
+}
+
+// mergeExpirationRule заменяет в rules правило lifecycleRuleID правилом на days дней, days <= 0 - удаляет его.
+// Второе значение false, если правила не изменились
+func mergeExpirationRule(rules []types.LifecycleRule, days int) ([]types.LifecycleRule, bool) {
+	merged := make([]types.LifecycleRule, 0, len(rules)+1)
+	changed := false
+	for _, rule := range rules {
+		if aws.ToString(rule.ID) != lifecycleRuleID {
+			merged = append(merged, rule)
+			continue
+		}
+		if days <= 0 || rule.Expiration == nil || int(aws.ToInt32(rule.Expiration.Days)) != days {
+			changed = true
+			continue
+		}
+		// правило уже задано с тем же сроком
+		return rules, false
+	}
+	if days <= 0 {
+		return merged, changed
+	}
+
+	return append(merged, types.LifecycleRule{
+		ID:     aws.String(lifecycleRuleID),
+		Status: types.ExpirationStatusEnabled,
+		Filter: &types.LifecycleRuleFilter{
+			Tag: &types.Tag{Key: aws.String(ExpiringTag), Value: aws.String("true")},
+		},
+		Expiration: &types.LifecycleExpiration{
+			Days: aws.Int32(int32(days)),
+		},
+	}), true
+}
+
+// CheckWriteAccess загружает и удаляет временный объект, чтобы убедиться, что в бакет можно писать
+func (s *S3Service) CheckWriteAccess(ctx context.Context, bucket string) error {
+	const op = "S3Service.CheckWriteAccess"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	key := probePrefix + uuid.NewString()
+	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(nil),
+	})
+	if err != nil {
+		err = fmt.Errorf("нет доступа на запись в бакет: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+		return err
+	}
+
+	return s.DeleteFile(ctx, bucket, key)
+}
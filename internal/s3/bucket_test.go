@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"testing"
+)
+
+func TestMergeExpirationRule(t *testing.T) {
+	foreign := types.LifecycleRule{
+		ID:     aws.String("archive-logs"),
+		Status: types.ExpirationStatusEnabled,
+		Filter: &types.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+	}
+	expiring := func(days int32) types.LifecycleRule {
+		return types.LifecycleRule{
+			ID:         aws.String(lifecycleRuleID),
+			Expiration: &types.LifecycleExpiration{Days: aws.Int32(days)},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		rules       []types.LifecycleRule
+		days        int
+		wantIDs     []string
+		wantDays    int32
+		wantChanged bool
+	}{
+		{name: "добавление в пустой бакет", days: 3, wantIDs: []string{lifecycleRuleID}, wantDays: 3, wantChanged: true},
+		{name: "чужое правило сохраняется", rules: []types.LifecycleRule{foreign}, days: 3, wantIDs: []string{"archive-logs", lifecycleRuleID}, wantDays: 3, wantChanged: true},
+		{name: "замена срока", rules: []types.LifecycleRule{expiring(2), foreign}, days: 5, wantIDs: []string{"archive-logs", lifecycleRuleID}, wantDays: 5, wantChanged: true},
+		{name: "тот же срок", rules: []types.LifecycleRule{foreign, expiring(5)}, days: 5, wantIDs: []string{"archive-logs", lifecycleRuleID}, wantDays: 5},
+		{name: "удаление только своего правила", rules: []types.LifecycleRule{foreign, expiring(5)}, days: 0, wantIDs: []string{"archive-logs"}, wantChanged: true},
+		{name: "удаление отсутствующего правила", rules: []types.LifecycleRule{foreign}, days: 0, wantIDs: []string{"archive-logs"}},
+		{name: "удаление последнего правила", rules: []types.LifecycleRule{expiring(5)}, days: -1, wantChanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, changed := mergeExpirationRule(tt.rules, tt.days)
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, ожидалось %v", changed, tt.wantChanged)
+			}
+			if len(rules) != len(tt.wantIDs) {
+				t.Fatalf("правил %d, ожидалось %v", len(rules), tt.wantIDs)
+			}
+			for i, rule := range rules {
+				if aws.ToString(rule.ID) != tt.wantIDs[i] {
+					t.Errorf("правило %d = %s, ожидалось %s", i, aws.ToString(rule.ID), tt.wantIDs[i])
+				}
+				if aws.ToString(rule.ID) == lifecycleRuleID && aws.ToInt32(rule.Expiration.Days) != tt.wantDays {
+					t.Errorf("срок %d, ожидалось %d", aws.ToInt32(rule.Expiration.Days), tt.wantDays)
+				}
+			}
+		})
+	}
+}
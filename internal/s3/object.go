@@ -0,0 +1,53 @@
+package s3
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrInvalidRange   = errors.New("диапазон за пределами объекта") // запрошенный диапазон начинается за концом объекта
+	ErrNotModified    = errors.New("объект не изменился")           // условие If-None-Match или If-Modified-Since не выполнено
+	ErrObjectNotFound = errors.New("объект не найден")
+)
+
+// ObjectAttributes заголовки и метаданные, сохраняемые вместе с объектом
+type ObjectAttributes struct {
+	ContentType        string
+	CacheControl       string // пустое значение заменяется значением из конфига
+	ContentDisposition string
+	Metadata           map[string]string // пользовательские метаданные, передаются как x-amz-meta-*
+	Private            bool              // объект загружается без публичного доступа на чтение
+	Tags               map[string]string // теги объекта, по ним применяются правила жизненного цикла
+}
+
+// encodeTags кодирует теги в формат заголовка x-amz-tagging
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// ObjectRequest диапазон и условия чтения объекта в формате заголовков HTTP
+type ObjectRequest struct {
+	Range           string // значение заголовка Range, пустое - объект целиком
+	IfNoneMatch     string
+	IfModifiedSince *time.Time
+}
+
+// Object содержимое объекта или его части, Body закрывает вызывающий
+type Object struct {
+	Body         io.ReadCloser // nil, если запрошены только сведения об объекте
+	ContentType  string
+	CacheControl string
+	ETag         string
+	LastModified time.Time
+	ContentRange string // значение заголовка Content-Range, пустое - объект целиком
+	Offset       int64  // смещение первого байта Body от начала объекта
+	Length       int64  // количество байт в Body
+	Size         int64  // полный размер объекта
+}
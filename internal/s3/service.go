@@ -3,8 +3,10 @@ package s3
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -12,17 +14,22 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	logit "github.com/budka-tech/logit-go"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 	"io"
+	"net/http"
+	"net/url"
 	cfg "s3n/internal/config"
 )
 
 type S3Service struct {
 	logger logit.Logger
 
-	redirectFormat string
-	fileFormat     string
-	client         *s3.Client
-	uploader       *manager.Uploader
+	region              string
+	redirectFormat      string
+	fileFormat          string
+	defaultCacheControl string
+	client              *s3.Client
+	uploader            *manager.Uploader
 }
 
 func NewS3Service(ctx context.Context, logger logit.Logger, s3Config *cfg.S3ServiceConfig) (Service, error) {
@@ -53,25 +60,50 @@ func NewS3Service(ctx context.Context, logger logit.Logger, s3Config *cfg.S3Serv
 	})
 
 	return &S3Service{
-		logger:         logger,
-		client:         client,
-		uploader:       uploader,
-		redirectFormat: s3Config.RedirectFormat,
-		fileFormat:     s3Config.FileFormat,
+		logger:              logger,
+		client:              client,
+		uploader:            uploader,
+		region:              s3Config.S3Server.Region,
+		redirectFormat:      s3Config.RedirectFormat,
+		fileFormat:          s3Config.FileFormat,
+		defaultCacheControl: s3Config.DefaultCacheControl,
 	}, nil
 }
 
-func (s *S3Service) UploadFile(ctx context.Context, bucket string, key string, file io.Reader) error {
+func (s *S3Service) UploadFile(ctx context.Context, bucket string, key string, file io.Reader, attributes *ObjectAttributes) error {
 	const op = "S3Service.UploadFile"
 	ctx = s.logger.NewOpCtx(ctx, op)
 
-	// Upload the file
-	_, err := s.uploader.Upload(context.TODO(), &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 		Body:   file,
 		ACL:    types.ObjectCannedACLPublicRead,
-	})
+	}
+	if s.defaultCacheControl != "" {
+		input.CacheControl = aws.String(s.defaultCacheControl)
+	}
+	if attributes != nil {
+		if attributes.ContentType != "" {
+			input.ContentType = aws.String(attributes.ContentType)
+		}
+		if attributes.CacheControl != "" {
+			input.CacheControl = aws.String(attributes.CacheControl)
+		}
+		if attributes.ContentDisposition != "" {
+			input.ContentDisposition = aws.String(attributes.ContentDisposition)
+		}
+		input.Metadata = attributes.Metadata
+		if attributes.Private {
+			input.ACL = types.ObjectCannedACLPrivate
+		}
+		if len(attributes.Tags) > 0 {
+			input.Tagging = aws.String(encodeTags(attributes.Tags))
+		}
+	}
+
+	// Upload the file
+	_, err := s.uploader.Upload(context.TODO(), input)
 	if err != nil {
 		err = fmt.Errorf("не удалось загрузить файл: %w", err)
 		s.logger.Error(ctx, err)
@@ -82,8 +114,8 @@ func (s *S3Service) UploadFile(ctx context.Context, bucket string, key string, f
 	return nil
 }
 
-func (s *S3Service) UploadFileBytes(ctx context.Context, bucket string, key string, file []byte) error {
-	return s.UploadFile(ctx, bucket, key, bytes.NewReader(file))
+func (s *S3Service) UploadFileBytes(ctx context.Context, bucket string, key string, file []byte, attributes *ObjectAttributes) error {
+	return s.UploadFile(ctx, bucket, key, bytes.NewReader(file), attributes)
 }
 
 func (s *S3Service) DeleteFile(ctx context.Context, bucket string, key string) error {
@@ -105,6 +137,235 @@ func (s *S3Service) DeleteFile(ctx context.Context, bucket string, key string) e
 	return nil
 }
 
+// CopyFile копирует объект на стороне S3, заменяя заголовки и метаданные на attributes
+func (s *S3Service) CopyFile(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string, attributes *ObjectAttributes) error {
+	const op = "S3Service.CopyFile"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(dstBucket),
+		Key:               aws.String(dstKey),
+		CopySource:        aws.String(srcBucket + "/" + url.PathEscape(srcKey)),
+		ACL:               types.ObjectCannedACLPublicRead,
+		MetadataDirective: types.MetadataDirectiveReplace,
+	}
+	if s.defaultCacheControl != "" {
+		input.CacheControl = aws.String(s.defaultCacheControl)
+	}
+	if attributes != nil {
+		if attributes.ContentType != "" {
+			input.ContentType = aws.String(attributes.ContentType)
+		}
+		if attributes.CacheControl != "" {
+			input.CacheControl = aws.String(attributes.CacheControl)
+		}
+		if attributes.ContentDisposition != "" {
+			input.ContentDisposition = aws.String(attributes.ContentDisposition)
+		}
+		input.Metadata = attributes.Metadata
+		if attributes.Private {
+			input.ACL = types.ObjectCannedACLPrivate
+		}
+		input.TaggingDirective = types.TaggingDirectiveReplace
+		if len(attributes.Tags) > 0 {
+			input.Tagging = aws.String(encodeTags(attributes.Tags))
+		}
+	}
+
+	_, err := s.client.CopyObject(context.TODO(), input)
+	if err != nil {
+		err = fmt.Errorf("не удалось скопировать файл: %w", err)
+		s.logger.Error(ctx, err, zap.String("source", srcBucket+"/"+srcKey), zap.String("destination", dstBucket+"/"+dstKey))
+		return err
+	}
+
+	return nil
+}
+
+// Invalidate сбрасывает закешированные копии объектов, S3Service объекты не кеширует
+func (s *S3Service) Invalidate(bucket string, keys ...string) {}
+
+// deleteObjectsLimit максимальное количество ключей в одном запросе DeleteObjects
+const deleteObjectsLimit = 1000
+
+func (s *S3Service) DeleteFiles(ctx context.Context, bucket string, keys []string) error {
+	const op = "S3Service.DeleteFiles"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	for start := 0; start < len(keys); start += deleteObjectsLimit {
+		end := min(start+deleteObjectsLimit, len(keys))
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		output, err := s.client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			err = fmt.Errorf("не удалось удалить файлы: %w", err)
+			s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+			return err
+		}
+		if len(output.Errors) > 0 {
+			failed := output.Errors[0]
+			err = fmt.Errorf("не удалось удалить %d файлов, %s: %s", len(output.Errors), aws.ToString(failed.Key), aws.ToString(failed.Message))
+			s.logger.Error(ctx, err, zap.String("bucket_name", bucket))
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *S3Service) DownloadFileBytes(ctx context.Context, bucket string, key string) ([]byte, error) {
+	const op = "S3Service.DownloadFileBytes"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	output, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		err = fmt.Errorf("не удалось получить файл: %w", err)
+		s.logger.Error(ctx, err)
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	file, err := io.ReadAll(output.Body)
+	if err != nil {
+		err = fmt.Errorf("не удалось прочитать файл: %w", err)
+		s.logger.Error(ctx, err)
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// GetObject открывает объект для чтения начиная с offset, length 0 - до конца объекта.
+// Если offset за концом объекта, возвращается ErrInvalidRange
+func (s *S3Service) GetObject(ctx context.Context, bucket string, key string, offset int64, length int64) (*Object, error) {
+	request := &ObjectRequest{}
+	if length > 0 {
+		request.Range = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	} else if offset > 0 {
+		request.Range = fmt.Sprintf("bytes=%d-", offset)
+	}
+	return s.ReadObject(ctx, bucket, key, request)
+}
+
+// ReadObject открывает объект для чтения с учётом диапазона и условий запроса.
+// Ответы S3 304, 404 и 416 возвращаются как ErrNotModified, ErrObjectNotFound и ErrInvalidRange
+func (s *S3Service) ReadObject(ctx context.Context, bucket string, key string, request *ObjectRequest) (*Object, error) {
+	const op = "S3Service.ReadObject"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if request.Range != "" {
+		input.Range = aws.String(request.Range)
+	}
+	if request.IfNoneMatch != "" {
+		input.IfNoneMatch = aws.String(request.IfNoneMatch)
+	}
+	input.IfModifiedSince = request.IfModifiedSince
+
+	output, err := s.client.GetObject(context.TODO(), input)
+	if err != nil {
+		if expected := objectError(err); expected != nil {
+			return nil, expected
+		}
+
+		err = fmt.Errorf("не удалось получить файл: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket), zap.String("key", key))
+		return nil, err
+	}
+
+	object := &Object{
+		Body:         output.Body,
+		ContentType:  aws.ToString(output.ContentType),
+		CacheControl: aws.ToString(output.CacheControl),
+		ETag:         aws.ToString(output.ETag),
+		LastModified: aws.ToTime(output.LastModified),
+		ContentRange: aws.ToString(output.ContentRange),
+		Length:       aws.ToInt64(output.ContentLength),
+	}
+	object.Size = object.Length
+	if object.ContentRange != "" {
+		// формат bytes first-last/size
+		var last int64
+		_, err = fmt.Sscanf(object.ContentRange, "bytes %d-%d/%d", &object.Offset, &last, &object.Size)
+		if err != nil {
+			output.Body.Close()
+			err = fmt.Errorf("не удалось разобрать Content-Range %q: %w", object.ContentRange, err)
+			s.logger.Error(ctx, err, zap.String("bucket_name", bucket), zap.String("key", key))
+			return nil, err
+		}
+	}
+
+	return object, nil
+}
+
+// StatObject возвращает сведения об объекте без содержимого, ошибки как у ReadObject
+func (s *S3Service) StatObject(ctx context.Context, bucket string, key string, request *ObjectRequest) (*Object, error) {
+	const op = "S3Service.StatObject"
+	ctx = s.logger.NewOpCtx(ctx, op)
+
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if request.IfNoneMatch != "" {
+		input.IfNoneMatch = aws.String(request.IfNoneMatch)
+	}
+	input.IfModifiedSince = request.IfModifiedSince
+
+	output, err := s.client.HeadObject(context.TODO(), input)
+	if err != nil {
+		if expected := objectError(err); expected != nil {
+			return nil, expected
+		}
+
+		err = fmt.Errorf("не удалось получить сведения о файле: %w", err)
+		s.logger.Error(ctx, err, zap.String("bucket_name", bucket), zap.String("key", key))
+		return nil, err
+	}
+
+	size := aws.ToInt64(output.ContentLength)
+	return &Object{
+		ContentType:  aws.ToString(output.ContentType),
+		CacheControl: aws.ToString(output.CacheControl),
+		ETag:         aws.ToString(output.ETag),
+		LastModified: aws.ToTime(output.LastModified),
+		Length:       size,
+		Size:         size,
+	}, nil
+}
+
+// objectError сопоставляет ожидаемые ответы S3 на чтение объекта с ошибками пакета, остальные - nil
+func objectError(err error) error {
+	var responseErr *awshttp.ResponseError
+	if !errors.As(err, &responseErr) {
+		return nil
+	}
+	switch responseErr.HTTPStatusCode() {
+	case http.StatusNotModified:
+		return ErrNotModified
+	case http.StatusNotFound:
+		return ErrObjectNotFound
+	case http.StatusRequestedRangeNotSatisfiable:
+		return ErrInvalidRange
+	}
+	return nil
+}
+
 func (s *S3Service) RedirectPath(bucket string, key string) string {
 	return fmt.Sprintf(s.redirectFormat, bucket, key)
 }
@@ -113,6 +374,14 @@ func (s *S3Service) FileName(id uuid.UUID) string {
 	return s.FileNameS(id.String())
 }
 
+// FileNameRevision возвращает ключ объекта ревизии изображения, ревизия 0 совпадает с FileName
+func (s *S3Service) FileNameRevision(id uuid.UUID, revision int) string {
+	if revision == 0 {
+		return s.FileName(id)
+	}
+	return s.FileNameS(fmt.Sprintf("%s-%d", id, revision))
+}
+
 func (s *S3Service) FileNameS(id string) string {
 	return fmt.Sprintf(s.fileFormat, id)
 }
@@ -7,10 +7,24 @@ import (
 )
 
 type Service interface {
-	UploadFile(ctx context.Context, bucket string, key string, file io.Reader) error
-	UploadFileBytes(ctx context.Context, bucket string, key string, file []byte) error
+	UploadFile(ctx context.Context, bucket string, key string, file io.Reader, attributes *ObjectAttributes) error
+	UploadFileBytes(ctx context.Context, bucket string, key string, file []byte, attributes *ObjectAttributes) error
 	DeleteFile(ctx context.Context, bucket string, key string) error
+	DeleteFiles(ctx context.Context, bucket string, keys []string) error
+	Invalidate(bucket string, keys ...string)
+	CopyFile(ctx context.Context, srcBucket string, srcKey string, dstBucket string, dstKey string, attributes *ObjectAttributes) error
+	DownloadFileBytes(ctx context.Context, bucket string, key string) ([]byte, error)
+	GetObject(ctx context.Context, bucket string, key string, offset int64, length int64) (*Object, error)
+	ReadObject(ctx context.Context, bucket string, key string, request *ObjectRequest) (*Object, error)
+	StatObject(ctx context.Context, bucket string, key string, request *ObjectRequest) (*Object, error)
+	CreateBucket(ctx context.Context, bucket string) error
+	SetBucketPublic(ctx context.Context, bucket string, public bool) error
+	SetBucketCors(ctx context.Context, bucket string, rules []CorsRule) error
+	GetBucketCors(ctx context.Context, bucket string) ([]CorsRule, error)
+	SetBucketExpiration(ctx context.Context, bucket string, days int) error
+	CheckWriteAccess(ctx context.Context, bucket string) error
 	RedirectPath(bucket string, key string) string
 	FileName(id uuid.UUID) string
+	FileNameRevision(id uuid.UUID, revision int) string
 	FileNameS(id string) string
 }
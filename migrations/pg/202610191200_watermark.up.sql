@@ -0,0 +1,16 @@
+create table watermark
+(
+    bucket_id smallint              not null,
+    image_id  uuid                  not null,
+    position  varchar(16)           not null,
+    margin    integer default 0     not null,
+    opacity   real    default 1     not null,
+    scale     real    default 0     not null,
+    tiled     boolean default false not null,
+    min_size  integer default 0     not null,
+    primary key (bucket_id),
+    foreign key (bucket_id) references bucket
+        on delete cascade,
+    foreign key (image_id) references image
+        on delete restrict
+);
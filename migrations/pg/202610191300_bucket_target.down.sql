@@ -0,0 +1,4 @@
+alter table bucket
+    drop column target_size,
+    drop column min_ssim,
+    drop column allow_downscale;
@@ -0,0 +1,4 @@
+alter table bucket
+    add column target_size     integer default 0     not null,
+    add column min_ssim        real    default 0     not null,
+    add column allow_downscale boolean default false not null;
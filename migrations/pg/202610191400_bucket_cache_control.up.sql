@@ -0,0 +1,2 @@
+alter table bucket
+    add column cache_control varchar(255) default '' not null;
@@ -0,0 +1,2 @@
+alter table bucket
+    add column private boolean default false not null;
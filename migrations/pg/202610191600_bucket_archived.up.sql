@@ -0,0 +1,2 @@
+alter table bucket
+    add column archived boolean default false not null;
@@ -0,0 +1,5 @@
+alter table bucket
+    add column storage_name varchar(255) default '' not null;
+
+update bucket
+set storage_name = bucket_name;
@@ -0,0 +1,2 @@
+alter table image
+    add column revision integer default 0 not null;
@@ -0,0 +1,14 @@
+create table image_version
+(
+    image_id   uuid                                   not null,
+    revision   integer                                not null,
+    size       integer     default 0                  not null,
+    created_at timestamptz default current_timestamp not null,
+    primary key (image_id, revision),
+    foreign key (image_id) references image
+        on delete cascade
+);
+
+insert into image_version (image_id, revision)
+select id, revision
+from image;
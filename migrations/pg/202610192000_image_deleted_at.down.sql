@@ -0,0 +1,4 @@
+drop index image_deleted_at_idx;
+
+alter table image
+    drop column deleted_at;
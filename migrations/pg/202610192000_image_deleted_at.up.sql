@@ -0,0 +1,5 @@
+alter table image
+    add column deleted_at timestamptz;
+
+create index image_deleted_at_idx on image (deleted_at)
+    where deleted_at is not null;
@@ -0,0 +1,7 @@
+alter table bucket
+    drop column default_ttl;
+
+drop index image_expires_at_idx;
+
+alter table image
+    drop column expires_at;
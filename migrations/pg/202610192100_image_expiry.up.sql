@@ -0,0 +1,8 @@
+alter table image
+    add column expires_at timestamptz;
+
+create index image_expires_at_idx on image (expires_at)
+    where expires_at is not null;
+
+alter table bucket
+    add column default_ttl integer default 0 not null;
@@ -0,0 +1,2 @@
+alter table bucket
+    drop column fallback_image_id;
@@ -0,0 +1,6 @@
+alter table bucket
+    add column fallback_image_id uuid;
+
+alter table bucket
+    add foreign key (fallback_image_id) references image
+        on delete set null;
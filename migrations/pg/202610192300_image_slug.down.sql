@@ -0,0 +1,6 @@
+drop table image_alias;
+
+drop index image_bucket_slug_idx;
+
+alter table image
+    drop column slug;
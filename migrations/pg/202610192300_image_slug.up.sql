@@ -0,0 +1,17 @@
+alter table image
+    add column slug varchar(128);
+
+create unique index image_bucket_slug_idx on image (bucket_id, slug)
+    where slug is not null;
+
+create table image_alias
+(
+    bucket_id smallint     not null,
+    slug      varchar(128) not null,
+    image_id  uuid         not null,
+    primary key (bucket_id, slug),
+    foreign key (bucket_id) references bucket
+        on delete cascade,
+    foreign key (image_id) references image
+        on delete cascade
+);
@@ -0,0 +1,4 @@
+alter table bucket
+    drop column hotlink_image_id,
+    drop column hotlink_allow_empty,
+    drop column hotlink_hosts;
@@ -0,0 +1,8 @@
+alter table bucket
+    add column hotlink_hosts       text[]  default '{}' not null,
+    add column hotlink_allow_empty boolean default true not null,
+    add column hotlink_image_id    uuid;
+
+alter table bucket
+    add foreign key (hotlink_image_id) references image
+        on delete set null;
@@ -0,0 +1,3 @@
+drop table api_key_grant;
+
+drop table api_key;
@@ -0,0 +1,25 @@
+create table api_key
+(
+    id         uuid                      not null,
+    name       varchar(128)              not null,
+    key_hash   bytea                     not null,
+    role       varchar(8),
+    created_at timestamptz default now() not null,
+    rotated_at timestamptz,
+    revoked_at timestamptz,
+    primary key (id)
+);
+
+create unique index api_key_hash_idx on api_key (key_hash);
+
+create table api_key_grant
+(
+    key_id    uuid       not null,
+    bucket_id smallint   not null,
+    role      varchar(8) not null,
+    primary key (key_id, bucket_id),
+    foreign key (key_id) references api_key
+        on delete cascade,
+    foreign key (bucket_id) references bucket
+        on delete cascade
+);
@@ -0,0 +1,10 @@
+alter table api_key
+    drop column tenant;
+
+drop index bucket_tenant_idx;
+
+alter table bucket
+    drop column tenant,
+    drop column quota_images,
+    drop column quota_bytes,
+    drop column quota_uploads;
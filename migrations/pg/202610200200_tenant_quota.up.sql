@@ -0,0 +1,10 @@
+alter table bucket
+    add column tenant        varchar(64),
+    add column quota_images  integer default 0 not null,
+    add column quota_bytes   bigint  default 0 not null,
+    add column quota_uploads integer default 0 not null;
+
+create index bucket_tenant_idx on bucket (tenant);
+
+alter table api_key
+    add column tenant varchar(64);
@@ -0,0 +1,2 @@
+alter table image
+    drop column last_revision;
@@ -0,0 +1,6 @@
+alter table image
+    add column last_revision integer default 0 not null;
+
+-- номера уже выданных ревизий, включая сохранённые в истории
+update image i
+set last_revision = greatest(i.revision, coalesce((select max(v.revision) from image_version v where v.image_id = i.id), 0));
@@ -0,0 +1 @@
+drop index image_version_unsized_idx;
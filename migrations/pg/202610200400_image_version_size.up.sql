@@ -0,0 +1,3 @@
+-- ревизии, добавленные миграцией image_version, получили размер 0: размер файлов известен только S3.
+-- Такие размеры заполняет s3n после запуска по сведениям об объектах, индекс нужен для их поиска
+create index image_version_unsized_idx on image_version (image_id, revision) where size = 0;
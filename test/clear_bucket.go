@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	pb "github.com/budka-tech/snip-common-go/contract/s3"
+	"github.com/budka-tech/snip-common-go/status"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"log"
@@ -35,13 +36,28 @@ func main() {
 
 	log.Printf("всего %d\n", len(inBucket.Images))
 
+	// сервер принимает не больше 1000 изображений в одном пакетном запросе
+	const batchSize = 1000
+
+	var ids [][]byte
 	for _, image := range inBucket.Images {
 		if image != nil {
-			_, err := client.DeleteImage(context.Background(), &pb.DeleteImageRequest{
-				Id: image.Id,
-			})
-			if err != nil {
-				log.Printf("ошибка удаления %s\n", image.Id)
+			ids = append(ids, image.Id)
+		}
+	}
+
+	for start := 0; start < len(ids); start += batchSize {
+		end := min(start+batchSize, len(ids))
+		response, err := client.BatchDeleteImages(context.Background(), &pb.BatchDeleteImagesRequest{
+			Ids: ids[start:end],
+		})
+		if err != nil {
+			log.Printf("ошибка удаления партии %d-%d: %s\n", start, end, err)
+			continue
+		}
+		for _, result := range response.Results {
+			if result.Status != status.OK {
+				log.Printf("ошибка удаления %x: %v\n", result.Id, result.Status)
 			}
 		}
 	}